@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// ARP / NDP monitor. Runs on the same capture handles as neighbor discovery
+// and keeps an IP -> MAC table for the default gateway and the local subnets,
+// raising network_security events for gateway MAC flips, duplicate IP
+// conflicts and gratuitous ARP / unsolicited NA floods.
+
+// ARP plus ICMPv6 neighbor solicitation (135) and advertisement (136)
+const ARP_BPF_FILTER = "arp or (icmp6 and (ip6[40] == 135 or ip6[40] == 136))"
+
+const (
+	ARP_BINDING_TTL         = 10 * time.Minute // forget bindings not refreshed for this long
+	ARP_CONFLICT_WINDOW     = 60 * time.Second // both MACs active within this window = conflict
+	ARP_GRATUITOUS_WINDOW   = 10 * time.Second
+	ARP_GRATUITOUS_FLOOD    = 20 // gratuitous announcements per MAC per window
+	ARP_ALERT_COOLDOWN      = 5 * time.Minute
+	ARP_LOCAL_REFRESH       = 60 * time.Second
+	ndpOptSourceLinkAddress = 1
+	ndpOptTargetLinkAddress = 2
+)
+
+type arpBinding struct {
+	mac       string
+	firstSeen time.Time
+	lastSeen  time.Time
+	// previous MAC for this IP and when it was last heard, for conflict detection
+	previousMAC  string
+	previousSeen time.Time
+}
+
+type arpMonitor struct {
+	mu         sync.Mutex
+	bindings   map[string]*arpBinding // interface|ip -> binding
+	gratuitous map[string][]time.Time // interface|mac -> announcement times
+	lastAlert  map[string]time.Time   // event|interface|key -> last alert
+
+	// scope, refreshed from the host every ARP_LOCAL_REFRESH by watchScope
+	gateways  map[string]bool
+	localNets []*net.IPNet
+	prunedAt  time.Time
+	// offline replay has no host context: every address is in scope
+	anyScope bool
+}
+
+var arpWatch = newARPMonitor()
+
+func newARPMonitor() *arpMonitor {
+	return &arpMonitor{
+		bindings:   make(map[string]*arpBinding),
+		gratuitous: make(map[string][]time.Time),
+		lastAlert:  make(map[string]time.Time),
+		gateways:   make(map[string]bool),
+	}
+}
+
+// observe inspects one captured packet and returns any alerts it triggers.
+func (m *arpMonitor) observe(packet gopacket.Packet, iface string, now time.Time) []LogEntry {
+	if l := packet.Layer(layers.LayerTypeARP); l != nil {
+		arp := l.(*layers.ARP)
+		if arp.AddrType != layers.LinkTypeEthernet || arp.Protocol != layers.EthernetTypeIPv4 {
+			return nil
+		}
+		ip := net.IP(arp.SourceProtAddress)
+		if ip.IsUnspecified() {
+			// ARP probe (RFC 5227) announces nothing yet
+			return nil
+		}
+		mac := net.HardwareAddr(arp.SourceHwAddress).String()
+		gratuitous := ip.Equal(net.IP(arp.DstProtAddress))
+		return m.record(iface, ip, mac, gratuitous, "arp", now)
+	}
+
+	if l := packet.Layer(layers.LayerTypeICMPv6NeighborAdvertisement); l != nil {
+		na := l.(*layers.ICMPv6NeighborAdvertisement)
+		mac := ndpLinkAddress(na.Options, ndpOptTargetLinkAddress)
+		if mac == "" {
+			return nil
+		}
+		// An unsolicited override advertisement is the NDP gratuitous ARP
+		gratuitous := na.Override() && !na.Solicited()
+		return m.record(iface, na.TargetAddress, mac, gratuitous, "ndp", now)
+	}
+
+	if l := packet.Layer(layers.LayerTypeICMPv6NeighborSolicitation); l != nil {
+		ns := l.(*layers.ICMPv6NeighborSolicitation)
+		ip6, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+		if !ok || ip6.SrcIP.IsUnspecified() {
+			// DAD probe
+			return nil
+		}
+		mac := ndpLinkAddress(ns.Options, ndpOptSourceLinkAddress)
+		if mac == "" {
+			return nil
+		}
+		return m.record(iface, ip6.SrcIP, mac, false, "ndp", now)
+	}
+	return nil
+}
+
+func ndpLinkAddress(opts layers.ICMPv6Options, optType layers.ICMPv6Opt) string {
+	for _, o := range opts {
+		if o.Type == optType && len(o.Data) >= 6 {
+			return net.HardwareAddr(o.Data[:6]).String()
+		}
+	}
+	return ""
+}
+
+func (m *arpMonitor) record(iface string, ip net.IP, mac string, gratuitous bool, protocol string, now time.Time) []LogEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	addr := ip.String()
+	isGateway := m.gateways[addr]
+	if !isGateway && !m.inScope(ip) {
+		return nil
+	}
+
+	var alerts []LogEntry
+	raw := map[string]interface{}{
+		"interface":  iface,
+		"protocol":   protocol,
+		"ip_address": addr,
+		"mac":        mac,
+		"is_gateway": isGateway,
+	}
+
+	key := iface + "|" + addr
+	b, ok := m.bindings[key]
+	switch {
+	case !ok || now.Sub(b.lastSeen) > ARP_BINDING_TTL:
+		m.bindings[key] = &arpBinding{mac: mac, firstSeen: now, lastSeen: now}
+	case b.mac == mac:
+		b.lastSeen = now
+	default:
+		oldMAC, oldSeen := b.mac, b.lastSeen
+		// The MAC we replaced came back while the other one is still active
+		conflict := mac == b.previousMAC && now.Sub(b.previousSeen) <= ARP_CONFLICT_WINDOW
+		b.previousMAC, b.previousSeen = oldMAC, oldSeen
+		b.mac, b.firstSeen, b.lastSeen = mac, now, now
+
+		raw["previous_mac"] = oldMAC
+		if isGateway {
+			if m.shouldAlert("gateway_mac_changed|"+key, now) {
+				msg := fmt.Sprintf("Gateway %s MAC changed from %s to %s on %s", addr, oldMAC, mac, iface)
+				alerts = append(alerts, arpAlert("gateway_mac_changed", "critical", msg, now, raw))
+			}
+		} else if conflict && m.shouldAlert("duplicate_ip|"+key, now) {
+			msg := fmt.Sprintf("Duplicate IP %s claimed by %s and %s on %s", addr, oldMAC, mac, iface)
+			alerts = append(alerts, arpAlert("duplicate_ip", "warning", msg, now, raw))
+		}
+	}
+
+	if gratuitous {
+		gkey := iface + "|" + mac
+		times := m.gratuitous[gkey][:0]
+		for _, t := range m.gratuitous[gkey] {
+			if now.Sub(t) <= ARP_GRATUITOUS_WINDOW {
+				times = append(times, t)
+			}
+		}
+		times = append(times, now)
+		m.gratuitous[gkey] = times
+
+		if len(times) >= ARP_GRATUITOUS_FLOOD && m.shouldAlert("gratuitous_arp_flood|"+gkey, now) {
+			flood := map[string]interface{}{
+				"interface":      iface,
+				"protocol":       protocol,
+				"mac":            mac,
+				"ip_address":     addr,
+				"announcements":  len(times),
+				"window_seconds": int(ARP_GRATUITOUS_WINDOW.Seconds()),
+			}
+			msg := fmt.Sprintf("Gratuitous %s flood from %s: %d announcements in %s", protocol, mac, len(times), ARP_GRATUITOUS_WINDOW)
+			alerts = append(alerts, arpAlert("gratuitous_arp_flood", "high", msg, now, flood))
+		}
+	}
+
+	m.prune(now)
+	return alerts
+}
+
+func (m *arpMonitor) shouldAlert(key string, now time.Time) bool {
+	if last, ok := m.lastAlert[key]; ok && now.Sub(last) < ARP_ALERT_COOLDOWN {
+		return false
+	}
+	m.lastAlert[key] = now
+	return true
+}
+
+func (m *arpMonitor) prune(now time.Time) {
+	if now.Sub(m.prunedAt) < ARP_GRATUITOUS_WINDOW {
+		return
+	}
+	m.prunedAt = now
+
+	for key, b := range m.bindings {
+		if now.Sub(b.lastSeen) > ARP_BINDING_TTL {
+			delete(m.bindings, key)
+		}
+	}
+	for key, times := range m.gratuitous {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) > ARP_GRATUITOUS_WINDOW {
+			delete(m.gratuitous, key)
+		}
+	}
+	for key, t := range m.lastAlert {
+		if now.Sub(t) > ARP_ALERT_COOLDOWN {
+			delete(m.lastAlert, key)
+		}
+	}
+}
+
+// watchScope runs for the agent lifetime, reloading the scope outside the
+// capture loops: looking the gateways up can take seconds (PowerShell on
+// Windows) and must not hold up packet processing.
+func (m *arpMonitor) watchScope() {
+	m.refreshScope()
+	ticker := time.NewTicker(ARP_LOCAL_REFRESH)
+	for range ticker.C {
+		m.refreshScope()
+	}
+}
+
+// refreshScope reloads the gateway addresses and local subnets.
+func (m *arpMonitor) refreshScope() {
+	gateways := make(map[string]bool)
+	for _, gw := range defaultGatewayIPs() {
+		gateways[gw] = true
+	}
+
+	var localNets []*net.IPNet
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+				localNets = append(localNets, ipnet)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.gateways, m.localNets = gateways, localNets
+	m.mu.Unlock()
+}
+
+func (m *arpMonitor) inScope(ip net.IP) bool {
+	if m.anyScope {
+		return true
+	}
+	for _, n := range m.localNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func arpAlert(event, severity, msg string, now time.Time, raw map[string]interface{}) LogEntry {
+	return LogEntry{
+		DeviceID:     deviceID,
+		DeviceName:   deviceName,
+		Hostname:     getHostname(),
+		LogType:      "network_security",
+		HardwareType: "network",
+		Event:        event,
+		Source:       "arp-monitor",
+		Severity:     severity,
+		Message:      msg,
+		Timestamp:    now.UTC().Format(time.RFC3339),
+		RawData:      raw,
+	}
+}
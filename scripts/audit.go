@@ -0,0 +1,564 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Linux audit (auditd) record parsing and event reassembly. One kernel event
+// is several records (SYSCALL, EXECVE, CWD, PATH, PROCTITLE, ...) sharing a
+// serial number and terminated by EOE; they are merged into one LogEntry.
+// Platform independent so audit.log files can be replayed anywhere with
+// -replay-audit; the live collector is in audit_linux.go.
+
+const (
+	AUDIT_LOG_FILE       = "/var/log/audit/audit.log"
+	AUDIT_OFFSET_FILE    = "audit.offset"
+	AUDIT_EVENT_TIMEOUT  = 3 * time.Second // multi-record events without EOE are flushed after this
+	AUDIT_MAX_PENDING    = 1024
+	AUDIT_MAX_EXECVE_ARG = 64
+)
+
+// Baseline rules installed with auditctl when audit_baseline_rules is set:
+// process execution, identity and privilege files, kernel modules and the
+// audit configuration itself.
+var auditBaselineRules = [][]string{
+	{"-a", "always,exit", "-F", "arch=b64", "-S", "execve,execveat", "-k", "exec"},
+	{"-a", "always,exit", "-F", "arch=b32", "-S", "execve", "-k", "exec"},
+	{"-w", "/etc/passwd", "-p", "wa", "-k", "identity"},
+	{"-w", "/etc/shadow", "-p", "wa", "-k", "identity"},
+	{"-w", "/etc/group", "-p", "wa", "-k", "identity"},
+	{"-w", "/etc/sudoers", "-p", "wa", "-k", "privilege"},
+	{"-w", "/etc/sudoers.d/", "-p", "wa", "-k", "privilege"},
+	{"-w", "/etc/ssh/sshd_config", "-p", "wa", "-k", "sshd_config"},
+	{"-w", "/root/.ssh/", "-p", "wa", "-k", "ssh_keys"},
+	{"-w", "/etc/crontab", "-p", "wa", "-k", "persistence"},
+	{"-w", "/etc/cron.d/", "-p", "wa", "-k", "persistence"},
+	{"-w", "/etc/systemd/system/", "-p", "wa", "-k", "persistence"},
+	{"-a", "always,exit", "-F", "arch=b64", "-S", "init_module,finit_module,delete_module", "-k", "kernel_modules"},
+	{"-w", "/etc/audit/", "-p", "wa", "-k", "audit_config"},
+}
+
+// Record types from linux/audit.h, for netlink messages (the log file has names)
+var auditTypeNames = map[uint16]string{
+	1100: "USER_AUTH", 1101: "USER_ACCT", 1102: "USER_MGMT", 1103: "CRED_ACQ",
+	1104: "CRED_DISP", 1105: "USER_START", 1106: "USER_END", 1107: "USER_AVC",
+	1108: "USER_CHAUTHTOK", 1109: "USER_ERR", 1110: "CRED_REFR", 1111: "USYS_CONFIG",
+	1112: "USER_LOGIN", 1113: "USER_LOGOUT", 1114: "ADD_USER", 1115: "DEL_USER",
+	1116: "ADD_GROUP", 1117: "DEL_GROUP", 1123: "USER_CMD", 1124: "USER_TTY",
+	1125: "CHUSER_ID", 1126: "GRP_AUTH", 1127: "SYSTEM_BOOT", 1128: "SYSTEM_SHUTDOWN",
+	1129: "SYSTEM_RUNLEVEL", 1130: "SERVICE_START", 1131: "SERVICE_STOP",
+	1300: "SYSCALL", 1302: "PATH", 1303: "IPC", 1304: "SOCKETCALL",
+	1305: "CONFIG_CHANGE", 1306: "SOCKADDR", 1307: "CWD", 1309: "EXECVE",
+	1317: "FD_PAIR", 1318: "OBJ_PID", 1320: "EOE", 1321: "BPRM_FCAPS",
+	1322: "CAPSET", 1323: "MMAP", 1325: "NETFILTER_CFG", 1326: "SECCOMP",
+	1327: "PROCTITLE", 1330: "KERN_MODULE", 1334: "BPF", 1400: "AVC",
+	1700: "ANOM_PROMISCUOUS", 1701: "ANOM_ABEND", 1702: "ANOM_LINK",
+}
+
+// Records that belong to a syscall event and wait for its EOE
+var auditSyscallRecords = map[string]bool{
+	"SYSCALL": true, "PATH": true, "IPC": true, "SOCKETCALL": true, "SOCKADDR": true,
+	"CWD": true, "EXECVE": true, "FD_PAIR": true, "OBJ_PID": true, "BPRM_FCAPS": true,
+	"CAPSET": true, "MMAP": true, "PROCTITLE": true, "KERN_MODULE": true, "EOE": true,
+}
+
+// Fields the kernel hex-encodes (unquoted) when the value has spaces,
+// quotes or control characters
+var auditHexFields = map[string]bool{
+	"proctitle": true, "cmd": true, "comm": true, "exe": true, "cwd": true, "name": true,
+	"key": true, "acct": true, "path": true, "data": true, "old-chardev": true, "new-chardev": true,
+}
+
+// Security-relevant syscall numbers for the raw (non-enriched) log format
+var auditSyscallNames = map[string]map[string]string{
+	"c000003e": { // x86_64
+		"2": "open", "42": "connect", "43": "accept", "49": "bind", "56": "clone", "57": "fork",
+		"59": "execve", "62": "kill", "82": "rename", "84": "rmdir", "87": "unlink", "90": "chmod",
+		"92": "chown", "101": "ptrace", "105": "setuid", "165": "mount", "175": "init_module",
+		"176": "delete_module", "257": "openat", "263": "unlinkat", "264": "renameat",
+		"268": "fchmodat", "313": "finit_module", "322": "execveat",
+	},
+	"c00000b7": { // aarch64
+		"35": "unlinkat", "38": "renameat", "40": "mount", "53": "fchmodat", "56": "openat",
+		"105": "init_module", "106": "delete_module", "117": "ptrace", "146": "setuid",
+		"200": "bind", "202": "accept", "203": "connect", "220": "clone", "221": "execve",
+		"273": "finit_module", "281": "execveat",
+	},
+}
+
+// type=SYSCALL msg=audit(1700000000.123:4567): ...
+var auditHeaderRegex = regexp.MustCompile(`audit\((\d+)\.(\d+):(\d+)\):\s*`)
+
+// auditRecord is one audit record.
+type auditRecord struct {
+	Type     string
+	Time     time.Time
+	Serial   uint64
+	Fields   map[string]string
+	Enriched map[string]string // uppercase translations after \x1d (log_format=ENRICHED)
+}
+
+// auditEvent is every record of one serial.
+type auditEvent struct {
+	Serial  uint64
+	Time    time.Time
+	Records []auditRecord
+}
+
+// parseAuditLine parses one audit.log line ("type=X msg=audit(...): fields").
+func parseAuditLine(line string) (auditRecord, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "type=") {
+		// Lines forwarded by audisp/syslog carry a prefix
+		i := strings.Index(line, " type=")
+		if i < 0 {
+			return auditRecord{}, false
+		}
+		line = line[i+1:]
+	}
+	typ, rest, _ := strings.Cut(strings.TrimPrefix(line, "type="), " ")
+	if i := strings.Index(rest, "msg="); i >= 0 {
+		rest = rest[i+len("msg="):]
+	}
+	return parseAuditRecord(typ, rest)
+}
+
+// parseAuditRecord parses the "audit(ts:serial): fields" text of a record
+// (log file or netlink payload).
+func parseAuditRecord(typ, text string) (auditRecord, bool) {
+	m := auditHeaderRegex.FindStringSubmatchIndex(text)
+	if m == nil {
+		return auditRecord{}, false
+	}
+	sec, _ := strconv.ParseInt(text[m[2]:m[3]], 10, 64)
+	msec, _ := strconv.ParseInt(text[m[4]:m[5]], 10, 64)
+	serial, _ := strconv.ParseUint(text[m[6]:m[7]], 10, 64)
+
+	r := auditRecord{
+		Type:   typ,
+		Time:   time.Unix(sec, msec*int64(time.Millisecond)).UTC(),
+		Serial: serial,
+		Fields: make(map[string]string),
+	}
+	body, enriched, hasEnriched := strings.Cut(text[m[1]:], "\x1d")
+	parseAuditFields(body, typ, r.Fields)
+	if hasEnriched {
+		r.Enriched = make(map[string]string)
+		parseAuditFields(enriched, typ, r.Enriched)
+	}
+	return r, true
+}
+
+// parseAuditFields parses key=value pairs. Values are "quoted", bare (hex
+// encoded for the fields in auditHexFields) or a 'single quoted' list of
+// further pairs (user space messages), which are merged in.
+func parseAuditFields(s, typ string, fields map[string]string) {
+	for {
+		s = strings.TrimLeft(s, " ")
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+
+		var value string
+		switch {
+		case strings.HasPrefix(s, `"`):
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				end = len(s) - 1
+			}
+			value, s = s[1:end+1], s[min(end+2, len(s)):]
+		case strings.HasPrefix(s, "'"):
+			end := strings.IndexByte(s[1:], '\'')
+			if end < 0 {
+				end = len(s) - 1
+			}
+			parseAuditFields(s[1:end+1], typ, fields)
+			s = s[min(end+2, len(s)):]
+			continue
+		default:
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+			if auditHexFields[key] || typ == "EXECVE" && isExecveArg(key) {
+				value = auditHexDecode(key, value)
+			}
+		}
+		if key == "key" {
+			// Several rule keys are joined with \x01
+			value = strings.ReplaceAll(value, "\x01", ",")
+		}
+		fields[key] = value
+	}
+}
+
+func isExecveArg(key string) bool {
+	if len(key) < 2 || key[0] != 'a' || key == "argc" {
+		return false
+	}
+	_, err := strconv.Atoi(key[1:])
+	return err == nil
+}
+
+// auditHexDecode decodes a bare hex value; "(null)", "?" and plain values
+// are returned unchanged.
+func auditHexDecode(key, value string) string {
+	if len(value) < 2 || len(value)%2 != 0 {
+		return value
+	}
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		return value
+	}
+	if key == "proctitle" {
+		// argv is NUL separated
+		return strings.TrimSpace(strings.ReplaceAll(string(b), "\x00", " "))
+	}
+	return string(b)
+}
+
+// auditAssembler groups records by serial until the event is complete.
+type auditAssembler struct {
+	mu      sync.Mutex
+	pending map[uint64]*auditEvent
+}
+
+var auditEvents = &auditAssembler{pending: make(map[uint64]*auditEvent)}
+
+// add adds a record and returns the events completed by it. Records outside
+// a syscall event are complete on their own; syscall events end with EOE, or
+// are flushed when older than AUDIT_EVENT_TIMEOUT relative to the newest record.
+func (a *auditAssembler) add(r auditRecord) []auditEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var done []auditEvent
+	if !auditSyscallRecords[r.Type] {
+		if ev, ok := a.pending[r.Serial]; ok {
+			// Part of a pending syscall event (e.g. CONFIG_CHANGE with its SYSCALL)
+			ev.Records = append(ev.Records, r)
+			return done
+		}
+		return append(done, auditEvent{Serial: r.Serial, Time: r.Time, Records: []auditRecord{r}})
+	}
+
+	ev, ok := a.pending[r.Serial]
+	if !ok {
+		ev = &auditEvent{Serial: r.Serial, Time: r.Time}
+		a.pending[r.Serial] = ev
+	}
+	if r.Type == "EOE" {
+		delete(a.pending, r.Serial)
+		done = append(done, *ev)
+	} else {
+		ev.Records = append(ev.Records, r)
+	}
+	return append(done, a.expire(r.Time, len(a.pending) > AUDIT_MAX_PENDING)...)
+}
+
+// expire flushes events older than AUDIT_EVENT_TIMEOUT before now (all of
+// them when force is set), oldest first.
+func (a *auditAssembler) expire(now time.Time, force bool) []auditEvent {
+	var done []auditEvent
+	for serial, ev := range a.pending {
+		if force || now.Sub(ev.Time) > AUDIT_EVENT_TIMEOUT {
+			delete(a.pending, serial)
+			if len(ev.Records) > 0 {
+				done = append(done, *ev)
+			}
+		}
+	}
+	sort.Slice(done, func(i, j int) bool { return done[i].Serial < done[j].Serial })
+	return done
+}
+
+// flush returns every pending event.
+func (a *auditAssembler) flush(now time.Time, force bool) []auditEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.expire(now, force)
+}
+
+func (ev *auditEvent) record(typ string) *auditRecord {
+	for i := range ev.Records {
+		if ev.Records[i].Type == typ {
+			return &ev.Records[i]
+		}
+	}
+	return nil
+}
+
+// auditUser returns the enriched user name of a uid field, or the uid.
+func auditUser(r *auditRecord, field string) string {
+	if name := r.Enriched[strings.ToUpper(field)]; name != "" {
+		return name
+	}
+	return r.Fields[field]
+}
+
+// logEntry maps an event to a LogEntry.
+func (ev *auditEvent) logEntry(host string) (LogEntry, bool) {
+	if len(ev.Records) == 0 {
+		return LogEntry{}, false
+	}
+
+	// Every field of every record for rule matching; the first record wins
+	merged := make(map[string]string)
+	var types []string
+	for _, r := range ev.Records {
+		types = append(types, r.Type)
+	}
+	records := ev.Records
+	merged["type"] = ev.Records[0].Type
+	if execve := ev.record("EXECVE"); execve != nil {
+		// Its a0..aN are the arguments, the SYSCALL record's are registers
+		records = append([]auditRecord{*execve}, records...)
+		merged["type"] = "EXECVE"
+	}
+	for _, r := range records {
+		for k, v := range r.Fields {
+			if _, ok := merged[k]; !ok {
+				merged[k] = v
+			}
+		}
+	}
+
+	raw := map[string]interface{}{
+		"serial":       ev.Serial,
+		"record_types": types,
+		"audit":        merged,
+	}
+	entry := LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   host,
+		LogType:    "security",
+		Source:     "auditd",
+		Severity:   "info",
+		Timestamp:  ev.Time.Format(time.RFC3339),
+		RawData:    raw,
+	}
+
+	if sc := ev.record("SYSCALL"); sc != nil {
+		ev.syscallEntry(sc, &entry)
+		return entry, true
+	}
+
+	r := &ev.Records[0]
+	f := r.Fields
+	failed := f["res"] == "failed" || f["res"] == "0"
+	for _, k := range []string{"acct", "exe", "hostname", "addr", "terminal", "op", "res", "pid", "uid", "auid", "ses"} {
+		if v, ok := f[k]; ok && v != "?" {
+			raw[k] = v
+		}
+	}
+	if _, ok := raw["uid"]; ok {
+		raw["user"] = auditUser(r, "uid")
+	}
+
+	who := f["acct"]
+	if who == "" || who == "?" {
+		who = auditUser(r, "uid")
+	}
+	from := ""
+	if addr := f["addr"]; addr != "" && addr != "?" {
+		from = " from " + addr
+	}
+	outcome := "succeeded"
+	if failed {
+		outcome = "failed"
+	}
+
+	switch r.Type {
+	case "USER_AUTH", "USER_LOGIN", "USER_ACCT", "USER_CHAUTHTOK", "GRP_AUTH":
+		entry.Event = map[string]string{
+			"USER_AUTH": "user_auth", "USER_LOGIN": "user_login", "USER_ACCT": "user_account",
+			"USER_CHAUTHTOK": "password_change", "GRP_AUTH": "group_auth",
+		}[r.Type]
+		entry.Message = fmt.Sprintf("%s %s for %s%s (%s)", strings.ReplaceAll(entry.Event, "_", " "), outcome, who, from, f["exe"])
+		if failed {
+			entry.Severity = "high"
+		}
+	case "USER_CMD":
+		entry.Event = "user_command"
+		raw["command_line"] = f["cmd"]
+		raw["current_directory"] = f["cwd"]
+		entry.Message = fmt.Sprintf("%s ran via %s: %s", who, f["exe"], f["cmd"])
+	case "ADD_USER", "DEL_USER", "ADD_GROUP", "DEL_GROUP", "USER_MGMT", "CHUSER_ID":
+		entry.Event = "account_change"
+		entry.Severity = "warning"
+		entry.Message = fmt.Sprintf("Account change %s (%s) %s: %s", r.Type, f["op"], outcome, who)
+	case "CONFIG_CHANGE", "USYS_CONFIG":
+		entry.Event = "audit_config_change"
+		entry.Severity = "warning"
+		entry.Message = fmt.Sprintf("Audit configuration changed: %s", auditSummary(f))
+	case "ANOM_PROMISCUOUS", "ANOM_ABEND", "ANOM_LINK", "AVC", "USER_AVC", "SECCOMP":
+		entry.Event = "audit_" + strings.ToLower(r.Type)
+		entry.Severity = "warning"
+		entry.Message = fmt.Sprintf("Audit %s: %s", r.Type, auditSummary(f))
+	default:
+		entry.Event = "audit_" + strings.ToLower(r.Type)
+		entry.LogType = "system"
+		entry.Message = fmt.Sprintf("Audit %s: %s", r.Type, auditSummary(f))
+	}
+	return entry, true
+}
+
+// syscallEntry fills entry from a syscall event: execve becomes a process
+// start, a keyed event with PATH records a file watch hit.
+func (ev *auditEvent) syscallEntry(sc *auditRecord, entry *LogEntry) {
+	f := sc.Fields
+	raw := entry.RawData
+
+	syscall := sc.Enriched["SYSCALL"]
+	if syscall == "" {
+		syscall = f["syscall"]
+		if name, ok := auditSyscallNames[f["arch"]][syscall]; ok {
+			syscall = name
+		}
+	}
+	raw["syscall"] = syscall
+	raw["success"] = f["success"]
+	raw["exit"] = f["exit"]
+	raw["process_id"] = f["pid"]
+	raw["parent_process_id"] = f["ppid"]
+	raw["process_name"] = f["comm"]
+	raw["image"] = f["exe"]
+	raw["uid"] = f["uid"]
+	raw["user"] = auditUser(sc, "uid")
+	raw["login_uid"] = f["auid"]
+	raw["login_user"] = auditUser(sc, "auid")
+	raw["euid"] = f["euid"]
+	raw["tty"] = f["tty"]
+	raw["session"] = f["ses"]
+	if key := f["key"]; key != "" && key != "(null)" {
+		raw["key"] = key
+	}
+	if cwd := ev.record("CWD"); cwd != nil {
+		raw["current_directory"] = cwd.Fields["cwd"]
+	}
+	if pt := ev.record("PROCTITLE"); pt != nil {
+		raw["proctitle"] = pt.Fields["proctitle"]
+	}
+
+	var paths []string
+	for _, r := range ev.Records {
+		if r.Type == "PATH" && r.Fields["nametype"] != "PARENT" && r.Fields["name"] != "(null)" {
+			paths = append(paths, r.Fields["name"])
+		}
+	}
+	if len(paths) > 0 {
+		raw["paths"] = paths
+	}
+
+	if execve := ev.record("EXECVE"); execve != nil {
+		argc, _ := strconv.Atoi(execve.Fields["argc"])
+		if argc > AUDIT_MAX_EXECVE_ARG {
+			argc = AUDIT_MAX_EXECVE_ARG
+		}
+		args := make([]string, 0, argc)
+		for i := 0; i < argc; i++ {
+			args = append(args, execve.Fields[fmt.Sprintf("a%d", i)])
+		}
+		cmdline := strings.Join(args, " ")
+		raw["command_line"] = cmdline
+
+		entry.LogType = "process"
+		entry.Event = "process_start"
+		entry.Message = fmt.Sprintf("Process started: %s (pid %s, user %s)", cmdline, f["pid"], raw["user"])
+		return
+	}
+
+	key, _ := raw["key"].(string)
+	switch {
+	case key != "" && len(paths) > 0:
+		entry.Event = "file_watch"
+		entry.Message = fmt.Sprintf("Audit watch %s: %s %s %s (success=%s)", key, f["comm"], syscall, strings.Join(paths, ", "), f["success"])
+	default:
+		entry.Event = "audit_syscall"
+		entry.Message = fmt.Sprintf("Audit %s by %s (success=%s)", syscall, f["exe"], f["success"])
+		if key != "" {
+			entry.Message = fmt.Sprintf("Audit %s by %s (key %s, success=%s)", syscall, f["exe"], key, f["success"])
+		}
+	}
+	if f["success"] == "no" && key != "" {
+		entry.Severity = "warning"
+	}
+}
+
+// auditSummary formats the fields of a record in a stable order.
+func auditSummary(f map[string]string) string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+f[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// readAuditLog feeds every line of an audit log through the assembler.
+func readAuditLog(r io.Reader, fn func(auditEvent) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var last time.Time
+	for scanner.Scan() {
+		rec, ok := parseAuditLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		last = rec.Time
+		for _, ev := range auditEvents.add(rec) {
+			if !fn(ev) {
+				return nil
+			}
+		}
+	}
+	for _, ev := range auditEvents.flush(last, true) {
+		if !fn(ev) {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+// replayAudit prints the LogEntry stream for an audit.log file.
+func replayAudit(path string, out io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	replayOutput = out
+	defer func() { replayOutput = nil }()
+
+	host := getHostname()
+	count := 0
+	err = readAuditLog(f, func(ev auditEvent) bool {
+		if entry, ok := ev.logEntry(host); ok {
+			sendLog(entry)
+			count++
+		}
+		return true
+	})
+	logMessage(fmt.Sprintf("Replayed %d audit events from %s", count, path))
+	return err
+}
@@ -0,0 +1,239 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Linux audit collection: tails /var/log/audit/audit.log when auditd runs,
+// otherwise listens on the audit netlink read-only multicast group (kernel
+// 3.16+, CAP_AUDIT_READ), which works next to any other audit daemon.
+
+const auditNetlinkGroupReadLog = 1 // AUDIT_NLGRP_READLOG
+
+var (
+	auditStartOnce sync.Once
+	auditNetlink   bool
+)
+
+// collectAuditLog sends new audit events; called from collectSystemLogs.
+func collectAuditLog() {
+	auditStartOnce.Do(func() {
+		if agentConfig.AuditBaselineRules {
+			installAuditRules()
+		}
+		if _, err := os.Stat(AUDIT_LOG_FILE); err != nil {
+			auditNetlink = true
+			go listenAuditNetlink()
+		}
+	})
+	if auditNetlink {
+		return
+	}
+
+	var prev syslogOffset
+	known := false
+	if data := loadBookmark(AUDIT_OFFSET_FILE); data != "" {
+		known = json.Unmarshal([]byte(data), &prev) == nil
+	}
+
+	f, inode, err := openSyslogFile(AUDIT_LOG_FILE)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+
+	host := getHostname()
+	limit := logCatchUpLimit()
+	start := prev.Offset
+	sent := 0
+	switch {
+	case !known:
+		start = tailOffset(f, info.Size(), LOG_INITIAL_ENTRIES)
+	case prev.Inode != inode:
+		// auditd rotates to audit.log.1: finish the old file first
+		if rf, rinode, err := openSyslogFile(AUDIT_LOG_FILE + ".1"); err == nil && rinode == prev.Inode {
+			offset, n, eof, _ := readAuditLines(rf, prev.Offset, host, limit)
+			rf.Close()
+			sent += n
+			if !eof {
+				saveAuditOffset(prev.Inode, offset)
+				return
+			}
+		} else {
+			reportLogGap("auditd", "security", "file rotated past bookmark", map[string]interface{}{
+				"file":     AUDIT_LOG_FILE,
+				"bookmark": prev.Offset,
+			})
+		}
+		start = 0
+	case prev.Offset > info.Size():
+		reportLogGap("auditd", "security", "file truncated below bookmark", map[string]interface{}{
+			"file":     AUDIT_LOG_FILE,
+			"bookmark": prev.Offset,
+			"size":     info.Size(),
+		})
+		start = 0
+	}
+
+	offset, _, eof, last := readAuditLines(f, start, host, limit-sent)
+	saveAuditOffset(inode, offset)
+
+	// Syscall events whose EOE never came. When the limit stopped reading,
+	// the rest of a pending event may be in the unread part: only events
+	// already stale at the last record read are complete.
+	now := time.Now()
+	if !eof {
+		now = last
+	}
+	sendAuditEvents(auditEvents.flush(now, false), host)
+}
+
+func saveAuditOffset(inode uint64, offset int64) {
+	data, _ := json.Marshal(syslogOffset{Inode: inode, Offset: offset})
+	saveBookmark(AUDIT_OFFSET_FILE, string(data))
+}
+
+// readAuditLines assembles complete lines from start and sends up to limit
+// events. It returns the offset after the last line read, whether the end
+// of the file was reached and the time of the last record read.
+func readAuditLines(f *os.File, start int64, host string, limit int) (int64, int, bool, time.Time) {
+	f.Seek(start, io.SeekStart)
+	reader := bufio.NewReader(f)
+	offset := start
+	sent := 0
+	var last time.Time
+	for sent < limit {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// Partial last line is re-read once it is complete
+			return offset, sent, true, last
+		}
+		offset += int64(len(line))
+		if rec, ok := parseAuditLine(line); ok {
+			last = rec.Time
+			sent += sendAuditEvents(auditEvents.add(rec), host)
+		}
+	}
+	return offset, sent, false, last
+}
+
+func sendAuditEvents(events []auditEvent, host string) int {
+	sent := 0
+	for _, ev := range events {
+		if entry, ok := ev.logEntry(host); ok {
+			sendLog(entry)
+			sent++
+		}
+	}
+	return sent
+}
+
+// listenAuditNetlink receives audit records from the kernel as they happen.
+func listenAuditNetlink() {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_AUDIT)
+	if err != nil {
+		logMessage("Audit netlink unavailable: " + err.Error())
+		return
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: auditNetlinkGroupReadLog}); err != nil {
+		logMessage("Audit netlink bind failed (needs CAP_AUDIT_READ): " + err.Error())
+		return
+	}
+	tv := unix.NsecToTimeval((5 * time.Second).Nanoseconds())
+	unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	logMessage("Audit events: listening on netlink")
+
+	host := getHostname()
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil && err != unix.EAGAIN && err != unix.EINTR {
+			logMessage("Audit netlink receive failed: " + err.Error())
+			return
+		}
+
+		var events []auditEvent
+		if n > 0 {
+			msgs, _, _ := parseNetlinkMessages(append([]byte(nil), buf[:n]...))
+			for _, msg := range msgs {
+				typ, ok := auditTypeNames[msg.Type]
+				if !ok {
+					continue
+				}
+				if rec, ok := parseAuditRecord(typ, strings.TrimRight(string(msg.Payload), "\x00\n")); ok {
+					events = append(events, auditEvents.add(rec)...)
+				}
+			}
+		}
+		events = append(events, auditEvents.flush(time.Now(), false)...)
+
+		policyMutex.RLock()
+		quarantined := isQuarantined
+		policyMutex.RUnlock()
+		if deviceID != "" && !quarantined {
+			sendAuditEvents(events, host)
+		}
+	}
+}
+
+// installAuditRules adds the baseline rules that are not loaded yet.
+func installAuditRules() {
+	if _, err := exec.LookPath("auditctl"); err != nil {
+		logMessage("Audit baseline rules not installed: auditctl not found")
+		return
+	}
+	out, err := runCommandWithTimeout("auditctl", "-l")
+	if err != nil {
+		logMessage("Audit baseline rules not installed: " + err.Error())
+		return
+	}
+	loaded := strings.Split(string(out), "\n")
+
+	added := 0
+	for _, rule := range auditBaselineRules {
+		if auditRuleLoaded(loaded, rule) {
+			continue
+		}
+		if _, err := runCommandWithTimeout("auditctl", rule...); err != nil {
+			logMessage("Audit rule " + strings.Join(rule, " ") + " failed: " + err.Error())
+			continue
+		}
+		added++
+	}
+	if added > 0 {
+		logMessage(fmt.Sprintf("Installed %d audit baseline rules", added))
+	}
+}
+
+// auditRuleLoaded looks for rule in `auditctl -l` output, which prints
+// watches as "-w <path> -p wa -k <key>" and syscall rules as
+// "-a always,exit -F arch=b64 -S <syscalls> -F key=<key>".
+func auditRuleLoaded(loaded []string, rule []string) bool {
+	key := rule[len(rule)-1]
+	for _, line := range loaded {
+		if !strings.Contains(line, "-k "+key) && !strings.Contains(line, "key="+key) {
+			continue
+		}
+		if rule[0] == "-w" && strings.Contains(line, "-w "+rule[1]+" ") {
+			return true
+		}
+		if rule[0] == "-a" && strings.Contains(line, rule[3]) && strings.Contains(line, "-S "+rule[5]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAuditLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		typ      string
+		serial   uint64
+		fields   map[string]string
+		enriched map[string]string
+	}{
+		{
+			"quoted and bare",
+			`type=SYSCALL msg=audit(1700000000.123:4567): arch=c000003e syscall=59 success=yes comm="ls" exe="/usr/bin/ls" key="exec"`,
+			"SYSCALL", 4567,
+			map[string]string{"arch": "c000003e", "syscall": "59", "success": "yes", "comm": "ls", "exe": "/usr/bin/ls", "key": "exec"},
+			nil,
+		},
+		{
+			"hex proctitle and exe",
+			`type=PROCTITLE msg=audit(1700000000.123:4567): proctitle=2F62696E2F6C73002D6C61 exe=2F746D702F6D7920746F6F6C`,
+			"PROCTITLE", 4567,
+			map[string]string{"proctitle": "/bin/ls -la", "exe": "/tmp/my tool"},
+			nil,
+		},
+		{
+			"execve arguments",
+			`type=EXECVE msg=audit(1700000000.123:4567): argc=3 a0="sh" a1="-c" a2=6563686F20686920746865726521`,
+			"EXECVE", 4567,
+			map[string]string{"argc": "3", "a0": "sh", "a1": "-c", "a2": "echo hi there!"},
+			nil,
+		},
+		{
+			"enriched section",
+			"type=SYSCALL msg=audit(1700000000.500:10): syscall=59 uid=1000 auid=1000\x1dSYSCALL=execve UID=\"alice\" AUID=\"alice\"",
+			"SYSCALL", 10,
+			map[string]string{"syscall": "59", "uid": "1000", "auid": "1000"},
+			map[string]string{"SYSCALL": "execve", "UID": "alice", "AUID": "alice"},
+		},
+		{
+			"user message fields",
+			`type=USER_AUTH msg=audit(1700000001.000:20): pid=812 uid=0 auid=4294967295 ses=4294967295 msg='op=PAM:authentication grantors=? acct="bob" exe="/usr/sbin/sshd" hostname=10.0.0.5 addr=10.0.0.5 terminal=ssh res=failed'`,
+			"USER_AUTH", 20,
+			map[string]string{"pid": "812", "uid": "0", "op": "PAM:authentication", "acct": "bob", "exe": "/usr/sbin/sshd", "addr": "10.0.0.5", "terminal": "ssh", "res": "failed"},
+			nil,
+		},
+		{
+			"syslog prefix",
+			`Mar  1 12:00:00 web1 audispd: node=web1 type=EOE msg=audit(1700000002.000:30): `,
+			"EOE", 30,
+			map[string]string{},
+			nil,
+		},
+		{
+			"joined rule keys",
+			`type=SYSCALL msg=audit(1700000003.000:40): syscall=2 key=6964656E746974790170726976696C656765`,
+			"SYSCALL", 40,
+			map[string]string{"syscall": "2", "key": "identity,privilege"},
+			nil,
+		},
+		{
+			"null and odd values stay",
+			`type=PATH msg=audit(1700000003.000:41): name=(null) cwd=abc inode=?`,
+			"PATH", 41,
+			map[string]string{"name": "(null)", "cwd": "abc", "inode": "?"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := parseAuditLine(tt.line + "\n")
+			if !ok {
+				t.Fatal("line not parsed")
+			}
+			if r.Type != tt.typ || r.Serial != tt.serial {
+				t.Errorf("got %s:%d, want %s:%d", r.Type, r.Serial, tt.typ, tt.serial)
+			}
+			for k, want := range tt.fields {
+				if got := r.Fields[k]; got != want {
+					t.Errorf("field %s = %q, want %q", k, got, want)
+				}
+			}
+			for k, want := range tt.enriched {
+				if got := r.Enriched[k]; got != want {
+					t.Errorf("enriched %s = %q, want %q", k, got, want)
+				}
+			}
+			if tt.enriched == nil && r.Enriched != nil {
+				t.Errorf("unexpected enriched fields %v", r.Enriched)
+			}
+		})
+	}
+
+	r, _ := parseAuditLine(`type=SYSCALL msg=audit(1700000000.123:1): syscall=59`)
+	if want := time.Unix(1700000000, 123*int64(time.Millisecond)).UTC(); !r.Time.Equal(want) {
+		t.Errorf("time = %v, want %v", r.Time, want)
+	}
+
+	for _, line := range []string{
+		"",
+		"random text",
+		"type=SYSCALL msg=garbage",
+		"node=web1 syscall=59",
+	} {
+		if _, ok := parseAuditLine(line); ok {
+			t.Errorf("parseAuditLine(%q) accepted", line)
+		}
+	}
+}
+
+func auditTestRecord(t *testing.T, line string) auditRecord {
+	t.Helper()
+	r, ok := parseAuditLine(line)
+	if !ok {
+		t.Fatalf("line not parsed: %s", line)
+	}
+	return r
+}
+
+func TestAuditAssembler(t *testing.T) {
+	a := &auditAssembler{pending: make(map[uint64]*auditEvent)}
+
+	// Syscall records wait for their EOE
+	for _, line := range []string{
+		`type=SYSCALL msg=audit(1700000000.000:1): syscall=59 pid=100`,
+		`type=EXECVE msg=audit(1700000000.000:1): argc=1 a0="ls"`,
+		`type=CWD msg=audit(1700000000.000:1): cwd="/root"`,
+	} {
+		if done := a.add(auditTestRecord(t, line)); len(done) != 0 {
+			t.Fatalf("event completed before EOE: %v", done)
+		}
+	}
+	done := a.add(auditTestRecord(t, `type=EOE msg=audit(1700000000.000:1): `))
+	if len(done) != 1 || done[0].Serial != 1 || len(done[0].Records) != 3 {
+		t.Fatalf("EOE: got %+v", done)
+	}
+
+	// Records outside a syscall event are complete on their own
+	done = a.add(auditTestRecord(t, `type=USER_LOGIN msg=audit(1700000000.500:2): pid=1 res=success`))
+	if len(done) != 1 || done[0].Serial != 2 {
+		t.Fatalf("USER_LOGIN: got %+v", done)
+	}
+
+	// An event without EOE is flushed once a record is AUDIT_EVENT_TIMEOUT newer
+	a.add(auditTestRecord(t, `type=SYSCALL msg=audit(1700000001.000:3): syscall=2 key="identity"`))
+	a.add(auditTestRecord(t, `type=PATH msg=audit(1700000001.000:3): name="/etc/passwd" nametype=NORMAL`))
+	if done := a.add(auditTestRecord(t, `type=SYSCALL msg=audit(1700000002.000:4): syscall=2`)); len(done) != 0 {
+		t.Fatalf("flushed before timeout: %+v", done)
+	}
+	done = a.add(auditTestRecord(t, `type=SYSCALL msg=audit(1700000005.000:5): syscall=2`))
+	if len(done) != 1 || done[0].Serial != 3 || len(done[0].Records) != 2 {
+		t.Fatalf("timeout: got %+v", done)
+	}
+
+	// Flushing by the last record read keeps events whose rest is still unread
+	if done := a.flush(time.Unix(1700000005, 0), false); len(done) != 0 {
+		t.Fatalf("flush by last record flushed recent events: %+v", done)
+	}
+	if done := a.flush(time.Unix(1700000010, 0), false); len(done) != 2 || done[0].Serial != 4 || done[1].Serial != 5 {
+		t.Fatalf("flush by clock: got %+v", done)
+	}
+	if len(a.pending) != 0 {
+		t.Errorf("%d events still pending", len(a.pending))
+	}
+}
+
+func TestAuditLogEntry(t *testing.T) {
+	log := strings.Join([]string{
+		"type=SYSCALL msg=audit(1700000000.000:1): arch=c000003e syscall=59 success=yes exit=0 pid=100 ppid=1 uid=1000 auid=1000 comm=\"curl\" exe=\"/usr/bin/curl\" key=\"exec\"\x1dSYSCALL=execve UID=\"alice\" AUID=\"alice\"",
+		`type=EXECVE msg=audit(1700000000.000:1): argc=2 a0="curl" a1="http://example.com"`,
+		`type=CWD msg=audit(1700000000.000:1): cwd="/home/alice"`,
+		`type=EOE msg=audit(1700000000.000:1): `,
+		`type=USER_AUTH msg=audit(1700000001.000:2): pid=812 uid=0 msg='op=PAM:authentication acct="bob" exe="/usr/sbin/sshd" addr=10.0.0.5 terminal=ssh res=failed'`,
+		`type=SYSCALL msg=audit(1700000002.000:3): arch=c000003e syscall=257 success=no exit=-13 pid=200 uid=1000 comm="vi" exe="/usr/bin/vi" key="identity"`,
+		`type=PATH msg=audit(1700000002.000:3): item=0 name="/etc/" nametype=PARENT`,
+		`type=PATH msg=audit(1700000002.000:3): item=1 name="/etc/shadow" nametype=NORMAL`,
+		// The last event has no EOE and is flushed at the end of the log
+		`type=SYSCALL msg=audit(1700000003.000:4): arch=c000003e syscall=101 success=yes pid=300 exe="/usr/bin/gdb"`,
+	}, "\n")
+
+	auditEvents = &auditAssembler{pending: make(map[uint64]*auditEvent)}
+	var entries []LogEntry
+	if err := readAuditLog(strings.NewReader(log), func(ev auditEvent) bool {
+		if e, ok := ev.logEntry("host"); ok {
+			entries = append(entries, e)
+		}
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		event    string
+		logType  string
+		severity string
+	}{
+		{"process_start", "process", "info"},
+		{"user_auth", "security", "high"},
+		{"file_watch", "security", "warning"},
+		{"audit_syscall", "security", "info"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.Event != w.event || e.LogType != w.logType || e.Severity != w.severity {
+			t.Errorf("entry %d: got %s/%s/%s, want %s/%s/%s", i, e.Event, e.LogType, e.Severity, w.event, w.logType, w.severity)
+		}
+	}
+
+	exec := entries[0].RawData
+	if exec["command_line"] != "curl http://example.com" || exec["user"] != "alice" || exec["syscall"] != "execve" || exec["current_directory"] != "/home/alice" {
+		t.Errorf("process_start raw data: %v", exec)
+	}
+	if !strings.Contains(entries[1].Message, "failed for bob from 10.0.0.5") {
+		t.Errorf("user_auth message: %s", entries[1].Message)
+	}
+	if paths, _ := entries[2].RawData["paths"].([]string); len(paths) != 1 || paths[0] != "/etc/shadow" {
+		t.Errorf("file_watch paths: %v", entries[2].RawData["paths"])
+	}
+	if entries[3].RawData["syscall"] != "ptrace" {
+		t.Errorf("syscall name: %v", entries[3].RawData["syscall"])
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication analytics. Logon events from the Windows Security log,
+// journald/auth.log (sshd, sudo, su, login) and auditd are normalized into
+// user, source IP, logon type and outcome (added to the event as raw_data
+// "auth"), and correlated into one alert per incident: brute force,
+// password spraying, logons at hours the user never logs on, and additions
+// to administrator / sudo groups.
+
+const (
+	AUTH_WINDOW              = 10 * time.Minute
+	AUTH_INCIDENT_COOLDOWN   = 30 * time.Minute
+	AUTH_BRUTE_FORCE_FAILS   = 10 // failures for one user from one source
+	AUTH_SPRAY_USERS         = 5  // distinct users failing from one source
+	AUTH_BASELINE_MIN_LOGONS = 20 // logons before a user's hours are trusted
+	AUTH_BASELINE_FILE       = "auth-baseline.json"
+	AUTH_BASELINE_SAVE_EVERY = 5 * time.Minute // a sudo loop must not rewrite the file per call
+	AUTH_MAX_SAMPLES         = 20
+)
+
+// authEvent is a normalized logon.
+type authEvent struct {
+	User      string
+	SourceIP  string
+	LogonType string
+	Outcome   string // "success" or "failure"
+	Source    string
+	Time      time.Time
+}
+
+// Windows logon types (4624/4625 LogonType)
+var windowsLogonTypes = map[string]string{
+	"2": "interactive", "3": "network", "4": "batch", "5": "service", "7": "unlock",
+	"8": "network_cleartext", "9": "new_credentials", "10": "remote_interactive", "11": "cached_interactive",
+}
+
+// Logon types a person sits behind, checked against the user's usual hours
+var interactiveLogonTypes = map[string]bool{
+	"interactive": true, "remote_interactive": true, "cached_interactive": true, "unlock": true,
+	"ssh": true, "console": true, "su": true, "sudo": true,
+}
+
+// Groups whose new members are reported
+var privilegedGroups = map[string]bool{
+	"administrators": true, "domain admins": true, "enterprise admins": true, "schema admins": true,
+	"backup operators": true, "remote desktop users": true, "hyper-v administrators": true,
+	"sudo": true, "wheel": true, "admin": true, "root": true, "docker": true, "lxd": true,
+}
+
+var (
+	sshFailedRegex   = regexp.MustCompile(`^Failed (\S+) for (?:invalid user )?(\S+) from (\S+) port \d+`)
+	sshAcceptedRegex = regexp.MustCompile(`^Accepted (\S+) for (\S+) from (\S+) port \d+`)
+	sshInvalidRegex  = regexp.MustCompile(`^Invalid user (\S*) from (\S+)`)
+	pamFailureRegex  = regexp.MustCompile(`authentication failure;.*?(?:rhost=(\S*))?\s+user=(\S+)`)
+	sudoRegex        = regexp.MustCompile(`^\s*(\S+) : (?:(\d+) incorrect password attempts? ; )?TTY=\S+ ; PWD=\S+ ; USER=(\S+) ; COMMAND=`)
+	suRegex          = regexp.MustCompile(`^(?:\(to (\S+)\) (\S+) on|pam_unix\(su(?:-l)?:session\): session opened for user (\S+?)(?:\(uid=\d+\))? by (\S+?)(?:\(uid=\d+\))?$)`)
+	loginRegex       = regexp.MustCompile(`^(?:ROOT )?LOGIN ON (\S+) BY (\S+)`)
+	usermodRegex     = regexp.MustCompile(`^add '([^']+)' to (?:shadow )?group '([^']+)'`)
+	gpasswdRegex     = regexp.MustCompile(`^user (\S+) added by (\S+) to group (\S+)`)
+)
+
+// authAnalyzer correlates normalized logons.
+type authAnalyzer struct {
+	mu sync.Mutex
+	// "user|ip" -> failure times
+	failures map[string][]time.Time
+	// source ip -> user -> last failure
+	sprays map[string]map[string]time.Time
+	// incident key -> last alert
+	alerted map[string]time.Time
+	// "user|ip|outcome" -> last logon, to count a logon seen by both auditd and syslog once
+	lastSeen map[string]authEvent
+	// user -> logons per local hour of day
+	hours        map[string]*[24]int
+	hoursLoaded  bool
+	hoursDirty   bool
+	hoursSavedAt time.Time
+}
+
+var authAnalytics = &authAnalyzer{
+	failures: make(map[string][]time.Time),
+	sprays:   make(map[string]map[string]time.Time),
+	alerted:  make(map[string]time.Time),
+	lastSeen: make(map[string]authEvent),
+	hours:    make(map[string]*[24]int),
+}
+
+// observe normalizes entry (adding raw_data "auth") and returns the alerts it triggers.
+func (a *authAnalyzer) observe(entry *LogEntry) []LogEntry {
+	if entry.Source == "auth-analytics" || entry.Event == "detection" {
+		return nil
+	}
+	if alert, ok := privilegedGroupChange(*entry); ok {
+		return []LogEntry{alert}
+	}
+	ev, ok := normalizeAuthEvent(*entry)
+	if !ok {
+		return nil
+	}
+
+	if entry.RawData == nil {
+		entry.RawData = make(map[string]interface{})
+	}
+	entry.RawData["auth"] = map[string]string{
+		"user":       ev.User,
+		"source_ip":  ev.SourceIP,
+		"logon_type": ev.LogonType,
+		"outcome":    ev.Outcome,
+	}
+	if entry.Event == "" {
+		entry.Event = "logon_" + ev.Outcome
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	seenKey := ev.User + "|" + ev.SourceIP + "|" + ev.Outcome
+	if prev, ok := a.lastSeen[seenKey]; ok && prev.Source != ev.Source && ev.Time.Sub(prev.Time).Abs() < 2*time.Second {
+		return nil
+	}
+	a.lastSeen[seenKey] = ev
+	for k, e := range a.lastSeen {
+		if ev.Time.Sub(e.Time) > time.Minute {
+			delete(a.lastSeen, k)
+		}
+	}
+
+	if ev.Outcome == "failure" {
+		return a.observeFailure(ev, entry.Hostname)
+	}
+	return a.observeSuccess(ev, entry.Hostname)
+}
+
+func (a *authAnalyzer) observeFailure(ev authEvent, host string) []LogEntry {
+	var alerts []LogEntry
+	key := ev.User + "|" + ev.SourceIP
+	times := append(pruneAuthTimes(a.failures[key], ev.Time), ev.Time)
+	a.failures[key] = times
+	if len(a.failures)+len(a.sprays) > 1024 {
+		a.prune(ev.Time)
+	}
+
+	if len(times) >= AUTH_BRUTE_FORCE_FAILS && a.markIncident("brute_force:"+key, ev.Time) {
+		msg := fmt.Sprintf("Brute force against %s from %s: %d failed logons in %s", ev.User, authSourceText(ev.SourceIP), len(times), AUTH_WINDOW)
+		alerts = append(alerts, authAlert(host, ev, "brute_force", "high", msg, map[string]interface{}{
+			"failed_attempts": len(times),
+			"first_seen":      times[0].UTC().Format(time.RFC3339),
+			"last_seen":       ev.Time.UTC().Format(time.RFC3339),
+		}))
+	}
+
+	if ev.SourceIP == "" {
+		return alerts
+	}
+	users := a.sprays[ev.SourceIP]
+	if users == nil {
+		users = make(map[string]time.Time)
+		a.sprays[ev.SourceIP] = users
+	}
+	users[ev.User] = ev.Time
+	for u, t := range users {
+		if ev.Time.Sub(t) > AUTH_WINDOW {
+			delete(users, u)
+		}
+	}
+	if len(users) >= AUTH_SPRAY_USERS && a.markIncident("spray:"+ev.SourceIP, ev.Time) {
+		targets := make([]string, 0, len(users))
+		for u := range users {
+			targets = append(targets, u)
+		}
+		sort.Strings(targets)
+		if len(targets) > AUTH_MAX_SAMPLES {
+			targets = targets[:AUTH_MAX_SAMPLES]
+		}
+		msg := fmt.Sprintf("Password spraying from %s: %d accounts failed in %s", ev.SourceIP, len(users), AUTH_WINDOW)
+		alerts = append(alerts, authAlert(host, ev, "password_spray", "high", msg, map[string]interface{}{
+			"target_users":   targets,
+			"user_count":     len(users),
+			"window_minutes": int(AUTH_WINDOW.Minutes()),
+		}))
+	}
+	return alerts
+}
+
+func (a *authAnalyzer) observeSuccess(ev authEvent, host string) []LogEntry {
+	var alerts []LogEntry
+	key := ev.User + "|" + ev.SourceIP
+
+	// A success right after a brute force incident is the incident escalating
+	failures := pruneAuthTimes(a.failures[key], ev.Time)
+	if len(failures) >= AUTH_BRUTE_FORCE_FAILS && a.markIncident("brute_force_success:"+key, ev.Time) {
+		msg := fmt.Sprintf("Successful logon for %s from %s after %d failed attempts", ev.User, authSourceText(ev.SourceIP), len(failures))
+		alerts = append(alerts, authAlert(host, ev, "brute_force_success", "critical", msg, map[string]interface{}{
+			"failed_attempts": len(failures),
+			"first_seen":      failures[0].UTC().Format(time.RFC3339),
+		}))
+	}
+	delete(a.failures, key)
+
+	if !interactiveLogonTypes[ev.LogonType] {
+		return alerts
+	}
+	a.loadBaseline()
+	hours := a.hours[ev.User]
+	if hours == nil {
+		hours = new([24]int)
+		a.hours[ev.User] = hours
+	}
+	hour := ev.Time.Local().Hour()
+	total := 0
+	for _, n := range hours {
+		total += n
+	}
+	usual := hours[hour] > 0 || hours[(hour+23)%24] > 0 || hours[(hour+1)%24] > 0
+	if total >= AUTH_BASELINE_MIN_LOGONS && !usual && a.markIncident(fmt.Sprintf("unusual_hour:%s:%d", ev.User, hour), ev.Time) {
+		msg := fmt.Sprintf("Logon by %s at %02d:00, outside the hours this account normally logs on", ev.User, hour)
+		alerts = append(alerts, authAlert(host, ev, "unusual_logon_hour", "warning", msg, map[string]interface{}{
+			"hour":           hour,
+			"baseline_hours": usualHours(hours),
+			"baseline_count": total,
+		}))
+	}
+	hours[hour]++
+	a.hoursDirty = true
+	a.saveBaseline()
+	return alerts
+}
+
+// markIncident reports whether key is a new incident and starts its cooldown.
+func (a *authAnalyzer) markIncident(key string, now time.Time) bool {
+	if last, ok := a.alerted[key]; ok && now.Sub(last) < AUTH_INCIDENT_COOLDOWN {
+		return false
+	}
+	a.alerted[key] = now
+	for k, t := range a.alerted {
+		if now.Sub(t) > AUTH_INCIDENT_COOLDOWN {
+			delete(a.alerted, k)
+		}
+	}
+	return true
+}
+
+// prune drops windows without recent failures (sources that went quiet).
+func (a *authAnalyzer) prune(now time.Time) {
+	for k, times := range a.failures {
+		if times = pruneAuthTimes(times, now); len(times) == 0 {
+			delete(a.failures, k)
+		} else {
+			a.failures[k] = times
+		}
+	}
+	for ip, users := range a.sprays {
+		for u, t := range users {
+			if now.Sub(t) > AUTH_WINDOW {
+				delete(users, u)
+			}
+		}
+		if len(users) == 0 {
+			delete(a.sprays, ip)
+		}
+	}
+}
+
+func pruneAuthTimes(times []time.Time, now time.Time) []time.Time {
+	kept := times[:0]
+	for _, t := range times {
+		if now.Sub(t) <= AUTH_WINDOW {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+func (a *authAnalyzer) loadBaseline() {
+	if a.hoursLoaded {
+		return
+	}
+	a.hoursLoaded = true
+	data, err := os.ReadFile(filepath.Join(agentDir, AUTH_BASELINE_FILE))
+	if err != nil {
+		return
+	}
+	stored := make(map[string]*[24]int)
+	if json.Unmarshal(data, &stored) == nil {
+		a.hours = stored
+	}
+}
+
+// saveBaseline writes changed hour counts, at most every AUTH_BASELINE_SAVE_EVERY.
+func (a *authAnalyzer) saveBaseline() {
+	if replayOutput != nil {
+		return // replays must not teach the live baseline
+	}
+	if !a.hoursDirty || time.Since(a.hoursSavedAt) < AUTH_BASELINE_SAVE_EVERY {
+		return
+	}
+	data, _ := json.Marshal(a.hours)
+	if os.WriteFile(filepath.Join(agentDir, AUTH_BASELINE_FILE), data, 0644) == nil {
+		a.hoursDirty = false
+		a.hoursSavedAt = time.Now()
+	}
+}
+
+// flushBaseline writes hour counts held back by the throttle once it expires.
+// Called from the log collection loop so the last logons of a burst are kept.
+func (a *authAnalyzer) flushBaseline() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.saveBaseline()
+}
+
+func usualHours(hours *[24]int) []int {
+	var out []int
+	for h, n := range hours {
+		if n > 0 {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+func authSourceText(ip string) string {
+	if ip == "" {
+		return "local"
+	}
+	return ip
+}
+
+func authAlert(host string, ev authEvent, event, severity, msg string, raw map[string]interface{}) LogEntry {
+	logMessage("⚠️ " + msg)
+	raw["user"] = ev.User
+	raw["source_ip"] = ev.SourceIP
+	raw["logon_type"] = ev.LogonType
+	raw["event_source"] = ev.Source
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   host,
+		LogType:    "security",
+		Event:      event,
+		Source:     "auth-analytics",
+		Severity:   severity,
+		Message:    msg,
+		Timestamp:  ev.Time.UTC().Format(time.RFC3339),
+		RawData:    raw,
+	}
+}
+
+// ignoredAuthUser filters machine and built-in service accounts.
+func ignoredAuthUser(user string) bool {
+	u := strings.ToUpper(user)
+	return u == "" || u == "-" || strings.HasSuffix(u, "$") || u == "SYSTEM" || u == "LOCAL SERVICE" ||
+		u == "NETWORK SERVICE" || u == "ANONYMOUS LOGON" || strings.HasPrefix(u, "DWM-") || strings.HasPrefix(u, "UMFD-")
+}
+
+func authEntryTime(entry LogEntry) time.Time {
+	if t, err := time.Parse(time.RFC3339, entry.Timestamp); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// normalizeAuthEvent extracts a logon from a Windows Security, journald /
+// syslog or auditd entry.
+func normalizeAuthEvent(entry LogEntry) (authEvent, bool) {
+	ev := authEvent{Source: entry.Source, Time: authEntryTime(entry)}
+
+	switch {
+	case entry.Source == "WinEventLog-Security":
+		fields, _ := entry.RawData["event_data"].(map[string]string)
+		if fields == nil {
+			return ev, false
+		}
+		switch fmt.Sprint(entry.RawData["event_id"]) {
+		case "4624":
+			ev.Outcome = "success"
+		case "4625":
+			ev.Outcome = "failure"
+		case "4771", "4776": // Kerberos pre-authentication / NTLM validation
+			if status := fields["Status"]; status == "" || status == "0x0" {
+				return ev, false
+			}
+			ev.Outcome = "failure"
+			ev.LogonType = "network"
+		default:
+			return ev, false
+		}
+		ev.User = fields["TargetUserName"]
+		if ev.User == "" {
+			ev.User = fields["UserName"]
+		}
+		if domain := fields["TargetDomainName"]; domain != "" && domain != "-" && !strings.Contains(ev.User, "\\") {
+			ev.User = domain + "\\" + ev.User
+		}
+		// Local logons have IpAddress "-"; NTLM (4776) only names the workstation
+		ev.SourceIP = fields["IpAddress"]
+		if ev.SourceIP == "" || ev.SourceIP == "-" {
+			ev.SourceIP = fields["Workstation"]
+			if ev.SourceIP == "" {
+				ev.SourceIP = fields["WorkstationName"]
+			}
+		}
+		if lt, ok := windowsLogonTypes[fields["LogonType"]]; ok {
+			ev.LogonType = lt
+		}
+
+	case entry.Source == "auditd":
+		switch entry.Event {
+		case "user_auth", "user_login":
+		default:
+			return ev, false
+		}
+		ev.User, _ = entry.RawData["acct"].(string)
+		ev.SourceIP, _ = entry.RawData["addr"].(string)
+		ev.Outcome = "success"
+		if res, _ := entry.RawData["res"].(string); res == "failed" {
+			ev.Outcome = "failure"
+		}
+		ev.LogonType = "console"
+		if term, _ := entry.RawData["terminal"].(string); term == "ssh" || strings.HasSuffix(fmt.Sprint(entry.RawData["exe"]), "/sshd") {
+			ev.LogonType = "ssh"
+		}
+
+	case entry.Source == "journald" || strings.HasPrefix(entry.Source, "syslog-"):
+		if !parseSyslogAuth(entry.Message, &ev) {
+			return ev, false
+		}
+
+	default:
+		return ev, false
+	}
+
+	if ev.SourceIP == "-" || ev.SourceIP == "?" || ev.SourceIP == "::1" || ev.SourceIP == "127.0.0.1" {
+		ev.SourceIP = ""
+	}
+	if ignoredAuthUser(ev.User[strings.LastIndex(ev.User, "\\")+1:]) {
+		return ev, false
+	}
+	return ev, true
+}
+
+// parseSyslogAuth recognizes sshd, PAM, sudo, su and login messages.
+func parseSyslogAuth(msg string, ev *authEvent) bool {
+	if m := sshFailedRegex.FindStringSubmatch(msg); m != nil {
+		ev.User, ev.SourceIP, ev.LogonType, ev.Outcome = m[2], m[3], "ssh", "failure"
+		return true
+	}
+	if m := sshAcceptedRegex.FindStringSubmatch(msg); m != nil {
+		ev.User, ev.SourceIP, ev.LogonType, ev.Outcome = m[2], m[3], "ssh", "success"
+		return true
+	}
+	if m := sshInvalidRegex.FindStringSubmatch(msg); m != nil {
+		// sshd logs "Failed password for invalid user" too; count the invalid user once
+		return false
+	}
+	if m := sudoRegex.FindStringSubmatch(msg); m != nil {
+		ev.User, ev.LogonType, ev.Outcome = m[1], "sudo", "success"
+		if m[2] != "" {
+			ev.Outcome = "failure"
+		}
+		return true
+	}
+	if m := suRegex.FindStringSubmatch(msg); m != nil {
+		ev.LogonType, ev.Outcome = "su", "success"
+		ev.User = m[2]
+		if ev.User == "" {
+			ev.User = m[4]
+		}
+		return true
+	}
+	if m := loginRegex.FindStringSubmatch(msg); m != nil {
+		ev.User, ev.LogonType, ev.Outcome = m[2], "console", "success"
+		return true
+	}
+	if m := pamFailureRegex.FindStringSubmatch(msg); m != nil && !strings.Contains(msg, "sshd") {
+		// pam_unix(sudo:auth) / (login:auth) / (su:auth); sshd failures come from the lines above
+		ev.User, ev.SourceIP, ev.Outcome = m[2], m[1], "failure"
+		ev.LogonType = "console"
+		if strings.Contains(msg, "(sudo:") {
+			ev.LogonType = "sudo"
+		} else if strings.Contains(msg, "(su:") || strings.Contains(msg, "(su-l:") {
+			ev.LogonType = "su"
+		}
+		return true
+	}
+	return false
+}
+
+// privilegedGroupChange reports a user added to an administrator or sudo group.
+func privilegedGroupChange(entry LogEntry) (LogEntry, bool) {
+	var member, group, by string
+	switch {
+	case entry.Source == "WinEventLog-Security":
+		fields, _ := entry.RawData["event_data"].(map[string]string)
+		switch fmt.Sprint(entry.RawData["event_id"]) {
+		case "4728", "4732", "4756": // member added to global / local / universal security group
+		default:
+			return LogEntry{}, false
+		}
+		group = fields["TargetUserName"]
+		member = fields["MemberName"]
+		if member == "" || member == "-" {
+			member = fields["MemberSid"]
+		}
+		by = fields["SubjectUserName"]
+
+	case entry.Source == "auditd":
+		audit, _ := entry.RawData["audit"].(map[string]string)
+		if audit == nil || !strings.Contains(audit["op"], "add-user-to-group") && !strings.Contains(audit["op"], "add-to-group") {
+			return LogEntry{}, false
+		}
+		group, member = audit["grp"], audit["acct"]
+		by, _ = entry.RawData["user"].(string)
+
+	case entry.Source == "journald" || strings.HasPrefix(entry.Source, "syslog-"):
+		if m := usermodRegex.FindStringSubmatch(entry.Message); m != nil {
+			member, group = m[1], m[2]
+		} else if m := gpasswdRegex.FindStringSubmatch(entry.Message); m != nil {
+			member, by, group = m[1], m[2], m[3]
+		} else {
+			return LogEntry{}, false
+		}
+
+	default:
+		return LogEntry{}, false
+	}
+
+	if !privilegedGroups[strings.ToLower(group)] {
+		return LogEntry{}, false
+	}
+	ev := authEvent{User: member, Source: entry.Source, Time: authEntryTime(entry), LogonType: "group_change"}
+	msg := fmt.Sprintf("%s added to privileged group %s", member, group)
+	if by != "" {
+		msg += " by " + by
+	}
+	raw := map[string]interface{}{
+		"group":      group,
+		"member":     member,
+		"changed_by": by,
+	}
+	if id, ok := entry.RawData["event_id"]; ok {
+		raw["event_id"] = id
+	}
+	return authAlert(entry.Hostname, ev, "privileged_group_added", "high", msg, raw), true
+}
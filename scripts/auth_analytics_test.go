@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNormalizeWindowsLogonSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		fields map[string]string
+		source string
+	}{
+		{"remote", "4624", map[string]string{"TargetUserName": "alice", "IpAddress": "10.0.0.7", "WorkstationName": "PC7", "LogonType": "10"}, "10.0.0.7"},
+		{"local dash falls back to workstation", "4625", map[string]string{"TargetUserName": "alice", "IpAddress": "-", "WorkstationName": "PC7", "LogonType": "2"}, "PC7"},
+		{"ntlm workstation", "4776", map[string]string{"TargetUserName": "bob", "Workstation": "LAPTOP1", "Status": "0xc000006a"}, "LAPTOP1"},
+		{"nothing known", "4625", map[string]string{"TargetUserName": "alice", "IpAddress": "-", "WorkstationName": "-", "LogonType": "2"}, ""},
+		{"loopback", "4624", map[string]string{"TargetUserName": "alice", "IpAddress": "127.0.0.1", "LogonType": "3"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := LogEntry{
+				Source:    "WinEventLog-Security",
+				Timestamp: "2024-03-01T12:00:00Z",
+				RawData:   map[string]interface{}{"event_id": tt.id, "event_data": tt.fields},
+			}
+			ev, ok := normalizeAuthEvent(entry)
+			if !ok {
+				t.Fatal("event not normalized")
+			}
+			if ev.SourceIP != tt.source {
+				t.Errorf("source = %q, want %q", ev.SourceIP, tt.source)
+			}
+		})
+	}
+}
+
+func TestAuthBaselineSaveThrottle(t *testing.T) {
+	agentDir = t.TempDir()
+	path := filepath.Join(agentDir, AUTH_BASELINE_FILE)
+	a := &authAnalyzer{
+		failures:    make(map[string][]time.Time),
+		sprays:      make(map[string]map[string]time.Time),
+		alerted:     make(map[string]time.Time),
+		lastSeen:    make(map[string]authEvent),
+		hours:       make(map[string]*[24]int),
+		hoursLoaded: true,
+	}
+	sudo := func(at time.Time) {
+		a.observeSuccess(authEvent{User: "alice", LogonType: "sudo", Outcome: "success", Time: at}, "host")
+	}
+
+	now := time.Now()
+	sudo(now)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("first logon not saved: %v", err)
+	}
+	os.Remove(path)
+
+	// Further calls within the interval only mark the baseline changed
+	for i := 1; i <= 5; i++ {
+		sudo(now.Add(time.Duration(i) * time.Second))
+	}
+	a.flushBaseline()
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("baseline rewritten within the save interval")
+	}
+	if !a.hoursDirty {
+		t.Fatal("changed baseline not marked dirty")
+	}
+
+	a.hoursSavedAt = time.Now().Add(-AUTH_BASELINE_SAVE_EVERY)
+	a.flushBaseline()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("baseline not flushed after the interval: %v", err)
+	}
+	if a.hoursDirty {
+		t.Error("baseline still dirty after saving")
+	}
+}
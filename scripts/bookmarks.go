@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Log collection bookmarks. Every event source persists how far it has read
+// (event record ID, journal cursor, file offset) under agentDir, reads
+// everything after it up to the catch-up limit per cycle, and reports a
+// log_gap when the source has rotated or been cleared past the bookmark.
+
+const (
+	LOG_INITIAL_ENTRIES = 10  // a source without a bookmark starts with its newest entries
+	LOG_CATCHUP_LIMIT   = 500 // default entries sent per source per cycle
+)
+
+// logCatchUpLimit returns the configured per-cycle limit.
+func logCatchUpLimit() int {
+	if agentConfig.LogCatchUpLimit > 0 {
+		return agentConfig.LogCatchUpLimit
+	}
+	return LOG_CATCHUP_LIMIT
+}
+
+func loadBookmark(file string) string {
+	data, err := os.ReadFile(filepath.Join(agentDir, file))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// saveBookmark writes through a temp file so a crash never leaves a torn bookmark.
+func saveBookmark(file, value string) {
+	path := filepath.Join(agentDir, file)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(value), 0644); err != nil {
+		logMessage("Bookmark save failed for " + file + ": " + err.Error())
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// reportLogGap tells the server that entries between the bookmark and the
+// oldest entry still available were lost.
+func reportLogGap(source, logType, reason string, raw map[string]interface{}) {
+	raw["reason"] = reason
+	msg := fmt.Sprintf("Log gap in %s: %s", source, reason)
+	if missing, ok := raw["missing_entries"]; ok {
+		msg = fmt.Sprintf("Log gap in %s: %v entries lost (%s)", source, missing, reason)
+	}
+	logMessage(msg)
+
+	sendLog(LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    logType,
+		Event:      "log_gap",
+		Source:     source,
+		Severity:   "warning",
+		Message:    msg,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RawData:    raw,
+	})
+}
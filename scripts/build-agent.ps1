@@ -82,7 +82,7 @@ Write-Host "Compiling Windows agent..." -ForegroundColor Yellow
 # Build the binary. Use windows GUI subsystem so no console pops when running as service.
 $exePath = Join-Path $BUILD_DIR "CyArtAgent.exe"
 # Use cmd /c so quoting works consistently
-$buildCmd = "go build -ldflags=`"-s -w -H=windowsgui`" -o `"$exePath`" windows-agent-production.go"
+$buildCmd = "go build -ldflags=`"-s -w -H=windowsgui`" -o `"$exePath`" ."
 cmd /c $buildCmd
 if ($LASTEXITCODE -ne 0) {
     Write-Host "Build failed!" -ForegroundColor Red
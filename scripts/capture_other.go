@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+// captureLLDP does no live capture outside Windows: it relies on Npcap.
+// Captured traffic can still be decoded everywhere with -replay.
+func captureLLDP() {
+	safeGo("Neighbor_Expiry", expireNeighbors)
+	safeGo("WiFi_Scanner", scanWifiAccessPoint)
+}
@@ -0,0 +1,72 @@
+//go:build windows
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// captureLLDP runs live layer-2 discovery capture through Npcap on every
+// non-loopback interface.
+func captureLLDP() {
+	// Wait for network to be ready
+	time.Sleep(10 * time.Second)
+	logMessage("Initializing LLDP Capture...")
+
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		logMessage("LLDP Error: Could not list interfaces: " + err.Error())
+		return
+	}
+
+	go arpWatch.watchScope()
+
+	for _, device := range devices {
+		// Ignore loopback
+		if strings.Contains(strings.ToLower(device.Description), "loopback") {
+			continue
+		}
+
+		go func(dev pcap.Interface) {
+			logMessage("LLDP: Attempting to listen on " + dev.Description)
+
+			// Promiscuous mode often fails on Wi-Fi on Windows. Try false first if true fails?
+			// Actually, standard is promiscuous=true. But for LLDP (multicast), non-promiscuous might work if multicast is allowed.
+			handle, err := pcap.OpenLive(dev.Name, 1600, true, 30*time.Second)
+			if err != nil {
+				logMessage("LLDP Warning: Failed to open " + dev.Description + ": " + err.Error())
+				return
+			}
+			defer handle.Close()
+
+			if err := handle.SetBPFFilter(DISCOVERY_BPF_FILTER + " or " + ARP_BPF_FILTER); err != nil {
+				logMessage("LLDP: Failed to set BPF filter on " + dev.Description)
+				return
+			}
+
+			logMessage("LLDP: Listening on " + dev.Description)
+
+			packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+			for packet := range packetSource.Packets() {
+				now := time.Now()
+				if neighbor, ok := decodeDiscoveryPacket(packet, dev.Description); ok {
+					trackNeighbor(neighbor, now)
+					continue
+				}
+				for _, alert := range arpWatch.observe(packet, dev.Description, now) {
+					logMessage("⚠️ ARP: " + alert.Message)
+					sendLog(alert)
+				}
+			}
+		}(device)
+	}
+
+	safeGo("Neighbor_Expiry", expireNeighbors)
+
+	// Wi-Fi "LLDP" Fallback (BSSID Discovery)
+	safeGo("WiFi_Scanner", scanWifiAccessPoint)
+}
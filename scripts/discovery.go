@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Layer-2 neighbor discovery. LLDP, Cisco CDP and Extreme EDP frames are all
+// decoded into one topologyNeighbor so the dashboard sees a single
+// network_topology schema whatever the switch vendor speaks.
+
+// BPF filter for every discovery protocol we decode:
+// LLDP ethertype, CDP multicast (01:00:0c:cc:cc:cc), EDP multicast (00:e0:2b:00:00:00)
+const DISCOVERY_BPF_FILTER = "ether proto 0x88cc or ether dst 01:00:0c:cc:cc:cc or ether dst 00:e0:2b:00:00:00"
+
+const (
+	edpSNAPType     = 0x00bb
+	edpTLVMarker    = 0x99
+	edpTLVDisplay   = 1
+	edpTLVInfo      = 2
+	edpTLVVLAN      = 5
+	edpHeaderLength = 10 // version, reserved, length, checksum, sequence, machine id type
+)
+
+var edpOUI = []byte{0x00, 0xe0, 0x2b}
+
+type managementAddress struct {
+	Family           string `json:"family"`
+	Address          string `json:"address"`
+	InterfaceSubtype string `json:"interface_subtype,omitempty"`
+	InterfaceNumber  uint32 `json:"interface_number,omitempty"`
+	OID              string `json:"oid,omitempty"`
+}
+
+// topologyNeighbor is the protocol-independent neighbor record.
+type topologyNeighbor struct {
+	Protocol            string // "lldp", "cdp", "edp" or "wifi"
+	Interface           string
+	SystemName          string
+	ChassisID           string
+	PortID              string
+	PortDescription     string
+	SystemDescription   string
+	Platform            string
+	Capabilities        []string
+	ManagementAddresses []managementAddress
+	NativeVLAN          uint16
+	TTL                 uint16
+	// Protocol-specific typed fields (LLDP 802.1/802.3/MED, CDP VTP domain, ...)
+	Details map[string]interface{}
+}
+
+// decodeDiscoveryPacket returns the neighbor announced in packet, if any.
+func decodeDiscoveryPacket(packet gopacket.Packet, iface string) (topologyNeighbor, bool) {
+	if l := packet.Layer(layers.LayerTypeLinkLayerDiscovery); l != nil {
+		lldp := decodeLLDP(l.(*layers.LinkLayerDiscovery))
+		return lldp.topology(iface), true
+	}
+
+	if l := packet.Layer(layers.LayerTypeCiscoDiscoveryInfo); l != nil {
+		var ttl uint8
+		var version uint8
+		if c, ok := packet.Layer(layers.LayerTypeCiscoDiscovery).(*layers.CiscoDiscovery); ok {
+			ttl, version = c.TTL, c.Version
+		}
+		return decodeCDP(l.(*layers.CiscoDiscoveryInfo), ttl, version, iface), true
+	}
+
+	if l := packet.Layer(layers.LayerTypeSNAP); l != nil {
+		snap := l.(*layers.SNAP)
+		if string(snap.OrganizationalCode) == string(edpOUI) && snap.Type == edpSNAPType {
+			return decodeEDP(snap.Payload, iface)
+		}
+	}
+	return topologyNeighbor{}, false
+}
+
+// reportNeighbor sends a neighbor event. previous is attached for neighbor_changed.
+func reportNeighbor(n topologyNeighbor, event, severity, message string, previous *topologyNeighbor, at time.Time) {
+	raw := n.rawData()
+	if previous != nil {
+		raw["previous"] = previous.rawData()
+		if n.Protocol == "wifi" {
+			raw["roamed"] = previous.SystemName == n.SystemName
+		}
+	}
+
+	hardwareType := "switch"
+	if n.Protocol == "wifi" {
+		hardwareType = "wifi_ap"
+	}
+
+	sendLog(LogEntry{
+		DeviceID:     deviceID,
+		DeviceName:   deviceName,
+		Hostname:     getHostname(),
+		LogType:      "network_topology",
+		HardwareType: hardwareType,
+		Event:        event,
+		Source:       n.Protocol + "-agent",
+		Severity:     severity,
+		Message:      message,
+		Timestamp:    at.UTC().Format(time.RFC3339),
+		RawData:      raw,
+	})
+}
+
+func (n *topologyNeighbor) summary() string {
+	return fmt.Sprintf("Switch: %s | Port: %s | Chassis: %s", n.SystemName, n.PortID, n.ChassisID)
+}
+
+// rawData flattens the neighbor into LogEntry.RawData. The switch_name,
+// port_id and chassis_id keys are kept for existing dashboard queries.
+func (n *topologyNeighbor) rawData() map[string]interface{} {
+	raw := map[string]interface{}{
+		"protocol":    n.Protocol,
+		"switch_name": n.SystemName,
+		"port_id":     n.PortID,
+		"chassis_id":  n.ChassisID,
+		"ttl":         n.TTL,
+		"interface":   n.Interface,
+	}
+	if n.PortDescription != "" {
+		raw["port_description"] = n.PortDescription
+	}
+	if n.SystemDescription != "" {
+		raw["system_description"] = n.SystemDescription
+	}
+	if n.Platform != "" {
+		raw["platform"] = n.Platform
+	}
+	if len(n.Capabilities) > 0 {
+		raw["capabilities"] = n.Capabilities
+	}
+	if len(n.ManagementAddresses) > 0 {
+		raw["management_addresses"] = n.ManagementAddresses
+	}
+	if n.NativeVLAN != 0 {
+		raw["port_vlan_id"] = n.NativeVLAN
+	}
+	for k, v := range n.Details {
+		raw[k] = v
+	}
+	return raw
+}
+
+// decodeCDP normalizes a Cisco Discovery Protocol announcement.
+func decodeCDP(info *layers.CiscoDiscoveryInfo, ttl, version uint8, iface string) topologyNeighbor {
+	n := topologyNeighbor{
+		Protocol:          "cdp",
+		Interface:         iface,
+		SystemName:        info.DeviceID,
+		ChassisID:         info.DeviceID,
+		PortID:            info.PortID,
+		SystemDescription: strings.TrimSpace(info.Version),
+		Platform:          info.Platform,
+		NativeVLAN:        info.NativeVLAN,
+		TTL:               uint16(ttl),
+		Details: map[string]interface{}{
+			"cdp_version": version,
+			"full_duplex": info.FullDuplex,
+		},
+	}
+	if info.SysName != "" {
+		n.SystemName = info.SysName
+	}
+
+	caps := info.Capabilities
+	for _, c := range []struct {
+		set  bool
+		name string
+	}{
+		{caps.L3Router, "router"},
+		{caps.TBBridge || caps.SPBridge, "bridge"},
+		{caps.L2Switch, "switch"},
+		{caps.IsHost, "host"},
+		{caps.IGMPFilter, "igmp_filter"},
+		{caps.L1Repeater, "repeater"},
+		{caps.IsPhone, "telephone"},
+		{caps.RemotelyManaged, "remotely_managed"},
+	} {
+		if c.set {
+			n.Capabilities = append(n.Capabilities, c.name)
+		}
+	}
+
+	// Management addresses first, then interface addresses, without duplicates
+	seen := make(map[string]bool)
+	for _, ip := range append(append([]net.IP{}, info.MgmtAddresses...), info.Addresses...) {
+		if ip == nil || seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		family := "ipv6"
+		if ip.To4() != nil {
+			family = "ipv4"
+		}
+		n.ManagementAddresses = append(n.ManagementAddresses, managementAddress{Family: family, Address: ip.String()})
+	}
+
+	if info.VTPDomain != "" {
+		n.Details["vtp_domain"] = info.VTPDomain
+	}
+	if info.MTU != 0 {
+		n.Details["mtu"] = info.MTU
+	}
+	if info.PowerConsumption != 0 {
+		n.Details["power_consumption_mw"] = info.PowerConsumption
+	}
+	if info.Location.Location != "" {
+		n.Details["location"] = info.Location.Location
+	}
+	if info.SysOID != "" {
+		n.Details["sys_oid"] = info.SysOID
+	}
+	if len(info.IPPrefixes) > 0 {
+		var prefixes []string
+		for _, p := range info.IPPrefixes {
+			prefixes = append(prefixes, p.String())
+		}
+		n.Details["ip_prefixes"] = prefixes
+	}
+	return n
+}
+
+// decodeEDP parses an Extreme Discovery Protocol PDU (SNAP payload).
+func decodeEDP(data []byte, iface string) (topologyNeighbor, bool) {
+	if len(data) < edpHeaderLength+6 {
+		return topologyNeighbor{}, false
+	}
+
+	n := topologyNeighbor{
+		Protocol:  "edp",
+		Interface: iface,
+		ChassisID: net.HardwareAddr(data[edpHeaderLength : edpHeaderLength+6]).String(),
+		Details: map[string]interface{}{
+			"edp_version": data[0],
+		},
+	}
+
+	var vlans []lldpVLAN
+	rest := data[edpHeaderLength+6:]
+	for len(rest) >= 4 {
+		if rest[0] != edpTLVMarker {
+			break
+		}
+		tlvType := rest[1]
+		tlvLen := int(binary.BigEndian.Uint16(rest[2:4]))
+		if tlvLen < 4 || tlvLen > len(rest) {
+			break
+		}
+		value := rest[4:tlvLen]
+		rest = rest[tlvLen:]
+
+		switch tlvType {
+		case edpTLVDisplay:
+			n.SystemName = lldpString(value)
+		case edpTLVInfo:
+			// slot(2) port(2) virtual chassis(2) reserved(6) version(4)
+			if len(value) >= 16 {
+				slot := binary.BigEndian.Uint16(value[0:2])
+				port := binary.BigEndian.Uint16(value[2:4])
+				n.PortID = fmt.Sprintf("%d:%d", slot+1, port+1)
+				n.SystemDescription = fmt.Sprintf("ExtremeXOS %d.%d.%d.%d", value[12], value[13], value[14], value[15])
+			}
+		case edpTLVVLAN:
+			// flags(1) reserved(1) vlan id(2) reserved(4) ip(4) name
+			if len(value) >= 12 {
+				vlan := lldpVLAN{
+					ID:   binary.BigEndian.Uint16(value[2:4]),
+					Name: lldpString(value[12:]),
+				}
+				vlans = append(vlans, vlan)
+				if ip := net.IP(value[8:12]); !ip.IsUnspecified() {
+					n.ManagementAddresses = append(n.ManagementAddresses, managementAddress{Family: "ipv4", Address: ip.String()})
+				}
+			}
+		}
+	}
+
+	if len(vlans) > 0 {
+		n.Details["vlans"] = vlans
+	}
+	return n, true
+}
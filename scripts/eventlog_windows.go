@@ -0,0 +1,186 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Event log collection with per-log record ID bookmarks. Each cycle exports
+// the records after the bookmark (up to the catch-up limit) with
+// `wevtutil epl` and decodes them with the EVTX parser, so every EventData
+// field is sent, bursts are not dropped and quiet periods do not resend old
+// events.
+
+// collectSystemLogs sends new Application, System and Security event log entries.
+func collectSystemLogs() {
+	host := getHostname()
+	limit := logCatchUpLimit()
+
+	// Collect logs from multiple sources: Application, System, and Security
+	logSources := []struct {
+		logName string
+		logType string
+	}{
+		{"Application", "application"},
+		{"System", "system"},
+		{"Security", "security"},
+	}
+
+	for _, source := range logSources {
+		bookmarkFile := "eventlog-" + source.logName + ".bookmark"
+		sourceName := fmt.Sprintf("WinEventLog-%s", source.logName)
+
+		oldest, newest, ok := eventLogRange(source.logName)
+		if !ok || newest == 0 {
+			continue
+		}
+
+		after := int64(-1)
+		if v, err := strconv.ParseInt(loadBookmark(bookmarkFile), 10, 64); err == nil {
+			after = v
+		}
+
+		switch {
+		case after < 0:
+			after = newest - LOG_INITIAL_ENTRIES
+			if after < 0 {
+				after = 0
+			}
+		case newest < after:
+			// Log cleared: record IDs restarted below the bookmark
+			reportLogGap(sourceName, source.logType, "log cleared", map[string]interface{}{
+				"bookmark":      after,
+				"newest_record": newest,
+			})
+			after = oldest - 1
+		case oldest > after+1:
+			reportLogGap(sourceName, source.logType, "log rotated past bookmark", map[string]interface{}{
+				"bookmark":        after,
+				"oldest_record":   oldest,
+				"missing_entries": oldest - after - 1,
+			})
+			after = oldest - 1
+		}
+		if newest <= after {
+			saveBookmark(bookmarkFile, strconv.FormatInt(after, 10))
+			continue
+		}
+
+		upper := after + int64(limit)
+		if upper > newest {
+			upper = newest
+		}
+
+		last, err := sendEventLogRange(source.logName, after, upper, host)
+		if err != nil {
+			logMessage("Event log collection stopped (" + source.logName + "): " + err.Error())
+			// Keep what was sent before the failure so it is not sent again;
+			// the record that failed is the first one sent next cycle
+			if last > after {
+				saveBookmark(bookmarkFile, strconv.FormatInt(last, 10))
+			}
+			continue
+		}
+		if last < upper {
+			// Records in the window that were not exported do not exist (anymore)
+			last = upper
+		}
+		saveBookmark(bookmarkFile, strconv.FormatInt(last, 10))
+	}
+}
+
+// eventLogRange returns the oldest and newest record IDs. `wevtutil gli`
+// gives the oldest record and the count; the newest is read from the newest
+// event itself, since record IDs are not contiguous after a clear or a wrap.
+func eventLogRange(logName string) (int64, int64, bool) {
+	out, err := runCommandWithTimeout("wevtutil", "gli", logName)
+	if err != nil {
+		return 0, 0, false
+	}
+	var oldest, count int64
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "oldestRecordNumber":
+			oldest = n
+		case "numberOfLogRecords":
+			count = n
+		}
+	}
+	if count == 0 {
+		return oldest, 0, true
+	}
+
+	out, err = runCommandWithTimeout("wevtutil", "qe", logName, "/c:1", "/rd:true", "/f:xml")
+	if err != nil {
+		return 0, 0, false
+	}
+	newest, ok := eventRecordID(string(out))
+	if !ok {
+		return 0, 0, false
+	}
+	return oldest, newest, true
+}
+
+// eventRecordID returns the EventRecordID of an event rendered as XML.
+func eventRecordID(xml string) (int64, bool) {
+	_, rest, ok := strings.Cut(xml, "<EventRecordID>")
+	if !ok {
+		return 0, false
+	}
+	value, _, ok := strings.Cut(rest, "</EventRecordID>")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	return n, err == nil
+}
+
+// sendEventLogRange exports records (after, upper] and sends them. It returns
+// the highest record ID sent, also when reading or sending stops with an
+// error.
+func sendEventLogRange(logName string, after, upper int64, host string) (int64, error) {
+	path := filepath.Join(agentDir, "eventlog-"+logName+".evtx")
+	defer os.Remove(path)
+
+	query := fmt.Sprintf("/q:*[System[(EventRecordID>%d) and (EventRecordID<=%d)]]", after, upper)
+	if _, err := runCommandWithTimeout("wevtutil", "epl", logName, path, query, "/ow:true"); err != nil {
+		return after, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return after, err
+	}
+	defer f.Close()
+
+	last := after
+	var sendErr error
+	err = readEvtx(f, func(r evtxRecord) bool {
+		if entry, ok := r.logEntry(host); ok {
+			if sendErr = sendLog(entry); sendErr != nil {
+				return false
+			}
+		}
+		if int64(r.RecordID) > last {
+			last = int64(r.RecordID)
+		}
+		return true
+	})
+	if sendErr != nil {
+		return last, sendErr
+	}
+	return last, err
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testdata/security.evtx has two chunks. The first holds records 101-103:
+// 101 defines the logon template inline and 102 and 103 reference it. The
+// second chunk defines the template again for 104 (offsets are chunk
+// relative) and holds 105, a record without template whose values are all
+// inline tokens.
+func readEvtxFixture(t *testing.T, data []byte) []evtxRecord {
+	t.Helper()
+	var records []evtxRecord
+	if err := readEvtx(bytes.NewReader(data), func(r evtxRecord) bool {
+		records = append(records, r)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func evtxFixture(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/security.evtx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReadEvtxTemplates(t *testing.T) {
+	records := readEvtxFixture(t, evtxFixture(t))
+
+	var ids []uint64
+	for _, r := range records {
+		ids = append(ids, r.RecordID)
+	}
+	if want := []uint64{101, 102, 103, 104, 105}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("record IDs = %v, want %v", ids, want)
+	}
+
+	tests := []struct {
+		record   int
+		user     string
+		ip       string
+		severity string
+		userSID  interface{}
+	}{
+		{0, "alice", "10.0.0.5", "info", "S-1-5-18"},
+		{1, "bob", "203.0.113.9", "high", "S-1-5-18"},
+		// optional substitution left empty: no Security UserID
+		{2, "carol", "-", "info", nil},
+		{3, "dave", "10.0.0.7", "info", "S-1-5-18"},
+	}
+	for _, tt := range tests {
+		r := records[tt.record]
+		e, ok := r.logEntry("host")
+		if !ok {
+			t.Fatalf("record %d: no log entry", r.RecordID)
+		}
+		fields := e.RawData["event_data"].(map[string]string)
+		if fields["TargetUserName"] != tt.user || fields["IpAddress"] != tt.ip || e.Severity != tt.severity {
+			t.Errorf("record %d: user %q ip %q severity %q", r.RecordID, fields["TargetUserName"], fields["IpAddress"], e.Severity)
+		}
+		if e.RawData["user_sid"] != tt.userSID {
+			t.Errorf("record %d: user_sid = %v, want %v", r.RecordID, e.RawData["user_sid"], tt.userSID)
+		}
+		if fields["TargetUserSid"] != "S-1-5-21-1004336348-1177238915-682003330-1104" ||
+			fields["LogonGuid"] != "{3F2504E0-4F89-11D3-9A0C-0305E82C3301}" || fields["LogonType"] == "" {
+			t.Errorf("record %d: substitution values %v", r.RecordID, fields)
+		}
+		if e.LogType != "security" || e.Source != "WinEventLog-Security" || e.RawData["process_id"] != "716" {
+			t.Errorf("record %d: entry %+v", r.RecordID, e)
+		}
+	}
+
+	// TimeCreated comes from a FILETIME substitution
+	if e, _ := records[1].logEntry("host"); e.Timestamp != "2024-03-01T12:01:00Z" {
+		t.Errorf("record 102 timestamp = %s", e.Timestamp)
+	}
+}
+
+func TestReadEvtxInlineValues(t *testing.T) {
+	records := readEvtxFixture(t, evtxFixture(t))
+	e, ok := records[4].logEntry("host")
+	if !ok {
+		t.Fatal("record 105: no log entry")
+	}
+	want := map[string]string{
+		"Count":       "4294967295",
+		"Delta":       "-42",
+		"Flags":       "0x0000001f",
+		"ServiceGuid": "{3F2504E0-4F89-11D3-9A0C-0305E82C3301}",
+		"ServiceName": "evil7", // a string and an integer value in one element
+	}
+	if got := e.RawData["event_data"].(map[string]string); !reflect.DeepEqual(got, want) {
+		t.Errorf("event data = %v, want %v", got, want)
+	}
+	if e.RawData["event_id"] != 7045 || e.Severity != "warning" || e.LogType != "system" {
+		t.Errorf("entry = %+v", e)
+	}
+	if e.RawData["user_sid"] != "S-1-5-21-1-2-3-500" || e.Timestamp != "2024-03-01T12:05:00Z" {
+		t.Errorf("inline SID %v, FILETIME %s", e.RawData["user_sid"], e.Timestamp)
+	}
+}
+
+func TestReadEvtxChunkBoundaries(t *testing.T) {
+	data := evtxFixture(t)
+	header := data[:evtxHeaderSize]
+	chunk1 := data[evtxHeaderSize : evtxHeaderSize+evtxChunkSize]
+	chunk2 := data[evtxHeaderSize+evtxChunkSize:]
+
+	// first record of a damaged chunk: the rest of that chunk is lost, the
+	// next chunk is still read
+	damaged := append([]byte(nil), chunk1...)
+	binary.LittleEndian.PutUint32(damaged[evtxRecordsStart:], 0)
+
+	// a template reference pointing past the chunk fails only that record
+	badRef := append([]byte(nil), chunk1...)
+	for off := evtxRecordsStart; off+8 <= len(badRef); {
+		size := int(binary.LittleEndian.Uint32(badRef[off+4:]))
+		if binary.LittleEndian.Uint64(badRef[off+8:]) == 102 {
+			// record header(24), fragment header(4), token(2), template id(4)
+			binary.LittleEndian.PutUint32(badRef[off+24+4+6:], 0xfffffff0)
+			break
+		}
+		off += size
+	}
+
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	tests := []struct {
+		name string
+		data []byte
+		ids  []uint64
+	}{
+		{"preallocated zero chunk between", join(header, chunk1, make([]byte, evtxChunkSize), chunk2), []uint64{101, 102, 103, 104, 105}},
+		{"damaged first chunk", join(header, damaged, chunk2), []uint64{104, 105}},
+		{"bad template reference", join(header, badRef, chunk2), []uint64{101, 103, 104, 105}},
+		{"file cut inside the second chunk", join(header, chunk1, chunk2[:4096]), []uint64{101, 102, 103}},
+		{"header only", header, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []uint64
+			for _, r := range readEvtxFixture(t, tt.data) {
+				ids = append(ids, r.RecordID)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("record IDs = %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+
+	if err := readEvtx(bytes.NewReader([]byte("MZ")), func(evtxRecord) bool { return true }); err == nil {
+		t.Error("non-EVTX input: no error")
+	}
+
+	count := 0
+	readEvtx(bytes.NewReader(data), func(evtxRecord) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("callback ran %d times after returning false, want 2", count)
+	}
+}
+
+func TestEvtxValueString(t *testing.T) {
+	le := func(n uint64, size int) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, n)
+		return b[:size]
+	}
+	tests := []struct {
+		name string
+		vt   byte
+		b    []byte
+		want string
+	}{
+		{"int8", evtxTypeInt8, []byte{0xfe}, "-2"},
+		{"uint16", evtxTypeUint16, le(4624, 2), "4624"},
+		{"int32", evtxTypeInt32, le(0xffffffff, 4), "-1"},
+		{"uint64", evtxTypeUint64, le(1<<40, 8), "1099511627776"},
+		{"hex64", evtxTypeHexInt64, le(0x8020000000000000, 8), "0x8020000000000000"},
+		{"bool", evtxTypeBool, le(1, 4), "true"},
+		{"guid", evtxTypeGUID, []byte{0xe0, 0x04, 0x25, 0x3f, 0x89, 0x4f, 0xd3, 0x11, 0x9a, 0x0c, 0x03, 0x05, 0xe8, 0x2c, 0x33, 0x01}, "{3F2504E0-4F89-11D3-9A0C-0305E82C3301}"},
+		{"sid", evtxTypeSID, []byte{1, 2, 0, 0, 0, 0, 0, 5, 32, 0, 0, 0, 0x20, 0x02, 0, 0}, "S-1-5-32-544"},
+		{"filetime", evtxTypeFileTime, le(133537680000000000, 8), "2024-03-01T12:00:00Z"},
+		{"string", evtxTypeString, []byte{'o', 0, 'k', 0, 0, 0}, "ok"},
+		{"string array", evtxTypeString | evtxTypeArray, []byte{'a', 0, 0, 0, 'b', 0, 0, 0}, "a, b"},
+		{"uint16 array", evtxTypeUint16 | evtxTypeArray, []byte{1, 0, 2, 0}, "1, 2"},
+		{"short uint32", evtxTypeUint32, []byte{1, 2}, "0102"},
+		{"short sid", evtxTypeSID, []byte{1, 1, 0}, "010100"},
+	}
+	for _, tt := range tests {
+		if got := evtxValueString(tt.vt, tt.b); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
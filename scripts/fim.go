@@ -0,0 +1,561 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File integrity monitoring. Configured files and directories are baselined
+// (hash, size, mode, owner, mtime) under agentDir; every difference found
+// later is reported as a fim LogEntry with the before and after attributes.
+// Linux follows changes in real time with inotify (fim_linux.go) and
+// rescans hourly as a safety net; elsewhere the paths are rescanned every
+// FIM_RESCAN_INTERVAL.
+
+const (
+	FIM_BASELINE_FILE       = "fim-baseline.json"
+	FIM_RESCAN_INTERVAL     = 5 * time.Minute
+	FIM_WATCHED_RESCAN      = time.Hour
+	FIM_MAX_HASH_SIZE       = 32 * 1024 * 1024
+	FIM_MAX_ENTRIES         = 50000
+	FIM_EVENT_SETTLE        = time.Second // inotify events are batched until writes settle
+	FIM_MAX_CHANGES_PER_RUN = 1000        // a mass change is summarized, not sent file by file
+)
+
+// Default monitored paths; Config.FIMPaths replaces them. Globs are
+// expanded on every scan so new users' authorized_keys are picked up.
+var fimDefaultPaths = map[string][]string{
+	"linux": {
+		"/etc",
+		"/usr/lib/systemd/system",
+		"/lib/systemd/system",
+		"/var/spool/cron",
+		"/root/.ssh/authorized_keys",
+		"/home/*/.ssh/authorized_keys",
+	},
+	"darwin": {
+		"/etc/hosts",
+		"/etc/sudoers",
+		"/etc/ssh",
+		"/Library/LaunchDaemons",
+		"/Library/LaunchAgents",
+		"/usr/lib/cron/tabs",
+		"/Users/*/.ssh/authorized_keys",
+	},
+	"windows": {
+		`${SystemRoot}\System32\drivers\etc`,
+		`${SystemRoot}\System32\Tasks`,
+		`${ProgramData}\Microsoft\Windows\Start Menu\Programs\StartUp`,
+		`${ProgramData}\ssh\sshd_config`,
+		`${ProgramData}\ssh\administrators_authorized_keys`,
+		`C:\Users\*\.ssh\authorized_keys`,
+	},
+}
+
+// Files that change on their own; Config.FIMExclude adds patterns. A
+// pattern matches the full path or the file name.
+var fimDefaultExclude = []string{"/etc/mtab", "/etc/adjtime", "/etc/ld.so.cache", "*.swp", "*.swx", "*~", "*.dpkg-tmp"}
+
+// Changes to these are the classic persistence and privilege paths
+var fimHighSeverity = []string{
+	"authorized_keys", "sudoers", "/etc/passwd", "/etc/shadow", "/etc/group", "/etc/gshadow",
+	"cron", "systemd/system", "/etc/hosts", `drivers\etc\hosts`, "LaunchDaemons", "LaunchAgents",
+	"StartUp", `System32\Tasks`, "sshd_config", "/etc/ld.so.preload", "/etc/pam.d",
+}
+
+// fimAttrs is the baseline of one path.
+type fimAttrs struct {
+	Type   string    `json:"type"` // file, dir, symlink, other
+	Size   int64     `json:"size"`
+	Mode   string    `json:"mode"`
+	Owner  string    `json:"owner"`
+	MTime  time.Time `json:"mtime"`
+	Inode  uint64    `json:"inode,omitempty"`
+	SHA256 string    `json:"sha256,omitempty"`
+	Target string    `json:"target,omitempty"` // symlinks
+}
+
+type fimMonitor struct {
+	mu       sync.Mutex
+	baseline map[string]fimAttrs
+	dirty    bool
+	exclude  []string
+}
+
+var fim = &fimMonitor{baseline: make(map[string]fimAttrs)}
+
+var errFIMWatchUnsupported = errors.New("real-time file monitoring is not available on this platform")
+
+// fimChange is one difference between the baseline and the disk.
+type fimChange struct {
+	path    string
+	kind    string // created, modified, deleted, attributes_changed
+	before  *fimAttrs
+	after   *fimAttrs
+	changed []string
+}
+
+// fimPatterns returns the configured paths with variables expanded.
+func fimPatterns() []string {
+	paths := agentConfig.FIMPaths
+	if len(paths) == 0 {
+		paths = fimDefaultPaths[runtime.GOOS]
+	}
+	patterns := make([]string, 0, len(paths))
+	for _, p := range paths {
+		patterns = append(patterns, filepath.Clean(os.ExpandEnv(p)))
+	}
+	return patterns
+}
+
+// fimRoots expands the globs of patterns, without duplicates reached
+// through symlinks (/lib -> /usr/lib).
+func fimRoots(patterns []string) []string {
+	seen := make(map[string]bool)
+	var roots []string
+	for _, p := range patterns {
+		matches, _ := filepath.Glob(p)
+		for _, m := range matches {
+			real, err := filepath.EvalSymlinks(m)
+			if err != nil || seen[real] {
+				continue
+			}
+			seen[real] = true
+			roots = append(roots, filepath.Clean(m))
+		}
+	}
+	return roots
+}
+
+// fimCovered reports whether path or one of its parents matches a
+// pattern, so files that vanished together with their glob match count.
+func fimCovered(path string, patterns []string) bool {
+	for p := path; ; p = filepath.Dir(p) {
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, p); ok {
+				return true
+			}
+		}
+		if filepath.Dir(p) == p {
+			return false
+		}
+	}
+}
+
+func (m *fimMonitor) excluded(path string) bool {
+	base := filepath.Base(path)
+	for _, pattern := range m.exclude {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// runFIM loads (or builds) the baseline, then follows changes.
+func runFIM() {
+	fim.exclude = append(append([]string{}, fimDefaultExclude...), agentConfig.FIMExclude...)
+	patterns := fimPatterns()
+	if fim.load() {
+		// Changes made while the agent was not running
+		fim.report(fim.rescan(patterns), "rescan")
+	} else {
+		fim.apply(fim.rescan(patterns))
+		fim.mu.Lock()
+		n := len(fim.baseline)
+		fim.mu.Unlock()
+		logMessage(fmt.Sprintf("FIM baseline created: %d entries under %d paths", n, len(patterns)))
+	}
+	fim.save()
+
+	if err := fimWatch(patterns); err != nil {
+		logMessage("FIM: " + err.Error() + ", rescanning every " + FIM_RESCAN_INTERVAL.String())
+	}
+	for {
+		time.Sleep(FIM_RESCAN_INTERVAL)
+		fim.report(fim.rescan(patterns), "rescan")
+		fim.save()
+	}
+}
+
+// rescan walks the paths and returns the differences to the baseline.
+func (m *fimMonitor) rescan(patterns []string) []fimChange {
+	current := make(map[string]fimAttrs)
+	truncated := false
+	for _, root := range fimRoots(patterns) {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || m.excluded(path) {
+				if err == nil && d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if len(current) >= FIM_MAX_ENTRIES {
+				truncated = true
+				return filepath.SkipAll
+			}
+			if attrs, ok := m.attributes(path); ok {
+				current[path] = attrs
+			}
+			return nil
+		})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var changes []fimChange
+	for path, after := range current {
+		if c, ok := m.compare(path, &after); ok {
+			changes = append(changes, c)
+		}
+	}
+	if truncated {
+		logMessage(fmt.Sprintf("FIM: more than %d entries, deletions are not tracked", FIM_MAX_ENTRIES))
+	}
+	for path, before := range m.baseline {
+		if _, ok := current[path]; !ok && !truncated && fimCovered(path, patterns) {
+			b := before
+			changes = append(changes, fimChange{path: path, kind: "deleted", before: &b})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	return changes
+}
+
+// check re-reads one path (and, for a new or deleted directory, everything
+// below it) after a real-time notification.
+func (m *fimMonitor) check(path string, patterns []string) []fimChange {
+	if m.excluded(path) || !fimCovered(path, patterns) {
+		return nil
+	}
+	attrs, exists := m.attributes(path)
+
+	// Files moved in with a directory produce no events of their own
+	var below []string
+	belowAttrs := make(map[string]fimAttrs)
+	if exists && attrs.Type == "dir" {
+		filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || p == path {
+				return nil
+			}
+			if m.excluded(p) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if a, ok := m.attributes(p); ok {
+				below = append(below, p)
+				belowAttrs[p] = a
+			}
+			return nil
+		})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var changes []fimChange
+	if !exists {
+		for p, before := range m.baseline {
+			if p == path || strings.HasPrefix(p, path+string(filepath.Separator)) {
+				b := before
+				changes = append(changes, fimChange{path: p, kind: "deleted", before: &b})
+			}
+		}
+		sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+		return changes
+	}
+
+	if c, ok := m.compare(path, &attrs); ok {
+		changes = append(changes, c)
+	}
+	for _, p := range below {
+		if _, known := m.baseline[p]; !known {
+			a := belowAttrs[p]
+			if c, ok := m.compare(p, &a); ok {
+				changes = append(changes, c)
+			}
+		}
+	}
+	return changes
+}
+
+// compare returns the difference between attrs and the baseline of path.
+// The baseline only takes the change once it is reported (apply). Callers
+// hold m.mu.
+func (m *fimMonitor) compare(path string, attrs *fimAttrs) (fimChange, bool) {
+	before, known := m.baseline[path]
+	if !known {
+		if len(m.baseline) >= FIM_MAX_ENTRIES {
+			return fimChange{}, false
+		}
+		return fimChange{path: path, kind: "created", after: attrs}, true
+	}
+
+	var changed []string
+	if before.Type != attrs.Type {
+		changed = append(changed, "type")
+	}
+	if before.SHA256 != attrs.SHA256 {
+		changed = append(changed, "sha256")
+	}
+	if before.Size != attrs.Size {
+		changed = append(changed, "size")
+	}
+	if before.Target != attrs.Target {
+		changed = append(changed, "target")
+	}
+	if before.Mode != attrs.Mode {
+		changed = append(changed, "mode")
+	}
+	if before.Owner != attrs.Owner {
+		changed = append(changed, "owner")
+	}
+	if before.Inode != attrs.Inode && before.Inode != 0 && attrs.Type != "dir" {
+		changed = append(changed, "inode") // replaced by another file
+	}
+	if !before.MTime.Equal(attrs.MTime) && attrs.Type != "dir" {
+		changed = append(changed, "mtime")
+	}
+	if len(changed) == 0 {
+		return fimChange{}, false
+	}
+
+	kind := "attributes_changed"
+	for _, c := range changed {
+		if c == "sha256" || c == "size" || c == "type" || c == "target" {
+			kind = "modified"
+		}
+	}
+	if kind == "attributes_changed" && len(changed) == 1 && changed[0] == "mtime" {
+		// Touched, content and permissions unchanged: nothing to report
+		m.baseline[path] = *attrs
+		m.dirty = true
+		return fimChange{}, false
+	}
+	return fimChange{path: path, kind: kind, before: &before, after: attrs, changed: changed}, true
+}
+
+// attributes reads the current attributes of path. The hash of an
+// unchanged file (same size, mtime and inode) is taken from the baseline.
+func (m *fimMonitor) attributes(path string) (fimAttrs, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fimAttrs{}, false
+	}
+	a := fimAttrs{
+		Size:  info.Size(),
+		Mode:  info.Mode().String(),
+		Owner: fileOwner(path, info),
+		MTime: info.ModTime().UTC(),
+		Inode: fileInode(path, info),
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		a.Type = "symlink"
+		a.Target, _ = os.Readlink(path)
+	case info.IsDir():
+		a.Type = "dir"
+		a.Size = 0
+	case info.Mode().IsRegular():
+		a.Type = "file"
+	default:
+		a.Type = "other"
+	}
+	if a.Type != "file" || a.Size > FIM_MAX_HASH_SIZE {
+		return a, true
+	}
+
+	m.mu.Lock()
+	before, known := m.baseline[path]
+	m.mu.Unlock()
+	if known && before.SHA256 != "" && before.Size == a.Size && before.MTime.Equal(a.MTime) && before.Inode == a.Inode {
+		a.SHA256 = before.SHA256
+		return a, true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return a, true // unreadable: attributes only
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err == nil {
+		a.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	return a, true
+}
+
+// report sends the changes unless the device is quarantined and then
+// moves the baseline forward over the ones delivered. Changes not sent stay
+// out of the baseline, so the next scan finds and reports them.
+func (m *fimMonitor) report(changes []fimChange, detection string) {
+	policyMutex.RLock()
+	quarantined := isQuarantined
+	policyMutex.RUnlock()
+	if deviceID == "" || quarantined || len(changes) == 0 {
+		return
+	}
+	var delivered []fimChange
+	defer func() { m.apply(delivered) }()
+
+	sent := changes
+	if len(sent) > FIM_MAX_CHANGES_PER_RUN {
+		sent = sent[:FIM_MAX_CHANGES_PER_RUN]
+	}
+	for _, c := range sent {
+		if sendLog(c.logEntry(detection)) != nil {
+			return
+		}
+		delivered = append(delivered, c)
+	}
+	if len(changes) > len(sent) {
+		counts := make(map[string]int)
+		for _, c := range changes[len(sent):] {
+			counts[c.kind]++
+		}
+		err := sendLog(LogEntry{
+			DeviceID:   deviceID,
+			DeviceName: deviceName,
+			Hostname:   getHostname(),
+			LogType:    "fim",
+			Event:      "fim_changes_truncated",
+			Source:     "fim",
+			Severity:   "warning",
+			Message:    fmt.Sprintf("FIM: %d more changes not reported individually", len(changes)-len(sent)),
+			Timestamp:  time.Now().UTC().Format(time.RFC3339),
+			RawData:    map[string]interface{}{"omitted": len(changes) - len(sent), "omitted_by_kind": counts, "detection": detection},
+		})
+		if err == nil {
+			// the omitted changes are accounted for by the summary
+			delivered = changes
+		}
+	}
+}
+
+func (c fimChange) logEntry(detection string) LogEntry {
+	severity := "info"
+	for _, s := range fimHighSeverity {
+		if strings.Contains(c.path, s) {
+			severity = "high"
+			break
+		}
+	}
+	if severity == "info" && c.kind != "attributes_changed" {
+		severity = "warning"
+	}
+
+	raw := map[string]interface{}{
+		"path":        c.path,
+		"change_type": c.kind,
+		"detection":   detection,
+	}
+	if c.before != nil {
+		raw["before"] = c.before.fields()
+		raw["type"] = c.before.Type
+	}
+	if c.after != nil {
+		raw["after"] = c.after.fields()
+		raw["type"] = c.after.Type
+	}
+	if len(c.changed) > 0 {
+		raw["changed_attributes"] = c.changed
+	}
+
+	msg := fmt.Sprintf("FIM: %s %s", c.path, strings.ReplaceAll(c.kind, "_", " "))
+	if len(c.changed) > 0 {
+		msg += " (" + strings.Join(c.changed, ", ") + ")"
+	}
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    "fim",
+		Event:      "file_" + c.kind,
+		Source:     "fim",
+		Severity:   severity,
+		Message:    msg,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RawData:    raw,
+	}
+}
+
+func (a *fimAttrs) fields() map[string]interface{} {
+	f := map[string]interface{}{
+		"size":  a.Size,
+		"mode":  a.Mode,
+		"owner": a.Owner,
+		"mtime": a.MTime.Format(time.RFC3339),
+	}
+	if a.SHA256 != "" {
+		f["sha256"] = a.SHA256
+	}
+	if a.Target != "" {
+		f["target"] = a.Target
+	}
+	if a.Inode != 0 {
+		f["inode"] = a.Inode
+	}
+	return f
+}
+
+// apply records changes in the baseline.
+func (m *fimMonitor) apply(changes []fimChange) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range changes {
+		switch {
+		case c.after == nil:
+			delete(m.baseline, c.path)
+		case c.before == nil && len(m.baseline) >= FIM_MAX_ENTRIES:
+			continue
+		default:
+			m.baseline[c.path] = *c.after
+		}
+		m.dirty = true
+	}
+}
+
+// load reads the stored baseline; false when there is none yet.
+func (m *fimMonitor) load() bool {
+	data, err := os.ReadFile(filepath.Join(agentDir, FIM_BASELINE_FILE))
+	if err != nil {
+		return false
+	}
+	stored := make(map[string]fimAttrs)
+	if json.Unmarshal(data, &stored) != nil {
+		logMessage("FIM baseline unreadable, building a new one")
+		return false
+	}
+	m.mu.Lock()
+	m.baseline = stored
+	m.mu.Unlock()
+	return true
+}
+
+// save writes the baseline through a temp file when it changed.
+func (m *fimMonitor) save() {
+	m.mu.Lock()
+	if !m.dirty {
+		m.mu.Unlock()
+		return
+	}
+	data, _ := json.Marshal(m.baseline)
+	m.dirty = false
+	m.mu.Unlock()
+	saveBookmark(FIM_BASELINE_FILE, string(data))
+}
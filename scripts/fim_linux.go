@@ -0,0 +1,189 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	fimWatchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB |
+		unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_DONT_FOLLOW | unix.IN_ONLYDIR
+	FIM_MAX_WATCHES     = 8192
+	FIM_MAX_EVENT_DELAY = 10 * time.Second // report even while a directory keeps changing
+)
+
+type fimWatcher struct {
+	fd   int
+	dirs map[int]string // watch descriptor -> directory
+	wds  map[string]int
+}
+
+// fimWatch follows the monitored paths with inotify: directories are
+// watched recursively, single files through their parent directory. It
+// only returns when inotify cannot be used or fails; the caller then
+// falls back to periodic rescans.
+func fimWatch(patterns []string) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	w := &fimWatcher{fd: fd, dirs: make(map[int]string), wds: make(map[string]int)}
+	for _, root := range fimRoots(patterns) {
+		w.addTree(root)
+	}
+	if len(w.dirs) == 0 {
+		return errors.New("inotify: no directory could be watched")
+	}
+	logMessage(fmt.Sprintf("FIM: watching %d directories with inotify", len(w.dirs)))
+
+	buf := make([]byte, 64*1024)
+	pending := make(map[string]bool)
+	var firstEvent, lastEvent time.Time
+	lastRescan := time.Now()
+	for {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		if _, err := unix.Poll(fds, 1000); err != nil && err != unix.EINTR {
+			return err
+		}
+		now := time.Now()
+		if fds[0].Revents&unix.POLLIN != 0 {
+			n, err := unix.Read(fd, buf)
+			if err != nil && err != unix.EAGAIN && err != unix.EINTR {
+				return err
+			}
+			if n > 0 {
+				if len(pending) == 0 {
+					firstEvent = now
+				}
+				lastEvent = now
+				if w.parse(buf[:n], pending) {
+					// Queue overflow: events were lost, compare everything
+					logMessage("FIM: inotify queue overflow, rescanning")
+					pending = make(map[string]bool)
+					lastRescan = time.Time{}
+				}
+			}
+		}
+
+		if len(pending) > 0 && (now.Sub(lastEvent) >= FIM_EVENT_SETTLE || now.Sub(firstEvent) >= FIM_MAX_EVENT_DELAY) {
+			paths := make([]string, 0, len(pending))
+			for p := range pending {
+				paths = append(paths, p)
+			}
+			sort.Strings(paths)
+			pending = make(map[string]bool)
+
+			var changes []fimChange
+			for _, p := range paths {
+				changes = append(changes, fim.check(p, patterns)...)
+				if info, err := os.Lstat(p); err == nil && info.IsDir() && fimCovered(p, patterns) {
+					w.addTree(p) // new or moved-in directory
+				}
+			}
+			fim.report(changes, "inotify")
+			fim.save()
+		}
+
+		if now.Sub(lastRescan) >= FIM_WATCHED_RESCAN {
+			fim.report(fim.rescan(patterns), "rescan")
+			fim.save()
+			for _, root := range fimRoots(patterns) {
+				w.addTree(root) // new glob matches
+			}
+			lastRescan = now
+		}
+	}
+}
+
+// parse queues the paths named by a buffer of inotify events and reports
+// a queue overflow.
+func (w *fimWatcher) parse(buf []byte, pending map[string]bool) bool {
+	overflow := false
+	for len(buf) >= unix.SizeofInotifyEvent {
+		// struct inotify_event: wd, mask, cookie, len, name[len]
+		wd := int(int32(binary.NativeEndian.Uint32(buf[0:4])))
+		mask := binary.NativeEndian.Uint32(buf[4:8])
+		end := unix.SizeofInotifyEvent + int(binary.NativeEndian.Uint32(buf[12:16]))
+		if end > len(buf) {
+			break
+		}
+		name := string(bytes.TrimRight(buf[unix.SizeofInotifyEvent:end], "\x00"))
+		buf = buf[end:]
+
+		if mask&unix.IN_Q_OVERFLOW != 0 {
+			overflow = true
+			continue
+		}
+		dir, ok := w.dirs[wd]
+		if !ok {
+			continue
+		}
+		switch {
+		case mask&unix.IN_IGNORED != 0:
+			delete(w.dirs, wd)
+			delete(w.wds, dir)
+		case mask&unix.IN_MOVE_SELF != 0:
+			// The path no longer names this directory
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+			delete(w.wds, dir)
+			pending[dir] = true
+		case name == "":
+			pending[dir] = true
+		default:
+			pending[filepath.Join(dir, name)] = true
+		}
+	}
+	return overflow
+}
+
+// addTree watches path and the directories below it, or the parent
+// directory of a file.
+func (w *fimWatcher) addTree(path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		w.add(filepath.Dir(path))
+		return
+	}
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if fim.excluded(p) || !w.add(p) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+func (w *fimWatcher) add(dir string) bool {
+	if _, ok := w.wds[dir]; ok {
+		return true
+	}
+	if len(w.dirs) >= FIM_MAX_WATCHES {
+		return false // the hourly rescan still covers it
+	}
+	wd, err := unix.InotifyAddWatch(w.fd, dir, fimWatchMask)
+	if err != nil {
+		return false
+	}
+	w.dirs[wd] = dir
+	w.wds[dir] = wd
+	return true
+}
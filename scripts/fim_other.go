@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// fimWatch has no real-time source here; runFIM rescans instead.
+func fimWatch(patterns []string) error {
+	return errFIMWatchUnsupported
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFIMBaselineAdvancesOnlyWhenReported(t *testing.T) {
+	agentDir = t.TempDir()
+	root := filepath.Join(t.TempDir(), "watched")
+	os.MkdirAll(root, 0755)
+	file := filepath.Join(root, "config")
+	os.WriteFile(file, []byte("one"), 0644)
+
+	var out bytes.Buffer
+	replayOutput = &out
+	savedID := deviceID
+	deviceID = "test-device"
+	defer func() {
+		replayOutput, deviceID, isQuarantined = nil, savedID, false
+	}()
+
+	m := &fimMonitor{baseline: make(map[string]fimAttrs)}
+	patterns := []string{root}
+	m.apply(m.rescan(patterns))
+	if _, ok := m.baseline[file]; !ok {
+		t.Fatal("file not in the initial baseline")
+	}
+
+	os.WriteFile(file, []byte("two, longer"), 0644)
+	os.WriteFile(filepath.Join(root, "new"), []byte("x"), 0644)
+
+	// Quarantined: nothing is sent and the baseline keeps the old state
+	isQuarantined = true
+	m.report(m.rescan(patterns), "rescan")
+	if out.Len() != 0 {
+		t.Fatalf("changes sent while quarantined: %s", out.String())
+	}
+	if m.baseline[file].Size != 3 {
+		t.Fatal("baseline moved forward without reporting")
+	}
+
+	// Released: the same changes are found again, sent and recorded
+	isQuarantined = false
+	changes := m.rescan(patterns)
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes after the quarantine, want 2", len(changes))
+	}
+	m.report(changes, "rescan")
+	for _, event := range []string{`"event":"file_modified"`, `"event":"file_created"`} {
+		if !strings.Contains(out.String(), event) {
+			t.Errorf("%s not sent: %s", event, out.String())
+		}
+	}
+	if m.baseline[file].Size != int64(len("two, longer")) {
+		t.Error("baseline not updated after reporting")
+	}
+	if changes := m.rescan(patterns); len(changes) != 0 {
+		t.Errorf("changes reported twice: %+v", changes)
+	}
+
+	// Deletions wait the same way
+	os.Remove(file)
+	deviceID = ""
+	m.report(m.check(file, patterns), "inotify")
+	if _, ok := m.baseline[file]; !ok {
+		t.Fatal("deletion applied without a device ID")
+	}
+	deviceID = "test-device"
+	m.report(m.rescan(patterns), "rescan")
+	if _, ok := m.baseline[file]; ok {
+		t.Error("deletion not applied after reporting")
+	}
+}
+
+func TestFIMBaselineKeepsChangesNotDelivered(t *testing.T) {
+	agentDir = t.TempDir()
+	root := filepath.Join(t.TempDir(), "watched")
+	os.MkdirAll(root, 0755)
+	for _, name := range []string{"a", "b", "c"} {
+		os.WriteFile(filepath.Join(root, name), []byte("one"), 0644)
+	}
+	savedID := deviceID
+	deviceID = "test-device"
+	defer func() { deviceID = savedID }()
+
+	m := &fimMonitor{baseline: make(map[string]fimAttrs)}
+	patterns := []string{root}
+	m.apply(m.rescan(patterns))
+
+	for _, name := range []string{"a", "b", "c"} {
+		os.WriteFile(filepath.Join(root, name), []byte("two, longer"), 0644)
+	}
+
+	// The server takes the first change and fails the rest
+	received := logServer(t, 1)
+	m.report(m.rescan(patterns), "rescan")
+	if got := received(); len(got) != 1 || got[0].Event != "file_modified" {
+		t.Fatalf("server received %+v, want one file_modified", got)
+	}
+	changes := m.rescan(patterns)
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes after the failed send, want the 2 not delivered", len(changes))
+	}
+	delivered, _ := received()[0].RawData["path"].(string)
+	for _, c := range changes {
+		if c.path == delivered {
+			t.Errorf("delivered change to %s found again", c.path)
+		}
+	}
+
+	received = logServer(t, 10)
+	m.report(changes, "rescan")
+	if got := len(received()); got != 2 {
+		t.Fatalf("server received %d changes on retry, want 2", got)
+	}
+	if changes := m.rescan(patterns); len(changes) != 0 {
+		t.Errorf("changes left after delivery: %+v", changes)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Flow export: the connection table polled by trackNetworkConnections is turned
+// into flow records and sent over UDP to a NetFlow v9 (RFC 3954) or IPFIX
+// (RFC 7011) collector configured in agent.config. Process attribution is
+// carried in enterprise-specific fields so the network team can tie flows to
+// the binary that opened them.
+const (
+	FLOW_FORMAT_IPFIX    = "ipfix"
+	FLOW_FORMAT_NETFLOW9 = "netflow9"
+
+	FLOW_ACTIVE_TIMEOUT    = 60 * time.Second // re-export long-lived flows
+	FLOW_TEMPLATE_REFRESH  = 60 * time.Second // templates are resent for UDP collectors
+	FLOW_MAX_PACKET_SIZE   = 1400
+	FLOW_PROCESS_NAME_SIZE = 32 // fixed width for NetFlow v9, which has no variable-length fields
+
+	// IANA "example" PEN (RFC 5612); override with flow_enterprise_id
+	FLOW_DEFAULT_ENTERPRISE = 32473
+
+	flowTemplateIPv4 = 256
+	flowTemplateIPv6 = 257
+
+	// flowEndReason (IPFIX IE 136)
+	flowEndIdleTimeout   = 1
+	flowEndActiveTimeout = 2
+)
+
+// Information element / field type IDs shared by NetFlow v9 and IPFIX
+const (
+	ieProtocol     = 4
+	ieSrcPort      = 7
+	ieSrcIPv4      = 8
+	ieDstPort      = 11
+	ieDstIPv4      = 12
+	ieLastSwitched = 21 // v9: sysUptime ms
+	ieFirstSwitch  = 22 // v9: sysUptime ms
+	ieSrcIPv6      = 27
+	ieDstIPv6      = 28
+	ieFlowEnd      = 136
+	ieFlowStartMs  = 152 // IPFIX: epoch ms
+	ieFlowEndMs    = 153 // IPFIX: epoch ms
+
+	// Enterprise-specific elements (IPFIX: enterprise bit + PEN, v9: vendor range)
+	ieProcessName = 1
+	ieProcessID   = 2
+	v9VendorBase  = 40000
+)
+
+type flowKey struct {
+	localAddr  string
+	localPort  int
+	remoteAddr string
+	remotePort int
+	transport  string
+}
+
+type flowRecord struct {
+	key         flowKey
+	processName string
+	processID   int
+	ipv6        bool
+	start       time.Time
+	lastSeen    time.Time
+	lastExport  time.Time
+}
+
+type flowField struct {
+	id         uint16
+	length     uint16
+	enterprise bool
+}
+
+type flowExporter struct {
+	mu           sync.Mutex
+	conn         net.Conn
+	format       string
+	enterprise   uint32
+	flows        map[flowKey]*flowRecord
+	sequence     uint32
+	bootTime     time.Time
+	lastTemplate time.Time
+}
+
+var flowExport *flowExporter
+
+// startFlowExporter dials the configured collector. Export stays disabled
+// (flowExport == nil) when no collector is configured.
+func startFlowExporter() {
+	if agentConfig.FlowCollector == "" {
+		return
+	}
+
+	format := strings.ToLower(agentConfig.FlowFormat)
+	if format == "" {
+		format = FLOW_FORMAT_IPFIX
+	}
+	if format != FLOW_FORMAT_IPFIX && format != FLOW_FORMAT_NETFLOW9 {
+		logMessage("Flow export: unknown format '" + agentConfig.FlowFormat + "', export disabled")
+		return
+	}
+
+	conn, err := net.Dial("udp", agentConfig.FlowCollector)
+	if err != nil {
+		logMessage("Flow export: could not reach collector " + agentConfig.FlowCollector + ": " + err.Error())
+		return
+	}
+
+	enterprise := agentConfig.FlowEnterpriseID
+	if enterprise == 0 {
+		enterprise = FLOW_DEFAULT_ENTERPRISE
+	}
+
+	flowExport = &flowExporter{
+		conn:       conn,
+		format:     format,
+		enterprise: enterprise,
+		flows:      make(map[flowKey]*flowRecord),
+		bootTime:   time.Now(),
+	}
+	logMessage(fmt.Sprintf("Flow export: sending %s to %s", format, agentConfig.FlowCollector))
+}
+
+// observe records that a connection was present in the current poll.
+// Connections no template can carry (an address that does not parse, or
+// one IPv4 and one IPv6 end) are not exported.
+func (e *flowExporter) observe(key flowKey, processName string, pid int, now time.Time) {
+	if e == nil {
+		return
+	}
+	ipv6, ok := flowFamily(key)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if f, ok := e.flows[key]; ok {
+		f.lastSeen = now
+		return
+	}
+	e.flows[key] = &flowRecord{
+		key:         key,
+		processName: processName,
+		processID:   pid,
+		ipv6:        ipv6,
+		start:       now,
+		lastSeen:    now,
+	}
+}
+
+// flowFamily tells whether both ends of a flow are IPv6 (or both IPv4);
+// ok is false when they differ or an address does not parse.
+func flowFamily(key flowKey) (ipv6 bool, ok bool) {
+	local, remote := net.ParseIP(key.localAddr), net.ParseIP(key.remoteAddr)
+	if local == nil || remote == nil {
+		return false, false
+	}
+	local4, remote4 := local.To4() != nil, remote.To4() != nil
+	return !local4, local4 == remote4
+}
+
+// flush exports flows that disappeared since the last poll (idle timeout) and
+// long-lived flows whose active timeout elapsed.
+func (e *flowExporter) flush(now time.Time) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var v4, v6 []*flowRecord
+	reasons := make(map[*flowRecord]uint8)
+
+	for key, f := range e.flows {
+		var reason uint8
+		switch {
+		case f.lastSeen.Before(now):
+			reason = flowEndIdleTimeout
+			delete(e.flows, key)
+		case now.Sub(f.start) >= FLOW_ACTIVE_TIMEOUT && now.Sub(f.lastExport) >= FLOW_ACTIVE_TIMEOUT:
+			reason = flowEndActiveTimeout
+			f.lastExport = now
+		default:
+			continue
+		}
+
+		reasons[f] = reason
+		if f.ipv6 {
+			v6 = append(v6, f)
+		} else {
+			v4 = append(v4, f)
+		}
+	}
+
+	if len(v4) == 0 && len(v6) == 0 {
+		return
+	}
+
+	for _, batch := range []struct {
+		template uint16
+		records  []*flowRecord
+	}{{flowTemplateIPv4, v4}, {flowTemplateIPv6, v6}} {
+		for len(batch.records) > 0 {
+			n := e.sendBatch(batch.template, batch.records, reasons, now)
+			batch.records = batch.records[n:]
+		}
+	}
+}
+
+// sendBatch encodes as many records as fit in one datagram and returns how many were sent.
+func (e *flowExporter) sendBatch(template uint16, records []*flowRecord, reasons map[*flowRecord]uint8, now time.Time) int {
+	withTemplates := now.Sub(e.lastTemplate) >= FLOW_TEMPLATE_REFRESH
+
+	var sets []byte
+	setCount := 0
+	if withTemplates {
+		sets = append(sets, e.templateSet()...)
+		setCount += 2
+		e.lastTemplate = now
+	}
+
+	var data []byte
+	n := 0
+	for _, f := range records {
+		rec := e.encodeRecord(f, reasons[f], template == flowTemplateIPv6)
+		if len(sets)+len(data)+len(rec)+4+20 > FLOW_MAX_PACKET_SIZE && n > 0 {
+			break
+		}
+		data = append(data, rec...)
+		n++
+	}
+	sets = append(sets, flowSet(template, data)...)
+	setCount += n
+
+	var header []byte
+	if e.format == FLOW_FORMAT_IPFIX {
+		header = make([]byte, 16)
+		binary.BigEndian.PutUint16(header[0:], 10)
+		binary.BigEndian.PutUint16(header[2:], uint16(16+len(sets)))
+		binary.BigEndian.PutUint32(header[4:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(header[8:], e.sequence)
+		binary.BigEndian.PutUint32(header[12:], 0) // observation domain
+		e.sequence += uint32(n)                    // IPFIX counts data records
+	} else {
+		header = make([]byte, 20)
+		binary.BigEndian.PutUint16(header[0:], 9)
+		binary.BigEndian.PutUint16(header[2:], uint16(setCount))
+		binary.BigEndian.PutUint32(header[4:], e.uptime(now))
+		binary.BigEndian.PutUint32(header[8:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(header[12:], e.sequence)
+		binary.BigEndian.PutUint32(header[16:], 0) // source id
+		e.sequence++                               // v9 counts export packets
+	}
+
+	if _, err := e.conn.Write(append(header, sets...)); err != nil {
+		logMessage("Flow export error: " + err.Error())
+	}
+	return n
+}
+
+func (e *flowExporter) fields(ipv6 bool) []flowField {
+	src, dst, addrLen := uint16(ieSrcIPv4), uint16(ieDstIPv4), uint16(4)
+	if ipv6 {
+		src, dst, addrLen = ieSrcIPv6, ieDstIPv6, 16
+	}
+
+	fields := []flowField{
+		{id: src, length: addrLen},
+		{id: dst, length: addrLen},
+		{id: ieSrcPort, length: 2},
+		{id: ieDstPort, length: 2},
+		{id: ieProtocol, length: 1},
+	}
+
+	if e.format == FLOW_FORMAT_IPFIX {
+		return append(fields,
+			flowField{id: ieFlowStartMs, length: 8},
+			flowField{id: ieFlowEndMs, length: 8},
+			flowField{id: ieFlowEnd, length: 1},
+			flowField{id: ieProcessID, length: 4, enterprise: true},
+			flowField{id: ieProcessName, length: 0xFFFF, enterprise: true}, // variable length
+		)
+	}
+	return append(fields,
+		flowField{id: ieFirstSwitch, length: 4},
+		flowField{id: ieLastSwitched, length: 4},
+		flowField{id: v9VendorBase + ieProcessID, length: 4},
+		flowField{id: v9VendorBase + ieProcessName, length: FLOW_PROCESS_NAME_SIZE},
+	)
+}
+
+// templateSet encodes the IPv4 and IPv6 templates as two template sets.
+func (e *flowExporter) templateSet() []byte {
+	setID := uint16(0) // NetFlow v9 template flowset
+	if e.format == FLOW_FORMAT_IPFIX {
+		setID = 2
+	}
+
+	var out []byte
+	for _, t := range []struct {
+		id   uint16
+		ipv6 bool
+	}{{flowTemplateIPv4, false}, {flowTemplateIPv6, true}} {
+		fields := e.fields(t.ipv6)
+		body := make([]byte, 4)
+		binary.BigEndian.PutUint16(body[0:], t.id)
+		binary.BigEndian.PutUint16(body[2:], uint16(len(fields)))
+		for _, f := range fields {
+			id := f.id
+			if f.enterprise {
+				id |= 0x8000
+			}
+			body = binary.BigEndian.AppendUint16(body, id)
+			body = binary.BigEndian.AppendUint16(body, f.length)
+			if f.enterprise {
+				body = binary.BigEndian.AppendUint32(body, e.enterprise)
+			}
+		}
+		out = append(out, flowSet(setID, body)...)
+	}
+	return out
+}
+
+func (e *flowExporter) encodeRecord(f *flowRecord, reason uint8, ipv6 bool) []byte {
+	addr := func(s string) []byte {
+		ip := net.ParseIP(s)
+		if ipv6 {
+			return ip.To16()
+		}
+		return ip.To4()
+	}
+
+	// Direction is not known from the connection table, so local is the source
+	rec := append([]byte{}, addr(f.key.localAddr)...)
+	rec = append(rec, addr(f.key.remoteAddr)...)
+	rec = binary.BigEndian.AppendUint16(rec, uint16(f.key.localPort))
+	rec = binary.BigEndian.AppendUint16(rec, uint16(f.key.remotePort))
+	rec = append(rec, transportProtocolNumber(f.key.transport))
+
+	if e.format == FLOW_FORMAT_IPFIX {
+		rec = binary.BigEndian.AppendUint64(rec, uint64(f.start.UnixMilli()))
+		rec = binary.BigEndian.AppendUint64(rec, uint64(f.lastSeen.UnixMilli()))
+		rec = append(rec, reason)
+		rec = binary.BigEndian.AppendUint32(rec, uint32(f.processID))
+		name := f.processName
+		if len(name) > 254 {
+			name = name[:254]
+		}
+		rec = append(rec, byte(len(name)))
+		return append(rec, name...)
+	}
+
+	rec = binary.BigEndian.AppendUint32(rec, e.uptime(f.start))
+	rec = binary.BigEndian.AppendUint32(rec, e.uptime(f.lastSeen))
+	rec = binary.BigEndian.AppendUint32(rec, uint32(f.processID))
+	name := make([]byte, FLOW_PROCESS_NAME_SIZE)
+	copy(name, f.processName)
+	return append(rec, name...)
+}
+
+func (e *flowExporter) uptime(t time.Time) uint32 {
+	return uint32(t.Sub(e.bootTime).Milliseconds())
+}
+
+// flowSet wraps a body in a set header, padding to a 4-byte boundary.
+func flowSet(id uint16, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	out := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint16(out[0:], id)
+	binary.BigEndian.PutUint16(out[2:], uint16(4+len(body)))
+	return append(out, body...)
+}
+
+func transportProtocolNumber(transport string) byte {
+	switch strings.ToUpper(transport) {
+	case "TCP":
+		return 6
+	case "UDP":
+		return 17
+	}
+	return 0
+}
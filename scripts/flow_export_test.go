@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// flowCapture is a collector connection that keeps the datagrams written.
+type flowCapture struct {
+	net.Conn
+	packets [][]byte
+}
+
+func (c *flowCapture) Write(b []byte) (int, error) {
+	c.packets = append(c.packets, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+// decodedFlows is what a collector gets out of the export packets.
+type decodedFlows struct {
+	templates map[uint16][]flowField
+	records   map[uint16][]map[uint16][]byte // template ID -> records by field ID
+}
+
+// decodeFlowPackets parses NetFlow v9 or IPFIX packets the way a collector
+// does: templates first, then data sets by their template.
+func decodeFlowPackets(t *testing.T, format string, packets [][]byte) decodedFlows {
+	t.Helper()
+	d := decodedFlows{templates: make(map[uint16][]flowField), records: make(map[uint16][]map[uint16][]byte)}
+	templateSetID, headerLen, version := uint16(0), 20, uint16(9)
+	if format == FLOW_FORMAT_IPFIX {
+		templateSetID, headerLen, version = 2, 16, 10
+	}
+	for _, p := range packets {
+		if v := binary.BigEndian.Uint16(p[0:]); v != version {
+			t.Fatalf("version %d, want %d", v, version)
+		}
+		if format == FLOW_FORMAT_IPFIX && int(binary.BigEndian.Uint16(p[2:])) != len(p) {
+			t.Fatalf("IPFIX length %d, packet is %d bytes", binary.BigEndian.Uint16(p[2:]), len(p))
+		}
+		for sets := p[headerLen:]; len(sets) > 0; {
+			id, length := binary.BigEndian.Uint16(sets[0:]), int(binary.BigEndian.Uint16(sets[2:]))
+			if length < 4 || length > len(sets) || length%4 != 0 {
+				t.Fatalf("set %d: bad length %d", id, length)
+			}
+			body := sets[4:length]
+			sets = sets[length:]
+
+			if id == templateSetID {
+				tid, count := binary.BigEndian.Uint16(body[0:]), int(binary.BigEndian.Uint16(body[2:]))
+				body = body[4:]
+				var fields []flowField
+				for i := 0; i < count; i++ {
+					f := flowField{id: binary.BigEndian.Uint16(body[0:]), length: binary.BigEndian.Uint16(body[2:])}
+					body = body[4:]
+					if format == FLOW_FORMAT_IPFIX && f.id&0x8000 != 0 {
+						f.id &^= 0x8000
+						f.enterprise = true
+						if pen := binary.BigEndian.Uint32(body); pen != FLOW_DEFAULT_ENTERPRISE {
+							t.Errorf("enterprise number %d", pen)
+						}
+						body = body[4:]
+					}
+					fields = append(fields, f)
+				}
+				d.templates[tid] = fields
+				continue
+			}
+
+			fields, ok := d.templates[id]
+			if !ok {
+				t.Fatalf("data set %d before its template", id)
+			}
+			// records until only padding is left
+			for len(body) >= 4 {
+				rec := make(map[uint16][]byte)
+				for _, f := range fields {
+					n := int(f.length)
+					if f.length == 0xFFFF {
+						n = int(body[0])
+						body = body[1:]
+					}
+					rec[f.id] = body[:n]
+					body = body[n:]
+				}
+				d.records[id] = append(d.records[id], rec)
+			}
+		}
+	}
+	return d
+}
+
+func TestFlowExportRoundTrip(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	flows := []flowKey{
+		{localAddr: "10.0.0.5", localPort: 51000, remoteAddr: "93.184.216.34", remotePort: 443, transport: "TCP"},
+		{localAddr: "2001:db8::5", localPort: 53000, remoteAddr: "2001:db8::53", remotePort: 53, transport: "UDP"},
+		// neither template fits these
+		{localAddr: "10.0.0.5", localPort: 52000, remoteAddr: "2001:db8::1", remotePort: 80, transport: "TCP"},
+		{localAddr: "*", localPort: 0, remoteAddr: "10.0.0.1", remotePort: 80, transport: "TCP"},
+	}
+
+	for _, format := range []string{FLOW_FORMAT_IPFIX, FLOW_FORMAT_NETFLOW9} {
+		t.Run(format, func(t *testing.T) {
+			conn := &flowCapture{}
+			e := &flowExporter{conn: conn, format: format, enterprise: FLOW_DEFAULT_ENTERPRISE, flows: make(map[flowKey]*flowRecord), bootTime: start.Add(-time.Hour)}
+			for _, k := range flows {
+				e.observe(k, "curl", 4242, start)
+			}
+			if len(e.flows) != 2 {
+				t.Fatalf("%d flows recorded, want 2", len(e.flows))
+			}
+			e.observe(flows[0], "curl", 4242, start.Add(10*time.Second))
+			e.observe(flows[1], "curl", 4242, start.Add(10*time.Second))
+			e.flush(start.Add(20 * time.Second))
+
+			d := decodeFlowPackets(t, format, conn.packets)
+			addrLen := map[uint16]uint16{flowTemplateIPv4: 4, flowTemplateIPv6: 16}
+			for tid, want := range addrLen {
+				fields := d.templates[tid]
+				if len(fields) != len(e.fields(tid == flowTemplateIPv6)) {
+					t.Fatalf("template %d: %d fields", tid, len(fields))
+				}
+				if fields[0].length != want || fields[1].length != want {
+					t.Errorf("template %d: address lengths %d/%d, want %d", tid, fields[0].length, fields[1].length, want)
+				}
+				if len(d.records[tid]) != 1 {
+					t.Fatalf("template %d: %d records, want 1", tid, len(d.records[tid]))
+				}
+			}
+
+			v4 := d.records[flowTemplateIPv4][0]
+			if got := net.IP(v4[ieSrcIPv4]).String(); got != "10.0.0.5" {
+				t.Errorf("source address %s", got)
+			}
+			if got := net.IP(v4[ieDstIPv4]).String(); got != "93.184.216.34" {
+				t.Errorf("destination address %s", got)
+			}
+			if binary.BigEndian.Uint16(v4[ieSrcPort]) != 51000 || binary.BigEndian.Uint16(v4[ieDstPort]) != 443 || v4[ieProtocol][0] != 6 {
+				t.Errorf("ports/protocol %v %v %v", v4[ieSrcPort], v4[ieDstPort], v4[ieProtocol])
+			}
+			v6 := d.records[flowTemplateIPv6][0]
+			if got := net.IP(v6[ieDstIPv6]).String(); got != "2001:db8::53" || v6[ieProtocol][0] != 17 {
+				t.Errorf("IPv6 destination %s protocol %d", got, v6[ieProtocol][0])
+			}
+
+			if format == FLOW_FORMAT_IPFIX {
+				if got := int64(binary.BigEndian.Uint64(v4[ieFlowStartMs])); got != start.UnixMilli() {
+					t.Errorf("flowStartMilliseconds %d", got)
+				}
+				if got := int64(binary.BigEndian.Uint64(v4[ieFlowEndMs])); got != start.Add(10*time.Second).UnixMilli() {
+					t.Errorf("flowEndMilliseconds %d", got)
+				}
+				if v4[ieFlowEnd][0] != flowEndIdleTimeout {
+					t.Errorf("flowEndReason %d", v4[ieFlowEnd][0])
+				}
+				if binary.BigEndian.Uint32(v4[ieProcessID]) != 4242 || string(v4[ieProcessName]) != "curl" {
+					t.Errorf("process %v %q", v4[ieProcessID], v4[ieProcessName])
+				}
+				return
+			}
+			if got := binary.BigEndian.Uint32(v4[ieFirstSwitch]); got != uint32(time.Hour.Milliseconds()) {
+				t.Errorf("FIRST_SWITCHED %d", got)
+			}
+			if got := binary.BigEndian.Uint32(v4[ieLastSwitched]); got != uint32((time.Hour + 10*time.Second).Milliseconds()) {
+				t.Errorf("LAST_SWITCHED %d", got)
+			}
+			name := v4[v9VendorBase+ieProcessName]
+			if binary.BigEndian.Uint32(v4[v9VendorBase+ieProcessID]) != 4242 || !reflect.DeepEqual(name, append([]byte("curl"), make([]byte, FLOW_PROCESS_NAME_SIZE-4)...)) {
+				t.Errorf("process %v %q", v4[v9VendorBase+ieProcessID], name)
+			}
+		})
+	}
+}
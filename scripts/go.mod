@@ -4,4 +4,6 @@ go 1.25.4
 
 require github.com/google/gopacket v1.1.19
 
+require golang.org/x/net v0.0.0-20190620200207-3b0461eec859 // indirect
+
 require golang.org/x/sys v0.39.0 // indirect; direct
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Hardware inventory: system identity, firmware, CPU, memory, disks (with
+// serials and encryption), TPM and network interfaces. It is sent with the
+// device registration and again as a hardware_inventory event whenever a
+// section differs from the last copy sent, which is kept under agentDir.
+
+const (
+	HARDWARE_STATE_FILE = "hardware.json"
+	HARDWARE_INTERVAL   = time.Hour
+
+	HARDWARE_MEMORY_ROUND = 1 << 30
+)
+
+// SMBIOS chassis types (DSP0134 7.4.1)
+var chassisTypes = map[int]string{
+	1: "Other", 2: "Unknown", 3: "Desktop", 4: "Low Profile Desktop", 5: "Pizza Box", 6: "Mini Tower",
+	7: "Tower", 8: "Portable", 9: "Laptop", 10: "Notebook", 11: "Hand Held", 12: "Docking Station",
+	13: "All in One", 14: "Sub Notebook", 15: "Space-saving", 16: "Lunch Box", 17: "Main Server Chassis",
+	23: "Rack Mount Chassis", 24: "Sealed-case PC", 28: "Blade", 30: "Tablet", 31: "Convertible",
+	32: "Detachable", 33: "IoT Gateway", 34: "Embedded PC", 35: "Mini PC", 36: "Stick PC",
+}
+
+// SMBIOS memory types (DSP0134 7.18.2)
+var memoryTypes = map[byte]string{
+	0x12: "DDR", 0x13: "DDR2", 0x18: "DDR3", 0x1A: "DDR4", 0x1B: "LPDDR", 0x1C: "LPDDR2",
+	0x1D: "LPDDR3", 0x1E: "LPDDR4", 0x20: "HBM", 0x21: "HBM2", 0x22: "DDR5", 0x23: "LPDDR5", 0x24: "HBM3",
+}
+
+type hardwareInventory struct {
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Model        string `json:"model,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	UUID         string `json:"uuid,omitempty"`
+	ChassisType  string `json:"chassis_type,omitempty"`
+
+	Firmware    hardwareFirmware `json:"firmware"`
+	CPU         hardwareCPU      `json:"cpu"`
+	MemoryBytes uint64           `json:"memory_bytes"`
+	MemoryBanks []hardwareMemory `json:"memory_modules,omitempty"`
+	Disks       []hardwareDisk   `json:"disks"`
+	TPM         hardwareTPM      `json:"tpm"`
+	Interfaces  []netInterface   `json:"interfaces"`
+}
+
+type hardwareFirmware struct {
+	Vendor     string `json:"vendor,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Date       string `json:"date,omitempty"`
+	Type       string `json:"type,omitempty"`        // uefi, bios
+	SecureBoot *bool  `json:"secure_boot,omitempty"` // nil when it cannot be read
+}
+
+type hardwareCPU struct {
+	Model   string `json:"model,omitempty"`
+	Vendor  string `json:"vendor,omitempty"`
+	Sockets int    `json:"sockets,omitempty"`
+	Cores   int    `json:"cores,omitempty"`
+	Threads int    `json:"threads"`
+	MaxMHz  int    `json:"max_mhz,omitempty"`
+	Arch    string `json:"arch"`
+	Virtual bool   `json:"virtualized,omitempty"` // running under a hypervisor
+}
+
+type hardwareMemory struct {
+	Slot         string `json:"slot,omitempty"`
+	SizeBytes    uint64 `json:"size_bytes"`
+	Type         string `json:"type,omitempty"`
+	SpeedMHz     int    `json:"speed_mhz,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	PartNumber   string `json:"part_number,omitempty"`
+}
+
+type hardwareDisk struct {
+	Name             string   `json:"name"`
+	Model            string   `json:"model,omitempty"`
+	SerialNumber     string   `json:"serial_number,omitempty"`
+	SizeBytes        uint64   `json:"size_bytes"`
+	Type             string   `json:"type,omitempty"` // ssd, hdd
+	Bus              string   `json:"bus,omitempty"`  // nvme, sata, usb, virtio, ...
+	Removable        bool     `json:"removable,omitempty"`
+	Encryption       string   `json:"encryption"` // luks, bitlocker, filevault, none, unknown
+	EncryptedVolumes []string `json:"encrypted_volumes,omitempty"`
+}
+
+type hardwareTPM struct {
+	Present      bool   `json:"present"`
+	Version      string `json:"version,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Enabled      *bool  `json:"enabled,omitempty"`
+}
+
+// collectHardware gathers the inventory; sections the platform cannot read
+// are left empty.
+func collectHardware() hardwareInventory {
+	inv := platformHardware()
+	inv.Interfaces = localInterfaces()
+	sort.Slice(inv.Disks, func(i, j int) bool { return inv.Disks[i].Name < inv.Disks[j].Name })
+	return inv
+}
+
+// sections splits the inventory into the parts compared for changes.
+// Interface addresses change with every roam and are not hardware: only
+// the adapters themselves count. Removable media (USB sticks, SD cards)
+// come and go and are left out of the disks. The usable memory the OS
+// reports moves with kernel reservations, so the modules are compared, or
+// the total rounded to whole GiB where they cannot be read.
+func (inv hardwareInventory) sections() map[string]string {
+	enc := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	var adapters []string
+	for _, ifc := range inv.Interfaces {
+		adapters = append(adapters, ifc.Name+"|"+ifc.MAC)
+	}
+	var fixed []hardwareDisk
+	for _, d := range inv.Disks {
+		// USB disks often report removable=0
+		if !d.Removable && d.Bus != "usb" {
+			fixed = append(fixed, d)
+		}
+	}
+	var memory interface{} = (inv.MemoryBytes + HARDWARE_MEMORY_ROUND/2) / HARDWARE_MEMORY_ROUND
+	if len(inv.MemoryBanks) > 0 {
+		memory = inv.MemoryBanks
+	}
+	return map[string]string{
+		"system":     enc([]string{inv.Manufacturer, inv.Model, inv.SerialNumber, inv.UUID, inv.ChassisType}),
+		"firmware":   enc(inv.Firmware),
+		"cpu":        enc(inv.CPU),
+		"memory":     enc(memory),
+		"disks":      enc(fixed),
+		"tpm":        enc(inv.TPM),
+		"interfaces": enc(adapters),
+	}
+}
+
+// runHardwareInventory reports the inventory when it changed since the
+// last copy sent (at registration or by a previous run).
+func runHardwareInventory() {
+	for {
+		policyMutex.RLock()
+		quarantined := isQuarantined
+		policyMutex.RUnlock()
+		if deviceID != "" && !quarantined {
+			checkHardware()
+		}
+		time.Sleep(HARDWARE_INTERVAL)
+	}
+}
+
+func checkHardware() {
+	inv := collectHardware()
+	var previous hardwareInventory
+	changed := []string{"initial"}
+	severity := "info"
+	if data, err := os.ReadFile(filepath.Join(agentDir, HARDWARE_STATE_FILE)); err == nil && json.Unmarshal(data, &previous) == nil {
+		changed = nil
+		before := previous.sections()
+		for name, now := range inv.sections() {
+			if before[name] != now {
+				changed = append(changed, name)
+			}
+		}
+		if len(changed) == 0 {
+			return
+		}
+		sort.Strings(changed)
+		for _, c := range changed {
+			// a swapped disk or TPM, or firmware settings changed
+			if c == "disks" || c == "tpm" || c == "firmware" {
+				severity = "warning"
+			}
+		}
+	}
+
+	var raw map[string]interface{}
+	data, _ := json.Marshal(inv)
+	json.Unmarshal(data, &raw)
+	raw["changed_sections"] = changed
+	sendLog(LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    "inventory",
+		Event:      "hardware_inventory",
+		Source:     "hardware",
+		Severity:   severity,
+		Message:    fmt.Sprintf("Hardware inventory (%s): %s %s, %d disks, %d interfaces", strings.Join(changed, ", "), inv.Manufacturer, inv.Model, len(inv.Disks), len(inv.Interfaces)),
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RawData:    raw,
+	})
+	saveHardwareState(inv)
+}
+
+func saveHardwareState(inv hardwareInventory) {
+	data, _ := json.Marshal(inv)
+	saveBookmark(HARDWARE_STATE_FILE, string(data))
+}
@@ -0,0 +1,284 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Linux hardware inventory from sysfs (/sys/class/dmi/id, /sys/block,
+// /sys/class/tpm), /proc and the raw SMBIOS table for memory modules.
+
+const (
+	DMI_ID_DIR        = "/sys/class/dmi/id"
+	SMBIOS_TABLE      = "/sys/firmware/dmi/tables/DMI"
+	EFI_SECURE_BOOT   = "/sys/firmware/efi/efivars/SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+	SMBIOS_TYPE_MEM   = 17
+	SMBIOS_TYPE_END   = 127
+	SYSFS_SECTOR_SIZE = 512 // /sys/block/*/size is always in 512-byte sectors
+)
+
+func platformHardware() hardwareInventory {
+	inv := hardwareInventory{
+		Manufacturer: sysfsValue(DMI_ID_DIR, "sys_vendor"),
+		Model:        sysfsValue(DMI_ID_DIR, "product_name"),
+		SerialNumber: sysfsValue(DMI_ID_DIR, "product_serial"),
+		UUID:         sysfsValue(DMI_ID_DIR, "product_uuid"),
+		Firmware: hardwareFirmware{
+			Vendor:  sysfsValue(DMI_ID_DIR, "bios_vendor"),
+			Version: sysfsValue(DMI_ID_DIR, "bios_version"),
+			Date:    sysfsValue(DMI_ID_DIR, "bios_date"),
+			Type:    "bios",
+		},
+	}
+	if n, err := strconv.Atoi(sysfsValue(DMI_ID_DIR, "chassis_type")); err == nil {
+		inv.ChassisType = chassisTypes[n]
+	}
+	if _, err := os.Stat("/sys/firmware/efi"); err == nil {
+		inv.Firmware.Type = "uefi"
+		// attributes (4 bytes), then the value
+		if data, err := os.ReadFile(EFI_SECURE_BOOT); err == nil && len(data) >= 5 {
+			enabled := data[4] == 1
+			inv.Firmware.SecureBoot = &enabled
+		}
+	}
+
+	inv.CPU = linuxCPU()
+	for _, line := range configLines("/proc/meminfo") {
+		if f := strings.Fields(line); len(f) >= 2 && f[0] == "MemTotal:" {
+			kb, _ := strconv.ParseUint(f[1], 10, 64)
+			inv.MemoryBytes = kb * 1024
+		}
+	}
+	if table, err := os.ReadFile(SMBIOS_TABLE); err == nil {
+		inv.MemoryBanks = smbiosMemory(table)
+	}
+	inv.Disks = linuxDisks()
+	inv.TPM = linuxTPM()
+	return inv
+}
+
+// sysfsValue reads a one-line sysfs attribute; placeholder values vendors
+// leave in DMI are dropped.
+func sysfsValue(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	v := strings.TrimSpace(string(data))
+	switch strings.ToLower(v) {
+	case "", "none", "default string", "to be filled by o.e.m.", "not specified", "system serial number", "0":
+		return ""
+	}
+	return v
+}
+
+func linuxCPU() hardwareCPU {
+	cpu := hardwareCPU{Arch: runtime.GOARCH}
+	sockets := make(map[string]bool)
+	for _, line := range configLines("/proc/cpuinfo") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "processor":
+			cpu.Threads++
+		case "model name", "Model":
+			if cpu.Model == "" {
+				cpu.Model = value
+			}
+		case "vendor_id":
+			cpu.Vendor = value
+		case "physical id":
+			sockets[value] = true
+		case "cpu cores":
+			cpu.Cores, _ = strconv.Atoi(value)
+		case "flags":
+			cpu.Virtual = strings.Contains(" "+value+" ", " hypervisor ")
+		}
+	}
+	cpu.Sockets = max(len(sockets), 1)
+	cpu.Cores *= cpu.Sockets // "cpu cores" is per socket
+	if cpu.Threads == 0 {
+		cpu.Threads = runtime.NumCPU()
+	}
+	if khz, err := strconv.Atoi(sysfsValue("/sys/devices/system/cpu/cpu0/cpufreq", "cpuinfo_max_freq")); err == nil {
+		cpu.MaxMHz = khz / 1000
+	}
+	return cpu
+}
+
+// smbiosMemory lists the populated memory devices (type 17) of a raw
+// SMBIOS table.
+func smbiosMemory(table []byte) []hardwareMemory {
+	var modules []hardwareMemory
+	for pos := 0; pos+4 <= len(table); {
+		typ, length := table[pos], int(table[pos+1])
+		if length < 4 || pos+length > len(table) {
+			break
+		}
+		formatted := table[pos : pos+length]
+		// the string set follows the formatted area and ends with two NULs
+		end := pos + length
+		for end+1 < len(table) && (table[end] != 0 || table[end+1] != 0) {
+			end++
+		}
+		strs := strings.Split(string(table[pos+length:end]), "\x00")
+		str := func(off int) string {
+			if off >= len(formatted) || formatted[off] == 0 || int(formatted[off]) > len(strs) {
+				return ""
+			}
+			return strings.TrimSpace(strs[formatted[off]-1])
+		}
+
+		if typ == SMBIOS_TYPE_MEM && length >= 0x1B {
+			size := uint64(binary.LittleEndian.Uint16(formatted[0x0C:]))
+			switch {
+			case size == 0 || size == 0xFFFF:
+				size = 0 // empty slot or unknown
+			case size == 0x7FFF && length >= 0x20:
+				size = uint64(binary.LittleEndian.Uint32(formatted[0x1C:])) << 20
+			case size&0x8000 != 0:
+				size = (size & 0x7FFF) << 10 // KB granularity
+			default:
+				size <<= 20
+			}
+			if size > 0 {
+				modules = append(modules, hardwareMemory{
+					Slot:         str(0x10),
+					SizeBytes:    size,
+					Type:         memoryTypes[formatted[0x12]],
+					SpeedMHz:     int(binary.LittleEndian.Uint16(formatted[0x15:])),
+					Manufacturer: str(0x17),
+					SerialNumber: str(0x18),
+					PartNumber:   str(0x1A),
+				})
+			}
+		}
+		if typ == SMBIOS_TYPE_END {
+			break
+		}
+		pos = end + 2
+	}
+	return modules
+}
+
+// linuxDisks lists the physical block devices.
+func linuxDisks() []hardwareDisk {
+	entries, _ := os.ReadDir("/sys/block")
+	var disks []hardwareDisk
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram") ||
+			strings.HasPrefix(name, "dm-") || strings.HasPrefix(name, "md") || strings.HasPrefix(name, "sr") ||
+			strings.HasPrefix(name, "fd") || strings.HasPrefix(name, "nbd") {
+			continue
+		}
+		dir := filepath.Join("/sys/block", name)
+		sectors, _ := strconv.ParseUint(sysfsValue(dir, "size"), 10, 64)
+		d := hardwareDisk{
+			Name:      "/dev/" + name,
+			Model:     sysfsValue(dir, "device/model"),
+			SizeBytes: sectors * SYSFS_SECTOR_SIZE,
+			Type:      "ssd",
+			Removable: sysfsValue(dir, "removable") == "1",
+		}
+		if sysfsValue(dir, "queue/rotational") == "1" {
+			d.Type = "hdd"
+		}
+		d.SerialNumber = firstNonEmpty(sysfsValue(dir, "device/serial"), sysfsValue(dir, "serial"), vpdSerial(dir))
+
+		real, _ := filepath.EvalSymlinks(dir)
+		switch {
+		case strings.HasPrefix(name, "nvme"):
+			d.Bus = "nvme"
+		case strings.Contains(real, "/usb"):
+			d.Bus = "usb"
+		case strings.HasPrefix(name, "vd"):
+			d.Bus = "virtio"
+		case strings.HasPrefix(name, "mmcblk"):
+			d.Bus = "mmc"
+		case strings.Contains(real, "/ata"):
+			d.Bus = "sata"
+		default:
+			d.Bus = "scsi"
+		}
+
+		d.Encryption = "none"
+		seen := make(map[string]bool)
+		for _, part := range diskPartitions(dir) {
+			d.EncryptedVolumes = append(d.EncryptedVolumes, cryptHolders(part, seen)...)
+		}
+		if len(d.EncryptedVolumes) > 0 {
+			d.Encryption = "luks"
+		}
+		disks = append(disks, d)
+	}
+	return disks
+}
+
+// vpdSerial reads the unit serial number VPD page of SCSI/SATA disks.
+func vpdSerial(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "device/vpd_pg80"))
+	if err != nil || len(data) <= 4 {
+		return ""
+	}
+	return strings.TrimSpace(string(data[4:]))
+}
+
+// diskPartitions returns the sysfs directories of a disk and its partitions.
+func diskPartitions(dir string) []string {
+	parts := []string{dir}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(dir, e.Name(), "partition")); err == nil {
+			parts = append(parts, filepath.Join(dir, e.Name()))
+		}
+	}
+	return parts
+}
+
+// cryptHolders follows the device-mapper devices stacked on a block device
+// (LUKS on LVM, LVM on LUKS) and returns the dm-crypt mappings found.
+func cryptHolders(dir string, seen map[string]bool) []string {
+	var found []string
+	holders, _ := os.ReadDir(filepath.Join(dir, "holders"))
+	for _, h := range holders {
+		name := h.Name()
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		holder := filepath.Join("/sys/class/block", name)
+		if strings.HasPrefix(sysfsValue(holder, "dm/uuid"), "CRYPT-") {
+			found = append(found, firstNonEmpty(sysfsValue(holder, "dm/name"), name))
+		}
+		found = append(found, cryptHolders(holder, seen)...)
+	}
+	return found
+}
+
+func linuxTPM() hardwareTPM {
+	dir := "/sys/class/tpm/tpm0"
+	if _, err := os.Stat(dir); err != nil {
+		return hardwareTPM{}
+	}
+	tpm := hardwareTPM{Present: true, Version: "1.2"}
+	if major := sysfsValue(dir, "tpm_version_major"); major == "2" {
+		tpm.Version = "2.0"
+	} else if _, err := os.Stat("/dev/tpmrm0"); err == nil {
+		tpm.Version = "2.0" // kernels before 5.6 have no tpm_version_major
+	}
+	if enabled := sysfsValue(dir, "device/enabled"); enabled != "" {
+		on := enabled == "1"
+		tpm.Enabled = &on
+	}
+	return tpm
+}
@@ -0,0 +1,97 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// smbiosMemoryDevice builds a type 17 structure (SMBIOS 3.x length) with
+// the device locator, manufacturer, serial and part number strings; empty
+// ones are left unset.
+func smbiosMemoryDevice(size uint16, extended uint32, memType byte, speed uint16, strs ...string) []byte {
+	s := make([]byte, 0x28)
+	s[0], s[1] = SMBIOS_TYPE_MEM, byte(len(s))
+	binary.LittleEndian.PutUint16(s[0x0C:], size)
+	s[0x12] = memType
+	binary.LittleEndian.PutUint16(s[0x15:], speed)
+	binary.LittleEndian.PutUint32(s[0x1C:], extended)
+	n := 0
+	for i, off := range []int{0x10, 0x17, 0x18, 0x1A} {
+		if i < len(strs) && strs[i] != "" {
+			n++
+			s[off] = byte(n)
+			s = append(s, strs[i]...)
+			s = append(s, 0)
+		}
+	}
+	if n == 0 {
+		s = append(s, 0)
+	}
+	return append(s, 0)
+}
+
+func TestSmbiosMemory(t *testing.T) {
+	// a structure of another type (BIOS information) with strings
+	bios := append([]byte{0, 0x18}, make([]byte, 0x16)...)
+	bios[4] = 1
+	bios = append(bios, "Vendor\x00\x00"...)
+	end := []byte{SMBIOS_TYPE_END, 4, 0, 0, 0, 0}
+
+	tests := []struct {
+		name  string
+		table []byte
+		want  []hardwareMemory
+	}{
+		{
+			"populated and empty slots",
+			concatBytes(
+				bios,
+				smbiosMemoryDevice(8192, 0, 0x1A, 3200, "DIMM A", "Samsung", "1234ABCD", "M471A1K43DB1 "),
+				smbiosMemoryDevice(0, 0, 0x02, 0, "DIMM B"),
+				smbiosMemoryDevice(0xFFFF, 0, 0x1A, 0, "DIMM C"),
+				end,
+			),
+			[]hardwareMemory{{Slot: "DIMM A", SizeBytes: 8 << 30, Type: "DDR4", SpeedMHz: 3200, Manufacturer: "Samsung", SerialNumber: "1234ABCD", PartNumber: "M471A1K43DB1"}},
+		},
+		{
+			"extended and KB sizes",
+			concatBytes(
+				smbiosMemoryDevice(0x7FFF, 64*1024, 0x22, 4800, "CPU0_DIMM_A1", "", "", ""),
+				smbiosMemoryDevice(0x8000|512, 0, 0x12, 0, "ROM"),
+				end,
+			),
+			[]hardwareMemory{
+				{Slot: "CPU0_DIMM_A1", SizeBytes: 64 << 30, Type: "DDR5", SpeedMHz: 4800},
+				{Slot: "ROM", SizeBytes: 512 << 10, Type: "DDR"},
+			},
+		},
+		{
+			"nothing after the end structure",
+			concatBytes(end, smbiosMemoryDevice(4096, 0, 0x18, 1600, "DIMM0")),
+			nil,
+		},
+		{
+			"truncated structure",
+			smbiosMemoryDevice(4096, 0, 0x18, 1600, "DIMM0")[:0x20],
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := smbiosMemory(tt.table); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("smbiosMemory = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
@@ -0,0 +1,58 @@
+//go:build !windows && !linux
+
+package main
+
+import (
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// macOS hardware inventory from sysctl, the IOPlatformExpertDevice
+// registry entry and fdesetup. Disks are not enumerated.
+
+var ioregPropertyRegex = regexp.MustCompile(`"(\w+)" = <?"?([^">]*)"?>?`)
+
+func platformHardware() hardwareInventory {
+	inv := hardwareInventory{CPU: hardwareCPU{Arch: runtime.GOARCH, Threads: runtime.NumCPU()}}
+
+	sysctl := func(name string) string {
+		out, err := runCommandWithTimeout("sysctl", "-n", name)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+	inv.CPU.Model = sysctl("machdep.cpu.brand_string")
+	inv.CPU.Vendor = sysctl("machdep.cpu.vendor")
+	inv.CPU.Cores, _ = strconv.Atoi(sysctl("hw.physicalcpu"))
+	inv.CPU.Sockets = 1
+	inv.CPU.Virtual = sysctl("kern.hv_vmm_present") == "1"
+	inv.MemoryBytes, _ = strconv.ParseUint(sysctl("hw.memsize"), 10, 64)
+	inv.Model = sysctl("hw.model")
+	inv.Manufacturer = "Apple Inc."
+	inv.Firmware.Type = "uefi"
+
+	if out, err := runCommandWithTimeout("ioreg", "-rd1", "-c", "IOPlatformExpertDevice"); err == nil {
+		for _, m := range ioregPropertyRegex.FindAllStringSubmatch(string(out), -1) {
+			switch m[1] {
+			case "IOPlatformSerialNumber":
+				inv.SerialNumber = m[2]
+			case "IOPlatformUUID":
+				inv.UUID = m[2]
+			}
+		}
+	}
+
+	// FileVault covers the boot volume
+	if out, err := runCommandWithTimeout("fdesetup", "status"); err == nil {
+		disk := hardwareDisk{Name: "/", Encryption: "none"}
+		if strings.Contains(string(out), "FileVault is On") {
+			disk.Encryption = "filevault"
+			disk.EncryptedVolumes = []string{"/"}
+		}
+		inv.Disks = append(inv.Disks, disk)
+	}
+	return inv
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHardwareSections(t *testing.T) {
+	base := hardwareInventory{
+		Manufacturer: "Dell Inc.",
+		Model:        "Latitude 7440",
+		MemoryBytes:  16*1024*1024*1024 - 420*1024*1024,
+		Disks: []hardwareDisk{
+			{Name: "/dev/nvme0n1", SerialNumber: "S6P1", SizeBytes: 512e9, Bus: "nvme", Encryption: "luks"},
+		},
+		Interfaces: []netInterface{{Name: "eth0", MAC: "aa:bb:cc:dd:ee:ff"}},
+	}
+	withBanks := base
+	withBanks.MemoryBanks = []hardwareMemory{{Slot: "DIMM A", SizeBytes: 8 << 30, SerialNumber: "1111"}, {Slot: "DIMM B", SizeBytes: 8 << 30, SerialNumber: "2222"}}
+
+	tests := []struct {
+		name    string
+		before  hardwareInventory
+		change  func(inv *hardwareInventory)
+		changed []string
+	}{
+		{"usb stick plugged in", base, func(inv *hardwareInventory) {
+			inv.Disks = append(inv.Disks, hardwareDisk{Name: "/dev/sda", SizeBytes: 32e9, Bus: "usb", Encryption: "none"})
+		}, nil},
+		{"sd card inserted", base, func(inv *hardwareInventory) {
+			inv.Disks = append(inv.Disks, hardwareDisk{Name: "/dev/mmcblk0", SizeBytes: 64e9, Bus: "mmc", Removable: true, Encryption: "none"})
+		}, nil},
+		{"internal disk swapped", base, func(inv *hardwareInventory) {
+			inv.Disks[0].SerialNumber = "S6P2"
+		}, []string{"disks"}},
+		{"memtotal drift", base, func(inv *hardwareInventory) {
+			inv.MemoryBytes += 24 * 1024 * 1024
+		}, nil},
+		{"memory added without modules", base, func(inv *hardwareInventory) {
+			inv.MemoryBytes *= 2
+		}, []string{"memory"}},
+		{"memtotal drift with modules", withBanks, func(inv *hardwareInventory) {
+			inv.MemoryBytes -= 600 * 1024 * 1024
+		}, nil},
+		{"module replaced", withBanks, func(inv *hardwareInventory) {
+			inv.MemoryBanks = []hardwareMemory{inv.MemoryBanks[0], {Slot: "DIMM B", SizeBytes: 8 << 30, SerialNumber: "3333"}}
+		}, []string{"memory"}},
+		{"address change", base, func(inv *hardwareInventory) {
+			inv.Interfaces = []netInterface{{Name: "eth0", MAC: "aa:bb:cc:dd:ee:ff", Addresses: []string{"10.0.0.9/24"}}}
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			after := tt.before
+			after.Disks = append([]hardwareDisk(nil), tt.before.Disks...)
+			tt.change(&after)
+			before, now := tt.before.sections(), after.sections()
+			var changed []string
+			for _, name := range []string{"system", "firmware", "cpu", "memory", "disks", "tpm", "interfaces"} {
+				if before[name] != now[name] {
+					changed = append(changed, name)
+				}
+			}
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("changed sections = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
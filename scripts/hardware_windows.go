@@ -0,0 +1,172 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"strings"
+)
+
+// Windows hardware inventory through CIM (Win32_ComputerSystem, Win32_BIOS,
+// Win32_Processor, Win32_PhysicalMemory, Win32_Tpm) and the Storage and
+// BitLocker modules. Disks are a separate query so a slow BitLocker call
+// cannot time out the rest.
+
+const hardwareSystemScript = `
+	$ErrorActionPreference = 'SilentlyContinue'
+	$cs = Get-CimInstance Win32_ComputerSystem
+	$bios = Get-CimInstance Win32_BIOS
+	$cpus = @(Get-CimInstance Win32_Processor)
+	$tpm = Get-CimInstance -Namespace root\cimv2\Security\MicrosoftTpm -ClassName Win32_Tpm
+	$sb = $null
+	try { $sb = Confirm-SecureBootUEFI -ErrorAction Stop } catch {}
+	[pscustomobject]@{
+		manufacturer = $cs.Manufacturer; model = $cs.Model; serial = $bios.SerialNumber
+		uuid = (Get-CimInstance Win32_ComputerSystemProduct).UUID
+		chassis = [int]@((Get-CimInstance Win32_SystemEnclosure).ChassisTypes)[0]
+		bios_vendor = $bios.Manufacturer; bios_version = $bios.SMBIOSBIOSVersion
+		bios_date = $(if ($bios.ReleaseDate) { $bios.ReleaseDate.ToString('yyyy-MM-dd') })
+		firmware = $env:firmware_type; secure_boot = $sb
+		cpu_model = $cpus[0].Name; cpu_vendor = $cpus[0].Manufacturer; sockets = $cpus.Count
+		cores = [int]($cpus | Measure-Object NumberOfCores -Sum).Sum
+		threads = [int]($cpus | Measure-Object NumberOfLogicalProcessors -Sum).Sum
+		max_mhz = [int]$cpus[0].MaxClockSpeed; virtual = [bool]$cs.HypervisorPresent
+		memory = [uint64]$cs.TotalPhysicalMemory
+		modules = @(Get-CimInstance Win32_PhysicalMemory | ForEach-Object {
+			[pscustomobject]@{
+				slot = $_.DeviceLocator; size = [uint64]$_.Capacity; type = [int]$_.SMBIOSMemoryType
+				speed = [int]$_.Speed; manufacturer = $_.Manufacturer; serial = $_.SerialNumber; part = $_.PartNumber
+			}
+		})
+		tpm_present = [bool]$tpm; tpm_version = $tpm.SpecVersion; tpm_manufacturer = $tpm.ManufacturerIdTxt
+		tpm_enabled = $tpm.IsEnabled_InitialValue
+	} | ConvertTo-Json -Depth 4 -Compress
+`
+
+const hardwareDiskScript = `
+	$ErrorActionPreference = 'SilentlyContinue'
+	$bitlocker = [bool](Get-Command Get-BitLockerVolume)
+	$encrypted = @{}
+	foreach ($v in Get-BitLockerVolume) {
+		if ($v.VolumeStatus -eq 'FullyDecrypted') { continue }
+		$part = Get-Partition -DriveLetter ($v.MountPoint.TrimEnd(':\'))
+		if ($part) { $encrypted[[int]$part.DiskNumber] += @("$($v.MountPoint) ($($v.VolumeStatus), protection $($v.ProtectionStatus))") }
+	}
+	$disks = foreach ($d in Get-CimInstance Win32_DiskDrive) {
+		$pd = Get-PhysicalDisk | Where-Object { $_.DeviceId -eq [string]$d.Index }
+		[pscustomobject]@{
+			name = $d.DeviceID; model = $d.Model; serial = ([string]$d.SerialNumber).Trim(); size = [uint64]$d.Size
+			media = [string]$pd.MediaType; bus = [string]$pd.BusType; removable = ([string]$d.MediaType -like 'Removable*')
+			encrypted = @($encrypted[[int]$d.Index] | Where-Object { $_ })
+		}
+	}
+	[pscustomobject]@{ bitlocker = $bitlocker; disks = @($disks) } | ConvertTo-Json -Depth 4 -Compress
+`
+
+func platformHardware() hardwareInventory {
+	inv := hardwareInventory{CPU: hardwareCPU{Arch: runtime.GOARCH, Threads: runtime.NumCPU()}}
+
+	if out, err := runCommandWithTimeout("powershell", "-Command", hardwareSystemScript); err == nil {
+		var s struct {
+			Manufacturer, Model, Serial, UUID string
+			Chassis                           int
+			BiosVendor                        string `json:"bios_vendor"`
+			BiosVersion                       string `json:"bios_version"`
+			BiosDate                          string `json:"bios_date"`
+			Firmware                          string
+			SecureBoot                        *bool  `json:"secure_boot"`
+			CPUModel                          string `json:"cpu_model"`
+			CPUVendor                         string `json:"cpu_vendor"`
+			Sockets, Cores, Threads           int
+			MaxMHz                            int `json:"max_mhz"`
+			Virtual                           bool
+			Memory                            uint64
+			Modules                           []struct {
+				Slot, Manufacturer, Serial, Part string
+				Size                             uint64
+				Type, Speed                      int
+			}
+			TPMPresent      bool   `json:"tpm_present"`
+			TPMVersion      string `json:"tpm_version"`
+			TPMManufacturer string `json:"tpm_manufacturer"`
+			TPMEnabled      *bool  `json:"tpm_enabled"`
+		}
+		if json.Unmarshal(out, &s) == nil {
+			inv.Manufacturer, inv.Model, inv.SerialNumber, inv.UUID = s.Manufacturer, s.Model, strings.TrimSpace(s.Serial), s.UUID
+			inv.ChassisType = chassisTypes[s.Chassis]
+			inv.Firmware = hardwareFirmware{
+				Vendor:     s.BiosVendor,
+				Version:    s.BiosVersion,
+				Date:       s.BiosDate,
+				Type:       strings.ToLower(s.Firmware), // UEFI, Legacy
+				SecureBoot: s.SecureBoot,
+			}
+			if inv.Firmware.Type == "legacy" {
+				inv.Firmware.Type = "bios"
+			}
+			inv.CPU = hardwareCPU{
+				Model:   strings.TrimSpace(s.CPUModel),
+				Vendor:  s.CPUVendor,
+				Sockets: s.Sockets,
+				Cores:   s.Cores,
+				Threads: max(s.Threads, 1),
+				MaxMHz:  s.MaxMHz,
+				Arch:    runtime.GOARCH,
+				Virtual: s.Virtual,
+			}
+			inv.MemoryBytes = s.Memory
+			for _, m := range s.Modules {
+				inv.MemoryBanks = append(inv.MemoryBanks, hardwareMemory{
+					Slot:         m.Slot,
+					SizeBytes:    m.Size,
+					Type:         memoryTypes[byte(m.Type)],
+					SpeedMHz:     m.Speed,
+					Manufacturer: strings.TrimSpace(m.Manufacturer),
+					SerialNumber: strings.TrimSpace(m.Serial),
+					PartNumber:   strings.TrimSpace(m.Part),
+				})
+			}
+			inv.TPM = hardwareTPM{Present: s.TPMPresent, Manufacturer: s.TPMManufacturer, Enabled: s.TPMEnabled}
+			// SpecVersion: "2.0, 0, 1.38"
+			inv.TPM.Version, _, _ = strings.Cut(s.TPMVersion, ",")
+		}
+	}
+
+	if out, err := runCommandWithTimeout("powershell", "-Command", hardwareDiskScript); err == nil {
+		var d struct {
+			BitLocker bool
+			Disks     []struct {
+				Name, Model, Serial, Media, Bus string
+				Size                            uint64
+				Removable                       bool
+				Encrypted                       []string
+			}
+		}
+		if json.Unmarshal(out, &d) == nil {
+			for _, disk := range d.Disks {
+				hd := hardwareDisk{
+					Name:             disk.Name,
+					Model:            disk.Model,
+					SerialNumber:     disk.Serial,
+					SizeBytes:        disk.Size,
+					Type:             strings.ToLower(disk.Media), // SSD, HDD, Unspecified
+					Bus:              strings.ToLower(disk.Bus),
+					Removable:        disk.Removable,
+					Encryption:       "unknown", // BitLocker is not available on Home editions
+					EncryptedVolumes: disk.Encrypted,
+				}
+				if hd.Type == "unspecified" {
+					hd.Type = ""
+				}
+				if len(disk.Encrypted) > 0 {
+					hd.Encryption = "bitlocker"
+				} else if d.BitLocker {
+					hd.Encryption = "none"
+				}
+				inv.Disks = append(inv.Disks, hd)
+			}
+		}
+	}
+	return inv
+}
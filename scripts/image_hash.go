@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Executable hashing. SHA-256 is always computed; with extended_hashes the
+// PE import hash and an ssdeep-compatible fuzzy hash are added so that
+// recompiled or repacked variants of the same tool can still be grouped.
+// Results are cached per path, inode, size and mtime and persisted, so an
+// agent restart does not re-read every running binary.
+
+const (
+	IMAGE_HASH_CACHE_FILE = "image-hashes.json"
+	IMAGE_HASH_CACHE_MAX  = 8192
+	IMAGE_FUZZY_MAX_SIZE  = 32 * 1024 * 1024 // larger images get SHA-256 only
+)
+
+// imageHashes is what the cache stores for one file version.
+type imageHashes struct {
+	SHA256  string `json:"sha256"`
+	Imphash string `json:"imphash,omitempty"`
+	SSDeep  string `json:"ssdeep,omitempty"`
+	Size    int64  `json:"size"`
+}
+
+type imageHashCache struct {
+	mu      sync.Mutex
+	entries map[string]imageHashes // "path|inode|size|mtime"
+	loaded  bool
+	dirty   bool
+}
+
+var imageHashCacheTable = &imageHashCache{entries: make(map[string]imageHashes)}
+
+// hashImage returns the hashes of an executable, or ok=false when it cannot
+// be read or is too large.
+func hashImage(path string) (imageHashes, bool) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > PROCESS_HASH_MAX_SIZE {
+		return imageHashes{}, false
+	}
+	extended := agentConfig.ExtendedHashes
+	key := fmt.Sprintf("%s|%d|%d|%d", path, fileInode(path, info), info.Size(), info.ModTime().UnixNano())
+
+	c := imageHashCacheTable
+	c.mu.Lock()
+	c.load()
+	h, ok := c.entries[key]
+	c.mu.Unlock()
+	// Cached before extended hashes were enabled: hash again
+	if ok && (!extended || h.Imphash != "" || h.SSDeep != "" || info.Size() > IMAGE_FUZZY_MAX_SIZE) {
+		return h, true
+	}
+
+	h, err = computeImageHashes(path, info.Size(), extended)
+	if err != nil {
+		return imageHashes{}, false
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= IMAGE_HASH_CACHE_MAX {
+		c.entries = make(map[string]imageHashes)
+	}
+	c.entries[key] = h
+	c.dirty = true
+	c.mu.Unlock()
+	return h, true
+}
+
+func computeImageHashes(path string, size int64, extended bool) (imageHashes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return imageHashes{}, err
+	}
+	defer f.Close()
+
+	h := imageHashes{Size: size}
+	if !extended || size > IMAGE_FUZZY_MAX_SIZE {
+		sum := sha256.New()
+		if _, err := io.Copy(sum, f); err != nil {
+			return imageHashes{}, err
+		}
+		h.SHA256 = hex.EncodeToString(sum.Sum(nil))
+		return h, nil
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return imageHashes{}, err
+	}
+	sum := sha256.Sum256(data)
+	h.SHA256 = hex.EncodeToString(sum[:])
+	h.Imphash = peImphash(data)
+	h.SSDeep = fuzzyHash(data)
+	return h, nil
+}
+
+func (c *imageHashCache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	data, err := os.ReadFile(filepath.Join(agentDir, IMAGE_HASH_CACHE_FILE))
+	if err != nil {
+		return
+	}
+	stored := make(map[string]imageHashes)
+	if json.Unmarshal(data, &stored) == nil {
+		c.entries = stored
+	}
+}
+
+// save writes the cache when it changed since the last save.
+func (c *imageHashCache) save() {
+	c.mu.Lock()
+	if !c.dirty || replayOutput != nil {
+		c.mu.Unlock()
+		return
+	}
+	data, _ := json.Marshal(c.entries)
+	c.dirty = false
+	c.mu.Unlock()
+	if err := os.WriteFile(filepath.Join(agentDir, IMAGE_HASH_CACHE_FILE), data, 0644); err != nil {
+		logMessage("Image hash cache write failed: " + err.Error())
+	}
+}
+
+// peImphash computes the import hash of a PE image the way pefile does:
+// md5 of the comma separated "dll.function" list, lowercase, in import
+// order. Ordinal imports are written as "ordN" (pefile resolves a few
+// well-known ws2_32/oleaut32 ordinals to names; we do not).
+func peImphash(data []byte) string {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var dir pe.DataDirectory
+	is64 := false
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if oh.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_IMPORT {
+			dir = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_IMPORT]
+		}
+	case *pe.OptionalHeader64:
+		if oh.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_IMPORT {
+			dir = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_IMPORT]
+		}
+		is64 = true
+	default:
+		return ""
+	}
+	if dir.VirtualAddress == 0 {
+		return ""
+	}
+
+	// rva maps a virtual address to the file bytes behind it
+	rva := func(addr uint32) []byte {
+		for _, s := range f.Sections {
+			if addr >= s.VirtualAddress && addr < s.VirtualAddress+max(s.VirtualSize, s.Size) {
+				off := int64(s.Offset) + int64(addr-s.VirtualAddress)
+				end := int64(s.Offset) + int64(s.Size)
+				if off < end && end <= int64(len(data)) {
+					return data[off:end]
+				}
+			}
+		}
+		return nil
+	}
+	cstring := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b)
+	}
+
+	var entries []string
+	desc := rva(dir.VirtualAddress)
+	for len(desc) >= 20 && len(entries) < 65536 {
+		lookup := binary.LittleEndian.Uint32(desc[0:4])
+		nameRVA := binary.LittleEndian.Uint32(desc[12:16])
+		first := binary.LittleEndian.Uint32(desc[16:20])
+		desc = desc[20:]
+		if nameRVA == 0 && first == 0 {
+			break
+		}
+		lib := strings.ToLower(cstring(rva(nameRVA)))
+		if ext := filepath.Ext(lib); ext == ".dll" || ext == ".ocx" || ext == ".sys" {
+			lib = strings.TrimSuffix(lib, ext)
+		}
+		if lookup == 0 {
+			lookup = first // no import lookup table: the IAT holds the same thunks
+		}
+
+		thunks := rva(lookup)
+		for len(entries) < 65536 {
+			var thunk uint64
+			var ordinal bool
+			if is64 {
+				if len(thunks) < 8 {
+					break
+				}
+				thunk = binary.LittleEndian.Uint64(thunks)
+				ordinal = thunk&(1<<63) != 0
+				thunks = thunks[8:]
+			} else {
+				if len(thunks) < 4 {
+					break
+				}
+				thunk = uint64(binary.LittleEndian.Uint32(thunks))
+				ordinal = thunk&(1<<31) != 0
+				thunks = thunks[4:]
+			}
+			if thunk == 0 {
+				break
+			}
+			fn := ""
+			if ordinal {
+				fn = fmt.Sprintf("ord%d", thunk&0xffff)
+			} else if hint := rva(uint32(thunk & 0x7fffffff)); len(hint) > 2 {
+				fn = strings.ToLower(cstring(hint[2:]))
+			}
+			if fn != "" {
+				entries = append(entries, lib+"."+fn)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+	sum := md5.Sum([]byte(strings.Join(entries, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Context triggered piecewise hash, compatible with ssdeep/spamsum output
+// ("blocksize:hash:hash2") so the server can compare it with ssdeep tooling.
+const (
+	fuzzyHashLength   = 64
+	fuzzyMinBlockSize = 3
+	fuzzyWindow       = 7
+	fuzzyHashPrime    = 0x01000193
+	fuzzyHashInit     = 0x28021967
+	fuzzyAlphabet     = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+)
+
+func fuzzyHash(data []byte) string {
+	blockSize := uint32(fuzzyMinBlockSize)
+	for uint64(blockSize)*fuzzyHashLength < uint64(len(data)) {
+		blockSize *= 2
+	}
+
+	for {
+		var window [fuzzyWindow]byte
+		var r1, r2, r3, n uint32
+		h1, h2 := uint32(fuzzyHashInit), uint32(fuzzyHashInit)
+		var sig1, sig2 []byte
+
+		for _, c := range data {
+			h1 = h1*fuzzyHashPrime ^ uint32(c)
+			h2 = h2*fuzzyHashPrime ^ uint32(c)
+
+			// rolling hash over the last fuzzyWindow bytes
+			r2 -= r1
+			r2 += fuzzyWindow * uint32(c)
+			r1 += uint32(c)
+			r1 -= uint32(window[n%fuzzyWindow])
+			window[n%fuzzyWindow] = c
+			n++
+			r3 = r3<<5 ^ uint32(c)
+			roll := r1 + r2 + r3
+
+			if roll%blockSize == blockSize-1 {
+				if len(sig1) < fuzzyHashLength-1 {
+					sig1 = append(sig1, fuzzyAlphabet[h1%64])
+					h1 = fuzzyHashInit
+				}
+				if roll%(blockSize*2) == blockSize*2-1 && len(sig2) < fuzzyHashLength/2-1 {
+					sig2 = append(sig2, fuzzyAlphabet[h2%64])
+					h2 = fuzzyHashInit
+				}
+			}
+		}
+		// Too few pieces for this block size: halve it and try again
+		if blockSize > fuzzyMinBlockSize && len(sig1) < fuzzyHashLength/2 {
+			blockSize /= 2
+			continue
+		}
+		// The unfinished last piece
+		if r1+r2+r3 != 0 {
+			sig1 = append(sig1, fuzzyAlphabet[h1%64])
+			sig2 = append(sig2, fuzzyAlphabet[h2%64])
+		}
+		return fmt.Sprintf("%d:%s:%s", blockSize, sig1, sig2)
+	}
+}
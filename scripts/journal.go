@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemd journal export format (journalctl -o export) and classic syslog
+// line parsing. Platform independent so exported journal files can be
+// replayed anywhere with -replay-journal; the live collector is in
+// journal_linux.go.
+
+const (
+	JOURNAL_CURSOR_FILE = "journal.cursor"
+	SYSLOG_OFFSETS_FILE = "syslog.offsets"
+)
+
+// Journal fields copied into RawData under their own names
+var journalRawFields = []string{
+	"_SYSTEMD_UNIT", "_SYSTEMD_USER_UNIT", "_PID", "_UID", "_GID", "_COMM", "_EXE",
+	"_CMDLINE", "_HOSTNAME", "_TRANSPORT", "_BOOT_ID", "SYSLOG_IDENTIFIER",
+	"SYSLOG_FACILITY", "PRIORITY", "MESSAGE_ID", "CODE_FILE", "CODE_FUNC",
+}
+
+// journalEntry is one entry of the export stream: field name -> value.
+type journalEntry map[string]string
+
+// readJournalExport calls fn for every entry of a journal export stream.
+// Text fields are "NAME=value\n"; binary fields are "NAME\n", a little-endian
+// uint64 length, the data and "\n". Entries end with an empty line.
+func readJournalExport(r io.Reader, fn func(journalEntry) bool) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	entry := make(journalEntry)
+
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			if len(entry) > 0 {
+				fn(entry)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		if line == "" {
+			if len(entry) > 0 && !fn(entry) {
+				return nil
+			}
+			entry = make(journalEntry)
+			continue
+		}
+
+		if name, value, ok := strings.Cut(line, "="); ok {
+			entry[name] = value
+			continue
+		}
+
+		// Binary field
+		var size uint64
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return fmt.Errorf("journal export: field %s: %v", line, err)
+		}
+		if size > 16*1024*1024 {
+			return fmt.Errorf("journal export: field %s too large (%d bytes)", line, size)
+		}
+		data := make([]byte, size+1) // trailing newline
+		if _, err := io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("journal export: field %s: %v", line, err)
+		}
+		entry[line] = string(data[:size])
+	}
+}
+
+// journalSeverity maps a syslog PRIORITY to our severity levels.
+func journalSeverity(priority string) string {
+	switch priority {
+	case "0", "1": // emerg, alert
+		return "critical"
+	case "2": // crit
+		return "high"
+	case "3": // err
+		return "error"
+	case "4": // warning
+		return "warning"
+	}
+	return "info" // notice, info, debug
+}
+
+// journalLogType maps the entry to the application/system/security types the
+// Windows collector uses.
+func journalLogType(e journalEntry) string {
+	switch e["SYSLOG_FACILITY"] {
+	case "4", "10": // auth, authpriv
+		return "security"
+	}
+	if e["_SYSTEMD_USER_UNIT"] != "" {
+		return "application"
+	}
+	return "system"
+}
+
+// logEntry converts a journal entry to a LogEntry.
+func (e journalEntry) logEntry(host string) (LogEntry, bool) {
+	msg := e["MESSAGE"]
+	if msg == "" {
+		return LogEntry{}, false
+	}
+
+	ts := time.Now().UTC()
+	if usec, err := strconv.ParseInt(e["__REALTIME_TIMESTAMP"], 10, 64); err == nil {
+		ts = time.UnixMicro(usec).UTC()
+	}
+
+	raw := make(map[string]interface{})
+	for _, f := range journalRawFields {
+		if v, ok := e[f]; ok {
+			raw[f] = v
+		}
+	}
+	raw["cursor"] = e["__CURSOR"]
+
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   host,
+		LogType:    journalLogType(e),
+		Source:     "journald",
+		Severity:   journalSeverity(e["PRIORITY"]),
+		Message:    msg,
+		Timestamp:  ts.Format(time.RFC3339),
+		RawData:    raw,
+	}, true
+}
+
+// Mon dd hh:mm:ss host program[pid]: message
+var syslogLineRegex = regexp.MustCompile(`^([A-Z][a-z]{2} +\d{1,2} \d{2}:\d{2}:\d{2}) (\S+) ([^:\[\s]+)(?:\[(\d+)\])?: (.*)$`)
+
+// syslogPriority splits the <PRI> prefix (facility * 8 + severity) that
+// rsyslog and syslog-ng write when their template includes it.
+func syslogPriority(line string) (facility, severity int, rest string, ok bool) {
+	if !strings.HasPrefix(line, "<") {
+		return 0, 0, line, false
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return 0, 0, line, false
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, 0, line, false
+	}
+	return pri / 8, pri % 8, strings.TrimSpace(line[end+1:]), true
+}
+
+// syslogLogEntry parses one RFC 3164 line from a /var/log file. Severity
+// comes from the line's priority; lines written without one are "info".
+func syslogLogEntry(line, file, host string, now time.Time) (LogEntry, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return LogEntry{}, false
+	}
+
+	raw := map[string]interface{}{"file": file}
+	severity := "info"
+	logType := "system"
+	if facility, level, rest, ok := syslogPriority(line); ok {
+		line = rest
+		raw["PRIORITY"] = strconv.Itoa(level)
+		raw["SYSLOG_FACILITY"] = strconv.Itoa(facility)
+		severity = journalSeverity(strconv.Itoa(level))
+		logType = journalLogType(journalEntry{"SYSLOG_FACILITY": strconv.Itoa(facility)})
+	}
+	msg := line
+	ts := now.UTC()
+
+	if m := syslogLineRegex.FindStringSubmatch(line); m != nil {
+		// RFC 3164 timestamps carry no year or zone
+		if t, err := time.ParseInLocation("Jan _2 15:04:05", m[1], time.Local); err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			ts = t.UTC()
+		}
+		raw["_HOSTNAME"] = m[2]
+		raw["SYSLOG_IDENTIFIER"] = m[3]
+		raw["_COMM"] = m[3]
+		if m[4] != "" {
+			raw["_PID"] = m[4]
+		}
+		msg = m[5]
+	}
+
+	base := filepath.Base(file)
+	if base == "auth.log" || base == "secure" {
+		logType = "security"
+	}
+
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   host,
+		LogType:    logType,
+		Source:     "syslog-" + base,
+		Severity:   severity,
+		Message:    msg,
+		Timestamp:  ts.Format(time.RFC3339),
+		RawData:    raw,
+	}, true
+}
+
+// replayJournal prints the LogEntry stream for a journal export file.
+func replayJournal(path string, out io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	replayOutput = out
+	defer func() { replayOutput = nil }()
+
+	host := getHostname()
+	count := 0
+	err = readJournalExport(f, func(e journalEntry) bool {
+		if entry, ok := e.logEntry(host); ok {
+			sendLog(entry)
+			count++
+		}
+		return true
+	})
+	logMessage(fmt.Sprintf("Replayed %d journal entries from %s", count, path))
+	return err
+}
+
+// journalCursorSeq returns the seqnum id and sequence number of a cursor
+// ("s=<id>;i=<hex seqnum>;b=...").
+func journalCursorSeq(cursor string) (string, uint64, bool) {
+	var id string
+	var seq uint64
+	found := false
+	for _, part := range strings.Split(cursor, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "s":
+			id = v
+		case "i":
+			n, err := strconv.ParseUint(v, 16, 64)
+			if err != nil {
+				return "", 0, false
+			}
+			seq, found = n, true
+		}
+	}
+	return id, seq, found && id != ""
+}
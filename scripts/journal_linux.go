@@ -0,0 +1,286 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// JOURNAL_READ_TIMEOUT bounds one journalctl run; entries read before it
+	// expires are kept and the rest follows next cycle
+	JOURNAL_READ_TIMEOUT = 60 * time.Second
+	// JOURNAL_CURSOR_SAVE_EVERY entries the cursor is saved while reading
+	JOURNAL_CURSOR_SAVE_EVERY = 100
+)
+
+// Classic syslog files read when journald is not available
+var syslogFiles = []string{"/var/log/syslog", "/var/log/messages", "/var/log/auth.log", "/var/log/secure", "/var/log/kern.log"}
+
+// syslogOffset remembers how far each file was read; a new inode or a
+// shorter file means it was rotated and is read from the start.
+type syslogOffset struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// collectSystemLogs sends new audit events and journal entries, falling
+// back to /var/log for the latter.
+func collectSystemLogs() {
+	collectAuditLog()
+
+	if _, err := exec.LookPath("journalctl"); err == nil {
+		err := collectJournal()
+		if err == nil {
+			return
+		}
+		logMessage("Journal collection failed, using /var/log: " + err.Error())
+	}
+	collectSyslogFiles()
+}
+
+func collectJournal() error {
+	bookmark := loadBookmark(JOURNAL_CURSOR_FILE)
+	args := []string{"-o", "export", "--no-pager"}
+	if bookmark != "" {
+		args = append(args, "--after-cursor", bookmark)
+	} else {
+		args = append(args, "-n", fmt.Sprint(LOG_INITIAL_ENTRIES))
+	}
+
+	// Streamed rather than buffered: the backlog after the cursor has no size
+	// limit, and journalctl is stopped once the catch-up limit is reached
+	ctx, cancel := context.WithTimeout(context.Background(), JOURNAL_READ_TIMEOUT)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	host := getHostname()
+	limit := logCatchUpLimit()
+	cursor := ""
+	read, sent := 0, 0
+	var sendErr error
+	err = readJournalExport(stdout, func(e journalEntry) bool {
+		if read == 0 && bookmark != "" {
+			checkJournalGap(bookmark, e["__CURSOR"])
+		}
+		read++
+
+		if entry, ok := e.logEntry(host); ok {
+			// The cursor stays before the entry that failed
+			if sendErr = sendLog(entry); sendErr != nil {
+				return false
+			}
+			sent++
+		}
+		cursor = e["__CURSOR"]
+		if read%JOURNAL_CURSOR_SAVE_EVERY == 0 && cursor != "" {
+			saveBookmark(JOURNAL_CURSOR_FILE, cursor)
+		}
+		// The rest is picked up after the saved cursor next cycle
+		return sent < limit
+	})
+	stopped := sent >= limit
+	cancel()
+	waitErr := cmd.Wait()
+	if cursor != "" {
+		saveBookmark(JOURNAL_CURSOR_FILE, cursor)
+	}
+	if sendErr != nil {
+		// The server is unreachable, not the journal: /var/log would not help
+		logMessage(fmt.Sprintf("Journal collection stopped after %d entries: %v", sent, sendErr))
+		return nil
+	}
+
+	if err == nil && !stopped && waitErr != nil {
+		err = fmt.Errorf("journalctl: %v %s", waitErr, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil && cursor != "" {
+		// Entries already sent are behind the saved cursor; falling back to
+		// /var/log now would send them twice
+		logMessage(fmt.Sprintf("Journal read interrupted after %d entries: %v", read, err))
+		return nil
+	}
+	return err
+}
+
+// checkJournalGap compares the first entry read with the bookmark. journalctl
+// seeks to the nearest entry when the bookmarked one was vacuumed, so a jump
+// in the sequence number means entries were lost.
+func checkJournalGap(bookmark, next string) {
+	bookmarkID, bookmarkSeq, ok1 := journalCursorSeq(bookmark)
+	nextID, nextSeq, ok2 := journalCursorSeq(next)
+	if !ok1 || !ok2 || bookmarkID != nextID || nextSeq <= bookmarkSeq+1 {
+		return
+	}
+	reportLogGap("journald", "system", "journal vacuumed past bookmark", map[string]interface{}{
+		"bookmark":        bookmark,
+		"first_available": next,
+		"missing_entries": nextSeq - bookmarkSeq - 1,
+	})
+}
+
+func collectSyslogFiles() {
+	offsets := make(map[string]syslogOffset)
+	if data := loadBookmark(SYSLOG_OFFSETS_FILE); data != "" {
+		json.Unmarshal([]byte(data), &offsets)
+	}
+
+	host := getHostname()
+	now := time.Now()
+	limit := logCatchUpLimit()
+files:
+	for _, path := range syslogFiles {
+		f, inode, err := openSyslogFile(path)
+		if err != nil {
+			continue
+		}
+		info, _ := f.Stat()
+		source := "syslog-" + filepath.Base(path)
+
+		prev, known := offsets[path]
+		start := prev.Offset
+		sent := 0
+		switch {
+		case !known:
+			// First run: only the tail, like the journal's initial entries
+			start = tailOffset(f, info.Size(), LOG_INITIAL_ENTRIES)
+		case prev.Inode != inode:
+			// Rotated: finish the old file (now path.1) before starting the new one
+			if rf, rinode, err := openSyslogFile(path + ".1"); err == nil && rinode == prev.Inode {
+				offset, n, eof, err := readSyslogLines(rf, prev.Offset, path, host, now, limit)
+				rf.Close()
+				sent += n
+				if !eof || err != nil {
+					offsets[path] = syslogOffset{Inode: prev.Inode, Offset: offset}
+					f.Close()
+					if err != nil {
+						logMessage("Syslog collection stopped: " + err.Error())
+						break files
+					}
+					continue
+				}
+			} else {
+				reportLogGap(source, "system", "file rotated past bookmark", map[string]interface{}{
+					"file":     path,
+					"bookmark": prev.Offset,
+				})
+			}
+			start = 0
+		case prev.Offset > info.Size():
+			reportLogGap(source, "system", "file truncated below bookmark", map[string]interface{}{
+				"file":     path,
+				"bookmark": prev.Offset,
+				"size":     info.Size(),
+			})
+			start = 0
+		}
+
+		offset, _, _, err := readSyslogLines(f, start, path, host, now, limit-sent)
+		f.Close()
+		offsets[path] = syslogOffset{Inode: inode, Offset: offset}
+		if err != nil {
+			// The other files wait for the server too
+			logMessage("Syslog collection stopped: " + err.Error())
+			break
+		}
+	}
+
+	data, _ := json.Marshal(offsets)
+	saveBookmark(SYSLOG_OFFSETS_FILE, string(data))
+}
+
+func openSyslogFile(path string) (*os.File, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	var inode uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		inode = st.Ino
+	}
+	return f, inode, nil
+}
+
+// readSyslogLines sends up to limit complete lines from start. It returns the
+// offset after the last line sent, whether the end of the file was reached
+// and the error of a line that could not be sent.
+func readSyslogLines(f *os.File, start int64, path, host string, now time.Time, limit int) (int64, int, bool, error) {
+	f.Seek(start, io.SeekStart)
+	reader := bufio.NewReader(f)
+	offset := start
+	sent := 0
+	for sent < limit {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// Partial last line is re-read once it is complete
+			return offset, sent, true, nil
+		}
+		if entry, ok := syslogLogEntry(line, path, host, now); ok {
+			if err := sendLog(entry); err != nil {
+				return offset, sent, false, err
+			}
+			sent++
+		}
+		offset += int64(len(line))
+	}
+	return offset, sent, false, nil
+}
+
+// tailOffset returns the offset of the last n lines of f.
+func tailOffset(f *os.File, size int64, n int) int64 {
+	const chunk = 64 * 1024
+	start := size - chunk
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, size-start)
+	if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+		return size
+	}
+	// Skip the final newline, then count back n more
+	idx := len(buf) - 1
+	if idx >= 0 && buf[idx] == '\n' {
+		idx--
+	}
+	for lines := 0; idx >= 0; idx-- {
+		if buf[idx] == '\n' {
+			lines++
+			if lines == n {
+				return start + int64(idx) + 1
+			}
+		}
+	}
+	if start == 0 {
+		return 0
+	}
+	// Fewer than n lines in the chunk: start at the first complete line
+	if i := strings.IndexByte(string(buf), '\n'); i >= 0 {
+		return start + int64(i) + 1
+	}
+	return size
+}
@@ -0,0 +1,55 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadSyslogLinesStopsAtFailedSend(t *testing.T) {
+	agentDir = t.TempDir()
+	received := logServer(t, 2)
+
+	lines := []string{
+		"Mar  1 11:59:01 web1 cron[812]: first\n",
+		"Mar  1 11:59:02 web1 cron[812]: second\n",
+		"Mar  1 11:59:03 web1 cron[812]: third\n",
+		"Mar  1 11:59:04 web1 cron[812]: fourth\n",
+	}
+	path := filepath.Join(t.TempDir(), "syslog")
+	os.WriteFile(path, []byte(strings.Join(lines, "")), 0644)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	offset, sent, eof, err := readSyslogLines(f, 0, path, "web1", now, 10)
+	if err == nil || eof {
+		t.Fatalf("failed send not reported: eof %v, err %v", eof, err)
+	}
+	if sent != 2 || len(received()) != 2 {
+		t.Fatalf("sent %d, server received %d, want 2", sent, len(received()))
+	}
+	// The offset stops before the line that failed, so it is sent again
+	if want := int64(len(lines[0]) + len(lines[1])); offset != want {
+		t.Errorf("offset = %d, want %d", offset, want)
+	}
+
+	received = logServer(t, 10)
+	offset, sent, eof, err = readSyslogLines(f, offset, path, "web1", now, 10)
+	if err != nil || !eof || sent != 2 {
+		t.Fatalf("resume: sent %d, eof %v, err %v", sent, eof, err)
+	}
+	if got := received(); got[0].Message != "third" {
+		t.Errorf("resumed with %q, want the failed line", got[0].Message)
+	}
+	if offset != int64(len(strings.Join(lines, ""))) {
+		t.Errorf("offset = %d after the whole file", offset)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogLogEntry(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		line     string
+		file     string
+		severity string
+		logType  string
+		message  string
+		ident    string
+	}{
+		{"no priority", "Mar  1 11:59:01 web1 cron[812]: (root) CMD (run-parts /etc/cron.hourly)", "/var/log/syslog", "info", "system", "(root) CMD (run-parts /etc/cron.hourly)", "cron"},
+		{"kern.err", "<3>Mar  1 11:59:02 web1 kernel: EXT4-fs error (device sda1)", "/var/log/kern.log", "error", "system", "EXT4-fs error (device sda1)", "kernel"},
+		{"daemon.warning", "<28>Mar  1 11:59:03 web1 systemd[1]: unit entered failed state", "/var/log/messages", "warning", "system", "unit entered failed state", "systemd"},
+		{"authpriv.notice", "<85>Mar  1 11:59:04 web1 sudo: alice : TTY=pts/0 ; COMMAND=/bin/ls", "/var/log/messages", "info", "security", "alice : TTY=pts/0 ; COMMAND=/bin/ls", "sudo"},
+		{"user.emerg", "<8>Mar  1 11:59:05 web1 app: disk on fire", "/var/log/syslog", "critical", "system", "disk on fire", "app"},
+		{"auth file without priority", "Mar  1 11:59:06 web1 sshd[99]: Accepted publickey for bob", "/var/log/auth.log", "info", "security", "Accepted publickey for bob", "sshd"},
+		{"priority out of range", "<999>free text", "/var/log/syslog", "info", "system", "<999>free text", ""},
+		{"unparsed line keeps priority", "<11>not a syslog line", "/var/log/syslog", "error", "system", "not a syslog line", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, ok := syslogLogEntry(tt.line, tt.file, "host", now)
+			if !ok {
+				t.Fatal("line not parsed")
+			}
+			if e.Severity != tt.severity || e.LogType != tt.logType || e.Message != tt.message {
+				t.Errorf("got severity %q type %q message %q, want %q %q %q", e.Severity, e.LogType, e.Message, tt.severity, tt.logType, tt.message)
+			}
+			if ident, _ := e.RawData["SYSLOG_IDENTIFIER"].(string); ident != tt.ident {
+				t.Errorf("identifier = %q, want %q", ident, tt.ident)
+			}
+		})
+	}
+
+	if _, ok := syslogLogEntry("   \n", "/var/log/syslog", "host", now); ok {
+		t.Error("blank line parsed")
+	}
+}
+
+func TestSyslogTimestampYear(t *testing.T) {
+	// a December line read on the 1st of January belongs to last year
+	now := time.Date(2024, 1, 1, 0, 5, 0, 0, time.Local)
+	e, _ := syslogLogEntry("Dec 31 23:59:59 web1 app: late", "/var/log/syslog", "host", now)
+	want := time.Date(2023, 12, 31, 23, 59, 59, 0, time.Local).UTC().Format(time.RFC3339)
+	if e.Timestamp != want {
+		t.Errorf("timestamp = %s, want %s", e.Timestamp, want)
+	}
+}
+
+func TestReadJournalExport(t *testing.T) {
+	// the second entry carries MESSAGE as a binary field (it contains a newline)
+	binaryMsg := "line one\nline two"
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len(binaryMsg)))
+	stream := "__CURSOR=s=abc;i=10;b=1\n__REALTIME_TIMESTAMP=1709294400000000\nPRIORITY=6\nMESSAGE=hello\n\n" +
+		"__CURSOR=s=abc;i=11;b=1\nPRIORITY=3\nMESSAGE\n" + string(size) + binaryMsg + "\n\n" +
+		"__CURSOR=s=abc;i=12;b=1\nMESSAGE=last, no trailing blank line\n"
+
+	var entries []journalEntry
+	if err := readJournalExport(strings.NewReader(stream), func(e journalEntry) bool {
+		entries = append(entries, e)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	if entries[1]["MESSAGE"] != binaryMsg || entries[1]["PRIORITY"] != "3" {
+		t.Errorf("binary entry = %v", entries[1])
+	}
+
+	e, ok := entries[0].logEntry("host")
+	if !ok || e.Severity != "info" || e.Timestamp != "2024-03-01T12:00:00Z" || e.RawData["cursor"] != "s=abc;i=10;b=1" {
+		t.Errorf("logEntry = %+v", e)
+	}
+
+	// stopping early leaves the rest unread
+	count := 0
+	readJournalExport(strings.NewReader(stream), func(journalEntry) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("callback ran %d times after returning false, want 1", count)
+	}
+
+	// a binary field whose length runs past the stream is an error
+	if err := readJournalExport(strings.NewReader("MESSAGE\n"+string(size)+"short"), func(journalEntry) bool { return true }); err == nil {
+		t.Error("truncated binary field: no error")
+	}
+}
+
+func TestJournalCursorSeq(t *testing.T) {
+	tests := []struct {
+		cursor string
+		id     string
+		seq    uint64
+		ok     bool
+	}{
+		{"s=6a1b;i=1f4;b=9c;m=2a;t=5f;x=e1", "6a1b", 0x1f4, true},
+		{"s=6a1b;b=9c", "", 0, false},
+		{"i=1f4", "", 0, false},
+		{"s=6a1b;i=zz", "", 0, false},
+	}
+	for _, tt := range tests {
+		id, seq, ok := journalCursorSeq(tt.cursor)
+		if !tt.ok && !ok {
+			continue
+		}
+		if id != tt.id || seq != tt.seq || ok != tt.ok {
+			t.Errorf("journalCursorSeq(%q) = %q, %d, %v; want %q, %d, %v", tt.cursor, id, seq, ok, tt.id, tt.seq, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+)
+
+// Full LLDP (IEEE 802.1AB) decoding. gopacket's LinkLayerDiscoveryInfo only keeps
+// the last management address and its 802.3 decoder can index past short TLVs,
+// so we walk the raw TLVs ourselves and never trust a length we have not checked.
+
+const (
+	lldpOUI8021 = 0x0080c2
+	lldpOUI8023 = 0x00120f
+	lldpOUIMED  = 0x0012bb // TIA TR-41 (LLDP-MED)
+)
+
+type lldpNeighbor struct {
+	ChassisIDSubtype    string
+	ChassisID           string
+	PortIDSubtype       string
+	PortID              string
+	TTL                 uint16
+	PortDescription     string
+	SystemName          string
+	SystemDescription   string
+	Capabilities        []string
+	EnabledCapabilities []string
+	ManagementAddresses []managementAddress
+	PortVLANID          uint16
+	ManagementVLANID    uint16
+	ProtocolVLANs       []lldpProtocolVLAN
+	VLANs               []lldpVLAN
+	LinkAggregation     *lldpLinkAggregation
+	MACPHY              *lldpMACPHY
+	PoE                 *lldpPoE
+	MaxFrameSize        uint16
+	MED                 *lldpMED
+}
+
+type lldpProtocolVLAN struct {
+	ID        uint16 `json:"id"`
+	Supported bool   `json:"supported"`
+	Enabled   bool   `json:"enabled"`
+}
+
+type lldpVLAN struct {
+	ID   uint16 `json:"id"`
+	Name string `json:"name"`
+}
+
+type lldpLinkAggregation struct {
+	Supported bool   `json:"supported"`
+	Enabled   bool   `json:"enabled"`
+	PortID    uint32 `json:"aggregated_port_id"`
+}
+
+type lldpMACPHY struct {
+	AutonegSupported bool   `json:"autoneg_supported"`
+	AutonegEnabled   bool   `json:"autoneg_enabled"`
+	AdvertisedCaps   uint16 `json:"advertised_capabilities"`
+	MAUType          uint16 `json:"mau_type"`
+	MAUTypeName      string `json:"mau_type_name"`
+}
+
+type lldpPoE struct {
+	PortClass      string  `json:"port_class"` // "PSE" or "PD"
+	Supported      bool    `json:"supported"`
+	Enabled        bool    `json:"enabled"`
+	PairControl    bool    `json:"pair_control"`
+	PowerPair      uint8   `json:"power_pair"`
+	PowerClass     uint8   `json:"power_class"`
+	PowerType      string  `json:"power_type,omitempty"`
+	PowerSource    uint8   `json:"power_source,omitempty"`
+	Priority       string  `json:"priority,omitempty"`
+	RequestedWatts float64 `json:"requested_watts,omitempty"`
+	AllocatedWatts float64 `json:"allocated_watts,omitempty"`
+}
+
+var lldpCapabilityNames = []string{
+	"other", "repeater", "bridge", "wlan_ap", "router", "telephone",
+	"docsis", "station_only", "cvlan", "svlan", "tpmr",
+}
+
+// dot3MauType values (RFC 4836) seen on access and uplink ports
+var lldpMAUTypeNames = map[uint16]string{
+	5: "10BaseT", 10: "10BaseTHD", 11: "10BaseTFD",
+	15: "100BaseTXHD", 16: "100BaseTXFD", 17: "100BaseFXHD", 18: "100BaseFXFD",
+	21: "1000BaseXHD", 22: "1000BaseXFD", 23: "1000BaseLXHD", 24: "1000BaseLXFD",
+	25: "1000BaseSXHD", 26: "1000BaseSXFD", 29: "1000BaseTHD", 30: "1000BaseTFD",
+	31: "10GBaseX", 33: "10GBaseR", 34: "10GBaseER", 35: "10GBaseLR", 36: "10GBaseSR",
+	41: "10GBaseCX4", 54: "10GBaseT", 55: "10GBaseLRM",
+}
+
+// decodeLLDP turns a parsed LLDPDU into typed neighbor information.
+func decodeLLDP(lldp *layers.LinkLayerDiscovery) lldpNeighbor {
+	n := lldpNeighbor{
+		ChassisIDSubtype: lldpChassisSubtypeName(lldp.ChassisID.Subtype),
+		ChassisID:        decodeLLDPChassisID(lldp.ChassisID),
+		PortIDSubtype:    lldpPortSubtypeName(lldp.PortID.Subtype),
+		PortID:           decodeLLDPPortID(lldp.PortID),
+		TTL:              lldp.TTL,
+	}
+
+	for _, tlv := range lldp.Values {
+		v := tlv.Value
+		switch tlv.Type {
+		case layers.LLDPTLVPortDescription:
+			n.PortDescription = lldpString(v)
+		case layers.LLDPTLVSysName:
+			n.SystemName = lldpString(v)
+		case layers.LLDPTLVSysDescription:
+			n.SystemDescription = lldpString(v)
+		case layers.LLDPTLVSysCapabilities:
+			if len(v) >= 4 {
+				n.Capabilities = namedBits(binary.BigEndian.Uint16(v[0:2]), lldpCapabilityNames)
+				n.EnabledCapabilities = namedBits(binary.BigEndian.Uint16(v[2:4]), lldpCapabilityNames)
+			}
+		case layers.LLDPTLVMgmtAddress:
+			if addr, ok := decodeLLDPManagementAddress(v); ok {
+				n.ManagementAddresses = append(n.ManagementAddresses, addr)
+			}
+		case layers.LLDPTLVOrgSpecific:
+			if len(v) < 4 {
+				continue
+			}
+			oui := uint32(v[0])<<16 | uint32(v[1])<<8 | uint32(v[2])
+			switch oui {
+			case lldpOUI8021:
+				n.decode8021(v[3], v[4:])
+			case lldpOUI8023:
+				n.decode8023(v[3], v[4:])
+			case lldpOUIMED:
+				if n.MED == nil {
+					n.MED = &lldpMED{}
+				}
+				n.MED.decode(v[3], v[4:])
+			}
+		}
+	}
+	return n
+}
+
+func (n *lldpNeighbor) decode8021(subtype byte, info []byte) {
+	switch subtype {
+	case 1: // Port VLAN ID
+		if len(info) >= 2 {
+			n.PortVLANID = binary.BigEndian.Uint16(info)
+		}
+	case 2: // Port and protocol VLAN ID
+		if len(info) >= 3 {
+			n.ProtocolVLANs = append(n.ProtocolVLANs, lldpProtocolVLAN{
+				ID:        binary.BigEndian.Uint16(info[1:3]),
+				Supported: info[0]&0x02 != 0,
+				Enabled:   info[0]&0x04 != 0,
+			})
+		}
+	case 3: // VLAN name
+		if len(info) >= 3 {
+			nameLen := int(info[2])
+			if 3+nameLen <= len(info) {
+				n.VLANs = append(n.VLANs, lldpVLAN{
+					ID:   binary.BigEndian.Uint16(info[0:2]),
+					Name: lldpString(info[3 : 3+nameLen]),
+				})
+			}
+		}
+	case 6: // Management VID
+		if len(info) >= 2 {
+			n.ManagementVLANID = binary.BigEndian.Uint16(info)
+		}
+	case 7: // Link aggregation (802.1AX)
+		if len(info) >= 5 {
+			n.LinkAggregation = decodeLLDPLinkAggregation(info)
+		}
+	}
+}
+
+func (n *lldpNeighbor) decode8023(subtype byte, info []byte) {
+	switch subtype {
+	case 1: // MAC/PHY configuration/status
+		if len(info) >= 5 {
+			mau := binary.BigEndian.Uint16(info[3:5])
+			name, ok := lldpMAUTypeNames[mau]
+			if !ok {
+				name = fmt.Sprintf("mau-%d", mau)
+			}
+			n.MACPHY = &lldpMACPHY{
+				AutonegSupported: info[0]&0x01 != 0,
+				AutonegEnabled:   info[0]&0x02 != 0,
+				AdvertisedCaps:   binary.BigEndian.Uint16(info[1:3]),
+				MAUType:          mau,
+				MAUTypeName:      name,
+			}
+		}
+	case 2: // Power via MDI
+		if len(info) < 3 {
+			return
+		}
+		poe := &lldpPoE{
+			PortClass:   "PD",
+			Supported:   info[0]&0x02 != 0,
+			Enabled:     info[0]&0x04 != 0,
+			PairControl: info[0]&0x08 != 0,
+			PowerPair:   info[1],
+			PowerClass:  info[2],
+		}
+		if info[0]&0x01 != 0 {
+			poe.PortClass = "PSE"
+		}
+		// 802.3at extension: type/source/priority + requested/allocated in 0.1 W
+		if len(info) >= 8 {
+			poe.PowerType = []string{"type2_pse", "type2_pd", "type1_pse", "type1_pd"}[info[3]>>6]
+			poe.PowerSource = (info[3] >> 4) & 0x03
+			poe.Priority = []string{"unknown", "critical", "high", "low"}[info[3]&0x03]
+			poe.RequestedWatts = float64(binary.BigEndian.Uint16(info[4:6])) / 10
+			poe.AllocatedWatts = float64(binary.BigEndian.Uint16(info[6:8])) / 10
+		}
+		n.PoE = poe
+	case 3: // Link aggregation (deprecated 802.3 form)
+		if len(info) >= 5 && n.LinkAggregation == nil {
+			n.LinkAggregation = decodeLLDPLinkAggregation(info)
+		}
+	case 4: // Maximum frame size
+		if len(info) >= 2 {
+			n.MaxFrameSize = binary.BigEndian.Uint16(info)
+		}
+	}
+}
+
+// topology converts the LLDP view into the common neighbor schema, keeping
+// the 802.1/802.3/MED specifics as typed detail fields.
+func (n *lldpNeighbor) topology(iface string) topologyNeighbor {
+	t := topologyNeighbor{
+		Protocol:            "lldp",
+		Interface:           iface,
+		SystemName:          n.SystemName,
+		ChassisID:           n.ChassisID,
+		PortID:              n.PortID,
+		PortDescription:     n.PortDescription,
+		SystemDescription:   n.SystemDescription,
+		Capabilities:        n.Capabilities,
+		ManagementAddresses: n.ManagementAddresses,
+		NativeVLAN:          n.PortVLANID,
+		TTL:                 n.TTL,
+		Details: map[string]interface{}{
+			"chassis_id_subtype": n.ChassisIDSubtype,
+			"port_id_subtype":    n.PortIDSubtype,
+		},
+	}
+	if n.MED != nil && n.MED.Model != "" {
+		t.Platform = strings.TrimSpace(n.MED.Manufacturer + " " + n.MED.Model)
+	}
+
+	if len(n.EnabledCapabilities) > 0 {
+		t.Details["enabled_capabilities"] = n.EnabledCapabilities
+	}
+	if n.ManagementVLANID != 0 {
+		t.Details["management_vlan_id"] = n.ManagementVLANID
+	}
+	if len(n.ProtocolVLANs) > 0 {
+		t.Details["protocol_vlans"] = n.ProtocolVLANs
+	}
+	if len(n.VLANs) > 0 {
+		t.Details["vlans"] = n.VLANs
+	}
+	if n.LinkAggregation != nil {
+		t.Details["link_aggregation"] = n.LinkAggregation
+	}
+	if n.MACPHY != nil {
+		t.Details["mac_phy"] = n.MACPHY
+	}
+	if n.PoE != nil {
+		t.Details["poe"] = n.PoE
+	}
+	if n.MaxFrameSize != 0 {
+		t.Details["max_frame_size"] = n.MaxFrameSize
+	}
+	if n.MED != nil {
+		t.Details["lldp_med"] = n.MED
+	}
+	return t
+}
+
+func decodeLLDPChassisID(id layers.LLDPChassisID) string {
+	switch id.Subtype {
+	case layers.LLDPChassisIDSubTypeMACAddr:
+		return lldpMAC(id.ID)
+	case layers.LLDPChassisIDSubTypeNetworkAddr:
+		return lldpNetworkAddress(id.ID)
+	}
+	return lldpString(id.ID)
+}
+
+func decodeLLDPPortID(id layers.LLDPPortID) string {
+	switch id.Subtype {
+	case layers.LLDPPortIDSubtypeMACAddr:
+		return lldpMAC(id.ID)
+	case layers.LLDPPortIDSubtypeNetworkAddr:
+		return lldpNetworkAddress(id.ID)
+	case layers.LLDPPortIDSubtypeAgentCircuitID:
+		return hex.EncodeToString(id.ID)
+	}
+	return lldpString(id.ID)
+}
+
+func lldpChassisSubtypeName(t layers.LLDPChassisIDSubType) string {
+	switch t {
+	case layers.LLDPChassisIDSubTypeChassisComp:
+		return "chassis_component"
+	case layers.LLDPChassisIDSubtypeIfaceAlias:
+		return "interface_alias"
+	case layers.LLDPChassisIDSubTypePortComp:
+		return "port_component"
+	case layers.LLDPChassisIDSubTypeMACAddr:
+		return "mac_address"
+	case layers.LLDPChassisIDSubTypeNetworkAddr:
+		return "network_address"
+	case layers.LLDPChassisIDSubtypeIfaceName:
+		return "interface_name"
+	case layers.LLDPChassisIDSubTypeLocal:
+		return "local"
+	}
+	return fmt.Sprintf("reserved-%d", t)
+}
+
+func lldpPortSubtypeName(t layers.LLDPPortIDSubType) string {
+	switch t {
+	case layers.LLDPPortIDSubtypeIfaceAlias:
+		return "interface_alias"
+	case layers.LLDPPortIDSubtypePortComp:
+		return "port_component"
+	case layers.LLDPPortIDSubtypeMACAddr:
+		return "mac_address"
+	case layers.LLDPPortIDSubtypeNetworkAddr:
+		return "network_address"
+	case layers.LLDPPortIDSubtypeIfaceName:
+		return "interface_name"
+	case layers.LLDPPortIDSubtypeAgentCircuitID:
+		return "agent_circuit_id"
+	case layers.LLDPPortIDSubtypeLocal:
+		return "local"
+	}
+	return fmt.Sprintf("reserved-%d", t)
+}
+
+// decodeLLDPManagementAddress parses one Management Address TLV (type 8).
+// Layout: addrLen(1) family(1) addr(addrLen-1) ifSubtype(1) ifNumber(4) oidLen(1) oid
+func decodeLLDPManagementAddress(v []byte) (managementAddress, bool) {
+	if len(v) < 1 {
+		return managementAddress{}, false
+	}
+	addrLen := int(v[0])
+	if addrLen < 1 || len(v) < 1+addrLen+6 {
+		return managementAddress{}, false
+	}
+
+	addr := managementAddress{
+		Family:  lldpAddressFamilyName(v[1]),
+		Address: lldpNetworkAddress(v[1 : 1+addrLen]),
+	}
+
+	rest := v[1+addrLen:]
+	switch rest[0] {
+	case 2:
+		addr.InterfaceSubtype = "ifindex"
+	case 3:
+		addr.InterfaceSubtype = "system_port"
+	default:
+		addr.InterfaceSubtype = "unknown"
+	}
+	addr.InterfaceNumber = binary.BigEndian.Uint32(rest[1:5])
+
+	oidLen := int(rest[5])
+	if oidLen > 0 && len(rest) >= 6+oidLen {
+		addr.OID = decodeBEROID(rest[6 : 6+oidLen])
+	}
+	return addr, true
+}
+
+func lldpAddressFamilyName(family byte) string {
+	switch family {
+	case 1:
+		return "ipv4"
+	case 2:
+		return "ipv6"
+	case 6:
+		return "mac"
+	case 16:
+		return "dns"
+	}
+	return fmt.Sprintf("iana-%d", family)
+}
+
+// lldpNetworkAddress decodes an IANA address-family-prefixed address.
+func lldpNetworkAddress(b []byte) string {
+	if len(b) < 2 {
+		return hex.EncodeToString(b)
+	}
+	addr := b[1:]
+	switch b[0] {
+	case 1:
+		if len(addr) == net.IPv4len {
+			return net.IP(addr).String()
+		}
+	case 2:
+		if len(addr) == net.IPv6len {
+			return net.IP(addr).String()
+		}
+	case 6:
+		return lldpMAC(addr)
+	case 16:
+		return lldpString(addr)
+	}
+	return hex.EncodeToString(addr)
+}
+
+func lldpMAC(b []byte) string {
+	if len(b) != 6 {
+		return hex.EncodeToString(b)
+	}
+	return net.HardwareAddr(b).String()
+}
+
+func decodeLLDPLinkAggregation(info []byte) *lldpLinkAggregation {
+	return &lldpLinkAggregation{
+		Supported: info[0]&0x01 != 0,
+		Enabled:   info[0]&0x02 != 0,
+		PortID:    binary.BigEndian.Uint32(info[1:5]),
+	}
+}
+
+// decodeBEROID renders an ASN.1 BER-encoded object identifier as dotted decimal.
+func decodeBEROID(b []byte) string {
+	var parts []string
+	var value uint64
+	for _, c := range b {
+		value = value<<7 | uint64(c&0x7f)
+		if c&0x80 != 0 {
+			continue
+		}
+		if len(parts) == 0 {
+			first := value / 40
+			if first > 2 {
+				first = 2
+			}
+			parts = append(parts, fmt.Sprint(first), fmt.Sprint(value-first*40))
+		} else {
+			parts = append(parts, fmt.Sprint(value))
+		}
+		value = 0
+	}
+	return strings.Join(parts, ".")
+}
+
+// lldpString trims the NUL padding some switches put on string TLVs.
+func lldpString(b []byte) string {
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+// lldpOrgTLV builds an organizationally specific TLV value.
+func lldpOrgTLV(oui uint32, subtype byte, info ...byte) layers.LinkLayerDiscoveryValue {
+	v := append([]byte{byte(oui >> 16), byte(oui >> 8), byte(oui), subtype}, info...)
+	return layers.LinkLayerDiscoveryValue{Type: layers.LLDPTLVOrgSpecific, Length: uint16(len(v)), Value: v}
+}
+
+func lldpMgmtTLV(v ...byte) layers.LinkLayerDiscoveryValue {
+	return layers.LinkLayerDiscoveryValue{Type: layers.LLDPTLVMgmtAddress, Length: uint16(len(v)), Value: v}
+}
+
+func TestDecodeLLDPTLVs(t *testing.T) {
+	base := lldpNeighbor{
+		ChassisIDSubtype: "mac_address",
+		ChassisID:        "00:11:22:33:44:55",
+		PortIDSubtype:    "interface_name",
+		PortID:           "Gi1/0/1",
+		TTL:              120,
+	}
+	with := func(f func(n *lldpNeighbor)) lldpNeighbor {
+		n := base
+		f(&n)
+		return n
+	}
+
+	tests := []struct {
+		name string
+		tlvs []layers.LinkLayerDiscoveryValue
+		want lldpNeighbor
+	}{
+		{
+			"management address ipv4 with oid",
+			[]layers.LinkLayerDiscoveryValue{lldpMgmtTLV(5, 1, 10, 0, 0, 2, 2, 0, 0, 0, 7, 8, 0x2b, 6, 1, 4, 1, 0x89, 0x0c, 1)},
+			with(func(n *lldpNeighbor) {
+				n.ManagementAddresses = []managementAddress{{Family: "ipv4", Address: "10.0.0.2", InterfaceSubtype: "ifindex", InterfaceNumber: 7, OID: "1.3.6.1.4.1.1164.1"}}
+			}),
+		},
+		{
+			"several management addresses are all kept",
+			[]layers.LinkLayerDiscoveryValue{
+				lldpMgmtTLV(5, 1, 10, 0, 0, 2, 3, 0, 0, 0, 1, 0),
+				lldpMgmtTLV(17, 2, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 1, 0, 0, 0, 0, 0),
+				lldpMgmtTLV(7, 6, 0, 0x11, 0x22, 0x33, 0x44, 0x55, 9, 0, 0, 0, 0, 0),
+			},
+			with(func(n *lldpNeighbor) {
+				n.ManagementAddresses = []managementAddress{
+					{Family: "ipv4", Address: "10.0.0.2", InterfaceSubtype: "system_port", InterfaceNumber: 1},
+					{Family: "ipv6", Address: "2001:db8::2", InterfaceSubtype: "unknown"},
+					{Family: "mac", Address: "00:11:22:33:44:55", InterfaceSubtype: "unknown"},
+				}
+			}),
+		},
+		{
+			"management address oid longer than the tlv",
+			[]layers.LinkLayerDiscoveryValue{lldpMgmtTLV(5, 1, 10, 0, 0, 2, 2, 0, 0, 0, 7, 9, 0x2b, 6)},
+			with(func(n *lldpNeighbor) {
+				n.ManagementAddresses = []managementAddress{{Family: "ipv4", Address: "10.0.0.2", InterfaceSubtype: "ifindex", InterfaceNumber: 7}}
+			}),
+		},
+		{
+			"management address length past the tlv",
+			[]layers.LinkLayerDiscoveryValue{lldpMgmtTLV(200, 1, 10, 0, 0, 2, 2, 0, 0, 0, 7, 0), lldpMgmtTLV(5, 1, 10, 0, 0, 2), lldpMgmtTLV(0, 1), lldpMgmtTLV()},
+			base,
+		},
+		{
+			"802.1 vlans",
+			[]layers.LinkLayerDiscoveryValue{
+				lldpOrgTLV(lldpOUI8021, 1, 0, 20),
+				lldpOrgTLV(lldpOUI8021, 2, 0x06, 0, 30),
+				lldpOrgTLV(lldpOUI8021, 3, 0, 20, 5, 'u', 's', 'e', 'r', 's'),
+				lldpOrgTLV(lldpOUI8021, 3, 0, 30, 6, 'v', 'o', 'i', 'c', 'e', 0),
+				lldpOrgTLV(lldpOUI8021, 6, 0, 99),
+				lldpOrgTLV(lldpOUI8021, 7, 0x03, 0, 0, 0x01, 0xf5),
+			},
+			with(func(n *lldpNeighbor) {
+				n.PortVLANID = 20
+				n.ProtocolVLANs = []lldpProtocolVLAN{{ID: 30, Supported: true, Enabled: true}}
+				n.VLANs = []lldpVLAN{{ID: 20, Name: "users"}, {ID: 30, Name: "voice"}}
+				n.ManagementVLANID = 99
+				n.LinkAggregation = &lldpLinkAggregation{Supported: true, Enabled: true, PortID: 501}
+			}),
+		},
+		{
+			"802.1 truncated and over-long",
+			[]layers.LinkLayerDiscoveryValue{
+				lldpOrgTLV(lldpOUI8021, 1, 20),
+				lldpOrgTLV(lldpOUI8021, 2, 0x06, 0),
+				lldpOrgTLV(lldpOUI8021, 3, 0, 20, 32, 'u', 's'),
+				lldpOrgTLV(lldpOUI8021, 6),
+				lldpOrgTLV(lldpOUI8021, 7, 0x03, 0, 0, 1),
+				{Type: layers.LLDPTLVOrgSpecific, Length: 3, Value: []byte{0x00, 0x80, 0xc2}},
+			},
+			base,
+		},
+		{
+			"802.3 mac/phy, power and frame size",
+			[]layers.LinkLayerDiscoveryValue{
+				lldpOrgTLV(lldpOUI8023, 1, 0x03, 0x6c, 0x01, 0, 30),
+				lldpOrgTLV(lldpOUI8023, 4, 0x05, 0xee),
+			},
+			with(func(n *lldpNeighbor) {
+				n.MACPHY = &lldpMACPHY{AutonegSupported: true, AutonegEnabled: true, AdvertisedCaps: 0x6c01, MAUType: 30, MAUTypeName: "1000BaseTFD"}
+				n.MaxFrameSize = 1518
+			}),
+		},
+		{
+			"unknown mau type",
+			[]layers.LinkLayerDiscoveryValue{lldpOrgTLV(lldpOUI8023, 1, 0x01, 0, 0, 0x01, 0x00)},
+			with(func(n *lldpNeighbor) {
+				n.MACPHY = &lldpMACPHY{AutonegSupported: true, MAUType: 256, MAUTypeName: "mau-256"}
+			}),
+		},
+		{
+			"poe 802.3af",
+			[]layers.LinkLayerDiscoveryValue{lldpOrgTLV(lldpOUI8023, 2, 0x07, 1, 3)},
+			with(func(n *lldpNeighbor) {
+				n.PoE = &lldpPoE{PortClass: "PSE", Supported: true, Enabled: true, PowerPair: 1, PowerClass: 3}
+			}),
+		},
+		{
+			"poe 802.3at extension",
+			[]layers.LinkLayerDiscoveryValue{lldpOrgTLV(lldpOUI8023, 2, 0x0e, 2, 5, 0x52, 0, 255, 0, 250)},
+			with(func(n *lldpNeighbor) {
+				n.PoE = &lldpPoE{
+					PortClass: "PD", Enabled: true, Supported: true, PairControl: true, PowerPair: 2, PowerClass: 5,
+					PowerType: "type2_pd", PowerSource: 1, Priority: "high", RequestedWatts: 25.5, AllocatedWatts: 25,
+				}
+			}),
+		},
+		{
+			"poe with a partial 802.3at extension keeps the base fields",
+			[]layers.LinkLayerDiscoveryValue{lldpOrgTLV(lldpOUI8023, 2, 0x07, 1, 3, 0x52, 0)},
+			with(func(n *lldpNeighbor) {
+				n.PoE = &lldpPoE{PortClass: "PSE", Supported: true, Enabled: true, PowerPair: 1, PowerClass: 3}
+			}),
+		},
+		{
+			"802.3 truncated",
+			[]layers.LinkLayerDiscoveryValue{
+				lldpOrgTLV(lldpOUI8023, 1, 0x03, 0x6c, 0x01, 0),
+				lldpOrgTLV(lldpOUI8023, 2, 0x07, 1),
+				lldpOrgTLV(lldpOUI8023, 3, 0x03, 0),
+				lldpOrgTLV(lldpOUI8023, 4, 0x05),
+			},
+			base,
+		},
+		{
+			"deprecated 802.3 aggregation yields to 802.1",
+			[]layers.LinkLayerDiscoveryValue{
+				lldpOrgTLV(lldpOUI8021, 7, 0x01, 0, 0, 0, 9),
+				lldpOrgTLV(lldpOUI8023, 3, 0x03, 0, 0, 0, 8),
+			},
+			with(func(n *lldpNeighbor) {
+				n.LinkAggregation = &lldpLinkAggregation{Supported: true, PortID: 9}
+			}),
+		},
+		{
+			"unknown organization ignored",
+			[]layers.LinkLayerDiscoveryValue{lldpOrgTLV(0x00000c, 1, 1, 2, 3)},
+			base,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeLLDP(&layers.LinkLayerDiscovery{
+				ChassisID: layers.LLDPChassisID{Subtype: layers.LLDPChassisIDSubTypeMACAddr, ID: []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55}},
+				PortID:    layers.LLDPPortID{Subtype: layers.LLDPPortIDSubtypeIfaceName, ID: []byte("Gi1/0/1")},
+				TTL:       120,
+				Values:    tt.tlvs,
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeLLDP =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLLDPIdentifiers(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"network address chassis", decodeLLDPChassisID(layers.LLDPChassisID{Subtype: layers.LLDPChassisIDSubTypeNetworkAddr, ID: []byte{1, 192, 0, 2, 1}}), "192.0.2.1"},
+		{"short mac chassis", decodeLLDPChassisID(layers.LLDPChassisID{Subtype: layers.LLDPChassisIDSubTypeMACAddr, ID: []byte{0, 0x11}}), "0011"},
+		{"local chassis padded", decodeLLDPChassisID(layers.LLDPChassisID{Subtype: layers.LLDPChassisIDSubTypeLocal, ID: []byte("sw-1\x00\x00")}), "sw-1"},
+		{"circuit id port", decodeLLDPPortID(layers.LLDPPortID{Subtype: layers.LLDPPortIDSubtypeAgentCircuitID, ID: []byte{0xde, 0xad}}), "dead"},
+		{"ipv6 wrong length", lldpNetworkAddress([]byte{2, 0x20, 0x01}), "2001"},
+		{"dns name", lldpNetworkAddress(append([]byte{16}, "sw.example.com"...)), "sw.example.com"},
+		{"oid multi-byte arc", decodeBEROID([]byte{0x2b, 6, 1, 4, 1, 0x82, 0x37}), "1.3.6.1.4.1.311"},
+		{"oid first arc 2", decodeBEROID([]byte{0x88, 0x37, 1}), "2.999.1"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// LLDP-MED (ANSI/TIA-1057) organizational TLVs: device class, voice/video
+// network policies, location (coordinate, civic address or ELIN), extended
+// power and the inventory strings IP phones and access points advertise.
+
+type lldpMED struct {
+	DeviceClass      string           `json:"device_class,omitempty"`
+	Capabilities     []string         `json:"capabilities,omitempty"`
+	NetworkPolicies  []lldpMEDPolicy  `json:"network_policies,omitempty"`
+	Location         *lldpMEDLocation `json:"location,omitempty"`
+	Power            *lldpMEDPower    `json:"power,omitempty"`
+	HardwareRevision string           `json:"hardware_revision,omitempty"`
+	FirmwareRevision string           `json:"firmware_revision,omitempty"`
+	SoftwareRevision string           `json:"software_revision,omitempty"`
+	SerialNumber     string           `json:"serial_number,omitempty"`
+	Manufacturer     string           `json:"manufacturer,omitempty"`
+	Model            string           `json:"model,omitempty"`
+	AssetID          string           `json:"asset_id,omitempty"`
+}
+
+type lldpMEDPolicy struct {
+	Application string `json:"application"`
+	Unknown     bool   `json:"unknown"`
+	Tagged      bool   `json:"tagged"`
+	VLANID      uint16 `json:"vlan_id"`
+	Priority    uint8  `json:"l2_priority"`
+	DSCP        uint8  `json:"dscp"`
+}
+
+type lldpMEDLocation struct {
+	Format      string             `json:"format"` // "coordinate", "civic" or "elin"
+	Coordinate  *lldpMEDCoordinate `json:"coordinate,omitempty"`
+	CountryCode string             `json:"country_code,omitempty"`
+	Civic       map[string]string  `json:"civic,omitempty"`
+	ELIN        string             `json:"elin,omitempty"`
+}
+
+type lldpMEDCoordinate struct {
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	Altitude     float64 `json:"altitude"`
+	AltitudeType string  `json:"altitude_type"`
+	Datum        uint8   `json:"datum"`
+}
+
+type lldpMEDPower struct {
+	Type     string  `json:"type"`
+	Source   uint8   `json:"source"`
+	Priority string  `json:"priority"`
+	Watts    float64 `json:"watts"`
+}
+
+var lldpMEDCapabilityNames = []string{
+	"capabilities", "network_policy", "location", "extended_power_pse", "extended_power_pd", "inventory",
+}
+
+var lldpMEDClassNames = []string{
+	"undefined", "endpoint_class_1", "endpoint_class_2", "endpoint_class_3", "network_connectivity",
+}
+
+var lldpMEDApplicationNames = []string{
+	"reserved", "voice", "voice_signaling", "guest_voice", "guest_voice_signaling",
+	"softphone_voice", "video_conferencing", "streaming_video", "video_signaling",
+}
+
+// Civic address CAtype names (RFC 4776)
+var lldpMEDCivicTypes = map[byte]string{
+	0: "language", 1: "state", 2: "county", 3: "city", 4: "city_division",
+	5: "neighborhood", 6: "street", 16: "leading_street_direction",
+	17: "trailing_street_suffix", 18: "street_suffix", 19: "house_number",
+	20: "house_number_suffix", 21: "landmark", 22: "additional_location",
+	23: "name", 24: "postal_code", 25: "building", 26: "unit", 27: "floor",
+	28: "room", 29: "place_type", 30: "postal_community", 31: "po_box",
+	32: "additional_code", 33: "seat", 34: "road_section", 128: "script",
+}
+
+func (m *lldpMED) decode(subtype byte, info []byte) {
+	switch subtype {
+	case 1: // Capabilities
+		if len(info) >= 3 {
+			m.Capabilities = namedBits(binary.BigEndian.Uint16(info[0:2]), lldpMEDCapabilityNames)
+			m.DeviceClass = enumName(int(info[2]), lldpMEDClassNames)
+		}
+	case 2: // Network policy
+		if len(info) >= 4 {
+			bits := uint32(info[1])<<16 | uint32(info[2])<<8 | uint32(info[3])
+			m.NetworkPolicies = append(m.NetworkPolicies, lldpMEDPolicy{
+				Application: enumName(int(info[0]), lldpMEDApplicationNames),
+				Unknown:     bits&(1<<23) != 0,
+				Tagged:      bits&(1<<22) != 0,
+				VLANID:      uint16((bits >> 9) & 0x0fff),
+				Priority:    uint8((bits >> 6) & 0x07),
+				DSCP:        uint8(bits & 0x3f),
+			})
+		}
+	case 3: // Location identification
+		if len(info) >= 1 {
+			m.Location = decodeLLDPMEDLocation(info[0], info[1:])
+		}
+	case 4: // Extended power via MDI
+		if len(info) >= 3 {
+			m.Power = &lldpMEDPower{
+				Type:     enumName(int(info[0]>>6), []string{"pse", "pd"}),
+				Source:   (info[0] >> 4) & 0x03,
+				Priority: enumName(int(info[0]&0x0f), []string{"unknown", "critical", "high", "low"}),
+				Watts:    float64(binary.BigEndian.Uint16(info[1:3])) / 10,
+			}
+		}
+	case 5:
+		m.HardwareRevision = lldpString(info)
+	case 6:
+		m.FirmwareRevision = lldpString(info)
+	case 7:
+		m.SoftwareRevision = lldpString(info)
+	case 8:
+		m.SerialNumber = lldpString(info)
+	case 9:
+		m.Manufacturer = lldpString(info)
+	case 10:
+		m.Model = lldpString(info)
+	case 11:
+		m.AssetID = lldpString(info)
+	}
+}
+
+func decodeLLDPMEDLocation(format byte, data []byte) *lldpMEDLocation {
+	switch format {
+	case 1:
+		if len(data) < 16 {
+			return nil
+		}
+		// RFC 3825 LCI: LaRes(6) Lat(34) LoRes(6) Long(34) AT(4) AltRes(6) Alt(30) Datum(8)
+		coord := &lldpMEDCoordinate{
+			Latitude:     fixedPoint(readBits(data, 6, 34), 34, 25),
+			Longitude:    fixedPoint(readBits(data, 46, 34), 34, 25),
+			AltitudeType: enumName(int(readBits(data, 80, 4)), []string{"unknown", "meters", "floors"}),
+			Altitude:     fixedPoint(readBits(data, 90, 30), 30, 8),
+			Datum:        data[15],
+		}
+		return &lldpMEDLocation{Format: "coordinate", Coordinate: coord}
+	case 2:
+		// LCI length(1) what(1) country(2) then CAtype(1) CAlength(1) CAvalue
+		if len(data) < 4 {
+			return nil
+		}
+		loc := &lldpMEDLocation{Format: "civic", CountryCode: string(data[2:4]), Civic: make(map[string]string)}
+		rest := data[4:]
+		for len(rest) >= 2 {
+			caType, caLen := rest[0], int(rest[1])
+			if 2+caLen > len(rest) {
+				break
+			}
+			name, ok := lldpMEDCivicTypes[caType]
+			if !ok {
+				name = fmt.Sprintf("catype_%d", caType)
+			}
+			loc.Civic[name] = lldpString(rest[2 : 2+caLen])
+			rest = rest[2+caLen:]
+		}
+		return loc
+	case 3:
+		return &lldpMEDLocation{Format: "elin", ELIN: lldpString(data)}
+	}
+	return nil
+}
+
+// readBits extracts width bits starting at bit offset (MSB first).
+func readBits(data []byte, offset, width int) uint64 {
+	var v uint64
+	for i := offset; i < offset+width; i++ {
+		v <<= 1
+		if data[i/8]&(0x80>>uint(i%8)) != 0 {
+			v |= 1
+		}
+	}
+	return v
+}
+
+// fixedPoint interprets a two's complement value with the given fraction bits.
+func fixedPoint(v uint64, width, fraction uint) float64 {
+	signed := int64(v)
+	if v&(1<<(width-1)) != 0 {
+		signed -= 1 << width
+	}
+	return float64(signed) / float64(uint64(1)<<fraction)
+}
+
+func namedBits(bits uint16, names []string) []string {
+	var out []string
+	for i, name := range names {
+		if bits&(1<<uint(i)) != 0 {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func enumName(v int, names []string) string {
+	if v >= 0 && v < len(names) {
+		return names[v]
+	}
+	return fmt.Sprintf("reserved-%d", v)
+}
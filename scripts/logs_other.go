@@ -0,0 +1,6 @@
+//go:build !windows && !linux
+
+package main
+
+// collectSystemLogs has no log source on this platform.
+func collectSystemLogs() {}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Neighbor table: one entry per neighbor chassis seen on each interface,
+// refreshed by every announcement and expired when the advertised TTL runs
+// out. Replaces the old global lldpNeighborInfo string so port moves and
+// vanished switches are reported instead of silently ignored.
+const (
+	NEIGHBOR_DEFAULT_TTL   = 180 * time.Second // CDP default; used when a protocol carries no TTL
+	NEIGHBOR_EXPIRY_CHECK  = 10 * time.Second
+	NEIGHBOR_EVENT_ADDED   = "neighbor_added"
+	NEIGHBOR_EVENT_CHANGED = "neighbor_changed"
+	NEIGHBOR_EVENT_EXPIRED = "neighbor_expired"
+)
+
+type neighborEntry struct {
+	neighbor  topologyNeighbor
+	firstSeen time.Time
+	lastSeen  time.Time
+	expiresAt time.Time
+}
+
+type neighborTable struct {
+	mu      sync.Mutex
+	entries map[string]*neighborEntry // neighborKey -> entry
+}
+
+var neighbors = &neighborTable{entries: make(map[string]*neighborEntry)}
+
+// neighborKey identifies a neighbor by interface, protocol and chassis, so
+// several switches or hosts behind one port (an unmanaged switch, a hub, a
+// hypervisor uplink) each get their own entry, while the same switch
+// announcing another port ID is the link moving to a new port. A station is
+// associated with one access point at a time, so Wi-Fi keeps a single entry
+// per interface and a new BSSID replaces it as a roam.
+func neighborKey(n topologyNeighbor) string {
+	if n.Protocol == "wifi" {
+		return n.Interface + "|" + n.Protocol
+	}
+	return n.Interface + "|" + n.Protocol + "|" + n.ChassisID
+}
+
+// update records an announcement. It returns the event to report ("" when
+// nothing changed) and, for neighbor_changed, the neighbor it replaced.
+func (t *neighborTable) update(n topologyNeighbor, now time.Time) (string, *topologyNeighbor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := neighborKey(n)
+
+	// LLDP TTL 0 is an explicit shutdown announcement
+	if n.Protocol == "lldp" && n.TTL == 0 {
+		if old, ok := t.entries[key]; ok {
+			delete(t.entries, key)
+			return NEIGHBOR_EVENT_EXPIRED, &old.neighbor
+		}
+		return "", nil
+	}
+
+	ttl := time.Duration(n.TTL) * time.Second
+	if ttl == 0 {
+		ttl = NEIGHBOR_DEFAULT_TTL
+	}
+
+	entry, ok := t.entries[key]
+	if !ok {
+		t.entries[key] = &neighborEntry{neighbor: n, firstSeen: now, lastSeen: now, expiresAt: now.Add(ttl)}
+		return NEIGHBOR_EVENT_ADDED, nil
+	}
+
+	previous := entry.neighbor
+	entry.neighbor = n
+	entry.lastSeen = now
+	entry.expiresAt = now.Add(ttl)
+
+	if sameNeighbor(previous, n) {
+		return "", nil
+	}
+	entry.firstSeen = now
+	return NEIGHBOR_EVENT_CHANGED, &previous
+}
+
+// expire removes entries whose TTL elapsed and returns them.
+func (t *neighborTable) expire(now time.Time) []topologyNeighbor {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var expired []topologyNeighbor
+	for key, entry := range t.entries {
+		if now.After(entry.expiresAt) {
+			expired = append(expired, entry.neighbor)
+			delete(t.entries, key)
+		}
+	}
+	return expired
+}
+
+// snapshot returns the current table for status updates.
+func (t *neighborTable) snapshot() []map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]string, 0, len(t.entries))
+	for key := range t.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	table := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		entry := t.entries[key]
+		raw := entry.neighbor.rawData()
+		raw["first_seen"] = entry.firstSeen.UTC().Format(time.RFC3339)
+		raw["last_seen"] = entry.lastSeen.UTC().Format(time.RFC3339)
+		raw["expires_at"] = entry.expiresAt.UTC().Format(time.RFC3339)
+		table = append(table, raw)
+	}
+	return table
+}
+
+// sameNeighbor compares identity only, so a changing Wi-Fi signal or LLDP
+// system description refresh does not count as a topology change.
+func sameNeighbor(a, b topologyNeighbor) bool {
+	return a.ChassisID == b.ChassisID &&
+		a.PortID == b.PortID &&
+		a.SystemName == b.SystemName &&
+		a.NativeVLAN == b.NativeVLAN
+}
+
+// trackNeighbor feeds an announcement into the table and reports any change.
+func trackNeighbor(n topologyNeighbor, now time.Time) {
+	event, previous := neighbors.update(n, now)
+	if event == "" {
+		return
+	}
+
+	info := n.summary()
+
+	// Wi-Fi keeps its wifi_discovery event; a changed BSSID is a roam
+	if n.Protocol == "wifi" && event != NEIGHBOR_EVENT_EXPIRED {
+		if previous != nil && previous.SystemName == n.SystemName {
+			logMessage("WiFi Roam: " + previous.ChassisID + " -> " + n.ChassisID + " (" + n.SystemName + ")")
+			reportNeighbor(n, "wifi_discovery", "info", "Roamed to AP: "+n.SystemName+" ("+previous.ChassisID+" -> "+n.ChassisID+")", previous, now)
+			return
+		}
+		logMessage("WiFi Discovery: " + info)
+		reportNeighbor(n, "wifi_discovery", "info", "Connected to AP: "+n.SystemName, previous, now)
+		return
+	}
+
+	switch event {
+	case NEIGHBOR_EVENT_ADDED:
+		logMessage("Neighbor added on " + n.Interface + ": " + info)
+		reportNeighbor(n, event, "info", "Neighbor Found: "+info, nil, now)
+	case NEIGHBOR_EVENT_CHANGED:
+		logMessage("Neighbor changed on " + n.Interface + ": " + previous.summary() + " -> " + info)
+		reportNeighbor(n, event, "warning", "Neighbor Changed: "+previous.summary()+" -> "+info, previous, now)
+	case NEIGHBOR_EVENT_EXPIRED:
+		// previous holds the neighbor that announced its shutdown
+		logMessage("Neighbor shutdown on " + n.Interface + ": " + previous.summary())
+		reportNeighbor(*previous, event, "info", "Neighbor Shutdown: "+previous.summary(), nil, now)
+	}
+}
+
+// expireNeighbors runs for the agent lifetime, reporting neighbors whose TTL ran out.
+func expireNeighbors() {
+	ticker := time.NewTicker(NEIGHBOR_EXPIRY_CHECK)
+	for range ticker.C {
+		reportExpiredNeighbors(time.Now())
+	}
+}
+
+func reportExpiredNeighbors(now time.Time) {
+	for _, n := range neighbors.expire(now) {
+		logMessage("Neighbor expired on " + n.Interface + ": " + n.summary())
+		reportNeighbor(n, NEIGHBOR_EVENT_EXPIRED, "info", "Neighbor Expired: "+n.summary(), nil, now)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNeighborTableKeysOnChassis(t *testing.T) {
+	table := &neighborTable{entries: make(map[string]*neighborEntry)}
+	now := time.Unix(1700000000, 0)
+
+	switchA := topologyNeighbor{Protocol: "lldp", Interface: "eth0", ChassisID: "00:11:22:33:44:55", PortID: "Gi1/0/1", SystemName: "sw-a", TTL: 120}
+	switchB := topologyNeighbor{Protocol: "lldp", Interface: "eth0", ChassisID: "66:77:88:99:aa:bb", PortID: "1", SystemName: "sw-b", TTL: 120}
+
+	if event, _ := table.update(switchA, now); event != NEIGHBOR_EVENT_ADDED {
+		t.Fatalf("first neighbor: event %q, want %q", event, NEIGHBOR_EVENT_ADDED)
+	}
+	// a second neighbor behind the same port is a new entry, not a change
+	if event, _ := table.update(switchB, now); event != NEIGHBOR_EVENT_ADDED {
+		t.Fatalf("second neighbor on eth0: event %q, want %q", event, NEIGHBOR_EVENT_ADDED)
+	}
+	if event, _ := table.update(switchA, now.Add(30*time.Second)); event != "" {
+		t.Fatalf("refresh: event %q, want none", event)
+	}
+	if got := len(table.snapshot()); got != 2 {
+		t.Fatalf("snapshot has %d neighbors, want 2", got)
+	}
+
+	renamed := switchA
+	renamed.SystemName = "sw-a-new"
+	event, previous := table.update(renamed, now.Add(60*time.Second))
+	if event != NEIGHBOR_EVENT_CHANGED || previous == nil || previous.SystemName != "sw-a" {
+		t.Fatalf("rename: event %q previous %+v, want %q from sw-a", event, previous, NEIGHBOR_EVENT_CHANGED)
+	}
+
+	// the same switch on another port is a port move replacing the entry
+	moved := renamed
+	moved.PortID = "Gi1/0/7"
+	event, previous = table.update(moved, now.Add(60*time.Second))
+	if event != NEIGHBOR_EVENT_CHANGED || previous == nil || previous.PortID != "Gi1/0/1" {
+		t.Fatalf("port move: event %q previous %+v, want %q from Gi1/0/1", event, previous, NEIGHBOR_EVENT_CHANGED)
+	}
+	if got := len(table.snapshot()); got != 2 {
+		t.Fatalf("snapshot has %d neighbors after the port move, want 2", got)
+	}
+
+	// TTL 0 shuts down only the announcing neighbor
+	shutdown := switchB
+	shutdown.TTL = 0
+	if event, previous := table.update(shutdown, now.Add(60*time.Second)); event != NEIGHBOR_EVENT_EXPIRED || previous.ChassisID != switchB.ChassisID {
+		t.Fatalf("shutdown: event %q, want %q for sw-b", event, NEIGHBOR_EVENT_EXPIRED)
+	}
+	if got := len(table.snapshot()); got != 1 {
+		t.Fatalf("snapshot has %d neighbors after shutdown, want 1", got)
+	}
+
+	expired := table.expire(now.Add(60*time.Second + 121*time.Second))
+	if len(expired) != 1 || expired[0].SystemName != "sw-a-new" {
+		t.Fatalf("expire returned %+v, want sw-a-new", expired)
+	}
+}
+
+func TestNeighborTableWifiRoam(t *testing.T) {
+	table := &neighborTable{entries: make(map[string]*neighborEntry)}
+	now := time.Unix(1700000000, 0)
+
+	ap1 := topologyNeighbor{Protocol: "wifi", Interface: "wlan0", ChassisID: "aa:aa:aa:aa:aa:01", SystemName: "corp"}
+	ap2 := ap1
+	ap2.ChassisID = "aa:aa:aa:aa:aa:02"
+
+	table.update(ap1, now)
+	event, previous := table.update(ap2, now.Add(time.Minute))
+	if event != NEIGHBOR_EVENT_CHANGED || previous == nil || previous.ChassisID != ap1.ChassisID {
+		t.Fatalf("roam: event %q previous %+v, want %q from %s", event, previous, NEIGHBOR_EVENT_CHANGED, ap1.ChassisID)
+	}
+	if got := len(table.snapshot()); got != 1 {
+		t.Fatalf("snapshot has %d access points, want 1", got)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Network interfaces. Addresses come from net.Interfaces and the primary
+// interface is the one holding the default route in the OS routing table
+// (netRoutes, per platform), so nothing is dialled and no command output
+// is scraped. runInterfaceMonitor re-registers the device when the
+// primary address or adapter changes, e.g. when a laptop roams.
+
+const NETIF_CHECK_INTERVAL = 30 * time.Second
+
+type netInterface struct {
+	Name      string   `json:"name"`
+	Index     int      `json:"index"`
+	MAC       string   `json:"mac,omitempty"`
+	MTU       int      `json:"mtu"`
+	Up        bool     `json:"up"`                  // administratively up
+	Running   bool     `json:"running"`             // link up
+	Addresses []string `json:"addresses,omitempty"` // CIDR
+	Default   bool     `json:"default_route,omitempty"`
+	Gateway   string   `json:"gateway,omitempty"`
+
+	routeRank int // 1 + position of its first default route, 0 for none
+}
+
+// netRoute is a default route from the routing table.
+type netRoute struct {
+	Interface string
+	Gateway   net.IP
+	Metric    int
+}
+
+// localInterfaces lists the non-loopback interfaces ordered by index, with
+// the default-route interfaces marked.
+func localInterfaces() []netInterface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	routes := netRoutes()
+	var list []netInterface
+	for _, ifc := range ifaces {
+		if ifc.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ni := netInterface{
+			Name:    ifc.Name,
+			Index:   ifc.Index,
+			MAC:     strings.ToUpper(ifc.HardwareAddr.String()),
+			MTU:     ifc.MTU,
+			Up:      ifc.Flags&net.FlagUp != 0,
+			Running: ifc.Flags&net.FlagRunning != 0,
+		}
+		addrs, _ := ifc.Addrs()
+		for _, a := range addrs {
+			ni.Addresses = append(ni.Addresses, a.String())
+		}
+		ni.applyRoutes(routes)
+		list = append(list, ni)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Index < list[j].Index })
+	return list
+}
+
+// applyRoutes marks the interface as holding a default route and takes the
+// gateway of its best one.
+func (ni *netInterface) applyRoutes(routes []netRoute) {
+	for i, r := range routes {
+		if r.Interface != ni.Name {
+			continue
+		}
+		if !ni.Default {
+			ni.Default, ni.routeRank = true, i+1
+		}
+		if ni.Gateway == "" && r.Gateway != nil && !r.Gateway.IsUnspecified() {
+			ni.Gateway = r.Gateway.String()
+		}
+	}
+}
+
+// primaryInterface picks the interface of the best default route, falling
+// back to the first running interface with a usable address when there is
+// no default route (isolated networks).
+func primaryInterface(ifaces []netInterface) *netInterface {
+	var best *netInterface
+	for i := range ifaces {
+		ni := &ifaces[i]
+		if ni.routeRank > 0 && ni.Running && ni.address(false) != "" && (best == nil || ni.routeRank < best.routeRank) {
+			best = ni
+		}
+	}
+	if best != nil {
+		return best
+	}
+	for _, wantV4 := range []bool{true, false} {
+		for i := range ifaces {
+			if ifaces[i].Running && ifaces[i].address(wantV4) != "" {
+				return &ifaces[i]
+			}
+		}
+	}
+	return nil
+}
+
+// primaryIdentity is what the device record holds of the primary interface.
+type primaryIdentity struct {
+	Name, Address, MAC string
+}
+
+func primaryIdentityOf(ifaces []netInterface) primaryIdentity {
+	ni := primaryInterface(ifaces)
+	if ni == nil {
+		return primaryIdentity{}
+	}
+	return primaryIdentity{Name: ni.Name, Address: ni.address(false), MAC: ni.MAC}
+}
+
+// address returns the first IPv4 address of the interface, or with
+// v4Only false the first global IPv6 one when it has no IPv4 address.
+// Link-local addresses are skipped.
+func (ni netInterface) address(v4Only bool) string {
+	var v6 string
+	for _, a := range ni.Addresses {
+		ip, _, err := net.ParseCIDR(a)
+		if err != nil || ip.IsLinkLocalUnicast() || ip.IsLoopback() {
+			continue
+		}
+		if ip.To4() != nil {
+			return ip.String()
+		}
+		if v6 == "" {
+			v6 = ip.String()
+		}
+	}
+	if v4Only {
+		return ""
+	}
+	return v6
+}
+
+// sortedRoutes orders default routes by metric, keeping the platform order
+// for ties.
+func sortedRoutes(routes []netRoute) []netRoute {
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].Metric < routes[j].Metric })
+	return routes
+}
+
+// runInterfaceMonitor reports address changes and updates the device
+// record when the primary address or adapter changed. The primary adapter
+// can change without any address changing (a second NIC with the same
+// DHCP reservation, a route metric change), so it is compared on its own.
+func runInterfaceMonitor() {
+	last := localInterfaces()
+	for {
+		time.Sleep(NETIF_CHECK_INTERVAL)
+		policyMutex.RLock()
+		quarantined := isQuarantined
+		policyMutex.RUnlock()
+		if deviceID == "" || quarantined {
+			continue // changes are reported once the device is back
+		}
+		now := localInterfaces()
+		added, removed := interfaceAddressChanges(last, now)
+		before, after := primaryIdentityOf(last), primaryIdentityOf(now)
+		if len(added) == 0 && len(removed) == 0 && before == after {
+			continue
+		}
+		last = now
+
+		msg := fmt.Sprintf("Network addresses changed: +%d -%d", len(added), len(removed))
+		if after.Address != before.Address {
+			msg += fmt.Sprintf(", primary address %s -> %s", firstNonEmpty(before.Address, "none"), firstNonEmpty(after.Address, "none"))
+		}
+		if after.Name != before.Name || after.MAC != before.MAC {
+			msg += fmt.Sprintf(", primary interface %s -> %s", firstNonEmpty(before.Name, "none"), firstNonEmpty(after.Name, "none"))
+		}
+		sendLog(LogEntry{
+			DeviceID:   deviceID,
+			DeviceName: deviceName,
+			Hostname:   getHostname(),
+			LogType:    "network",
+			Event:      "network_address_changed",
+			Source:     "network-interfaces",
+			Severity:   "info",
+			Message:    msg,
+			Timestamp:  time.Now().UTC().Format(time.RFC3339),
+			RawData: map[string]interface{}{
+				"added":              added,
+				"removed":            removed,
+				"primary_interface":  after.Name,
+				"primary_address":    after.Address,
+				"previous_interface": before.Name,
+				"previous_address":   before.Address,
+				"mac_address":        after.MAC,
+				"interfaces":         now,
+			},
+		})
+
+		if after != before {
+			if err := initializeDevice(); err != nil {
+				logMessage("Device record update failed: " + err.Error())
+			}
+		}
+	}
+}
+
+// interfaceAddressChanges returns the "interface address" pairs that
+// appeared and disappeared between two snapshots.
+func interfaceAddressChanges(before, after []netInterface) (added, removed []string) {
+	set := func(list []netInterface) map[string]bool {
+		m := make(map[string]bool)
+		for _, ni := range list {
+			for _, a := range ni.Addresses {
+				m[ni.Name+" "+a] = true
+			}
+		}
+		return m
+	}
+	b, a := set(before), set(after)
+	for k := range a {
+		if !b[k] {
+			added = append(added, k)
+		}
+	}
+	for k := range b {
+		if !a[k] {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	RTF_UP     = 0x0001
+	RTF_REJECT = 0x0200
+)
+
+// netRoutes reads the default routes from /proc/net/route and
+// /proc/net/ipv6_route, IPv4 first, each family by metric.
+func netRoutes() []netRoute {
+	return parseProcRoutes(configLines("/proc/net/route"), configLines("/proc/net/ipv6_route"))
+}
+
+// parseProcRoutes parses the lines of both files.
+func parseProcRoutes(route, ipv6Route []string) []netRoute {
+	var v4, v6 []netRoute
+	// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+	for _, line := range route {
+		f := strings.Fields(line)
+		if len(f) < 8 || f[1] != "00000000" || f[7] != "00000000" {
+			continue
+		}
+		flags, _ := strconv.ParseUint(f[3], 16, 32)
+		if flags&RTF_UP == 0 || flags&RTF_REJECT != 0 {
+			continue
+		}
+		var gw net.IP
+		if b, err := hex.DecodeString(f[2]); err == nil && len(b) == 4 {
+			gw = make(net.IP, 4)
+			binary.BigEndian.PutUint32(gw, binary.LittleEndian.Uint32(b))
+		}
+		metric, _ := strconv.Atoi(f[6])
+		v4 = append(v4, netRoute{Interface: f[0], Gateway: gw, Metric: metric})
+	}
+	// dest plen src splen nexthop metric refcnt use flags iface
+	for _, line := range ipv6Route {
+		f := strings.Fields(line)
+		if len(f) < 10 || f[1] != "00" || strings.Trim(f[0], "0") != "" || f[9] == "lo" {
+			continue
+		}
+		flags, _ := strconv.ParseUint(f[8], 16, 32)
+		if flags&RTF_UP == 0 || flags&RTF_REJECT != 0 {
+			continue
+		}
+		gw, _ := hex.DecodeString(f[4])
+		metric, _ := strconv.ParseUint(f[5], 16, 32)
+		v6 = append(v6, netRoute{Interface: f[9], Gateway: net.IP(gw), Metric: int(metric)})
+	}
+	return append(sortedRoutes(v4), sortedRoutes(v6)...)
+}
@@ -0,0 +1,48 @@
+//go:build linux
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProcRoutes(t *testing.T) {
+	route := strings.Split(strings.TrimSpace(`
+Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+wlan0	00000000	0101A8C0	0003	0	0	600	00000000	0	0	0
+eth0	00000000	FE01000A	0003	0	0	100	00000000	0	0	0
+eth0	0000000A	00000000	0001	0	0	100	00FFFFFF	0	0	0
+blackhole	00000000	00000000	0201	0	0	0	00000000	0	0	0
+down0	00000000	01010101	0002	0	0	0	00000000	0	0	0
+wg0	00000000	00000000	0001	0	0	600	00000000	0	0	0`), "\n")
+	ipv6Route := strings.Split(strings.TrimSpace(`
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003 eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000002 00000064 00000001 00000000 00000003 wlan0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200 lo
+20010db8000000000000000000000000 20 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001 eth0`), "\n")
+
+	type routeRow struct {
+		Interface, Gateway string
+		Metric             int
+	}
+	var got []routeRow
+	for _, r := range parseProcRoutes(route, ipv6Route) {
+		gw := ""
+		if r.Gateway != nil {
+			gw = r.Gateway.String()
+		}
+		got = append(got, routeRow{r.Interface, gw, r.Metric})
+	}
+	want := []routeRow{
+		{"eth0", "10.0.1.254", 100},
+		{"wlan0", "192.168.1.1", 600},
+		{"wg0", "0.0.0.0", 600},
+		{"wlan0", "fe80::2", 100},
+		{"eth0", "fe80::1", 1024},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseProcRoutes\n got  %v\n want %v", got, want)
+	}
+}
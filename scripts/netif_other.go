@@ -0,0 +1,39 @@
+//go:build !windows && !linux
+
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// netRoutes asks route(8) for the IPv4 and IPv6 default routes; the BSD
+// routing socket is not wrapped by the standard library.
+func netRoutes() []netRoute {
+	var routes []netRoute
+	for _, family := range []string{"-inet", "-inet6"} {
+		out, err := runCommandWithTimeout("route", "-n", "get", family, "default")
+		if err != nil {
+			continue
+		}
+		var r netRoute
+		for _, line := range strings.Split(string(out), "\n") {
+			key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+			if !ok {
+				continue
+			}
+			switch key {
+			case "interface":
+				r.Interface = strings.TrimSpace(value)
+			case "gateway":
+				// fe80::1%en0
+				addr, _, _ := strings.Cut(strings.TrimSpace(value), "%")
+				r.Gateway = net.ParseIP(addr)
+			}
+		}
+		if r.Interface != "" {
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestPrimaryInterface(t *testing.T) {
+	routes := []netRoute{
+		{Interface: "eth0", Gateway: net.ParseIP("10.0.0.1"), Metric: 100},
+		{Interface: "wlan0", Gateway: net.ParseIP("192.168.1.1"), Metric: 600},
+		{Interface: "eth0", Gateway: net.ParseIP("fe80::1"), Metric: 1024},
+	}
+	eth := netInterface{Name: "eth0", Running: true, Addresses: []string{"10.0.0.5/24"}}
+	wlan := netInterface{Name: "wlan0", Running: true, Addresses: []string{"192.168.1.20/24"}}
+	docker := netInterface{Name: "docker0", Running: true, Addresses: []string{"172.17.0.1/16"}}
+	v6only := netInterface{Name: "eth1", Running: true, Addresses: []string{"fe80::5/64", "2001:db8::5/64"}}
+	linkLocal := netInterface{Name: "eth2", Running: true, Addresses: []string{"fe80::6/64", "169.254.3.4/16"}}
+	down := func(ni netInterface) netInterface { ni.Running = false; return ni }
+
+	tests := []struct {
+		name   string
+		ifaces []netInterface
+		routes []netRoute
+		want   string
+	}{
+		{"lowest route wins", []netInterface{docker, wlan, eth}, routes, "eth0"},
+		{"route interface not running", []netInterface{docker, wlan, down(eth)}, routes, "wlan0"},
+		{"route interface without address", []netInterface{{Name: "eth0", Running: true, Addresses: []string{"fe80::5/64"}}, wlan}, routes, "wlan0"},
+		{"no route falls back to ipv4", []netInterface{v6only, linkLocal, docker}, nil, "docker0"},
+		{"no route falls back to ipv6", []netInterface{linkLocal, v6only}, nil, "eth1"},
+		{"nothing usable", []netInterface{linkLocal, down(docker)}, nil, ""},
+		{"no interfaces", nil, routes, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.ifaces {
+				tt.ifaces[i].applyRoutes(tt.routes)
+			}
+			got := ""
+			if ni := primaryInterface(tt.ifaces); ni != nil {
+				got = ni.Name
+			}
+			if got != tt.want {
+				t.Errorf("primaryInterface = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRoutes(t *testing.T) {
+	ni := netInterface{Name: "eth0"}
+	ni.applyRoutes([]netRoute{
+		{Interface: "wlan0", Gateway: net.ParseIP("192.168.1.1")},
+		{Interface: "eth0", Gateway: net.IPv4zero},
+		{Interface: "eth0", Gateway: net.ParseIP("10.0.0.1")},
+	})
+	if !ni.Default || ni.routeRank != 2 || ni.Gateway != "10.0.0.1" {
+		t.Errorf("applyRoutes = default %v rank %d gateway %q, want true 2 10.0.0.1", ni.Default, ni.routeRank, ni.Gateway)
+	}
+}
+
+func TestInterfaceAddressChanges(t *testing.T) {
+	before := []netInterface{
+		{Name: "eth0", Addresses: []string{"10.0.0.5/24", "fe80::5/64"}},
+		{Name: "wlan0", Addresses: []string{"192.168.1.20/24"}},
+	}
+	after := []netInterface{
+		{Name: "eth0", Addresses: []string{"10.0.0.7/24", "fe80::5/64"}},
+		{Name: "wlan1", Addresses: []string{"192.168.1.20/24"}},
+	}
+	added, removed := interfaceAddressChanges(before, after)
+	if want := []string{"eth0 10.0.0.7/24", "wlan1 192.168.1.20/24"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %v, want %v", added, want)
+	}
+	if want := []string{"eth0 10.0.0.5/24", "wlan0 192.168.1.20/24"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %v, want %v", removed, want)
+	}
+	if added, removed := interfaceAddressChanges(after, after); added != nil || removed != nil {
+		t.Errorf("unchanged snapshot reported %v %v", added, removed)
+	}
+}
+
+// The device record follows the primary adapter even when no address changed.
+func TestPrimaryIdentityChanges(t *testing.T) {
+	routes := []netRoute{{Interface: "eth0", Metric: 100}, {Interface: "eth1", Metric: 200}}
+	snapshot := func(primary, mac string) []netInterface {
+		list := []netInterface{
+			{Name: "eth0", MAC: mac, Running: primary == "eth0", Addresses: []string{"10.0.0.5/24"}},
+			{Name: "eth1", MAC: "66:77:88:99:AA:BB", Running: true, Addresses: []string{"10.0.0.5/24"}},
+		}
+		for i := range list {
+			list[i].applyRoutes(routes)
+		}
+		return list
+	}
+
+	before := snapshot("eth0", "00:11:22:33:44:55")
+	for _, tt := range []struct {
+		name    string
+		after   []netInterface
+		changed bool
+	}{
+		{"same", snapshot("eth0", "00:11:22:33:44:55"), false},
+		{"adapter", snapshot("eth1", "00:11:22:33:44:55"), true},
+		{"mac", snapshot("eth0", "00:11:22:33:44:66"), true},
+	} {
+		added, removed := interfaceAddressChanges(before, tt.after)
+		if len(added)+len(removed) != 0 {
+			t.Fatalf("%s: addresses changed: %v %v", tt.name, added, removed)
+		}
+		b, a := primaryIdentityOf(before), primaryIdentityOf(tt.after)
+		if (a != b) != tt.changed {
+			t.Errorf("%s: primary %+v -> %+v, changed = %v, want %v", tt.name, b, a, a != b, tt.changed)
+		}
+	}
+	if got := primaryIdentityOf(nil); got != (primaryIdentity{}) {
+		t.Errorf("primaryIdentityOf(nil) = %+v", got)
+	}
+}
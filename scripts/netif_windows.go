@@ -0,0 +1,67 @@
+//go:build windows
+
+package main
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// netRoutes lists the default routes of the IP Helper routing table, IPv4
+// first. Route metrics leave out the interface metric, so the route the
+// stack would actually use (GetBestInterfaceEx towards a documentation
+// address, which no specific route covers) is put first in each family.
+func netRoutes() []netRoute {
+	var table *windows.MibIpForwardTable2
+	if windows.GetIpForwardTable2(windows.AF_UNSPEC, &table) != nil {
+		return nil
+	}
+	defer windows.FreeMibTable(unsafe.Pointer(table))
+
+	best := make(map[uint16]uint32)
+	var index uint32
+	if windows.GetBestInterfaceEx(&windows.SockaddrInet4{Addr: [4]byte{203, 0, 113, 1}}, &index) == nil {
+		best[windows.AF_INET] = index
+	}
+	if windows.GetBestInterfaceEx(&windows.SockaddrInet6{Addr: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}, &index) == nil {
+		best[windows.AF_INET6] = index
+	}
+
+	var v4, v6 []netRoute
+	for _, row := range table.Rows() {
+		if row.DestinationPrefix.PrefixLength != 0 || row.Loopback != 0 {
+			continue
+		}
+		ifc, err := net.InterfaceByIndex(int(row.InterfaceIndex))
+		if err != nil {
+			continue
+		}
+		family := row.DestinationPrefix.Prefix.Family
+		r := netRoute{Interface: ifc.Name, Gateway: sockaddrInetIP(&row.NextHop), Metric: int(row.Metric)}
+		if b, ok := best[family]; ok && b == row.InterfaceIndex {
+			r.Metric = -1
+		}
+		switch family {
+		case windows.AF_INET:
+			v4 = append(v4, r)
+		case windows.AF_INET6:
+			v6 = append(v6, r)
+		}
+	}
+	return append(sortedRoutes(v4), sortedRoutes(v6)...)
+}
+
+// sockaddrInetIP decodes a SOCKADDR_INET: the IPv4 address follows the
+// port, the IPv6 one the port and flow info.
+func sockaddrInetIP(sa *windows.RawSockaddrInet) net.IP {
+	data := (*[24]byte)(unsafe.Pointer(&sa.Data))
+	switch sa.Family {
+	case windows.AF_INET:
+		return net.IP(append([]byte(nil), data[0:4]...))
+	case windows.AF_INET6:
+		return net.IP(append([]byte(nil), data[4:20]...))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// `netsh wlan show interfaces` parsing. Kept free of build tags so recorded
+// output parses on any platform; the polling side is in wifi_windows.go.
+
+// Field names are localized by Windows. These are the names of the fields
+// read by the parser in the display languages we have seen; SSID and BSSID
+// are not translated.
+var (
+	netshChannelKeys        = map[string]bool{"channel": true, "kanal": true, "canal": true, "canale": true}
+	netshSignalKeys         = map[string]bool{"signal": true, "señal": true, "segnale": true, "sinal": true}
+	netshAuthenticationKeys = map[string]bool{
+		"authentication":    true,
+		"authentifizierung": true,
+		"authentification":  true,
+		"autenticación":     true,
+		"autenticazione":    true,
+		"autenticação":      true,
+	}
+)
+
+// parseNetshInterfaces parses one block per interface. Every field is
+// identified by its key, never by its value, so an SSID or profile called
+// "Open" or "90%" is not mistaken for the authentication or the signal. The
+// interface name is the first field of each block, whatever its key is.
+func parseNetshInterfaces(output string) []wifiAssociation {
+	var result []wifiAssociation
+	var cur wifiAssociation
+	inBlock := false
+
+	flush := func() {
+		if cur.BSSID != "" {
+			result = append(result, cur)
+		}
+		cur = wifiAssociation{}
+		inBlock = false
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			flush()
+			continue
+		}
+		// Keys never contain ':' but BSSID values do, so split on the first one only
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !inBlock {
+			cur.Interface = value
+			inBlock = true
+			continue
+		}
+
+		switch {
+		case key == "ssid":
+			cur.SSID = value
+		case key == "bssid" || key == "ap bssid":
+			cur.BSSID = strings.ToLower(value)
+		case netshChannelKeys[key]:
+			cur.Channel, _ = strconv.Atoi(value)
+		case netshSignalKeys[key]:
+			cur.SignalPercent, _ = strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(value, "%")))
+		case netshAuthenticationKeys[key]:
+			cur.Authentication = value
+			cur.Security = netshSecurity(value)
+		}
+	}
+	flush()
+	return result
+}
+
+// netshSecurity maps the netsh Authentication value to a normalized security type.
+func netshSecurity(value string) string {
+	switch strings.ToLower(value) {
+	case "open", "ouvert", "offen", "abierto", "aperto":
+		return WIFI_SECURITY_OPEN
+	case "shared", "wep":
+		return WIFI_SECURITY_WEP
+	case "wpa-personal", "wpa-enterprise", "wpa":
+		return WIFI_SECURITY_WPA
+	case "wpa2-personal", "wpa2-psk":
+		return WIFI_SECURITY_WPA2_PERSONAL
+	case "wpa2-enterprise", "wpa2":
+		return WIFI_SECURITY_WPA2_ENTERPRISE
+	case "wpa3-personal", "wpa3-sae":
+		return WIFI_SECURITY_WPA3_PERSONAL
+	case "wpa3-enterprise", "wpa3-enterprise 192 bits", "wpa3":
+		return WIFI_SECURITY_WPA3_ENTERPRISE
+	case "owe":
+		return WIFI_SECURITY_OWE
+	}
+	return ""
+}
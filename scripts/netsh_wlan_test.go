@@ -0,0 +1,85 @@
+package main
+
+import "testing"
+
+const netshEnglish = `
+There is 1 interface on the system:
+
+    Name                   : Wi-Fi
+    Description            : Intel(R) Wi-Fi 6 AX201 160MHz
+    GUID                   : 5f3c0d8e-2a47-4e0a-9d2b-6c1f0e8a7b31
+    Physical address       : a4:c3:f0:11:22:33
+    Interface type         : Primary
+    State                  : connected
+    SSID                   : Open
+    AP BSSID               : 00:1A:2B:3C:4D:5E
+    Band                   : 5 GHz
+    Radio type             : 802.11ax
+    Authentication         : WPA2-Personal
+    Cipher                 : CCMP
+    Connection mode        : Profile
+    Channel                : 36
+    Receive rate (Mbps)    : 866.7
+    Transmit rate (Mbps)   : 866.7
+    Signal                 : 92%
+    Profile                : Open
+
+    Hosted network status  : Not available
+`
+
+const netshGerman = `
+Es ist 1 Schnittstelle auf dem System vorhanden:
+
+    Name                   : WLAN
+    Beschreibung           : Intel(R) Wi-Fi 6 AX201 160MHz
+    Status                 : Verbunden
+    SSID                   : Gast 100%
+    BSSID                  : 00:1a:2b:3c:4d:60
+    Netzwerktyp            : Infrastruktur
+    Authentifizierung      : Offen
+    Verschlüsselung        : Keine
+    Kanal                  : 6
+    Signal                 : 71%
+    Profil                 : WPA2-Personal
+`
+
+func TestParseNetshInterfaces(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []wifiAssociation
+	}{
+		{"english, profile named Open", netshEnglish, []wifiAssociation{{
+			Interface:      "Wi-Fi",
+			SSID:           "Open",
+			BSSID:          "00:1a:2b:3c:4d:5e",
+			Channel:        36,
+			SignalPercent:  92,
+			Security:       WIFI_SECURITY_WPA2_PERSONAL,
+			Authentication: "WPA2-Personal",
+		}}},
+		{"german, profile named like a security type", netshGerman, []wifiAssociation{{
+			Interface:      "WLAN",
+			SSID:           "Gast 100%",
+			BSSID:          "00:1a:2b:3c:4d:60",
+			Channel:        6,
+			SignalPercent:  71,
+			Security:       WIFI_SECURITY_OPEN,
+			Authentication: "Offen",
+		}}},
+		{"disconnected", "\n    Name                   : Wi-Fi\n    State                  : disconnected\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseNetshInterfaces(tt.output)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d associations, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("association %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Generic netlink / nl80211 message parsing. Kept free of socket code and
+// build tags so recorded netlink responses decode on any platform; the live
+// socket side is in wifi_linux.go.
+
+const (
+	nlmsgHeaderLen   = 16
+	genlHeaderLen    = 4
+	nlmsgTypeError   = 2
+	nlmsgTypeDone    = 3
+	nlaTypeMask      = 0x3fff
+	genlIDCtrl       = 0x10
+	ctrlCmdGetFamily = 3
+	ctrlAttrFamilyID = 1
+	ctrlAttrName     = 2
+
+	nl80211CmdGetInterface = 5
+	nl80211CmdGetStation   = 17
+	nl80211CmdGetScan      = 32
+
+	nl80211AttrIfindex   = 3
+	nl80211AttrIfname    = 4
+	nl80211AttrIftype    = 5
+	nl80211AttrMAC       = 6
+	nl80211AttrStaInfo   = 21
+	nl80211AttrWiphyFreq = 38
+	nl80211AttrBSS       = 47
+	nl80211AttrSSID      = 52
+
+	nl80211IftypeStation = 2
+
+	nl80211BSSBSSID      = 1
+	nl80211BSSFrequency  = 2
+	nl80211BSSCapability = 5
+	nl80211BSSIEs        = 6
+	nl80211BSSSignalMBM  = 7
+	nl80211BSSStatus     = 9
+
+	nl80211BSSStatusAssociated = 1
+	nl80211StaInfoSignal       = 7
+)
+
+// netlinkMessage is one nlmsghdr with its payload (genlmsghdr included).
+type netlinkMessage struct {
+	Type    uint16
+	Flags   uint16
+	Seq     uint32
+	Payload []byte
+}
+
+// parseNetlinkMessages splits a recvfrom buffer into messages. done reports
+// an NLMSG_DONE terminator; a NLMSG_ERROR with a non-zero code is an error.
+func parseNetlinkMessages(buf []byte) (msgs []netlinkMessage, done bool, err error) {
+	for len(buf) >= nlmsgHeaderLen {
+		length := int(binary.LittleEndian.Uint32(buf[0:4]))
+		if length < nlmsgHeaderLen || length > len(buf) {
+			return msgs, done, fmt.Errorf("netlink: truncated message")
+		}
+		msg := netlinkMessage{
+			Type:    binary.LittleEndian.Uint16(buf[4:6]),
+			Flags:   binary.LittleEndian.Uint16(buf[6:8]),
+			Seq:     binary.LittleEndian.Uint32(buf[8:12]),
+			Payload: buf[nlmsgHeaderLen:length],
+		}
+		// the last message may lack its alignment padding
+		if a := nlAlign(length); a < len(buf) {
+			buf = buf[a:]
+		} else {
+			buf = nil
+		}
+
+		switch msg.Type {
+		case nlmsgTypeDone:
+			return msgs, true, nil
+		case nlmsgTypeError:
+			if len(msg.Payload) >= 4 {
+				if code := int32(binary.LittleEndian.Uint32(msg.Payload[0:4])); code != 0 {
+					return msgs, true, fmt.Errorf("netlink: error %d", -code)
+				}
+			}
+			// code 0 is an ACK
+			return msgs, true, nil
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, done, nil
+}
+
+// parseNetlinkAttrs decodes a flat attribute list. Repeated types keep the last value.
+func parseNetlinkAttrs(data []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(data) >= 4 {
+		length := int(binary.LittleEndian.Uint16(data[0:2]))
+		if length < 4 || length > len(data) {
+			break
+		}
+		attrs[binary.LittleEndian.Uint16(data[2:4])&nlaTypeMask] = data[4:length]
+		if nlAlign(length) >= len(data) {
+			break
+		}
+		data = data[nlAlign(length):]
+	}
+	return attrs
+}
+
+// genlAttrs returns the attributes of a generic netlink message.
+func genlAttrs(msg netlinkMessage) map[uint16][]byte {
+	if len(msg.Payload) < genlHeaderLen {
+		return nil
+	}
+	return parseNetlinkAttrs(msg.Payload[genlHeaderLen:])
+}
+
+func nlAlign(n int) int {
+	return (n + 3) &^ 3
+}
+
+func nlAttrU16(attrs map[uint16][]byte, t uint16) (uint16, bool) {
+	v, ok := attrs[t]
+	if !ok || len(v) < 2 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(v), true
+}
+
+func nlAttrU32(attrs map[uint16][]byte, t uint16) (uint32, bool) {
+	v, ok := attrs[t]
+	if !ok || len(v) < 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(v), true
+}
+
+func nlAttrString(attrs map[uint16][]byte, t uint16) string {
+	return lldpString(attrs[t])
+}
+
+// encodeNetlinkAttr appends one attribute (padded) to b.
+func encodeNetlinkAttr(b []byte, t uint16, value []byte) []byte {
+	hdr := make([]byte, 4)
+	binary.LittleEndian.PutUint16(hdr[0:2], uint16(4+len(value)))
+	binary.LittleEndian.PutUint16(hdr[2:4], t)
+	b = append(b, hdr...)
+	b = append(b, value...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// encodeGenlRequest builds a generic netlink request message.
+func encodeGenlRequest(family uint16, flags uint16, seq uint32, cmd uint8, attrs []byte) []byte {
+	length := nlmsgHeaderLen + genlHeaderLen + len(attrs)
+	b := make([]byte, nlmsgHeaderLen+genlHeaderLen, length)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(length))
+	binary.LittleEndian.PutUint16(b[4:6], family)
+	binary.LittleEndian.PutUint16(b[6:8], flags)
+	binary.LittleEndian.PutUint32(b[8:12], seq)
+	b[16] = cmd
+	b[17] = 1 // version
+	return append(b, attrs...)
+}
+
+// nl80211Interface is one NL80211_CMD_GET_INTERFACE result.
+type nl80211Interface struct {
+	Index     uint32
+	Name      string
+	Type      uint32
+	SSID      string
+	Frequency int
+}
+
+func parseNL80211Interfaces(msgs []netlinkMessage) []nl80211Interface {
+	var ifaces []nl80211Interface
+	for _, msg := range msgs {
+		attrs := genlAttrs(msg)
+		index, ok := nlAttrU32(attrs, nl80211AttrIfindex)
+		if !ok {
+			continue
+		}
+		iftype, _ := nlAttrU32(attrs, nl80211AttrIftype)
+		freq, _ := nlAttrU32(attrs, nl80211AttrWiphyFreq)
+		ifaces = append(ifaces, nl80211Interface{
+			Index:     index,
+			Name:      nlAttrString(attrs, nl80211AttrIfname),
+			Type:      iftype,
+			SSID:      string(attrs[nl80211AttrSSID]),
+			Frequency: int(freq),
+		})
+	}
+	return ifaces
+}
+
+// parseNL80211Association finds the associated BSS in a GET_SCAN dump and
+// fills the association for iface. ok is false when the interface is not
+// associated.
+func parseNL80211Association(iface nl80211Interface, scan []netlinkMessage) (wifiAssociation, bool) {
+	for _, msg := range scan {
+		bss := parseNetlinkAttrs(genlAttrs(msg)[nl80211AttrBSS])
+		status, ok := nlAttrU32(bss, nl80211BSSStatus)
+		if !ok || status != nl80211BSSStatusAssociated {
+			continue
+		}
+		bssid := bss[nl80211BSSBSSID]
+		if len(bssid) != 6 {
+			continue
+		}
+
+		freq, _ := nlAttrU32(bss, nl80211BSSFrequency)
+		capability, _ := nlAttrU16(bss, nl80211BSSCapability)
+		ies := bss[nl80211BSSIEs]
+
+		a := wifiAssociation{
+			Interface:    iface.Name,
+			SSID:         iface.SSID,
+			BSSID:        net.HardwareAddr(bssid).String(),
+			FrequencyMHz: int(freq),
+			Channel:      wifiChannel(int(freq)),
+			Security:     wifiSecurityFromIEs(ies, capability&0x0010 != 0),
+		}
+		if a.SSID == "" {
+			a.SSID = wifiSSIDFromIEs(ies)
+		}
+		if mbm, ok := nlAttrU32(bss, nl80211BSSSignalMBM); ok {
+			a.SignalDBM = int(int32(mbm)) / 100
+		}
+		return a, true
+	}
+	return wifiAssociation{}, false
+}
+
+// parseNL80211StationSignal returns the current signal (dBm) from a
+// GET_STATION dump for the associated BSSID.
+func parseNL80211StationSignal(msgs []netlinkMessage, bssid string) (int, bool) {
+	for _, msg := range msgs {
+		attrs := genlAttrs(msg)
+		if net.HardwareAddr(attrs[nl80211AttrMAC]).String() != bssid {
+			continue
+		}
+		info := parseNetlinkAttrs(attrs[nl80211AttrStaInfo])
+		if v := info[nl80211StaInfoSignal]; len(v) >= 1 {
+			return int(int8(v[0])), true
+		}
+	}
+	return 0, false
+}
+
+func wifiSSIDFromIEs(ies []byte) string {
+	for len(ies) >= 2 {
+		id, n := ies[0], int(ies[1])
+		if len(ies) < 2+n {
+			break
+		}
+		if id == 0 {
+			return string(ies[2 : 2+n])
+		}
+		ies = ies[2+n:]
+	}
+	return ""
+}
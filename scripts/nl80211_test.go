@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The fixtures are NL80211_CMD_GET_INTERFACE, GET_SCAN and GET_STATION dumps
+// as read from the socket, NLMSG_DONE included.
+func readNetlinkDump(t *testing.T, name string) []byte {
+	t.Helper()
+	buf, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func parseNetlinkDump(t *testing.T, buf []byte) []netlinkMessage {
+	t.Helper()
+	msgs, done, err := parseNetlinkMessages(buf)
+	if err != nil || !done {
+		t.Fatalf("parseNetlinkMessages: done %v, err %v", done, err)
+	}
+	return msgs
+}
+
+func TestNL80211Dumps(t *testing.T) {
+	ifaces := parseNL80211Interfaces(parseNetlinkDump(t, readNetlinkDump(t, "nl80211_interface.bin")))
+	// the P2P device has no ifindex and is skipped
+	if len(ifaces) != 1 {
+		t.Fatalf("got %d interfaces, want 1: %+v", len(ifaces), ifaces)
+	}
+	iface := ifaces[0]
+	if iface.Index != 3 || iface.Name != "wlp2s0" || iface.Type != nl80211IftypeStation || iface.SSID != "CorpNet" || iface.Frequency != 5180 {
+		t.Fatalf("interface = %+v", iface)
+	}
+
+	a, ok := parseNL80211Association(iface, parseNetlinkDump(t, readNetlinkDump(t, "nl80211_scan.bin")))
+	if !ok {
+		t.Fatal("no associated BSS in scan dump")
+	}
+	want := wifiAssociation{
+		Interface:    "wlp2s0",
+		SSID:         "CorpNet",
+		BSSID:        "00:1a:2b:3c:4d:5e",
+		FrequencyMHz: 5180,
+		Channel:      36,
+		SignalDBM:    -54,
+		Security:     WIFI_SECURITY_WPA3_PERSONAL, // PSK and SAE advertised: strongest wins
+	}
+	if a != want {
+		t.Fatalf("association = %+v, want %+v", a, want)
+	}
+
+	stations := parseNetlinkDump(t, readNetlinkDump(t, "nl80211_station.bin"))
+	if dbm, ok := parseNL80211StationSignal(stations, a.BSSID); !ok || dbm != -52 {
+		t.Fatalf("station signal = %d, %v; want -52", dbm, ok)
+	}
+	if _, ok := parseNL80211StationSignal(stations, "00:1a:2b:3c:4d:5f"); ok {
+		t.Fatal("station signal found for a BSSID that is not in the dump")
+	}
+}
+
+func TestNL80211SSIDFromIEs(t *testing.T) {
+	// hidden SSID in GET_INTERFACE: the name comes from the beacon IEs
+	iface := nl80211Interface{Index: 3, Name: "wlp2s0"}
+	a, ok := parseNL80211Association(iface, parseNetlinkDump(t, readNetlinkDump(t, "nl80211_scan.bin")))
+	if !ok || a.SSID != "CorpNet" {
+		t.Fatalf("association = %+v, %v; want SSID CorpNet", a, ok)
+	}
+}
+
+func TestNetlinkMalformed(t *testing.T) {
+	scan := readNetlinkDump(t, "nl80211_scan.bin")
+	first := int(binary.LittleEndian.Uint32(scan[0:4]))
+
+	// buffer cut in the middle of the second message
+	msgs, done, err := parseNetlinkMessages(scan[:first+40])
+	if err == nil || done || len(msgs) != 1 {
+		t.Fatalf("truncated dump: %d messages, done %v, err %v; want 1 message and an error", len(msgs), done, err)
+	}
+
+	// message length shorter than a header
+	short := append([]byte(nil), scan...)
+	binary.LittleEndian.PutUint32(short[0:4], 8)
+	if _, _, err := parseNetlinkMessages(short); err == nil {
+		t.Fatal("undersized nlmsg_len: no error")
+	}
+
+	// last message with an unaligned length and no trailing padding
+	unaligned := make([]byte, nlmsgHeaderLen+1)
+	binary.LittleEndian.PutUint32(unaligned[0:4], uint32(len(unaligned)))
+	binary.LittleEndian.PutUint16(unaligned[4:6], 0x1c)
+	if msgs, done, err := parseNetlinkMessages(unaligned); err != nil || done || len(msgs) != 1 || len(msgs[0].Payload) != 1 {
+		t.Fatalf("unaligned last message: %d messages, done %v, err %v; want 1 message", len(msgs), done, err)
+	}
+
+	// NLMSG_ERROR carrying -EBUSY, as returned while a scan is in progress
+	errMsg := make([]byte, 36)
+	binary.LittleEndian.PutUint32(errMsg[0:4], 36)
+	binary.LittleEndian.PutUint16(errMsg[4:6], nlmsgTypeError)
+	binary.LittleEndian.PutUint32(errMsg[16:20], uint32(0xfffffff0)) // -16
+	if _, done, err := parseNetlinkMessages(errMsg); !done || err == nil || !strings.Contains(err.Error(), "16") {
+		t.Fatalf("NLMSG_ERROR: done %v, err %v; want error 16", done, err)
+	}
+
+	// a message without the genl header has no attributes
+	if attrs := genlAttrs(netlinkMessage{Payload: []byte{34, 1}}); attrs != nil {
+		t.Fatalf("genlAttrs of a 2-byte payload = %v", attrs)
+	}
+}
+
+func TestNetlinkMalformedAttributes(t *testing.T) {
+	iface := nl80211Interface{Index: 3, Name: "wlp2s0", SSID: "CorpNet"}
+	msgs := parseNetlinkDump(t, readNetlinkDump(t, "nl80211_scan.bin"))
+	assoc := msgs[1]
+
+	// locate the BSSID attribute inside the nested BSS of the associated result
+	bssOffset := -1
+	attrs := assoc.Payload[genlHeaderLen:]
+	for off := 0; off+4 <= len(attrs); {
+		l := int(binary.LittleEndian.Uint16(attrs[off:]))
+		if binary.LittleEndian.Uint16(attrs[off+2:])&nlaTypeMask == nl80211AttrBSS {
+			bssOffset = genlHeaderLen + off + 4
+			break
+		}
+		off += nlAlign(l)
+	}
+	if bssOffset < 0 {
+		t.Fatal("fixture has no BSS attribute")
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(p []byte) []byte
+		ok     bool
+	}{
+		{"intact", func(p []byte) []byte { return p }, true},
+		{"bssid shortened to 5 bytes", func(p []byte) []byte {
+			binary.LittleEndian.PutUint16(p[bssOffset:], 9)
+			return p
+		}, false},
+		{"bssid length past the end", func(p []byte) []byte {
+			binary.LittleEndian.PutUint16(p[bssOffset:], 0xfff0)
+			return p
+		}, false},
+		{"bss cut after the bssid", func(p []byte) []byte { return p[:bssOffset+12] }, false},
+		{"attribute length below header size", func(p []byte) []byte {
+			binary.LittleEndian.PutUint16(p[bssOffset:], 2)
+			return p
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := assoc
+			msg.Payload = tt.mutate(append([]byte(nil), assoc.Payload...))
+			if _, ok := parseNL80211Association(iface, []netlinkMessage{msg}); ok != tt.ok {
+				t.Fatalf("associated = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+
+	// truncated station info: the signal attribute is empty
+	station := parseNetlinkDump(t, readNetlinkDump(t, "nl80211_station.bin"))[0]
+	payload := append([]byte(nil), station.Payload...)
+	info := parseNetlinkAttrs(payload[genlHeaderLen:])[nl80211AttrStaInfo]
+	inner := parseNetlinkAttrs(info)
+	if len(inner[nl80211StaInfoSignal]) == 0 {
+		t.Fatal("fixture has no signal")
+	}
+	cut := netlinkMessage{Payload: encodeNetlinkAttr(encodeNetlinkAttr(make([]byte, genlHeaderLen), nl80211AttrMAC, []byte{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}),
+		nl80211AttrStaInfo, encodeNetlinkAttr(nil, nl80211StaInfoSignal, nil))}
+	if _, ok := parseNL80211StationSignal([]netlinkMessage{cut}, "00:1a:2b:3c:4d:5e"); ok {
+		t.Fatal("empty signal attribute reported a signal")
+	}
+}
+
+func TestWifiSecurityFromIEs(t *testing.T) {
+	rsn := func(akms ...byte) []byte {
+		body := []byte{1, 0, 0x00, 0x0f, 0xac, 4, 1, 0, 0x00, 0x0f, 0xac, 4, byte(len(akms)), 0}
+		for _, a := range akms {
+			body = append(body, 0x00, 0x0f, 0xac, a)
+		}
+		return append([]byte{48, byte(len(body))}, body...)
+	}
+	tests := []struct {
+		name    string
+		ies     []byte
+		privacy bool
+		want    string
+	}{
+		{"open", []byte{0, 3, 'a', 'b', 'c'}, false, WIFI_SECURITY_OPEN},
+		{"wep", nil, true, WIFI_SECURITY_WEP},
+		{"wpa vendor ie", []byte{221, 6, 0x00, 0x50, 0xf2, 1, 1, 0}, true, WIFI_SECURITY_WPA},
+		{"wpa2 psk", rsn(2), true, WIFI_SECURITY_WPA2_PERSONAL},
+		{"wpa2 enterprise", rsn(1), true, WIFI_SECURITY_WPA2_ENTERPRISE},
+		{"wpa3 transition", rsn(2, 8), true, WIFI_SECURITY_WPA3_PERSONAL},
+		{"suite b", rsn(12), true, WIFI_SECURITY_WPA3_ENTERPRISE},
+		{"owe", rsn(18), false, WIFI_SECURITY_OWE},
+		{"rsn too short", []byte{48, 2, 1, 0}, true, WIFI_SECURITY_WPA2_PERSONAL},
+		{"rsn pairwise count past the end", []byte{48, 8, 1, 0, 0x00, 0x0f, 0xac, 4, 0xff, 0xff}, true, WIFI_SECURITY_WPA2_PERSONAL},
+		{"ie length past the end", []byte{48, 200, 1, 0}, true, WIFI_SECURITY_WEP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wifiSecurityFromIEs(tt.ies, tt.privacy); got != tt.want {
+				t.Errorf("wifiSecurityFromIEs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
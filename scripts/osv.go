@@ -0,0 +1,528 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// OSV feed loading and matching (https://ossf.github.io/osv-schema/). A
+// feed is a JSON file holding one record or an array of records, a
+// directory of such files, or a zip export as published per ecosystem on
+// osv.dev (all.zip). Only the records naming an installed package are kept
+// in memory.
+
+type osvRecord struct {
+	ID               string                 `json:"id"`
+	Aliases          []string               `json:"aliases"`
+	Upstream         []string               `json:"upstream"`
+	Summary          string                 `json:"summary"`
+	Details          string                 `json:"details"`
+	Published        string                 `json:"published"`
+	Modified         string                 `json:"modified"`
+	Withdrawn        string                 `json:"withdrawn"`
+	Severity         []osvSeverity          `json:"severity"`
+	Affected         []osvAffected          `json:"affected"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+	References       []struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	} `json:"references"`
+}
+
+type osvSeverity struct {
+	Type  string `json:"type"` // CVSS_V3, CVSS_V4, Ubuntu, ...
+	Score string `json:"score"`
+}
+
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Severity          []osvSeverity          `json:"severity"`
+	Ranges            []osvRange             `json:"ranges"`
+	Versions          []string               `json:"versions"`
+	EcosystemSpecific map[string]interface{} `json:"ecosystem_specific"`
+	DatabaseSpecific  map[string]interface{} `json:"database_specific"`
+}
+
+type osvRange struct {
+	Type   string     `json:"type"` // ECOSYSTEM, SEMVER, GIT
+	Events []osvEvent `json:"events"`
+}
+
+type osvEvent struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// osvFeed indexes the affected entries of the kept records by lowercase
+// package name.
+type osvFeed struct {
+	records int
+	byName  map[string][]osvCandidate
+}
+
+type osvCandidate struct {
+	record   *osvRecord
+	affected *osvAffected
+}
+
+// osvFinding is one vulnerable installed package.
+type osvFinding struct {
+	pkg      softwarePackage
+	record   *osvRecord
+	affected *osvAffected
+	fixed    string // first fixed version above the installed one, if known
+}
+
+// osvEcosystem is the OS release the host's dpkg/rpm packages belong to.
+type osvEcosystem struct {
+	name    string // Debian, Ubuntu, Red Hat, AlmaLinux, ...
+	version string // VERSION_ID
+}
+
+// OS ecosystems and how their package versions order
+var (
+	osvDpkgEcosystems = map[string]bool{"Debian": true, "Ubuntu": true}
+	osvRpmEcosystems  = map[string]bool{
+		"Red Hat": true, "AlmaLinux": true, "Rocky Linux": true, "openSUSE": true,
+		"SUSE": true, "Mageia": true, "Azure Linux": true, "Photon OS": true, "openEuler": true,
+	}
+	// os-release ID (or ID_LIKE) to OSV ecosystem
+	osvReleaseEcosystems = map[string]string{
+		"debian": "Debian", "ubuntu": "Ubuntu", "rhel": "Red Hat", "centos": "Red Hat",
+		"almalinux": "AlmaLinux", "rocky": "Rocky Linux", "opensuse-leap": "openSUSE",
+		"opensuse-tumbleweed": "openSUSE", "sles": "SUSE", "mageia": "Mageia",
+		"azurelinux": "Azure Linux", "mariner": "Azure Linux", "photon": "Photon OS", "openEuler": "openEuler",
+	}
+)
+
+// loadOSVFeed reads a feed, keeping the affected entries whose package
+// name keep accepts (lowercase).
+func loadOSVFeed(path string, keep func(name string) bool) (*osvFeed, error) {
+	feed := &osvFeed{byName: make(map[string][]osvCandidate)}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(p, ".json") {
+				return nil
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return nil
+			}
+			defer f.Close()
+			if err := feed.decode(f, keep); err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+			return nil
+		})
+		return feed, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if n, _ := io.ReadFull(f, magic); n == 4 && bytes.Equal(magic, []byte("PK\x03\x04")) {
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range zr.File {
+			if !strings.HasSuffix(entry.Name, ".json") {
+				continue
+			}
+			rc, err := entry.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = feed.decode(rc, keep)
+			rc.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", entry.Name, err)
+			}
+		}
+		return feed, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return feed, feed.decode(f, keep)
+}
+
+// decode reads one record or a stream of them from an array, without
+// holding the whole array in memory.
+func (feed *osvFeed) decode(r io.Reader, keep func(string) bool) error {
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte("\xEF\xBB\xBF")) {
+		br.Discard(3)
+	}
+	for {
+		c, err := br.Peek(1)
+		if err != nil {
+			return nil // empty file
+		}
+		if c[0] != ' ' && c[0] != '\t' && c[0] != '\r' && c[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+	dec := json.NewDecoder(br)
+	if c, _ := br.Peek(1); c[0] != '[' {
+		var rec osvRecord
+		if err := dec.Decode(&rec); err != nil {
+			return err
+		}
+		feed.add(&rec, keep)
+		return nil
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		rec := new(osvRecord)
+		if err := dec.Decode(rec); err != nil {
+			return err
+		}
+		feed.add(rec, keep)
+	}
+	return nil
+}
+
+func (feed *osvFeed) add(rec *osvRecord, keep func(string) bool) {
+	if rec.Withdrawn != "" {
+		return
+	}
+	kept := false
+	for i := range rec.Affected {
+		name := strings.ToLower(rec.Affected[i].Package.Name)
+		if !keep(name) {
+			continue
+		}
+		feed.byName[name] = append(feed.byName[name], osvCandidate{record: rec, affected: &rec.Affected[i]})
+		kept = true
+	}
+	if kept {
+		feed.records++
+	}
+}
+
+// match returns the vulnerable packages, one finding per package and record.
+func (feed *osvFeed) match(pkgs []softwarePackage, host []osvEcosystem) []osvFinding {
+	var findings []osvFinding
+	for _, pkg := range pkgs {
+		seen := make(map[string]bool)
+		for _, name := range []string{pkg.Name, pkg.SourcePackage} {
+			if name == "" {
+				continue
+			}
+			for _, c := range feed.byName[strings.ToLower(name)] {
+				if seen[c.record.ID] || !strings.EqualFold(c.affected.Package.Name, name) ||
+					!osvEcosystemMatches(c.affected.Package.Ecosystem, pkg, host) {
+					continue
+				}
+				if affected, fixed := osvAffects(c.affected, pkg.Version); affected {
+					seen[c.record.ID] = true
+					findings = append(findings, osvFinding{pkg: pkg, record: c.record, affected: c.affected, fixed: fixed})
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// osvEcosystemMatches tells whether a feed ecosystem ("Debian:12",
+// "Ubuntu:22.04:LTS", "Red Hat:enterprise_linux:9::appstream") covers a
+// package. dpkg and rpm packages belong to the host's release; packages of
+// other sources (registry, snap, flatpak) match locally authored records
+// using the source name as ecosystem.
+func osvEcosystemMatches(ecosystem string, pkg softwarePackage, host []osvEcosystem) bool {
+	name, release, _ := strings.Cut(ecosystem, ":")
+	if pkg.Source != "dpkg" && pkg.Source != "rpm" {
+		return strings.EqualFold(name, pkg.Source)
+	}
+	if (pkg.Source == "dpkg") != osvDpkgEcosystems[name] || (pkg.Source == "rpm") != osvRpmEcosystems[name] {
+		return false
+	}
+	for _, h := range host {
+		if h.name != name {
+			continue
+		}
+		if release == "" {
+			return true
+		}
+		major, _, _ := strings.Cut(h.version, ".")
+		for _, part := range strings.Split(release, ":") {
+			if part == h.version || part == major {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// osvComparator returns the version ordering of a range, nil for GIT
+// ranges (commit hashes cannot be compared with installed versions).
+func osvComparator(rangeType, ecosystem string) func(a, b string) int {
+	name, _, _ := strings.Cut(ecosystem, ":")
+	switch {
+	case rangeType == "GIT":
+		return nil
+	case rangeType == "SEMVER":
+		return compareSemver
+	case osvDpkgEcosystems[name]:
+		return compareDpkgVersions
+	case osvRpmEcosystems[name]:
+		return compareRpmVersions
+	}
+	return compareSemver
+}
+
+// osvAffects evaluates the explicit versions and the ranges of an affected
+// entry against an installed version.
+func osvAffects(aff *osvAffected, version string) (bool, string) {
+	for _, v := range aff.Versions {
+		if v == version {
+			return true, ""
+		}
+	}
+	for _, r := range aff.Ranges {
+		cmp := osvComparator(r.Type, aff.Package.Ecosystem)
+		if cmp == nil {
+			continue
+		}
+		if affected, fixed := osvRangeAffects(r.Events, version, cmp); affected {
+			return true, fixed
+		}
+	}
+	return false, ""
+}
+
+// osvRangeAffects walks the events in version order up to the installed
+// version: the last introduced/fixed/last_affected event passed decides.
+func osvRangeAffects(events []osvEvent, version string, cmp func(a, b string) int) (bool, string) {
+	boundary := func(e osvEvent) string {
+		return e.Introduced + e.Fixed + e.LastAffected + e.Limit
+	}
+	sorted := append([]osvEvent(nil), events...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Introduced == "0" {
+			return sorted[j].Introduced != "0"
+		}
+		if sorted[j].Introduced == "0" {
+			return false
+		}
+		return cmp(boundary(sorted[i]), boundary(sorted[j])) < 0
+	})
+
+	affected := false
+	for _, e := range sorted {
+		switch {
+		case e.Introduced != "":
+			if e.Introduced != "0" && cmp(version, e.Introduced) < 0 {
+				return affected, ""
+			}
+			affected = true
+		case e.Fixed != "":
+			if cmp(version, e.Fixed) < 0 {
+				if affected {
+					return true, e.Fixed
+				}
+				return false, ""
+			}
+			affected = false
+		case e.LastAffected != "":
+			if cmp(version, e.LastAffected) <= 0 {
+				return affected, ""
+			}
+			affected = false
+		case e.Limit != "" && e.Limit != "*":
+			if cmp(version, e.Limit) >= 0 {
+				return false, ""
+			}
+			return affected, ""
+		}
+	}
+	return affected, ""
+}
+
+// hostEcosystems reads the OS release from /etc/os-release; empty where
+// the file does not exist (Windows, macOS).
+func hostEcosystems() []osvEcosystem {
+	var id, idLike, version string
+	for _, line := range configLines("/etc/os-release") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			id = value
+		case "ID_LIKE":
+			idLike = value
+		case "VERSION_ID":
+			version = value
+		}
+	}
+	var host []osvEcosystem
+	for _, candidate := range append([]string{id}, strings.Fields(idLike)...) {
+		if name, ok := osvReleaseEcosystems[candidate]; ok {
+			host = append(host, osvEcosystem{name: name, version: version})
+		}
+	}
+	return host
+}
+
+// cveIDs returns the CVE identifiers of a record.
+func (rec *osvRecord) cveIDs() []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, id := range append(append([]string{rec.ID}, rec.Aliases...), rec.Upstream...) {
+		if strings.HasPrefix(id, "CVE-") && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// severity rates a finding from its CVSS v3 vector when there is one,
+// otherwise from the textual severity the database assigns.
+func (f osvFinding) severity() (level string, score float64, vector string) {
+	for _, list := range [][]osvSeverity{f.affected.Severity, f.record.Severity} {
+		for _, s := range list {
+			if strings.HasPrefix(s.Type, "CVSS_V3") {
+				if v, ok := cvss3BaseScore(s.Score); ok {
+					return cvssRating(v), v, s.Score
+				}
+			}
+		}
+	}
+	texts := []interface{}{
+		f.affected.EcosystemSpecific["severity"], f.affected.DatabaseSpecific["severity"],
+		f.record.DatabaseSpecific["severity"], f.affected.EcosystemSpecific["urgency"],
+	}
+	for _, list := range [][]osvSeverity{f.affected.Severity, f.record.Severity} {
+		for _, s := range list {
+			texts = append(texts, s.Score)
+		}
+	}
+	for _, t := range texts {
+		text, _ := t.(string)
+		switch strings.ToLower(strings.TrimSpace(text)) {
+		case "critical":
+			return "critical", 0, ""
+		case "high", "important":
+			return "high", 0, ""
+		case "medium", "moderate":
+			return "warning", 0, ""
+		case "low", "negligible", "unimportant":
+			return "info", 0, ""
+		}
+	}
+	return "warning", 0, ""
+}
+
+func cvssRating(score float64) string {
+	switch {
+	case score >= 9:
+		return "critical"
+	case score >= 7:
+		return "high"
+	case score >= 4:
+		return "warning"
+	}
+	return "info"
+}
+
+// cvss3BaseScore computes the CVSS v3.x base score of a vector string
+// (CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H).
+func cvss3BaseScore(vector string) (float64, bool) {
+	metrics := make(map[string]string)
+	for _, part := range strings.Split(vector, "/") {
+		if k, v, ok := strings.Cut(part, ":"); ok {
+			metrics[k] = v
+		}
+	}
+	weights := map[string]map[string]float64{
+		"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+		"AC": {"L": 0.77, "H": 0.44},
+		"UI": {"N": 0.85, "R": 0.62},
+		"C":  {"H": 0.56, "L": 0.22, "N": 0},
+		"I":  {"H": 0.56, "L": 0.22, "N": 0},
+		"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	}
+	value := make(map[string]float64)
+	for metric, table := range weights {
+		w, ok := table[metrics[metric]]
+		if !ok {
+			return 0, false
+		}
+		value[metric] = w
+	}
+	changed := metrics["S"] == "C"
+	if !changed && metrics["S"] != "U" {
+		return 0, false
+	}
+	switch metrics["PR"] {
+	case "N":
+		value["PR"] = 0.85
+	case "L":
+		value["PR"] = 0.62
+		if changed {
+			value["PR"] = 0.68
+		}
+	case "H":
+		value["PR"] = 0.27
+		if changed {
+			value["PR"] = 0.5
+		}
+	default:
+		return 0, false
+	}
+
+	iss := 1 - (1-value["C"])*(1-value["I"])*(1-value["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	exploitability := 8.22 * value["AV"] * value["AC"] * value["PR"] * value["UI"]
+	if impact <= 0 {
+		return 0, true
+	}
+	if changed {
+		return cvssRoundUp(math.Min(1.08*(impact+exploitability), 10)), true
+	}
+	return cvssRoundUp(math.Min(impact+exploitability, 10)), true
+}
+
+// cvssRoundUp is the CVSS v3.1 Roundup: the smallest one-decimal number
+// not below x, computed on integers to avoid floating point artifacts.
+func cvssRoundUp(x float64) float64 {
+	i := int64(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return float64(i/10000+1) / 10
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCompareDpkgVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~", "1.0", -1},
+		{"1.0", "1.0a", -1},
+		{"1.0+dfsg", "1.0", 1},
+		{"1:1.0", "2.0", 1},
+		{"0010", "10", 0},
+		{"1.2.3-1", "1.2.3-1ubuntu1", -1},
+		{"2.30-0ubuntu2", "2.30-0ubuntu10", -1},
+		{"7.4.052-1ubuntu3.1", "7.4.052-1ubuntu3", 1},
+		{"3.0.2-0ubuntu1.10", "3.0.2-0ubuntu1.9", 1},
+		{"1.0-1", "1.0", 1},
+	}
+	for _, tt := range tests {
+		if got := compareDpkgVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareDpkgVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareDpkgVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareDpkgVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestCompareRpmVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.10", "2.0", -1},
+		{"1.0a", "1.0", 1},
+		{"001", "1", 0},
+		{"a", "1", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0^git1", "1.0.1", -1},
+		{"1.0^git1", "1.0^git2", -1},
+		{"1:1.0-1", "2.0-1", 1},
+		{"1.0-2.el9", "1.0-10.el9", -1},
+		{"5.14.0-362.8.1.el9_3", "5.14.0-362.13.1.el9_3", -1},
+		{"1.0", "1.0-5", 0}, // a boundary without release matches every release
+	}
+	for _, tt := range tests {
+		if got := compareRpmVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareRpmVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareRpmVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareRpmVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "2.0.0", -1},
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"v1.2", "1.2.0", 0},
+		{"1.0.0+build.5", "1.0.0", 0},
+		{"10.0", "9.9.9", 1},
+		{"120.0.6099.71", "120.0.6099.109", -1},
+	}
+	for _, tt := range tests {
+		if got := compareSemver(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareSemver(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareSemver(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareSemver(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestOSVRangeAffects(t *testing.T) {
+	events := []osvEvent{{Introduced: "0"}, {Fixed: "1.2.0"}, {Introduced: "2.0.0"}, {LastAffected: "2.1.0"}}
+	tests := []struct {
+		version  string
+		affected bool
+		fixed    string
+	}{
+		{"1.0.0", true, "1.2.0"},
+		{"1.2.0", false, ""},
+		{"1.5.0", false, ""},
+		{"2.0.0", true, ""},
+		{"2.1.0", true, ""},
+		{"2.1.1", false, ""},
+	}
+	for _, tt := range tests {
+		affected, fixed := osvRangeAffects(events, tt.version, compareSemver)
+		if affected != tt.affected || fixed != tt.fixed {
+			t.Errorf("%s: got %v %q, want %v %q", tt.version, affected, fixed, tt.affected, tt.fixed)
+		}
+	}
+}
+
+func TestCVSS3BaseScore(t *testing.T) {
+	tests := []struct {
+		vector string
+		score  float64
+		rating string
+	}{
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8, "critical"},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H", 10.0, "critical"},
+		{"CVSS:3.1/AV:N/AC:L/PR:L/UI:N/S:C/C:H/I:H/A:H", 9.9, "critical"},
+		{"CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H", 7.8, "high"},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", 6.1, "warning"},
+		{"CVSS:3.1/AV:A/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:H", 6.5, "warning"},
+		{"CVSS:3.1/AV:N/AC:H/PR:N/UI:N/S:U/C:H/I:N/A:N", 5.9, "warning"},
+		{"CVSS:3.1/AV:L/AC:H/PR:H/UI:R/S:U/C:L/I:N/A:N", 1.8, "info"},
+		{"CVSS:3.0/AV:P/AC:H/PR:H/UI:R/S:U/C:N/I:N/A:N", 0, "info"},
+	}
+	for _, tt := range tests {
+		score, ok := cvss3BaseScore(tt.vector)
+		if !ok || score != tt.score {
+			t.Errorf("%s: got %v %v, want %v", tt.vector, score, ok, tt.score)
+		}
+		if rating := cvssRating(score); rating != tt.rating {
+			t.Errorf("%s: rating %s, want %s", tt.vector, rating, tt.rating)
+		}
+	}
+
+	for _, vector := range []string{
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H",     // A missing
+		"CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", // unknown value
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:X/C:H/I:H/A:H",
+		"AV:N/AC:L/PR:Q/UI:N/S:U/C:H/I:H/A:H",
+		"",
+	} {
+		if _, ok := cvss3BaseScore(vector); ok {
+			t.Errorf("%q accepted", vector)
+		}
+	}
+
+	for _, tt := range []struct{ in, want float64 }{{4.0, 4.0}, {4.02, 4.1}, {4.000001, 4.0}, {9.91, 10.0}} {
+		if got := cvssRoundUp(tt.in); got != tt.want {
+			t.Errorf("cvssRoundUp(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
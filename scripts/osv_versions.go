@@ -0,0 +1,290 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Version ordering for OSV range evaluation: dpkg (Debian policy 5.6.12),
+// rpm (rpmvercmp, including ~ and ^) and semver 2.0. The semver comparison
+// is lenient (missing components are 0, a leading "v" is ignored) so it
+// also orders the free-form versions of Windows installers.
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isAlpha(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+
+// compareDpkgVersions orders [epoch:]upstream[-revision].
+func compareDpkgVersions(a, b string) int {
+	ea, ua, ra := splitDpkgVersion(a)
+	eb, ub, rb := splitDpkgVersion(b)
+	if ea != eb {
+		return sign(ea - eb)
+	}
+	if c := dpkgVerrevcmp(ua, ub); c != 0 {
+		return c
+	}
+	return dpkgVerrevcmp(ra, rb)
+}
+
+func splitDpkgVersion(v string) (epoch int, upstream, revision string) {
+	v = strings.TrimSpace(v)
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		epoch, _ = strconv.Atoi(v[:i])
+		v = v[i+1:]
+	}
+	if i := strings.LastIndexByte(v, '-'); i >= 0 {
+		return epoch, v[:i], v[i+1:]
+	}
+	return epoch, v, ""
+}
+
+// dpkgOrder: "~" sorts before everything, even the end of the string;
+// letters sort before non-letters.
+func dpkgOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case isDigit(c):
+		return 0
+	case isAlpha(c):
+		return int(c)
+	case c == '~':
+		return -1
+	default:
+		return int(c) + 256
+	}
+}
+
+func dpkgVerrevcmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		firstDiff := 0
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			ac, bc := dpkgOrder(a, i), dpkgOrder(b, j)
+			if ac != bc {
+				return sign(ac - bc)
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return sign(firstDiff)
+		}
+	}
+	return 0
+}
+
+// compareRpmVersions orders [epoch:]version[-release].
+func compareRpmVersions(a, b string) int {
+	ea, va, ra := splitRpmVersion(a)
+	eb, vb, rb := splitRpmVersion(b)
+	if ea != eb {
+		return sign(ea - eb)
+	}
+	if c := rpmvercmp(va, vb); c != 0 {
+		return c
+	}
+	// a range boundary without a release matches every release
+	if ra == "" || rb == "" {
+		return 0
+	}
+	return rpmvercmp(ra, rb)
+}
+
+func splitRpmVersion(v string) (epoch int, version, release string) {
+	v = strings.TrimSpace(v)
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		epoch, _ = strconv.Atoi(v[:i])
+		v = v[i+1:]
+	}
+	if i := strings.LastIndexByte(v, '-'); i >= 0 {
+		return epoch, v[:i], v[i+1:]
+	}
+	return epoch, v, ""
+}
+
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	isSep := func(c byte) bool { return !isDigit(c) && !isAlpha(c) && c != '~' && c != '^' }
+	for len(a) > 0 || len(b) > 0 {
+		for len(a) > 0 && isSep(a[0]) {
+			a = a[1:]
+		}
+		for len(b) > 0 && isSep(b[0]) {
+			b = b[1:]
+		}
+		// "~" sorts before everything else
+		if (len(a) > 0 && a[0] == '~') || (len(b) > 0 && b[0] == '~') {
+			if len(a) == 0 || a[0] != '~' {
+				return 1
+			}
+			if len(b) == 0 || b[0] != '~' {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		// "^" sorts after the end of the string but before anything else
+		if (len(a) > 0 && a[0] == '^') || (len(b) > 0 && b[0] == '^') {
+			if len(a) == 0 {
+				return -1
+			}
+			if len(b) == 0 {
+				return 1
+			}
+			if a[0] != '^' {
+				return 1
+			}
+			if b[0] != '^' {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if len(a) == 0 || len(b) == 0 {
+			break
+		}
+
+		numeric := isDigit(a[0])
+		class := isAlpha
+		if numeric {
+			class = isDigit
+		}
+		n := 0
+		for n < len(a) && class(a[n]) {
+			n++
+		}
+		m := 0
+		for m < len(b) && class(b[m]) {
+			m++
+		}
+		segA, segB := a[:n], b[:m]
+		a, b = a[n:], b[m:]
+		if m == 0 {
+			// numeric segments are newer than alphabetic ones
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				return sign(len(segA) - len(segB))
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// compareSemver orders major.minor.patch[-prerelease][+build].
+func compareSemver(a, b string) int {
+	coreA, preA := splitSemver(a)
+	coreB, preB := splitSemver(b)
+	partsA, partsB := strings.Split(coreA, "."), strings.Split(coreB, ".")
+	for i := 0; i < max(len(partsA), len(partsB)); i++ {
+		pa, pb := "0", "0"
+		if i < len(partsA) {
+			pa = partsA[i]
+		}
+		if i < len(partsB) {
+			pb = partsB[i]
+		}
+		if c := compareIdentifier(pa, pb); c != 0 {
+			return c
+		}
+	}
+	// a pre-release sorts before its release
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	}
+	idsA, idsB := strings.Split(preA, "."), strings.Split(preB, ".")
+	for i := 0; i < min(len(idsA), len(idsB)); i++ {
+		if c := compareIdentifier(idsA[i], idsB[i]); c != 0 {
+			return c
+		}
+	}
+	return sign(len(idsA) - len(idsB))
+}
+
+func splitSemver(v string) (core, prerelease string) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+// compareIdentifier compares numerically when both are numbers; numbers
+// sort before text.
+func compareIdentifier(a, b string) int {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		if na == nb {
+			return 0
+		}
+		if na < nb {
+			return -1
+		}
+		return 1
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
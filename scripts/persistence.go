@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Persistence inventory. Autostart locations (systemd units, cron, XDG
+// autostart, shell rc files, ld.so.preload; Run keys, services, scheduled
+// tasks and Startup folders on Windows) are enumerated every
+// PERSISTENCE_INTERVAL and diffed against the previous snapshot, persisted
+// under agentDir, so entries added while the agent was stopped are still
+// reported. The first snapshot is sent once as inventory.
+
+const (
+	PERSISTENCE_STATE_FILE      = "persistence.json"
+	PERSISTENCE_INTERVAL        = 10 * time.Minute
+	PERSISTENCE_INVENTORY_BATCH = 200
+)
+
+// Mechanisms malware favours; additions and changes to them are "high",
+// to the rest (services, systemd units, tasks) "warning" since software
+// installs add those routinely.
+var persistenceHighRisk = map[string]bool{
+	"ld_so_preload":  true,
+	"cron":           true,
+	"anacron":        true,
+	"autostart":      true,
+	"shell_rc":       true,
+	"run_key":        true,
+	"winlogon":       true,
+	"startup_folder": true,
+	"launchd":        true,
+}
+
+// persistenceItem is one autostart entry.
+type persistenceItem struct {
+	Mechanism string `json:"mechanism"` // systemd_service, cron, run_key, scheduled_task, ...
+	Location  string `json:"location"`  // file, directory or registry key holding the entry
+	Name      string `json:"name"`
+	Command   string `json:"command,omitempty"`
+	User      string `json:"user,omitempty"`
+	Trigger   string `json:"trigger,omitempty"` // schedule, wants target, start mode, Run/RunOnce
+	Image     string `json:"image,omitempty"`   // executable resolved from Command
+	SHA256    string `json:"sha256,omitempty"`  // of Image, or of the file itself for rc files and scripts
+}
+
+func (it persistenceItem) key() string {
+	return it.Mechanism + "|" + it.Location + "|" + it.Name
+}
+
+// runPersistenceMonitor reports the persistence entries added, removed or
+// changed since the previous snapshot.
+func runPersistenceMonitor() {
+	for {
+		policyMutex.RLock()
+		quarantined := isQuarantined
+		policyMutex.RUnlock()
+		// The snapshot only advances when the changes can be reported
+		if deviceID != "" && !quarantined {
+			scanPersistence()
+		}
+		time.Sleep(PERSISTENCE_INTERVAL)
+	}
+}
+
+func scanPersistence() {
+	items, failed := collectPersistence()
+	for i := range items {
+		items[i].resolve()
+	}
+
+	previous, ok := loadPersistenceState()
+	if !ok {
+		// Without a baseline the inventory is sent again next scan
+		if err := sendPersistenceInventory(items); err != nil {
+			return
+		}
+		savePersistenceState(items)
+		logMessage(fmt.Sprintf("Persistence baseline created: %d entries", len(items)))
+		return
+	}
+	reportPersistence(previous, items, failed)
+}
+
+// persistenceChange is one entry added (before nil), removed (after nil) or
+// modified between two snapshots.
+type persistenceChange struct {
+	event         string
+	before, after *persistenceItem
+}
+
+// persistenceChanges diffs the entries found against the previous snapshot.
+// A mechanism that could not be enumerated keeps its previous entries.
+func persistenceChanges(previous map[string]persistenceItem, items []persistenceItem, failed map[string]bool) []persistenceChange {
+	current := make(map[string]persistenceItem, len(items))
+	for _, it := range items {
+		current[it.key()] = it
+	}
+	for key, it := range previous {
+		if failed[it.Mechanism] {
+			current[key] = it
+		}
+	}
+
+	var changes []persistenceChange
+	for key, it := range current {
+		before, existed := previous[key]
+		switch {
+		case !existed:
+			changes = append(changes, persistenceChange{"persistence_added", nil, &it})
+		case before != it:
+			changes = append(changes, persistenceChange{"persistence_modified", &before, &it})
+		}
+	}
+	for key, it := range previous {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, persistenceChange{"persistence_removed", &it, nil})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].item().key() < changes[j].item().key() })
+	return changes
+}
+
+func (c persistenceChange) item() *persistenceItem {
+	if c.after != nil {
+		return c.after
+	}
+	return c.before
+}
+
+// reportPersistence sends the changes and moves the snapshot forward over
+// the ones delivered; the rest is found and sent again next scan.
+func reportPersistence(previous map[string]persistenceItem, items []persistenceItem, failed map[string]bool) {
+	delivered := 0
+	for _, c := range persistenceChanges(previous, items, failed) {
+		if sendLog(persistenceEvent(c.event, c.before, c.after)) != nil {
+			break
+		}
+		if c.after == nil {
+			delete(previous, c.before.key())
+		} else {
+			previous[c.after.key()] = *c.after
+		}
+		delivered++
+	}
+
+	if delivered > 0 {
+		list := make([]persistenceItem, 0, len(previous))
+		for _, it := range previous {
+			list = append(list, it)
+		}
+		savePersistenceState(list)
+	}
+}
+
+func persistenceEvent(event string, before, after *persistenceItem) LogEntry {
+	it := after
+	if it == nil {
+		it = before
+	}
+	severity := "warning"
+	if event == "persistence_removed" {
+		severity = "info"
+	} else if persistenceHighRisk[it.Mechanism] {
+		severity = "high"
+	}
+
+	raw := map[string]interface{}{
+		"mechanism": it.Mechanism,
+		"location":  it.Location,
+		"name":      it.Name,
+	}
+	if before != nil {
+		raw["before"] = before
+	}
+	if after != nil {
+		raw["after"] = after
+	}
+	if before != nil && after != nil {
+		raw["changed_fields"] = before.diff(*after)
+	}
+
+	verb := strings.TrimPrefix(event, "persistence_")
+	msg := fmt.Sprintf("Persistence %s: %s %s in %s", verb, it.Mechanism, it.Name, it.Location)
+	if it.Command != "" && !strings.Contains(it.Name, it.Command) {
+		msg += " (" + it.Command + ")"
+	}
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    "security",
+		Event:      event,
+		Source:     "persistence",
+		Severity:   severity,
+		Message:    msg,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RawData:    raw,
+	}
+}
+
+func (it persistenceItem) diff(other persistenceItem) []string {
+	var changed []string
+	if it.Command != other.Command {
+		changed = append(changed, "command")
+	}
+	if it.User != other.User {
+		changed = append(changed, "user")
+	}
+	if it.Trigger != other.Trigger {
+		changed = append(changed, "trigger")
+	}
+	if it.Image != other.Image {
+		changed = append(changed, "image")
+	}
+	if it.SHA256 != other.SHA256 {
+		changed = append(changed, "sha256")
+	}
+	return changed
+}
+
+func sendPersistenceInventory(items []persistenceItem) error {
+	sort.Slice(items, func(i, j int) bool { return items[i].key() < items[j].key() })
+	for start := 0; start < len(items); start += PERSISTENCE_INVENTORY_BATCH {
+		batch := items[start:min(start+PERSISTENCE_INVENTORY_BATCH, len(items))]
+		err := sendLog(LogEntry{
+			DeviceID:   deviceID,
+			DeviceName: deviceName,
+			Hostname:   getHostname(),
+			LogType:    "inventory",
+			Event:      "persistence_inventory",
+			Source:     "persistence",
+			Severity:   "info",
+			Message:    fmt.Sprintf("Persistence inventory: %d autostart entries", len(batch)),
+			Timestamp:  time.Now().UTC().Format(time.RFC3339),
+			RawData:    map[string]interface{}{"entries": batch, "count": len(batch), "total": len(items)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadPersistenceState() (map[string]persistenceItem, bool) {
+	data, err := os.ReadFile(filepath.Join(agentDir, PERSISTENCE_STATE_FILE))
+	if err != nil {
+		return nil, false
+	}
+	var items []persistenceItem
+	if json.Unmarshal(data, &items) != nil {
+		logMessage("Persistence state unreadable, building a new baseline")
+		return nil, false
+	}
+	state := make(map[string]persistenceItem, len(items))
+	for _, it := range items {
+		state[it.key()] = it
+	}
+	return state, true
+}
+
+func savePersistenceState(items []persistenceItem) {
+	data, _ := json.Marshal(items)
+	saveBookmark(PERSISTENCE_STATE_FILE, string(data))
+}
+
+// resolve fills Image and SHA256 from the command line.
+func (it *persistenceItem) resolve() {
+	if it.Image == "" {
+		it.Image = commandImage(it.Command)
+	}
+	if it.Image != "" && it.SHA256 == "" {
+		if h, ok := hashImage(it.Image); ok {
+			it.SHA256 = h.SHA256
+		}
+	}
+}
+
+var windowsEnvRegex = regexp.MustCompile(`%([^%]+)%`)
+
+// commandImage returns the absolute path of the program a command line
+// starts, or "" when it cannot be told without a shell.
+func commandImage(command string) string {
+	if runtime.GOOS == "windows" {
+		return windowsCommandImage(command, os.Getenv)
+	}
+	return unixCommandImage(command)
+}
+
+func unixCommandImage(command string) string {
+	// systemd ExecStart prefixes: -@:+!
+	cmd := strings.TrimLeft(strings.TrimSpace(command), "-@:+!")
+	if strings.HasPrefix(cmd, `"`) {
+		if end := strings.Index(cmd[1:], `"`); end >= 0 {
+			cmd = cmd[1 : end+1]
+		}
+	} else if f := strings.Fields(cmd); len(f) > 0 {
+		cmd = f[0]
+	}
+	if !strings.HasPrefix(cmd, "/") {
+		return ""
+	}
+	return cmd
+}
+
+// windowsCommandImage expands environment variables with getenv.
+func windowsCommandImage(command string, getenv func(string) string) string {
+	cmd := strings.TrimSpace(command)
+	if cmd == "" {
+		return ""
+	}
+	cmd = windowsEnvRegex.ReplaceAllStringFunc(cmd, func(v string) string {
+		if val := getenv(strings.Trim(v, "%")); val != "" {
+			return val
+		}
+		return v
+	})
+	cmd = strings.TrimPrefix(cmd, `\??\`)
+	systemRoot := getenv("SystemRoot")
+	switch lower := strings.ToLower(cmd); {
+	case strings.HasPrefix(lower, `\systemroot\`):
+		cmd = systemRoot + cmd[len(`\systemroot`):]
+	case strings.HasPrefix(lower, `system32\`), strings.HasPrefix(lower, `syswow64\`):
+		cmd = systemRoot + `\` + cmd
+	}
+	if strings.HasPrefix(cmd, `"`) {
+		if end := strings.Index(cmd[1:], `"`); end >= 0 {
+			cmd = cmd[1 : end+1]
+		}
+	} else if i := strings.Index(strings.ToLower(cmd), ".exe"); i >= 0 {
+		// unquoted paths with spaces: C:\Program Files\App\app.exe -arg
+		cmd = cmd[:i+4]
+	} else if f := strings.Fields(cmd); len(f) > 0 {
+		cmd = f[0]
+	}
+	if len(cmd) < 3 || cmd[1] != ':' {
+		return ""
+	}
+	return cmd
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandImage(t *testing.T) {
+	unix := []struct {
+		command string
+		image   string
+	}{
+		{"/usr/bin/backup --daily", "/usr/bin/backup"},
+		{"-/usr/sbin/sshd -D", "/usr/sbin/sshd"},
+		{"!!/usr/lib/polkit-1/polkitd", "/usr/lib/polkit-1/polkitd"},
+		{"@/bin/sh sh -c 'exit 0'", "/bin/sh"},
+		{"+-/usr/bin/true", "/usr/bin/true"},
+		{`"/opt/My App/run" --now`, "/opt/My App/run"},
+		{"python3 script.py", ""},
+		{"  ", ""},
+	}
+	for _, tt := range unix {
+		if got := unixCommandImage(tt.command); got != tt.image {
+			t.Errorf("unixCommandImage(%q) = %q, want %q", tt.command, got, tt.image)
+		}
+	}
+
+	env := map[string]string{
+		"SystemRoot":   `C:\Windows`,
+		"ProgramFiles": `C:\Program Files`,
+	}
+	getenv := func(name string) string { return env[name] }
+	windows := []struct {
+		command string
+		image   string
+	}{
+		{`"C:\Program Files\Vendor\agent.exe" /background`, `C:\Program Files\Vendor\agent.exe`},
+		{`C:\Program Files\Vendor\App\x.exe -arg`, `C:\Program Files\Vendor\App\x.exe`},
+		{`C:\Program Files\Vendor\App\X.EXE`, `C:\Program Files\Vendor\App\X.EXE`},
+		{`%SystemRoot%\system32\svchost.exe -k netsvcs`, `C:\Windows\system32\svchost.exe`},
+		{`%ProgramFiles%\Tool\tool.exe`, `C:\Program Files\Tool\tool.exe`},
+		{`\SystemRoot\System32\drivers\evil.sys`, `C:\Windows\System32\drivers\evil.sys`},
+		{`system32\DRIVERS\disk.sys`, `C:\Windows\system32\DRIVERS\disk.sys`},
+		{`\??\C:\Windows\System32\drivers\x.sys`, `C:\Windows\System32\drivers\x.sys`},
+		{`%UNSET%\tool.exe`, ""},
+		{`rundll32 shell32.dll,Control_RunDLL`, ""},
+		{"", ""},
+	}
+	for _, tt := range windows {
+		if got := windowsCommandImage(tt.command, getenv); got != tt.image {
+			t.Errorf("windowsCommandImage(%q) = %q, want %q", tt.command, got, tt.image)
+		}
+	}
+}
+
+func TestPersistenceChanges(t *testing.T) {
+	cron := persistenceItem{Mechanism: "cron", Location: "/etc/crontab", Name: "backup", Command: "/usr/bin/backup", Trigger: "0 3 * * *"}
+	unit := persistenceItem{Mechanism: "systemd_service", Location: "/etc/systemd/system/app.service", Name: "app.service", Command: "/opt/app/run"}
+	rc := persistenceItem{Mechanism: "shell_rc", Location: "/root/.bashrc", Name: ".bashrc", SHA256: "aaa"}
+	previous := func() map[string]persistenceItem {
+		return map[string]persistenceItem{cron.key(): cron, unit.key(): unit, rc.key(): rc}
+	}
+	rcChanged := rc
+	rcChanged.SHA256 = "bbb"
+	preload := persistenceItem{Mechanism: "ld_so_preload", Location: "/etc/ld.so.preload", Name: "/tmp/x.so"}
+
+	tests := []struct {
+		name   string
+		items  []persistenceItem
+		failed map[string]bool
+		want   []string // event|name, in key order
+	}{
+		{"unchanged", []persistenceItem{cron, unit, rc}, nil, nil},
+		{"added", []persistenceItem{cron, unit, rc, preload}, nil, []string{"persistence_added|/tmp/x.so"}},
+		{"removed", []persistenceItem{unit, rc}, nil, []string{"persistence_removed|backup"}},
+		{"modified", []persistenceItem{cron, unit, rcChanged}, nil, []string{"persistence_modified|.bashrc"}},
+		{"failed mechanism keeps its entries", []persistenceItem{unit, rc}, map[string]bool{"cron": true}, nil},
+		{
+			"failed mechanism with other changes",
+			[]persistenceItem{rcChanged, preload},
+			map[string]bool{"cron": true},
+			[]string{"persistence_added|/tmp/x.so", "persistence_modified|.bashrc", "persistence_removed|app.service"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, c := range persistenceChanges(previous(), tt.items, tt.failed) {
+				got = append(got, c.event+"|"+c.item().Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPersistenceStateKeepsUndelivered(t *testing.T) {
+	agentDir = t.TempDir()
+	a := persistenceItem{Mechanism: "cron", Location: "/etc/cron.d/a", Name: "a", Command: "/usr/bin/a"}
+	b := persistenceItem{Mechanism: "cron", Location: "/etc/cron.d/b", Name: "b", Command: "/usr/bin/b"}
+	savePersistenceState(nil)
+
+	// Only the first of the two additions reaches the server
+	received := logServer(t, 1)
+	previous, _ := loadPersistenceState()
+	reportPersistence(previous, []persistenceItem{a, b}, nil)
+	if got := received(); len(got) != 1 || got[0].Event != "persistence_added" {
+		t.Fatalf("server received %+v", got)
+	}
+
+	previous, _ = loadPersistenceState()
+	changes := persistenceChanges(previous, []persistenceItem{a, b}, nil)
+	if len(changes) != 1 || changes[0].event != "persistence_added" || changes[0].after.Name != "b" {
+		t.Fatalf("after a failed send: %+v, want b still to add", changes)
+	}
+
+	received = logServer(t, 10)
+	reportPersistence(previous, []persistenceItem{a, b}, nil)
+	previous, _ = loadPersistenceState()
+	if len(received()) != 1 || len(persistenceChanges(previous, []persistenceItem{a, b}, nil)) != 0 {
+		t.Errorf("retry sent %d entries, state has %d", len(received()), len(previous))
+	}
+}
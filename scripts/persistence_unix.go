@@ -0,0 +1,286 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Unix persistence locations. Everything is read from the filesystem, so a
+// location that does not exist simply has no entries and no mechanism is
+// ever reported as failed.
+
+var (
+	systemdUnitDirs = []string{"/etc/systemd/system", "/etc/systemd/user"}
+	systemdUserDirs = []string{"/root/.config/systemd/user", "/home/*/.config/systemd/user"}
+
+	// system crontab format: schedule, user, command
+	systemCrontabs = []string{"/etc/crontab", "/etc/cron.d/*"}
+	// per-user crontabs named after the user
+	userCrontabs = []string{"/var/spool/cron/crontabs/*", "/var/spool/cron/*", "/usr/lib/cron/tabs/*", "/var/at/tabs/*"}
+
+	autostartDirs = []string{"/etc/xdg/autostart", "/root/.config/autostart", "/home/*/.config/autostart"}
+
+	systemShellRC = []string{
+		"/etc/profile", "/etc/profile.d/*", "/etc/bash.bashrc", "/etc/bashrc", "/etc/environment",
+		"/etc/zshrc", "/etc/zprofile", "/etc/zshenv", "/etc/zsh/zshrc", "/etc/zsh/zprofile", "/etc/zsh/zshenv",
+	}
+	userShellRC = []string{".bashrc", ".bash_profile", ".bash_login", ".bash_logout", ".profile", ".zshrc", ".zprofile", ".zshenv", ".zlogin"}
+
+	launchdDirs = []string{"/Library/LaunchDaemons", "/Library/LaunchAgents", "/Users/*/Library/LaunchAgents"}
+)
+
+func collectPersistence() ([]persistenceItem, map[string]bool) {
+	var items []persistenceItem
+	items = append(items, systemdPersistence()...)
+	items = append(items, cronPersistence()...)
+	items = append(items, autostartPersistence()...)
+	items = append(items, shellRCPersistence()...)
+	items = append(items, preloadPersistence()...)
+	items = append(items, launchdPersistence()...)
+	return items, nil
+}
+
+// systemdPersistence lists the admin and user unit files and the units
+// enabled through *.wants links; vendor units under /usr/lib only count
+// once something enables them.
+func systemdPersistence() []persistenceItem {
+	var items []persistenceItem
+	dirs := append([]string{}, systemdUnitDirs...)
+	dirs = append(dirs, globAll(systemdUserDirs)...)
+	for _, dir := range dirs {
+		user := homeUser(dir)
+		for _, ext := range []string{".service", ".timer"} {
+			files, _ := filepath.Glob(filepath.Join(dir, "*"+ext))
+			for _, f := range files {
+				if it, ok := systemdUnit(f, dir, ""); ok {
+					it.User = firstNonEmpty(it.User, user)
+					items = append(items, it)
+				}
+			}
+			links, _ := filepath.Glob(filepath.Join(dir, "*.wants", "*"+ext))
+			for _, l := range links {
+				wants := filepath.Base(filepath.Dir(l))
+				if it, ok := systemdUnit(l, filepath.Dir(l), strings.TrimSuffix(wants, ".wants")); ok {
+					it.User = firstNonEmpty(it.User, user)
+					items = append(items, it)
+				}
+			}
+		}
+	}
+	return items
+}
+
+// systemdUnit reads a unit file (following links); masked units are skipped.
+func systemdUnit(path, location, wantedBy string) (persistenceItem, bool) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil || real == "/dev/null" {
+		return persistenceItem{}, false
+	}
+	it := persistenceItem{Mechanism: "systemd_service", Location: location, Name: filepath.Base(path), Trigger: wantedBy}
+	if strings.HasSuffix(path, ".timer") {
+		it.Mechanism = "systemd_timer"
+	}
+
+	var schedule []string
+	unit := ""
+	f, err := os.Open(real)
+	if err != nil {
+		return it, true
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "ExecStart":
+			if it.Command == "" {
+				it.Command = value
+			}
+		case "User":
+			it.User = value
+		case "OnCalendar", "OnBootSec", "OnStartupSec", "OnActiveSec", "OnUnitActiveSec", "OnUnitInactiveSec":
+			schedule = append(schedule, key+"="+value)
+		case "Unit":
+			unit = value
+		}
+	}
+	if it.Mechanism == "systemd_timer" {
+		if unit == "" {
+			unit = strings.TrimSuffix(it.Name, ".timer") + ".service"
+		}
+		it.Command = unit
+		if len(schedule) > 0 {
+			it.Trigger = strings.Join(schedule, " ")
+		}
+	}
+	return it, true
+}
+
+func cronPersistence() []persistenceItem {
+	var items []persistenceItem
+	for _, f := range globAll(systemCrontabs) {
+		items = append(items, crontabEntries(f, "")...)
+	}
+	for _, f := range globAll(userCrontabs) {
+		items = append(items, crontabEntries(f, filepath.Base(f))...)
+	}
+	for _, period := range []string{"hourly", "daily", "weekly", "monthly"} {
+		scripts, _ := filepath.Glob("/etc/cron." + period + "/*")
+		for _, s := range scripts {
+			if info, err := os.Stat(s); err != nil || !info.Mode().IsRegular() || filepath.Base(s) == ".placeholder" {
+				continue
+			}
+			items = append(items, persistenceItem{Mechanism: "cron", Location: filepath.Dir(s), Name: filepath.Base(s), Command: s, User: "root", Trigger: period})
+		}
+	}
+	for _, line := range configLines("/etc/anacrontab") {
+		// period delay job-id command
+		f := strings.Fields(line)
+		if len(f) < 4 || strings.Contains(f[0], "=") {
+			continue
+		}
+		items = append(items, persistenceItem{
+			Mechanism: "anacron",
+			Location:  "/etc/anacrontab",
+			Name:      f[2],
+			Command:   strings.Join(f[3:], " "),
+			User:      "root",
+			Trigger:   "period " + f[0] + ", delay " + f[1],
+		})
+	}
+	return items
+}
+
+// crontabEntries parses a crontab; user is "" for the system format that
+// carries the user in the sixth field. An entry is named after its whole
+// line, so editing it shows as one entry removed and another added.
+func crontabEntries(path, user string) []persistenceItem {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	var items []persistenceItem
+	for _, line := range configLines(path) {
+		f := strings.Fields(line)
+		if len(f) == 0 || (strings.Contains(f[0], "=") && !strings.HasPrefix(f[0], "@")) {
+			continue // environment assignment
+		}
+		n := 5
+		if strings.HasPrefix(f[0], "@") {
+			n = 1
+		}
+		if user == "" {
+			n++
+		}
+		if len(f) <= n {
+			continue
+		}
+		it := persistenceItem{
+			Mechanism: "cron",
+			Location:  path,
+			Name:      line,
+			Command:   strings.Join(f[n:], " "),
+			User:      user,
+			Trigger:   strings.Join(f[:n], " "),
+		}
+		if user == "" {
+			it.User = f[n-1]
+			it.Trigger = strings.Join(f[:n-1], " ")
+		}
+		items = append(items, it)
+	}
+	return items
+}
+
+func autostartPersistence() []persistenceItem {
+	var items []persistenceItem
+	for _, dir := range globAll(autostartDirs) {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.desktop"))
+		for _, f := range files {
+			it := persistenceItem{Mechanism: "autostart", Location: dir, Name: filepath.Base(f), User: homeUser(dir), Trigger: "login"}
+			for _, line := range configLines(f) {
+				key, value, _ := strings.Cut(line, "=")
+				switch strings.TrimSpace(key) {
+				case "Exec":
+					it.Command = strings.TrimSpace(value)
+				case "Hidden":
+					if strings.TrimSpace(value) == "true" {
+						it.Trigger = "disabled"
+					}
+				}
+			}
+			items = append(items, it)
+		}
+	}
+	return items
+}
+
+// shellRCPersistence records the rc files by content hash: any edit is a
+// change worth looking at.
+func shellRCPersistence() []persistenceItem {
+	files := globAll(systemShellRC)
+	homes := append([]string{"/root"}, globAll([]string{"/home/*", "/Users/*"})...)
+	for _, home := range homes {
+		for _, rc := range userShellRC {
+			files = append(files, filepath.Join(home, rc))
+		}
+	}
+
+	var items []persistenceItem
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		it := persistenceItem{Mechanism: "shell_rc", Location: filepath.Dir(f), Name: filepath.Base(f), User: homeUser(f), Trigger: "shell start", Image: f}
+		items = append(items, it)
+	}
+	return items
+}
+
+func preloadPersistence() []persistenceItem {
+	var items []persistenceItem
+	for _, line := range configLines("/etc/ld.so.preload") {
+		for _, lib := range strings.Fields(line) {
+			items = append(items, persistenceItem{Mechanism: "ld_so_preload", Location: "/etc/ld.so.preload", Name: lib, Command: lib, Trigger: "every dynamically linked program"})
+		}
+	}
+	return items
+}
+
+// launchdPersistence records macOS launch daemons and agents by file hash;
+// plists are often binary, so the program is not extracted.
+func launchdPersistence() []persistenceItem {
+	var items []persistenceItem
+	for _, dir := range globAll(launchdDirs) {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.plist"))
+		for _, f := range files {
+			trigger := "boot"
+			if strings.HasSuffix(dir, "LaunchAgents") {
+				trigger = "login"
+			}
+			items = append(items, persistenceItem{Mechanism: "launchd", Location: dir, Name: filepath.Base(f), User: homeUser(dir), Trigger: trigger, Image: f})
+		}
+	}
+	return items
+}
+
+// homeUser returns the user owning a path under /home, /Users or /root.
+func homeUser(path string) string {
+	parts := strings.Split(filepath.Clean(path), "/")
+	if len(parts) >= 2 && parts[1] == "root" {
+		return "root"
+	}
+	if len(parts) >= 3 && (parts[1] == "home" || parts[1] == "Users") {
+		return parts[2]
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Windows persistence locations, one PowerShell query per mechanism so a
+// slow one (scheduled tasks on a busy host) cannot time out the rest. A
+// query that fails is reported back as failed and keeps its previous
+// entries instead of showing every one of them as removed.
+
+const persistenceScriptPrelude = `
+	$ErrorActionPreference = 'SilentlyContinue'
+	$items = New-Object System.Collections.ArrayList
+	function Add-Item($mechanism, $location, $name, $command, $user, $trigger) {
+		[void]$items.Add([pscustomobject]@{
+			mechanism = [string]$mechanism; location = [string]$location; name = [string]$name
+			command = [string]$command; user = [string]$user; trigger = [string]$trigger
+		})
+	}
+`
+
+const persistenceScriptOutput = `
+	ConvertTo-Json -InputObject @($items) -Compress
+`
+
+var persistenceScripts = map[string]string{
+	"run_key": `
+	$keys = @(
+		'HKLM:\Software\Microsoft\Windows\CurrentVersion\Run',
+		'HKLM:\Software\Microsoft\Windows\CurrentVersion\RunOnce',
+		'HKLM:\Software\WOW6432Node\Microsoft\Windows\CurrentVersion\Run',
+		'HKLM:\Software\WOW6432Node\Microsoft\Windows\CurrentVersion\RunOnce'
+	)
+	# HKCU of every loaded user profile
+	Get-ChildItem 'Registry::HKEY_USERS' -ErrorAction Stop | Where-Object { $_.PSChildName -match '^S-1-5-21-[\d-]+$' } | ForEach-Object {
+		$keys += "Registry::HKEY_USERS\$($_.PSChildName)\Software\Microsoft\Windows\CurrentVersion\Run"
+		$keys += "Registry::HKEY_USERS\$($_.PSChildName)\Software\Microsoft\Windows\CurrentVersion\RunOnce"
+	}
+	$skip = 'PSPath','PSParentPath','PSChildName','PSDrive','PSProvider'
+	foreach ($k in $keys) {
+		$p = Get-ItemProperty -Path $k
+		if (-not $p) { continue }
+		$user = ''
+		if ($k -like 'Registry::HKEY_USERS\*') {
+			$sid = $k.Split('\')[2]
+			try { $user = (New-Object System.Security.Principal.SecurityIdentifier($sid)).Translate([System.Security.Principal.NTAccount]).Value } catch { $user = $sid }
+		}
+		foreach ($v in $p.PSObject.Properties) {
+			if ($skip -contains $v.Name) { continue }
+			Add-Item 'run_key' ($k -replace '^Registry::HKEY_USERS', 'HKU:') $v.Name $v.Value $user (Split-Path $k -Leaf)
+		}
+	}
+`,
+	"winlogon": `
+	$k = 'HKLM:\Software\Microsoft\Windows NT\CurrentVersion\Winlogon'
+	$w = Get-ItemProperty -Path $k -ErrorAction Stop
+	foreach ($n in 'Shell', 'Userinit', 'Taskman', 'AppSetup') {
+		if ($w.$n) { Add-Item 'winlogon' $k $n $w.$n '' 'logon' }
+	}
+`,
+	"service": `
+	foreach ($s in Get-CimInstance Win32_Service -ErrorAction Stop) {
+		Add-Item 'service' 'HKLM:\System\CurrentControlSet\Services' $s.Name $s.PathName $s.StartName $s.StartMode
+	}
+`,
+	"scheduled_task": `
+	foreach ($t in Get-ScheduledTask -ErrorAction Stop) {
+		$actions = $t.Actions | ForEach-Object {
+			if ($_.Execute) { ($_.Execute + ' ' + $_.Arguments).Trim() } elseif ($_.ClassId) { 'COM handler ' + $_.ClassId }
+		}
+		$trigger = ($t.Triggers | ForEach-Object { $_.CimClass.CimClassName -replace '^MSFT_Task', '' -replace 'Trigger$', '' }) -join ', '
+		if ([string]$t.State -eq 'Disabled') { $trigger = ($trigger + ' (disabled)').Trim() }
+		Add-Item 'scheduled_task' $t.TaskPath $t.TaskName (@($actions) -join '; ') $t.Principal.UserId $trigger
+	}
+`,
+	"startup_folder": `
+	$dirs = @("$env:ProgramData\Microsoft\Windows\Start Menu\Programs\StartUp")
+	Get-ChildItem "$env:SystemDrive\Users" -Directory -Force -ErrorAction Stop | ForEach-Object {
+		$dirs += Join-Path $_.FullName 'AppData\Roaming\Microsoft\Windows\Start Menu\Programs\Startup'
+	}
+	$shell = New-Object -ComObject WScript.Shell
+	foreach ($d in $dirs) {
+		$user = ''
+		if ($d -match '\\Users\\([^\\]+)\\') { $user = $Matches[1] }
+		Get-ChildItem -LiteralPath $d -File -Force | Where-Object { $_.Name -ne 'desktop.ini' } | ForEach-Object {
+			$command = $_.FullName
+			if ($_.Extension -eq '.lnk') {
+				$lnk = $shell.CreateShortcut($_.FullName)
+				if ($lnk.TargetPath) { $command = ($lnk.TargetPath + ' ' + $lnk.Arguments).Trim() }
+			}
+			Add-Item 'startup_folder' $d $_.Name $command $user 'logon'
+		}
+	}
+`,
+}
+
+func collectPersistence() ([]persistenceItem, map[string]bool) {
+	mechanisms := make([]string, 0, len(persistenceScripts))
+	for m := range persistenceScripts {
+		mechanisms = append(mechanisms, m)
+	}
+	sort.Strings(mechanisms)
+
+	var items []persistenceItem
+	failed := make(map[string]bool)
+	for _, m := range mechanisms {
+		script := persistenceScriptPrelude + "try {" + persistenceScripts[m] + "} catch { exit 1 }" + persistenceScriptOutput
+		out, err := runCommandWithTimeout("powershell", "-Command", script)
+		var found []persistenceItem
+		if err != nil || json.Unmarshal(out, &found) != nil {
+			logMessage("Persistence: enumerating " + m + " failed")
+			failed[m] = true
+			continue
+		}
+		items = append(items, found...)
+	}
+	return items, failed
+}
@@ -0,0 +1,147 @@
+//go:build !windows
+
+package main
+
+import (
+	"encoding/hex"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var (
+	userNameMutex sync.Mutex
+	userNames     = make(map[string]string)
+	groupNames    = make(map[string]string)
+)
+
+// runAgent runs the agent in the foreground; there is no service manager
+// integration outside Windows (use systemd or launchd to supervise it).
+func runAgent() {
+	log.Printf("CyArtAgent: Running in foreground mode")
+	initializeAgent() // blocks
+}
+
+func hideWindow(cmd *exec.Cmd) {}
+
+// defaultGateway returns the IPv4 default gateway of iface from
+// /proc/net/route and its MAC from the ARP cache.
+func defaultGateway(iface string) (string, string) {
+	gateway := procDefaultRoutes()[iface]
+	if gateway == "" {
+		return "", ""
+	}
+
+	arp, err := os.ReadFile("/proc/net/arp")
+	if err != nil {
+		return gateway, ""
+	}
+	for _, line := range strings.Split(string(arp), "\n")[1:] {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		f := strings.Fields(line)
+		if len(f) >= 6 && f[0] == gateway && f[5] == iface && f[3] != "00:00:00:00:00:00" {
+			return gateway, f[3]
+		}
+	}
+	return gateway, ""
+}
+
+// defaultGatewayIPs returns the IPv4 and IPv6 default gateways of every interface.
+func defaultGatewayIPs() []string {
+	var gateways []string
+	for _, gw := range procDefaultRoutes() {
+		gateways = append(gateways, gw)
+	}
+
+	// dest, dest prefix, src, src prefix, next hop, metric, refcnt, use, flags, device
+	routes, err := os.ReadFile("/proc/net/ipv6_route")
+	if err != nil {
+		return gateways
+	}
+	for _, line := range strings.Split(string(routes), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 10 || f[0] != strings.Repeat("0", 32) || f[1] != "00" {
+			continue
+		}
+		if hop, err := hex.DecodeString(f[4]); err == nil && len(hop) == 16 && !net.IP(hop).IsUnspecified() {
+			gateways = append(gateways, net.IP(hop).String())
+		}
+	}
+	return gateways
+}
+
+// procDefaultRoutes maps interface -> IPv4 default gateway from /proc/net/route.
+func procDefaultRoutes() map[string]string {
+	gateways := make(map[string]string)
+	routes, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		return gateways
+	}
+	for _, line := range strings.Split(string(routes), "\n")[1:] {
+		f := strings.Fields(line)
+		if len(f) < 3 || f[1] != "00000000" {
+			continue
+		}
+		v, err := strconv.ParseUint(f[2], 16, 32)
+		if err != nil || v == 0 {
+			continue
+		}
+		if _, ok := gateways[f[0]]; !ok {
+			// Little-endian hex
+			gateways[f[0]] = net.IPv4(byte(v), byte(v>>8), byte(v>>16), byte(v>>24)).String()
+		}
+	}
+	return gateways
+}
+
+// fileInode returns the inode number of a file (0 when unknown).
+func fileInode(path string, info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// fileOwner returns "user:group" of a file.
+func fileOwner(path string, info os.FileInfo) string {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return userName(strconv.Itoa(int(st.Uid))) + ":" + groupName(strconv.Itoa(int(st.Gid)))
+}
+
+// userName resolves a uid from /etc/passwd, falling back to the number.
+func userName(uid string) string {
+	userNameMutex.Lock()
+	defer userNameMutex.Unlock()
+	if name, ok := userNames[uid]; ok {
+		return name
+	}
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	userNames[uid] = name
+	return name
+}
+
+func groupName(gid string) string {
+	userNameMutex.Lock()
+	defer userNameMutex.Unlock()
+	if name, ok := groupNames[gid]; ok {
+		return name
+	}
+	name := gid
+	if g, err := user.LookupGroupId(gid); err == nil {
+		name = g.Name
+	}
+	groupNames[gid] = name
+	return name
+}
@@ -0,0 +1,172 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+)
+
+var (
+	accountNameMutex sync.Mutex
+	accountNames     = make(map[string]string)
+)
+
+// ----------------- main service wrapper -----------------
+
+type cyartService struct{}
+
+// Execute implements svc.Handler
+func (m *cyartService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+
+	// Notify Start Pending
+	changes <- svc.Status{State: svc.StartPending}
+
+	// Start initialization in background quickly so SCM doesn't time out
+	go func() {
+		initializeAgent()
+	}()
+
+	// Notify Running
+	changes <- svc.Status{State: svc.Running, Accepts: accepts}
+
+loop:
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				// break the loop to stop service
+				break loop
+			default:
+				// ignore other requests
+			}
+		}
+	}
+
+	// Notify Stop Pending
+	changes <- svc.Status{State: svc.StopPending}
+	// Cleanup if needed (none)
+	return false, 0
+}
+
+// runAgent starts the agent in the console or under the Service Control Manager.
+func runAgent() {
+	isInt, err := svc.IsAnInteractiveSession()
+	if err != nil {
+		log.Fatalf("Failed to detect session type: %v", err)
+	}
+
+	if isInt {
+		// Interactive / console mode
+		log.Printf("CyArtAgent: Running in interactive mode")
+		initializeAgent() // blocks
+		return
+	}
+
+	// Run as a windows service
+	err = svc.Run(SERVICE_NAME, &cyartService{})
+	if err != nil {
+		log.Printf("CyArtAgent service failed: %v", err)
+	}
+}
+
+func hideWindow(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+}
+
+// defaultGateway returns the IPv4 default gateway of iface and its MAC from
+// the neighbor cache.
+func defaultGateway(iface string) (string, string) {
+	alias := strings.ReplaceAll(iface, "'", "''")
+	script := fmt.Sprintf(`$r = Get-NetRoute -DestinationPrefix 0.0.0.0/0 -InterfaceAlias '%s' -ErrorAction SilentlyContinue | Sort-Object RouteMetric | Select-Object -First 1
+if ($r) { $n = Get-NetNeighbor -IPAddress $r.NextHop -InterfaceIndex $r.ifIndex -ErrorAction SilentlyContinue; "$($r.NextHop) $($n.LinkLayerAddress)" }`, alias)
+	out, err := runCommandWithTimeout("powershell", "-Command", script)
+	if err != nil {
+		return "", ""
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 {
+		return "", ""
+	}
+	// Get-NetNeighbor prints AA-BB-CC-DD-EE-FF
+	return fields[0], strings.ReplaceAll(fields[1], "-", ":")
+}
+
+// defaultGatewayIPs returns the IPv4 and IPv6 default gateways of every interface.
+func defaultGatewayIPs() []string {
+	out, err := runCommandWithTimeout("powershell", "-Command",
+		"Get-NetRoute -DestinationPrefix 0.0.0.0/0,::/0 -ErrorAction SilentlyContinue | Select-Object -ExpandProperty NextHop")
+	if err != nil {
+		return nil
+	}
+	var gateways []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if ip := net.ParseIP(line); ip != nil && !ip.IsUnspecified() {
+			gateways = append(gateways, ip.String())
+		}
+	}
+	return gateways
+}
+
+// fileInode returns the NTFS file index of a file, the closest thing to an
+// inode number (0 when unknown).
+func fileInode(path string, info os.FileInfo) uint64 {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0
+	}
+	// FILE_READ_ATTRIBUTES is enough and works on running executables
+	h, err := syscall.CreateFile(name, 0x80, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0
+	}
+	defer syscall.CloseHandle(h)
+	var fi syscall.ByHandleFileInformation
+	if syscall.GetFileInformationByHandle(h, &fi) != nil {
+		return 0
+	}
+	return uint64(fi.FileIndexHigh)<<32 | uint64(fi.FileIndexLow)
+}
+
+// fileOwner returns the owner account of a file (DOMAIN\user, or the SID
+// when it does not resolve).
+func fileOwner(path string, info os.FileInfo) string {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return ""
+	}
+	owner, _, err := sd.Owner()
+	if err != nil || owner == nil {
+		return ""
+	}
+	sid := owner.String()
+
+	accountNameMutex.Lock()
+	defer accountNameMutex.Unlock()
+	if name, ok := accountNames[sid]; ok {
+		return name
+	}
+	name := sid
+	if account, domain, _, err := owner.LookupAccount(""); err == nil {
+		name = account
+		if domain != "" {
+			name = domain + `\` + account
+		}
+	}
+	accountNames[sid] = name
+	return name
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Security posture assessment. A benchmark-style checklist is evaluated
+// periodically and every result is sent with the value observed and where
+// it was read from. Checks are declarative and come from the server with
+// the quarantine status; postureDefaultChecks is used until it sends any.
+// A check reads one value (a built-in probe, a file or a key in a config
+// file, or on Windows a registry value) and compares it with an operator:
+//
+//	{"id": "ssh_root_login", "title": "SSH root login disabled", "severity": "high",
+//	 "probe": "ssh_root_login", "operator": "in", "value": "no,prohibit-password"}
+//	{"id": "ip_forward", "title": "IP forwarding disabled", "severity": "medium",
+//	 "platform": "linux", "file": "/proc/sys/net/ipv4/ip_forward", "operator": "equals", "value": "0"}
+//
+// The outcome is the security_status reported by updateDeviceStatus.
+
+const (
+	POSTURE_POLICY_FILE = "posture-policy.json"
+	POSTURE_STATE_FILE  = "posture.json"
+	POSTURE_INTERVAL    = time.Hour
+	POSTURE_TICK        = time.Minute
+)
+
+var errPostureNotApplicable = errors.New("not applicable on this platform")
+
+// PosturePolicy is the checklist sent with the quarantine status.
+type PosturePolicy struct {
+	Checks          []PostureCheck `json:"checks"`
+	IntervalMinutes int            `json:"interval_minutes"` // 0: hourly
+}
+
+type PostureCheck struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Severity    string `json:"severity"`           // low, medium, high, critical
+	Platform    string `json:"platform,omitempty"` // windows, linux, darwin (comma-separated); empty for all
+	Probe       string `json:"probe,omitempty"`    // built-in probe, see postureProbe
+	File        string `json:"file,omitempty"`     // whole file, or the value of Key in it
+	Registry    string `json:"registry,omitempty"` // registry key (HKLM:\...), value Key
+	Key         string `json:"key,omitempty"`
+	Operator    string `json:"operator"` // equals, not_equals, in, contains, not_contains, matches, not_matches, exists, not_exists, lt, lte, gt, gte
+	Value       string `json:"value,omitempty"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+type postureResult struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Severity    string `json:"severity"`
+	Status      string `json:"status"` // pass, fail, error, not_applicable
+	Observed    string `json:"observed"`
+	Expected    string `json:"expected"`
+	Evidence    string `json:"evidence,omitempty"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// postureDefaultChecks are evaluated while the server has sent no checklist.
+var postureDefaultChecks = []PostureCheck{
+	{ID: "firewall_enabled", Title: "Host firewall enabled", Severity: "high", Probe: "firewall", Operator: "equals", Value: "enabled"},
+	{ID: "disk_encryption", Title: "System disk encrypted", Severity: "high", Probe: "disk_encryption", Operator: "equals", Value: "encrypted"},
+	{ID: "screen_lock", Title: "Screen locks after 15 minutes or less", Severity: "medium", Probe: "screen_lock_timeout", Operator: "lte", Value: "900"},
+	{ID: "ssh_root_login", Title: "SSH root login disabled", Severity: "high", Probe: "ssh_root_login", Operator: "in", Value: "no,prohibit-password,without-password,forced-commands-only"},
+	{ID: "password_min_length", Title: "Minimum password length of 12 or more", Severity: "medium", Probe: "password_min_length", Operator: "gte", Value: "12"},
+	{ID: "pending_updates", Title: "No pending OS updates", Severity: "medium", Probe: "pending_updates", Operator: "equals", Value: "0"},
+	{ID: "antivirus", Title: "Antivirus or EDR running", Severity: "high", Probe: "antivirus", Operator: "exists"},
+}
+
+// Antivirus and EDR processes, lowercase without .exe.
+var securityProducts = map[string]string{
+	"msmpeng":                "Microsoft Defender Antivirus",
+	"mssense":                "Microsoft Defender for Endpoint",
+	"wdavdaemon":             "Microsoft Defender for Endpoint",
+	"csfalconservice":        "CrowdStrike Falcon",
+	"falcon-sensor":          "CrowdStrike Falcon",
+	"falcond":                "CrowdStrike Falcon",
+	"sentinelagent":          "SentinelOne",
+	"s1-agent":               "SentinelOne",
+	"sentineld":              "SentinelOne",
+	"cbagentd":               "Carbon Black",
+	"repmgr":                 "Carbon Black Cloud",
+	"elastic-endpoint":       "Elastic Defend",
+	"sophos_threat_detector": "Sophos",
+	"savservice":             "Sophos",
+	"ds_agent":               "Trend Micro Deep Security",
+	"xagt":                   "Trellix Endpoint Security",
+	"cylancesvc":             "Cylance",
+	"cyserver":               "Cortex XDR",
+	"traps_pmd":              "Cortex XDR",
+	"ekrn":                   "ESET",
+	"esets_daemon":           "ESET",
+	"kesl":                   "Kaspersky Endpoint Security",
+	"avp":                    "Kaspersky",
+	"clamd":                  "ClamAV",
+}
+
+var posture = struct {
+	mu          sync.Mutex
+	policy      PosturePolicy
+	fingerprint string
+	results     []postureResult // last assessment, nil before the first
+	lastRun     time.Time
+}{}
+
+func updatePosturePolicy(policy PosturePolicy) {
+	data, _ := json.Marshal(policy)
+	posture.mu.Lock()
+	unchanged := string(data) == posture.fingerprint
+	posture.mu.Unlock()
+	if unchanged {
+		return
+	}
+
+	setPosturePolicy(policy, string(data))
+	if err := os.WriteFile(filepath.Join(agentDir, POSTURE_POLICY_FILE), data, 0644); err != nil {
+		logMessage("Posture policy cache write failed: " + err.Error())
+	}
+}
+
+// loadPosturePolicy loads the cached server checklist.
+func loadPosturePolicy(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var policy PosturePolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	data, _ = json.Marshal(policy)
+	setPosturePolicy(policy, string(data))
+	return nil
+}
+
+// setPosturePolicy installs a checklist; a new one is evaluated on the
+// next tick.
+func setPosturePolicy(policy PosturePolicy, fingerprint string) {
+	posture.mu.Lock()
+	posture.policy = policy
+	posture.fingerprint = fingerprint
+	posture.lastRun = time.Time{}
+	posture.mu.Unlock()
+	logMessage(fmt.Sprintf("Posture policy loaded: %d checks", len(policy.Checks)))
+}
+
+func runPostureAssessment() {
+	for {
+		policyMutex.RLock()
+		quarantined := isQuarantined
+		policyMutex.RUnlock()
+
+		posture.mu.Lock()
+		interval := POSTURE_INTERVAL
+		if posture.policy.IntervalMinutes > 0 {
+			interval = time.Duration(posture.policy.IntervalMinutes) * time.Minute
+		}
+		due := time.Since(posture.lastRun) >= interval
+		posture.mu.Unlock()
+
+		if deviceID != "" && !quarantined && due {
+			assessPosture(true)
+		}
+		time.Sleep(POSTURE_TICK)
+	}
+}
+
+// assessPosture evaluates the checklist and reports it. With track set,
+// checks are also reported on their own when they start failing and when
+// they pass again; without it every failing check is.
+func assessPosture(track bool) {
+	posture.mu.Lock()
+	checks := posture.policy.Checks
+	posture.mu.Unlock()
+	if len(checks) == 0 {
+		checks = postureDefaultChecks
+	}
+
+	previous := make(map[string]string)
+	if track {
+		if data := loadBookmark(POSTURE_STATE_FILE); data != "" {
+			json.Unmarshal([]byte(data), &previous)
+		}
+	}
+
+	results := make([]postureResult, 0, len(checks))
+	statuses := make(map[string]string, len(checks))
+	counts := make(map[string]int)
+	severity := "info"
+	for _, c := range checks {
+		r := c.evaluate()
+		results = append(results, r)
+		statuses[r.ID] = r.Status
+		counts[r.Status]++
+		if r.Status == "fail" {
+			severity = worseSeverity(severity, postureSeverity(r.Severity))
+			if previous[r.ID] != "fail" {
+				sendLog(r.logEntry("posture_check_failed"))
+			}
+		} else if r.Status == "pass" && previous[r.ID] == "fail" {
+			sendLog(r.logEntry("posture_check_passed"))
+		}
+	}
+
+	posture.mu.Lock()
+	posture.results = results
+	posture.lastRun = time.Now()
+	posture.mu.Unlock()
+	status, score, _ := postureStatus()
+
+	sendLog(LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    "security",
+		Event:      "posture_assessment",
+		Source:     "posture",
+		Severity:   severity,
+		Message:    fmt.Sprintf("Posture assessment: %d passed, %d failed, %d errors, %d not applicable (score %d%%)", counts["pass"], counts["fail"], counts["error"], counts["not_applicable"], score),
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RawData: map[string]interface{}{
+			"results":         results,
+			"passed":          counts["pass"],
+			"failed":          counts["fail"],
+			"errors":          counts["error"],
+			"not_applicable":  counts["not_applicable"],
+			"score":           score,
+			"security_status": status,
+		},
+	})
+
+	if track {
+		data, _ := json.Marshal(statuses)
+		saveBookmark(POSTURE_STATE_FILE, string(data))
+	}
+}
+
+// postureStatus summarises the last assessment: the security_status
+// (unknown before the first one), the share of applicable checks passed
+// and the IDs of the failed ones.
+func postureStatus() (string, int, []string) {
+	posture.mu.Lock()
+	defer posture.mu.Unlock()
+	if posture.results == nil {
+		return "unknown", 0, nil
+	}
+	status := "secure"
+	passed, evaluated := 0, 0
+	var failed []string
+	for _, r := range posture.results {
+		switch r.Status {
+		case "pass":
+			passed++
+			evaluated++
+		case "fail":
+			evaluated++
+			failed = append(failed, r.ID)
+			if r.Severity == "high" || r.Severity == "critical" {
+				status = "at_risk"
+			} else if status == "secure" {
+				status = "warning"
+			}
+		}
+	}
+	score := 100
+	if evaluated > 0 {
+		score = passed * 100 / evaluated
+	}
+	return status, score, failed
+}
+
+// postureSeverity maps a check severity onto the log severities, as the
+// Sigma rule levels are.
+func postureSeverity(level string) string {
+	switch strings.ToLower(level) {
+	case "low":
+		return "info"
+	case "high":
+		return "high"
+	case "critical":
+		return "critical"
+	}
+	return "warning"
+}
+
+func worseSeverity(a, b string) string {
+	rank := map[string]int{"info": 0, "warning": 1, "high": 2, "critical": 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+func (c PostureCheck) appliesTo(goos string) bool {
+	if c.Platform == "" {
+		return true
+	}
+	for _, p := range strings.Split(c.Platform, ",") {
+		if strings.EqualFold(strings.TrimSpace(p), goos) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c PostureCheck) evaluate() postureResult {
+	r := postureResult{
+		ID:          c.ID,
+		Title:       c.Title,
+		Severity:    strings.ToLower(c.Severity),
+		Expected:    strings.TrimSpace(c.Operator + " " + c.Value),
+		Remediation: c.Remediation,
+	}
+	if !c.appliesTo(runtime.GOOS) {
+		r.Status = "not_applicable"
+		r.Evidence = "platform " + c.Platform
+		return r
+	}
+	observed, evidence, present, err := c.observe()
+	r.Observed, r.Evidence = observed, evidence
+	if err == errPostureNotApplicable {
+		r.Status = "not_applicable"
+		return r
+	} else if err != nil {
+		r.Status = "error"
+		r.Evidence = err.Error()
+		return r
+	}
+	ok, err := postureCompare(c.Operator, observed, c.Value, present)
+	switch {
+	case err != nil:
+		r.Status = "error"
+		r.Evidence = err.Error()
+	case ok:
+		r.Status = "pass"
+	default:
+		r.Status = "fail"
+	}
+	return r
+}
+
+// observe reads the value a check compares; present is false when the
+// file, key or value does not exist.
+func (c PostureCheck) observe() (value, evidence string, present bool, err error) {
+	switch {
+	case c.Probe != "":
+		value, evidence, err = postureProbe(c.Probe)
+		return value, evidence, value != "", err
+
+	case c.File != "":
+		path := os.ExpandEnv(c.File)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return "", path + " not found", false, nil
+		} else if err != nil {
+			return "", "", false, err
+		}
+		if c.Key == "" {
+			return strings.TrimSpace(string(data)), path, true, nil
+		}
+		value, found := configValue(configLines(path), c.Key)
+		if !found {
+			return "", c.Key + " not set in " + path, false, nil
+		}
+		return value, path + ": " + c.Key + " " + value, true, nil
+
+	case c.Registry != "":
+		value, present, err = postureRegistryValue(c.Registry, c.Key)
+		return value, c.Registry + `\` + c.Key, present, err
+	}
+	return "", "", false, fmt.Errorf("check %s has no probe, file or registry", c.ID)
+}
+
+// configValue returns the value of the first "key value", "key=value" or
+// "key: value" line for key (case-insensitive), unquoted.
+func configValue(lines []string, key string) (string, bool) {
+	for _, line := range lines {
+		k, v := line, ""
+		if i := strings.IndexAny(line, "=: \t"); i >= 0 {
+			k, v = line[:i], line[i:]
+		}
+		if strings.EqualFold(k, key) {
+			return strings.Trim(strings.TrimLeft(v, "=: \t"), `"'`), true
+		}
+	}
+	return "", false
+}
+
+func postureCompare(op, observed, expected string, present bool) (bool, error) {
+	observed, expected = strings.TrimSpace(observed), strings.TrimSpace(expected)
+	switch strings.ToLower(op) {
+	case "exists":
+		return present, nil
+	case "not_exists":
+		return !present, nil
+	case "equals":
+		return strings.EqualFold(observed, expected), nil
+	case "not_equals":
+		return !strings.EqualFold(observed, expected), nil
+	case "in":
+		for _, v := range strings.Split(expected, ",") {
+			if strings.EqualFold(observed, strings.TrimSpace(v)) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "contains":
+		return strings.Contains(strings.ToLower(observed), strings.ToLower(expected)), nil
+	case "not_contains":
+		return !strings.Contains(strings.ToLower(observed), strings.ToLower(expected)), nil
+	case "matches", "not_matches":
+		re, err := regexp.Compile(expected)
+		if err != nil {
+			return false, fmt.Errorf("bad pattern %q: %v", expected, err)
+		}
+		return re.MatchString(observed) == (strings.ToLower(op) == "matches"), nil
+	case "lt", "lte", "gt", "gte":
+		want, err := strconv.ParseFloat(expected, 64)
+		if err != nil {
+			return false, fmt.Errorf("%s needs a number, got %q", op, expected)
+		}
+		got, err := strconv.ParseFloat(observed, 64)
+		if err != nil {
+			return false, nil // not configured, disabled or unlimited
+		}
+		switch strings.ToLower(op) {
+		case "lt":
+			return got < want, nil
+		case "lte":
+			return got <= want, nil
+		case "gt":
+			return got > want, nil
+		}
+		return got >= want, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", op)
+}
+
+// sshRootLogin reads the global PermitRootLogin setting of an sshd_config
+// and the files it includes. A file already being read is not read again,
+// so an Include loop ends instead of recursing forever.
+func sshRootLogin(config string) (string, string, error) {
+	if _, err := os.Stat(config); err != nil {
+		return "", config + " not found", errPostureNotApplicable // no SSH server
+	}
+	visited := make(map[string]bool)
+	var visit func(path string) (string, string, bool)
+	visit = func(path string) (string, string, bool) {
+		if visited[filepath.Clean(path)] {
+			return "", "", false
+		}
+		visited[filepath.Clean(path)] = true
+		for _, line := range configLines(path) {
+			key, value, _ := strings.Cut(strings.Join(strings.Fields(line), " "), " ")
+			switch strings.ToLower(key) {
+			case "match":
+				return "", "", false // only the global section applies to every login
+			case "include":
+				for _, pattern := range strings.Fields(value) {
+					if !filepath.IsAbs(pattern) {
+						pattern = filepath.Join(filepath.Dir(config), pattern)
+					}
+					matches, _ := filepath.Glob(pattern)
+					for _, m := range matches {
+						if v, where, ok := visit(m); ok {
+							return v, where, true
+						}
+					}
+				}
+			case "permitrootlogin":
+				return strings.ToLower(value), path, true
+			}
+		}
+		return "", "", false
+	}
+	if value, path, ok := visit(config); ok {
+		return value, path + ": PermitRootLogin " + value, nil
+	}
+	return "prohibit-password", config + ": PermitRootLogin not set (OpenSSH default)", nil
+}
+
+// runningSecurityProducts names the antivirus and EDR products with a
+// running process.
+func runningSecurityProducts(names []string) []string {
+	seen := make(map[string]bool)
+	var found []string
+	for _, n := range names {
+		product, ok := securityProducts[strings.TrimSuffix(strings.ToLower(filepath.Base(strings.TrimSpace(n))), ".exe")]
+		if ok && !seen[product] {
+			seen[product] = true
+			found = append(found, product)
+		}
+	}
+	return found
+}
+
+func (r postureResult) logEntry(event string) LogEntry {
+	severity := "info"
+	verb := "passed"
+	if r.Status == "fail" {
+		severity = postureSeverity(r.Severity)
+		verb = "failed"
+	}
+	observed := r.Observed
+	if observed == "" {
+		observed = "none"
+	}
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    "security",
+		Event:      event,
+		Source:     "posture",
+		Severity:   severity,
+		Message:    fmt.Sprintf("Posture check %s: %s (observed %s, expected %s)", verb, r.Title, observed, r.Expected),
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RawData: map[string]interface{}{
+			"check_id":    r.ID,
+			"title":       r.Title,
+			"severity":    r.Severity,
+			"status":      r.Status,
+			"observed":    r.Observed,
+			"expected":    r.Expected,
+			"evidence":    r.Evidence,
+			"remediation": r.Remediation,
+		},
+	}
+}
+
+// postureReport evaluates the checklist (the cached server one or the
+// defaults) and prints the resulting log entries (the -posture flag).
+func postureReport(out io.Writer) {
+	replayOutput = out
+	defer func() { replayOutput = nil }()
+	if err := loadPosturePolicy(filepath.Join(agentDir, POSTURE_POLICY_FILE)); err != nil && !os.IsNotExist(err) {
+		logMessage("Posture policy cache: " + err.Error())
+	}
+	assessPosture(false)
+}
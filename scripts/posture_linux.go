@@ -0,0 +1,249 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Linux posture probes. Values come from configuration files where the
+// distribution keeps them and from the firewall and package tools.
+
+func postureProbe(name string) (string, string, error) {
+	switch name {
+	case "firewall":
+		return linuxFirewall()
+	case "disk_encryption":
+		return linuxRootEncryption()
+	case "screen_lock_timeout":
+		return linuxScreenLock()
+	case "ssh_root_login":
+		return sshRootLogin("/etc/ssh/sshd_config")
+	case "password_min_length":
+		return linuxPasswordMinLength()
+	case "password_max_age":
+		if v, ok := configValue(configLines("/etc/login.defs"), "PASS_MAX_DAYS"); ok && v != "99999" {
+			return v, "/etc/login.defs: PASS_MAX_DAYS " + v, nil
+		}
+		return "", "/etc/login.defs: passwords do not expire", nil
+	case "pending_updates":
+		return linuxPendingUpdates()
+	case "antivirus":
+		var names []string
+		for _, p := range listProcesses() {
+			names = append(names, p.Name)
+		}
+		if products := runningSecurityProducts(names); len(products) > 0 {
+			return strings.Join(products, ", "), "running processes", nil
+		}
+		return "", "no known antivirus or EDR process running", nil
+	}
+	return "", "", fmt.Errorf("unknown probe %q", name)
+}
+
+func postureRegistryValue(key, name string) (string, bool, error) {
+	return "", false, errPostureNotApplicable
+}
+
+// linuxFirewall reports the firewall enabled when ufw or firewalld is on,
+// or when the nftables or iptables input path filters anything.
+func linuxFirewall() (string, string, error) {
+	if v, ok := configValue(configLines("/etc/ufw/ufw.conf"), "ENABLED"); ok && v == "yes" {
+		return "enabled", "ufw enabled in /etc/ufw/ufw.conf", nil
+	}
+	if out, _ := runCommandWithTimeout("systemctl", "is-active", "firewalld"); strings.TrimSpace(string(out)) == "active" {
+		return "enabled", "firewalld active", nil
+	}
+	if out, err := runCommandWithTimeout("nft", "list", "ruleset"); err == nil {
+		if rules, drop := nftInputFilter(string(out)); rules > 0 || drop {
+			return "enabled", fmt.Sprintf("nftables input chain: %d rules, policy drop %v", rules, drop), nil
+		}
+	}
+	if out, err := runCommandWithTimeout("iptables", "-S", "INPUT"); err == nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if len(lines) > 1 || strings.Contains(lines[0], "DROP") {
+			return "enabled", fmt.Sprintf("iptables INPUT chain: %d rules (%s)", len(lines)-1, lines[0]), nil
+		}
+	}
+	return "disabled", "no ufw, firewalld, nftables or iptables input filtering", nil
+}
+
+// nftInputFilter counts the rules of the input hook chains of an nft
+// ruleset listing and tells whether one drops by default. Empty chains
+// with an accept policy (created by iptables-nft) filter nothing.
+func nftInputFilter(ruleset string) (rules int, drop bool) {
+	inChain, input := false, false
+	for _, line := range strings.Split(ruleset, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "chain "):
+			inChain, input = true, false
+		case !inChain || line == "":
+		case line == "}":
+			inChain = false
+		case strings.HasPrefix(line, "type ") && strings.Contains(line, "hook input"):
+			input = true
+			drop = drop || strings.Contains(line, "policy drop")
+		case input:
+			rules++
+		}
+	}
+	return rules, drop
+}
+
+// linuxRootEncryption tells whether the root filesystem sits on dm-crypt,
+// directly or under LVM.
+func linuxRootEncryption() (string, string, error) {
+	dev := ""
+	for _, line := range configLines("/proc/self/mounts") {
+		if f := strings.Fields(line); len(f) >= 2 && f[1] == "/" {
+			dev = f[0] // the last mount on / is the visible one
+		}
+	}
+	if !strings.HasPrefix(dev, "/dev/") {
+		return "", "root filesystem is " + dev + ", not a block device", errPostureNotApplicable
+	}
+	real, err := filepath.EvalSymlinks(dev)
+	if err != nil {
+		return "", "", err
+	}
+	if mapping := cryptSlave(filepath.Join("/sys/class/block", filepath.Base(real)), 0); mapping != "" {
+		return "encrypted", fmt.Sprintf("root filesystem on %s (dm-crypt %s)", dev, mapping), nil
+	}
+	return "not_encrypted", "root filesystem on " + dev, nil
+}
+
+// cryptSlave returns the dm-crypt mapping a block device is, or is stacked
+// on.
+func cryptSlave(dir string, depth int) string {
+	if depth > 8 {
+		return ""
+	}
+	if strings.HasPrefix(sysfsValue(dir, "dm/uuid"), "CRYPT-") {
+		return firstNonEmpty(sysfsValue(dir, "dm/name"), filepath.Base(dir))
+	}
+	slaves, _ := os.ReadDir(filepath.Join(dir, "slaves"))
+	for _, s := range slaves {
+		if m := cryptSlave(filepath.Join("/sys/class/block", s.Name()), depth+1); m != "" {
+			return m
+		}
+	}
+	return ""
+}
+
+// linuxScreenLock reads the GNOME idle delay, from the system dconf
+// database when it is set there and otherwise from the gsettings defaults;
+// "" when the lock is disabled. Hosts without GNOME are not applicable.
+func linuxScreenLock() (string, string, error) {
+	delay, lock, evidence := "", "", ""
+	for _, path := range globAll([]string{"/etc/dconf/db/*.d/*"}) {
+		lines := configLines(path)
+		if v, ok := configValue(lines, "idle-delay"); ok {
+			delay, evidence = v, path
+		}
+		if v, ok := configValue(lines, "lock-enabled"); ok {
+			lock = v
+		}
+	}
+	if evidence == "" {
+		if _, err := exec.LookPath("gnome-shell"); err != nil {
+			return "", "no GNOME desktop", errPostureNotApplicable
+		}
+		out, err := runCommandWithTimeout("gsettings", "get", "org.gnome.desktop.session", "idle-delay")
+		if err != nil {
+			return "", "", err
+		}
+		delay, evidence = strings.TrimSpace(string(out)), "gsettings defaults"
+		out, _ = runCommandWithTimeout("gsettings", "get", "org.gnome.desktop.screensaver", "lock-enabled")
+		lock = strings.TrimSpace(string(out))
+	}
+	delay = strings.TrimSpace(strings.TrimPrefix(delay, "uint32"))
+	if lock == "false" || delay == "0" {
+		return "", evidence + ": screen lock disabled", nil
+	}
+	return delay, fmt.Sprintf("%s: idle-delay %s, lock-enabled %s", evidence, delay, firstNonEmpty(lock, "true")), nil
+}
+
+// linuxPasswordMinLength prefers pam_pwquality's minlen (later files in
+// pwquality.conf.d override) over PASS_MIN_LEN from login.defs.
+func linuxPasswordMinLength() (string, string, error) {
+	value, evidence := "", ""
+	for _, path := range append([]string{"/etc/security/pwquality.conf"}, globAll([]string{"/etc/security/pwquality.conf.d/*.conf"})...) {
+		if v, ok := configValue(configLines(path), "minlen"); ok {
+			value, evidence = v, path+": minlen "+v
+		}
+	}
+	if value != "" {
+		return value, evidence, nil
+	}
+	if v, ok := configValue(configLines("/etc/login.defs"), "PASS_MIN_LEN"); ok {
+		return v, "/etc/login.defs: PASS_MIN_LEN " + v, nil
+	}
+	return "", "no minimum password length configured", nil
+}
+
+// linuxPendingUpdates counts the upgrades the package manager would install
+// from its current metadata, without refreshing it.
+func linuxPendingUpdates() (string, string, error) {
+	reboot := ""
+	if _, err := os.Stat("/var/run/reboot-required"); err == nil {
+		reboot = ", reboot required"
+	}
+	if _, err := exec.LookPath("apt-get"); err == nil {
+		out, err := runCommandWithTimeout("apt-get", "-s", "-o", "Debug::NoLocking=true", "upgrade")
+		if err != nil {
+			return "", "", err
+		}
+		count, security := 0, 0
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.HasPrefix(line, "Inst ") {
+				count++
+				if strings.Contains(line, "-security") {
+					security++
+				}
+			}
+		}
+		return strconv.Itoa(count), fmt.Sprintf("apt-get -s upgrade: %d upgradable, %d from security%s", count, security, reboot), nil
+	}
+	for _, tool := range []string{"dnf", "yum"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			continue
+		}
+		// exit status 100 when updates are available
+		out, err := runCommandWithTimeout(tool, "-q", "-C", "check-update")
+		var exit *exec.ExitError
+		if err != nil && !(errors.As(err, &exit) && exit.ExitCode() == 100) {
+			return "", "", err
+		}
+		count := 0
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.HasPrefix(line, "Obsoleting") {
+				break
+			}
+			if len(strings.Fields(line)) == 3 {
+				count++
+			}
+		}
+		return strconv.Itoa(count), fmt.Sprintf("%s check-update (cached metadata): %d upgradable%s", tool, count, reboot), nil
+	}
+	if _, err := exec.LookPath("zypper"); err == nil {
+		out, err := runCommandWithTimeout("zypper", "-q", "--non-interactive", "--no-refresh", "list-updates")
+		if err != nil {
+			return "", "", err
+		}
+		count := 0
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.HasPrefix(line, "v ") {
+				count++
+			}
+		}
+		return strconv.Itoa(count), fmt.Sprintf("zypper list-updates: %d upgradable%s", count, reboot), nil
+	}
+	return "", "no supported package manager", errPostureNotApplicable
+}
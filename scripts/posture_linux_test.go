@@ -0,0 +1,95 @@
+//go:build linux
+
+package main
+
+import "testing"
+
+func TestNftInputFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		ruleset string
+		rules   int
+		drop    bool
+	}{
+		{"empty ruleset", "", 0, false},
+		{
+			"iptables-nft empty chains",
+			`table ip filter {
+	chain INPUT {
+		type filter hook input priority filter; policy accept;
+	}
+
+	chain FORWARD {
+		type filter hook forward priority filter; policy accept;
+	}
+
+	chain OUTPUT {
+		type filter hook output priority filter; policy accept;
+	}
+}`,
+			0, false,
+		},
+		{
+			"default drop with rules",
+			`table inet filter {
+	chain input {
+		type filter hook input priority filter; policy drop;
+		ct state established,related accept
+		iif "lo" accept
+		tcp dport 22 accept
+	}
+
+	chain forward {
+		type filter hook forward priority filter; policy drop;
+		ct state established accept
+	}
+}`,
+			3, true,
+		},
+		{
+			"policy drop without rules",
+			`table inet filter {
+	chain input {
+		type filter hook input priority 0; policy drop;
+	}
+}`,
+			0, true,
+		},
+		{
+			"rules jumping to a regular chain",
+			`table inet firewalld {
+	set blocked {
+		type ipv4_addr
+		elements = { 10.0.0.1, 10.0.0.2 }
+	}
+
+	chain filter_INPUT {
+		type filter hook input priority filter + 10; policy accept;
+		ct state established,related accept
+		jump filter_INPUT_ZONES
+		reject with icmpx admin-prohibited
+	}
+
+	chain filter_INPUT_ZONES {
+		iifname "eth0" goto filter_IN_public
+		goto filter_IN_public
+	}
+}
+table ip nat {
+	chain PREROUTING {
+		type nat hook prerouting priority dstnat; policy accept;
+		tcp dport 8080 redirect to :80
+	}
+}`,
+			3, false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, drop := nftInputFilter(tt.ruleset)
+			if rules != tt.rules || drop != tt.drop {
+				t.Errorf("nftInputFilter = %d, %v; want %d, %v", rules, drop, tt.rules, tt.drop)
+			}
+		})
+	}
+}
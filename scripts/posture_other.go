@@ -0,0 +1,78 @@
+//go:build !windows && !linux
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// macOS posture probes. The screen lock delay is a per-user setting and is
+// not read.
+
+var pwpolicyMinLengthRegex = regexp.MustCompile(`\.\{(\d+),`)
+
+func postureProbe(name string) (string, string, error) {
+	switch name {
+	case "firewall":
+		out, err := runCommandWithTimeout("/usr/libexec/ApplicationFirewall/socketfilterfw", "--getglobalstate")
+		if err != nil {
+			return "", "", err
+		}
+		state := strings.TrimSpace(string(out)) // Firewall is enabled. (State = 1)
+		if strings.Contains(state, "enabled") {
+			return "enabled", "application firewall: " + state, nil
+		}
+		return "disabled", "application firewall: " + state, nil
+	case "disk_encryption":
+		out, err := runCommandWithTimeout("fdesetup", "status")
+		if err != nil {
+			return "", "", err
+		}
+		status := strings.TrimSpace(string(out))
+		if strings.Contains(status, "FileVault is On") {
+			return "encrypted", status, nil
+		}
+		return "not_encrypted", status, nil
+	case "ssh_root_login":
+		return sshRootLogin("/etc/ssh/sshd_config")
+	case "password_min_length":
+		// the global policy states the length as a regular expression
+		out, err := runCommandWithTimeout("pwpolicy", "-getaccountpolicies")
+		if err != nil {
+			return "", "", err
+		}
+		if m := pwpolicyMinLengthRegex.FindStringSubmatch(string(out)); m != nil {
+			return m[1], "pwpolicy: policyAttributePassword matches .{" + m[1] + ",}", nil
+		}
+		return "", "pwpolicy: no minimum password length", nil
+	case "pending_updates":
+		out, err := runCommandWithTimeout("defaults", "read", "/Library/Preferences/com.apple.SoftwareUpdate", "LastRecommendedUpdatesAvailable")
+		if err != nil {
+			return "", "", err
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(string(out)))
+		if err != nil {
+			return "", "", fmt.Errorf("unexpected softwareupdate state %q", strings.TrimSpace(string(out)))
+		}
+		return strconv.Itoa(count), fmt.Sprintf("softwareupdate (last check): %d recommended updates", count), nil
+	case "antivirus":
+		out, err := runCommandWithTimeout("ps", "-axco", "comm=")
+		if err != nil {
+			return "", "", err
+		}
+		if products := runningSecurityProducts(strings.Split(string(out), "\n")); len(products) > 0 {
+			return strings.Join(products, ", "), "running processes", nil
+		}
+		return "", "no known antivirus or EDR process running", nil
+	case "screen_lock_timeout", "password_max_age":
+		return "", "", errPostureNotApplicable
+	}
+	return "", "", fmt.Errorf("unknown probe %q", name)
+}
+
+func postureRegistryValue(key, name string) (string, bool, error) {
+	return "", false, errPostureNotApplicable
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPostureCompare(t *testing.T) {
+	tests := []struct {
+		op, observed, expected string
+		present                bool
+		want                   bool
+		err                    bool
+	}{
+		{"exists", "", "", true, true, false},
+		{"exists", "", "", false, false, false},
+		{"not_exists", "", "", false, true, false},
+		{"equals", " No ", "no", true, true, false},
+		{"equals", "yes", "no", true, false, false},
+		{"not_equals", "yes", "no", true, true, false},
+		{"NOT_EQUALS", "NO", "no", true, false, false},
+		{"in", "prohibit-password", "no, prohibit-password", true, true, false},
+		{"in", "yes", "no,prohibit-password", true, false, false},
+		{"contains", "Status: Active", "active", true, true, false},
+		{"not_contains", "Status: Active", "inactive", true, true, false},
+		{"matches", "SHA512", "^(SHA512|YESCRYPT)$", true, true, false},
+		{"not_matches", "MD5", "^(SHA512|YESCRYPT)$", true, true, false},
+		{"matches", "x", "(", true, false, true},
+		{"lt", "90", "91", true, true, false},
+		{"lte", "91", "91", true, true, false},
+		{"gt", "14", "12", true, true, false},
+		{"gte", "11.5", "12", true, false, false},
+		{"lte", "99999", "90", true, false, false},
+		{"lte", "unlimited", "90", true, false, false},
+		{"lte", "90", "ninety", true, false, true},
+		{"between", "1", "2", true, false, true},
+	}
+	for _, tt := range tests {
+		got, err := postureCompare(tt.op, tt.observed, tt.expected, tt.present)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("postureCompare(%q, %q, %q, %v) = %v, %v; want %v, error %v", tt.op, tt.observed, tt.expected, tt.present, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	lines := []string{
+		"PASS_MAX_DAYS\t90",
+		"minlen = 14",
+		"ENABLED=yes",
+		`idle-delay: "uint32 300"`,
+		"lock-enabled='true'",
+		"UMASK",
+		"PASS_MAX_DAYS 99999",
+	}
+	tests := []struct {
+		key   string
+		want  string
+		found bool
+	}{
+		{"pass_max_days", "90", true}, // first line wins, case-insensitive
+		{"minlen", "14", true},
+		{"ENABLED", "yes", true},
+		{"idle-delay", "uint32 300", true},
+		{"lock-enabled", "true", true},
+		{"UMASK", "", true},
+		{"min", "", false},
+		{"PASS_MIN_LEN", "", false},
+	}
+	for _, tt := range tests {
+		got, found := configValue(lines, tt.key)
+		if got != tt.want || found != tt.found {
+			t.Errorf("configValue(%q) = %q, %v; want %q, %v", tt.key, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestSSHRootLogin(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string // relative to the config directory
+		want  string
+		where string
+	}{
+		{
+			"default",
+			map[string]string{"sshd_config": "Port 22\n"},
+			"prohibit-password", "sshd_config",
+		},
+		{
+			"set",
+			map[string]string{"sshd_config": "# PermitRootLogin yes\npermitrootlogin\t No\n"},
+			"no", "sshd_config",
+		},
+		{
+			"include first wins",
+			map[string]string{
+				"sshd_config":             "Include sshd_config.d/*.conf\nPermitRootLogin yes\n",
+				"sshd_config.d/10-a.conf": "PasswordAuthentication no\n",
+				"sshd_config.d/20-b.conf": "PermitRootLogin no\n",
+			},
+			"no", "sshd_config.d/20-b.conf",
+		},
+		{
+			"include without setting",
+			map[string]string{
+				"sshd_config":           "Include sshd_config.d/*.conf\nPermitRootLogin without-password\n",
+				"sshd_config.d/10.conf": "X11Forwarding no\n",
+			},
+			"without-password", "sshd_config",
+		},
+		{
+			"match block ignored",
+			map[string]string{"sshd_config": "Port 22\nMatch User backup\n    PermitRootLogin yes\n"},
+			"prohibit-password", "sshd_config",
+		},
+		{
+			"includes itself",
+			map[string]string{"sshd_config": "Include sshd_config\nPermitRootLogin no\n"},
+			"no", "sshd_config",
+		},
+		{
+			"include loop",
+			map[string]string{
+				"sshd_config": "Include a.conf\n",
+				"a.conf":      "Include b.conf\n",
+				"b.conf":      "Include a.conf sshd_config\n",
+			},
+			"prohibit-password", "sshd_config",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				os.MkdirAll(filepath.Dir(path), 0755)
+				os.WriteFile(path, []byte(content), 0644)
+			}
+			got, where, err := sshRootLogin(filepath.Join(dir, "sshd_config"))
+			if err != nil || got != tt.want {
+				t.Fatalf("sshRootLogin = %q, %v; want %q", got, err, tt.want)
+			}
+			if want := filepath.Join(dir, tt.where) + ": PermitRootLogin"; !strings.HasPrefix(where, want) {
+				t.Errorf("source %q, want it to start with %q", where, want)
+			}
+		})
+	}
+
+	if _, _, err := sshRootLogin(filepath.Join(t.TempDir(), "sshd_config")); !errors.Is(err, errPostureNotApplicable) {
+		t.Errorf("missing config: error %v, want errPostureNotApplicable", err)
+	}
+}
@@ -0,0 +1,171 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Windows posture probes through PowerShell and net accounts. Root SSH
+// logins do not exist here: the ssh_root_login probe is not applicable.
+
+// POSTURE_UPDATE_TIMEOUT bounds the Windows Update search, which takes
+// longer than runCommandWithTimeout allows even against the local cache.
+const POSTURE_UPDATE_TIMEOUT = 2 * time.Minute
+
+const postureUpdateScript = `
+	$searcher = (New-Object -ComObject Microsoft.Update.Session).CreateUpdateSearcher()
+	$searcher.Online = $false
+	$result = $searcher.Search("IsInstalled=0 and IsHidden=0 and Type='Software'")
+	$security = @($result.Updates | Where-Object { $_.MsrcSeverity }).Count
+	"$($result.Updates.Count) $security"
+`
+
+const postureAntivirusScript = `
+	Get-CimInstance -Namespace root/SecurityCenter2 -ClassName AntiVirusProduct -ErrorAction SilentlyContinue |
+		ForEach-Object { "$($_.productState)|$($_.displayName)" }
+`
+
+func postureProbe(name string) (string, string, error) {
+	switch name {
+	case "firewall":
+		out, err := runCommandWithTimeout("powershell", "-Command", `Get-NetFirewallProfile | ForEach-Object { "$($_.Name)=$($_.Enabled)" }`)
+		if err != nil {
+			return "", "", err
+		}
+		profiles := strings.Fields(string(out))
+		if len(profiles) == 0 {
+			return "", "", fmt.Errorf("no firewall profiles")
+		}
+		state := "enabled"
+		for _, p := range profiles {
+			if !strings.HasSuffix(p, "=True") {
+				state = "disabled"
+			}
+		}
+		return state, "firewall profiles: " + strings.Join(profiles, ", "), nil
+
+	case "disk_encryption":
+		out, err := runCommandWithTimeout("powershell", "-Command",
+			`$v = Get-BitLockerVolume -MountPoint $env:SystemDrive -ErrorAction Stop; "$($v.MountPoint) $($v.VolumeStatus) $($v.ProtectionStatus)"`)
+		if err != nil {
+			return "", "BitLocker is not available", err
+		}
+		status := strings.TrimSpace(string(out)) // C: FullyEncrypted On
+		if strings.HasSuffix(status, " On") {
+			return "encrypted", "BitLocker " + status, nil
+		}
+		return "not_encrypted", "BitLocker " + status, nil
+
+	case "screen_lock_timeout":
+		// machine inactivity limit, or a password-protected screen saver set by policy
+		if v, ok, err := postureRegistryValue(`HKLM:\SOFTWARE\Microsoft\Windows\CurrentVersion\Policies\System`, "InactivityTimeoutSecs"); err == nil && ok && v != "0" {
+			return v, "InactivityTimeoutSecs " + v, nil
+		}
+		desktop := `HKLM:\SOFTWARE\Policies\Microsoft\Windows\Control Panel\Desktop`
+		secure, _, _ := postureRegistryValue(desktop, "ScreenSaverIsSecure")
+		if v, ok, err := postureRegistryValue(desktop, "ScreenSaveTimeOut"); err == nil && ok && secure == "1" {
+			return v, "screen saver policy: ScreenSaveTimeOut " + v + ", ScreenSaverIsSecure 1", nil
+		}
+		return "", "no inactivity limit or secure screen saver policy", nil
+
+	case "password_min_length", "password_max_age":
+		out, err := runCommandWithTimeout("net", "accounts")
+		if err != nil {
+			return "", "", err
+		}
+		label := "Minimum password length"
+		if name == "password_max_age" {
+			label = "Maximum password age"
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if !strings.HasPrefix(line, label) {
+				continue
+			}
+			_, value, _ := strings.Cut(line, ":")
+			value = strings.TrimSpace(value)
+			if _, err := strconv.Atoi(value); err != nil {
+				return "", "net accounts: " + label + " " + value, nil // Unlimited
+			}
+			return value, "net accounts: " + label + " " + value, nil
+		}
+		return "", "", fmt.Errorf("net accounts: no %s", label)
+
+	case "pending_updates":
+		ctx, cancel := context.WithTimeout(context.Background(), POSTURE_UPDATE_TIMEOUT)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "powershell", "-Command", postureUpdateScript)
+		hideWindow(cmd)
+		out, err := cmd.Output()
+		if err != nil {
+			return "", "", err
+		}
+		f := strings.Fields(string(out))
+		if len(f) != 2 {
+			return "", "", fmt.Errorf("unexpected update search output %q", strings.TrimSpace(string(out)))
+		}
+		return f[0], fmt.Sprintf("Windows Update (last scan): %s pending, %s security", f[0], f[1]), nil
+
+	case "antivirus":
+		var found, evidence []string
+		seen := make(map[string]bool)
+		// Security Center only exists on client editions; productState
+		// bits 12-15 are 1 when real-time protection is on
+		if out, err := runCommandWithTimeout("powershell", "-Command", postureAntivirusScript); err == nil {
+			for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+				state, product, ok := strings.Cut(strings.TrimSpace(line), "|")
+				if !ok {
+					continue
+				}
+				n, _ := strconv.Atoi(state)
+				if (n>>12)&0xF == 1 {
+					seen[product] = true
+					found = append(found, product)
+					evidence = append(evidence, product+" enabled in Security Center")
+				} else {
+					evidence = append(evidence, product+" disabled in Security Center")
+				}
+			}
+		}
+		var names []string
+		for _, p := range listProcesses() {
+			names = append(names, p.Name)
+		}
+		for _, product := range runningSecurityProducts(names) {
+			if !seen[product] {
+				found = append(found, product)
+				evidence = append(evidence, product+" running")
+			}
+		}
+		if len(evidence) == 0 {
+			evidence = append(evidence, "no antivirus or EDR found")
+		}
+		return strings.Join(found, ", "), strings.Join(evidence, "; "), nil
+
+	case "ssh_root_login":
+		return "", "", errPostureNotApplicable
+	}
+	return "", "", fmt.Errorf("unknown probe %q", name)
+}
+
+// postureRegistryValue reads one registry value; present is false when
+// the key or value does not exist.
+func postureRegistryValue(key, name string) (string, bool, error) {
+	script := fmt.Sprintf(`$p = Get-ItemProperty -Path '%s' -Name '%s' -ErrorAction SilentlyContinue
+if ($p -ne $null) { "1" + (@($p.'%s') -join ',') } else { "0" }`,
+		strings.ReplaceAll(key, "'", "''"), strings.ReplaceAll(name, "'", "''"), strings.ReplaceAll(name, "'", "''"))
+	out, err := runCommandWithTimeout("powershell", "-Command", script)
+	if err != nil {
+		return "", false, err
+	}
+	v := strings.TrimSpace(string(out))
+	if !strings.HasPrefix(v, "1") {
+		return "", false, nil
+	}
+	return v[1:], true, nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Process execution telemetry. The platform monitors (process_linux.go,
+// process_windows.go) report starts and exits; the table keeps the live
+// process tree (plus recently exited processes, whose children and late
+// events still need them) so any event carrying a process_id can be
+// enriched with its full ancestry in sendLog.
+
+const (
+	PROCESS_POLL_INTERVAL = 10 * time.Second
+	PROCESS_EXIT_TTL      = 5 * time.Minute
+	PROCESS_MAX_DEPTH     = 32
+	PROCESS_HASH_MAX_SIZE = 256 * 1024 * 1024
+)
+
+// processInfo is one process as seen by the monitor.
+type processInfo struct {
+	PID         int
+	PPID        int
+	Name        string
+	Image       string
+	CommandLine string
+	User        string
+	StartTime   time.Time
+	SHA256      string
+	Imphash     string
+	SSDeep      string
+	ImageSize   int64
+	exited      time.Time
+}
+
+type processTable struct {
+	mu    sync.RWMutex
+	procs map[int]*processInfo
+}
+
+var processes = &processTable{procs: make(map[int]*processInfo)}
+
+// Live events whose process_id refers to a process on this host right now;
+// log records carry the PID of the logging process at some past time.
+var processEnrichTypes = map[string]bool{"network": true, "network_security": true, "usb": true, "file": true}
+
+// lookup returns a copy of the process with pid.
+func (t *processTable) lookup(pid int) (processInfo, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	p, ok := t.procs[pid]
+	if !ok {
+		return processInfo{}, false
+	}
+	return *p, true
+}
+
+// add records a running process without reporting it (initial snapshot).
+func (t *processTable) add(p processInfo) {
+	t.mu.Lock()
+	t.procs[p.PID] = &p
+	t.mu.Unlock()
+}
+
+// started records a new process and returns its process_start event.
+func (t *processTable) started(p processInfo) LogEntry {
+	if p.SHA256 == "" && p.Image != "" {
+		if h, ok := hashImage(p.Image); ok {
+			p.setHashes(h)
+		}
+	}
+	t.add(p)
+	processImages.record(p, time.Now())
+	return t.processEntry(p, "process_start", nil)
+}
+
+func (p *processInfo) setHashes(h imageHashes) {
+	p.SHA256, p.Imphash, p.SSDeep, p.ImageSize = h.SHA256, h.Imphash, h.SSDeep, h.Size
+}
+
+// hashMissing hashes the live processes that were added without hashes
+// (the startup snapshot) and returns them.
+func (t *processTable) hashMissing() []processInfo {
+	t.mu.RLock()
+	var missing []processInfo
+	for _, p := range t.procs {
+		if p.exited.IsZero() && p.Image != "" && p.SHA256 == "" {
+			missing = append(missing, *p)
+		}
+	}
+	t.mu.RUnlock()
+
+	var hashed []processInfo
+	for _, p := range missing {
+		h, ok := hashImage(p.Image)
+		if !ok {
+			continue
+		}
+		p.setHashes(h)
+		t.mu.Lock()
+		if cur, ok := t.procs[p.PID]; ok && cur.Image == p.Image {
+			cur.setHashes(h)
+		}
+		t.mu.Unlock()
+		hashed = append(hashed, p)
+	}
+	return hashed
+}
+
+// pidsForImage lists the live processes running path.
+func (t *processTable) pidsForImage(path string) []int {
+	t.mu.RLock()
+	var pids []int
+	for pid, p := range t.procs {
+		if p.exited.IsZero() && p.Image == path {
+			pids = append(pids, pid)
+		}
+	}
+	t.mu.RUnlock()
+	sort.Ints(pids)
+	return pids
+}
+
+// exited marks pid as exited and returns its process_exit event.
+func (t *processTable) exited(pid int, now time.Time, extra map[string]interface{}) (LogEntry, bool) {
+	t.mu.Lock()
+	p, ok := t.procs[pid]
+	if !ok || !p.exited.IsZero() {
+		t.mu.Unlock()
+		return LogEntry{}, false
+	}
+	p.exited = now
+	info := *p
+	t.mu.Unlock()
+
+	if extra == nil {
+		extra = make(map[string]interface{})
+	}
+	if !info.StartTime.IsZero() {
+		extra["duration_seconds"] = int(now.Sub(info.StartTime).Seconds())
+	}
+	return t.processEntry(info, "process_exit", extra), true
+}
+
+// sync compares a full snapshot with the table (polling monitors) and
+// returns the start and exit events. PIDs reused by a new process count as
+// an exit and a start.
+func (t *processTable) sync(snapshot []processInfo, now time.Time) []LogEntry {
+	var events []LogEntry
+	seen := make(map[int]bool, len(snapshot))
+	for _, p := range snapshot {
+		seen[p.PID] = true
+		prev, ok := t.lookup(p.PID)
+		if ok && prev.exited.IsZero() && (p.StartTime.IsZero() || prev.StartTime.Equal(p.StartTime)) {
+			continue
+		}
+		if ok && prev.exited.IsZero() {
+			if exit, ok := t.exited(p.PID, now, nil); ok {
+				events = append(events, exit)
+			}
+		}
+		events = append(events, t.started(p))
+	}
+
+	t.mu.RLock()
+	var gone []int
+	for pid, p := range t.procs {
+		if !seen[pid] && p.exited.IsZero() {
+			gone = append(gone, pid)
+		}
+	}
+	t.mu.RUnlock()
+	sort.Ints(gone)
+	for _, pid := range gone {
+		if exit, ok := t.exited(pid, now, nil); ok {
+			events = append(events, exit)
+		}
+	}
+	t.expire(now)
+	return events
+}
+
+// expire forgets processes that exited more than PROCESS_EXIT_TTL ago.
+func (t *processTable) expire(now time.Time) {
+	t.mu.Lock()
+	for pid, p := range t.procs {
+		if !p.exited.IsZero() && now.Sub(p.exited) > PROCESS_EXIT_TTL {
+			delete(t.procs, pid)
+		}
+	}
+	t.mu.Unlock()
+}
+
+// ancestry returns the parent chain of pid, nearest parent first.
+func (t *processTable) ancestry(pid int) []processInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var chain []processInfo
+	seen := map[int]bool{pid: true}
+	p, ok := t.procs[pid]
+	for ok && len(chain) < PROCESS_MAX_DEPTH {
+		parent, found := t.procs[p.PPID]
+		if !found || seen[p.PPID] || p.PPID == 0 {
+			break
+		}
+		// A parent that started after the child is a reused PID
+		if !parent.StartTime.IsZero() && !p.StartTime.IsZero() && parent.StartTime.After(p.StartTime) {
+			break
+		}
+		seen[p.PPID] = true
+		chain = append(chain, *parent)
+		p = parent
+	}
+	return chain
+}
+
+func ancestryList(chain []processInfo) []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(chain))
+	for _, a := range chain {
+		list = append(list, map[string]interface{}{
+			"process_id":   a.PID,
+			"process_name": a.Name,
+			"image":        a.Image,
+			"command_line": a.CommandLine,
+			"user":         a.User,
+		})
+	}
+	return list
+}
+
+func (t *processTable) processEntry(p processInfo, event string, extra map[string]interface{}) LogEntry {
+	raw := map[string]interface{}{
+		"process_id":        p.PID,
+		"parent_process_id": p.PPID,
+		"process_name":      p.Name,
+		"image":             p.Image,
+		"command_line":      p.CommandLine,
+		"user":              p.User,
+		"sha256":            p.SHA256,
+	}
+	if p.Imphash != "" {
+		raw["imphash"] = p.Imphash
+	}
+	if p.SSDeep != "" {
+		raw["ssdeep"] = p.SSDeep
+	}
+	if !p.StartTime.IsZero() {
+		raw["start_time"] = p.StartTime.UTC().Format(time.RFC3339)
+	}
+	for k, v := range extra {
+		raw[k] = v
+	}
+
+	chain := t.ancestry(p.PID)
+	if len(chain) > 0 {
+		raw["parent_image"] = chain[0].Image
+		raw["parent_command_line"] = chain[0].CommandLine
+		raw["process_ancestry"] = ancestryList(chain)
+	}
+
+	ts := time.Now()
+	msg := fmt.Sprintf("Process started: %s (pid %d, ppid %d, user %s)", processDisplay(p), p.PID, p.PPID, p.User)
+	if event == "process_exit" {
+		ts = p.exited
+		msg = fmt.Sprintf("Process exited: %s (pid %d)", processDisplay(p), p.PID)
+	} else if !p.StartTime.IsZero() {
+		ts = p.StartTime
+	}
+
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    "process",
+		Event:      event,
+		Source:     "process-monitor",
+		Severity:   "info",
+		Message:    msg,
+		Timestamp:  ts.UTC().Format(time.RFC3339),
+		RawData:    raw,
+	}
+}
+
+func processDisplay(p processInfo) string {
+	if p.CommandLine != "" {
+		return p.CommandLine
+	}
+	if p.Image != "" {
+		return p.Image
+	}
+	return p.Name
+}
+
+// enrich adds the process and its ancestry to any event with a process_id
+// the table knows (network connections, file and USB events, ...).
+func (t *processTable) enrich(entry *LogEntry) {
+	if entry.RawData == nil || !processEnrichTypes[entry.LogType] {
+		return
+	}
+	if _, done := entry.RawData["process_ancestry"]; done {
+		return
+	}
+	pid := 0
+	switch v := entry.RawData["process_id"].(type) {
+	case int:
+		pid = v
+	case float64:
+		pid = int(v)
+	case string:
+		pid, _ = strconv.Atoi(v)
+	}
+	if pid <= 0 {
+		return
+	}
+	// An exited process may have handed its PID on already
+	p, ok := t.lookup(pid)
+	if !ok || !p.exited.IsZero() {
+		return
+	}
+
+	for k, v := range map[string]string{"image": p.Image, "command_line": p.CommandLine, "user": p.User, "sha256": p.SHA256} {
+		if _, set := entry.RawData[k]; !set && v != "" {
+			entry.RawData[k] = v
+		}
+	}
+	chain := t.ancestry(pid)
+	if len(chain) > 0 {
+		entry.RawData["parent_image"] = chain[0].Image
+		entry.RawData["process_ancestry"] = ancestryList(chain)
+	}
+}
+
+// processNameByPID resolves a PID from the table, lowercase without the
+// extension like Get-Process reports it ("" when unknown).
+func processNameByPID(pid int) string {
+	p, ok := processes.lookup(pid)
+	if !ok {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(p.Name, filepath.Ext(p.Name)))
+}
+
+// sendProcessEvents ships monitor events unless the device is quarantined.
+func sendProcessEvents(events []LogEntry) {
+	policyMutex.RLock()
+	quarantined := isQuarantined
+	policyMutex.RUnlock()
+	if deviceID == "" || quarantined {
+		return
+	}
+	for _, e := range events {
+		sendLog(e)
+	}
+}
@@ -0,0 +1,275 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Linux process monitor: the netlink proc connector reports every exec and
+// exit as it happens (root only); without it /proc is polled.
+
+const (
+	cnIdxProc          = 1
+	cnValProc          = 1
+	procCnMcastListen  = 1
+	procEventExec      = 0x00000002
+	procEventExit      = 0x80000000
+	cnMsgHeaderLen     = 20
+	procEventHeaderLen = 16
+	clockTicks         = 100 // USER_HZ
+	procEventQueueSize = 4096
+)
+
+// procEvent is one exec or exit read from the connector. Exec events carry
+// the /proc data read right away, before a short-lived process is gone.
+type procEvent struct {
+	what  uint32
+	pid   int
+	info  processInfo
+	extra map[string]interface{}
+	now   time.Time
+}
+
+var (
+	bootTime     time.Time
+	bootTimeOnce sync.Once
+)
+
+// runProcessMonitor snapshots the running processes, then follows starts
+// and exits.
+func runProcessMonitor() {
+	for _, p := range listProcesses() {
+		processes.add(p)
+	}
+
+	if err := followProcConnector(); err != nil {
+		logMessage("Process connector unavailable, polling /proc: " + err.Error())
+	}
+	for {
+		time.Sleep(PROCESS_POLL_INTERVAL)
+		sendProcessEvents(processes.sync(listProcesses(), time.Now()))
+	}
+}
+
+// followProcConnector returns when the connector cannot be used or fails.
+func followProcConnector() error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_CONNECTOR)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: cnIdxProc, Pid: uint32(os.Getpid())}); err != nil {
+		return err
+	}
+
+	// nlmsghdr + cn_msg + PROC_CN_MCAST_LISTEN
+	req := make([]byte, nlmsgHeaderLen+cnMsgHeaderLen+4)
+	binary.LittleEndian.PutUint32(req[0:4], uint32(len(req)))
+	binary.LittleEndian.PutUint16(req[4:6], nlmsgTypeDone)
+	binary.LittleEndian.PutUint32(req[12:16], uint32(os.Getpid()))
+	cn := req[nlmsgHeaderLen:]
+	binary.LittleEndian.PutUint32(cn[0:4], cnIdxProc)
+	binary.LittleEndian.PutUint32(cn[4:8], cnValProc)
+	binary.LittleEndian.PutUint16(cn[16:18], 4)
+	binary.LittleEndian.PutUint32(cn[20:24], procCnMcastListen)
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+	logMessage("Process monitor: listening on the proc connector")
+
+	// Hashing a new image can take seconds; it runs on the worker so the
+	// socket is drained fast enough not to overflow during exec bursts.
+	queue := make(chan procEvent, procEventQueueSize)
+	defer close(queue)
+	go applyProcEvents(queue)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == unix.EINTR || err == unix.ENOBUFS {
+				continue // ENOBUFS: events were dropped; the worker's sweep catches up
+			}
+			return err
+		}
+		now := time.Now()
+		// Connector messages are typed NLMSG_DONE, so parseNetlinkMessages does not apply
+		for data := buf[:n]; len(data) >= nlmsgHeaderLen; {
+			length := int(binary.LittleEndian.Uint32(data[0:4]))
+			if length < nlmsgHeaderLen || length > len(data) {
+				break
+			}
+			if ev, ok := decodeProcEvent(data[nlmsgHeaderLen:length], now); ok {
+				select {
+				case queue <- ev:
+				default:
+					// Worker behind: dropped like ENOBUFS, the sweep catches up
+				}
+			}
+			data = data[min(nlAlign(length), len(data)):]
+		}
+	}
+}
+
+// applyProcEvents updates the process table from connector events in
+// order and sends the resulting events.
+func applyProcEvents(queue <-chan procEvent) {
+	lastSweep := time.Now()
+	for ev := range queue {
+		events := ev.apply()
+
+		// Exits of processes that started before we subscribed or whose events were dropped
+		if ev.now.Sub(lastSweep) > time.Minute {
+			events = append(events, processes.sync(listProcesses(), ev.now)...)
+			lastSweep = ev.now
+		}
+		sendProcessEvents(events)
+	}
+}
+
+// decodeProcEvent decodes one cn_msg carrying a proc_event.
+func decodeProcEvent(payload []byte, now time.Time) (procEvent, bool) {
+	if len(payload) < cnMsgHeaderLen+procEventHeaderLen+8 {
+		return procEvent{}, false
+	}
+	ev := payload[cnMsgHeaderLen:]
+	what := binary.LittleEndian.Uint32(ev[0:4])
+	data := ev[procEventHeaderLen:]
+	pid := int(binary.LittleEndian.Uint32(data[0:4]))
+	tgid := int(binary.LittleEndian.Uint32(data[4:8]))
+	if pid != tgid {
+		return procEvent{}, false // thread
+	}
+
+	switch what {
+	case procEventExec:
+		p, ok := readProcProcess(pid)
+		if !ok {
+			return procEvent{}, false
+		}
+		return procEvent{what: what, pid: pid, info: p, now: now}, true
+	case procEventExit:
+		extra := map[string]interface{}{}
+		if len(data) >= 12 {
+			code := binary.LittleEndian.Uint32(data[8:12])
+			extra["exit_code"] = int(code >> 8 & 0xff)
+			if sig := code & 0x7f; sig != 0 {
+				extra["exit_signal"] = int(sig)
+			}
+		}
+		return procEvent{what: what, pid: pid, extra: extra, now: now}, true
+	}
+	return procEvent{}, false
+}
+
+// apply records the event in the process table (hashing new images) and
+// returns the resulting process events.
+func (ev procEvent) apply() []LogEntry {
+	switch ev.what {
+	case procEventExec:
+		var events []LogEntry
+		// exec replaces the image of a known PID: report the old one as exited
+		if prev, known := processes.lookup(ev.pid); known && prev.exited.IsZero() && prev.Image != ev.info.Image {
+			if exit, ok := processes.exited(ev.pid, ev.now, map[string]interface{}{"reason": "exec"}); ok {
+				events = append(events, exit)
+			}
+		}
+		return append(events, processes.started(ev.info))
+	case procEventExit:
+		if exit, ok := processes.exited(ev.pid, ev.now, ev.extra); ok {
+			return []LogEntry{exit}
+		}
+	}
+	return nil
+}
+
+// listProcesses reads every user space process from /proc.
+func listProcesses() []processInfo {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var list []processInfo
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if p, ok := readProcProcess(pid); ok {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// readProcProcess reads one process from /proc; kernel threads are skipped.
+func readProcProcess(pid int) (processInfo, bool) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	stat, err := os.ReadFile(dir + "/stat")
+	if err != nil {
+		return processInfo{}, false
+	}
+	// pid (comm) state ppid ... starttime is field 22; comm may contain spaces
+	s := string(stat)
+	open, close := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+	if open < 0 || close < open {
+		return processInfo{}, false
+	}
+	fields := strings.Fields(s[close+1:])
+	if len(fields) < 20 {
+		return processInfo{}, false
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	flags, _ := strconv.ParseUint(fields[6], 10, 64)
+	if flags&0x00200000 != 0 { // PF_KTHREAD
+		return processInfo{}, false
+	}
+	startTicks, _ := strconv.ParseInt(fields[19], 10, 64)
+
+	p := processInfo{
+		PID:       pid,
+		PPID:      ppid,
+		Name:      s[open+1 : close],
+		StartTime: procBootTime().Add(time.Duration(startTicks) * time.Second / clockTicks),
+	}
+	p.Image, _ = os.Readlink(dir + "/exe")
+	p.Image = strings.TrimSuffix(p.Image, " (deleted)")
+	if cmdline, err := os.ReadFile(dir + "/cmdline"); err == nil {
+		p.CommandLine = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	}
+	if status, err := os.ReadFile(dir + "/status"); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if strings.HasPrefix(line, "Uid:") {
+				if f := strings.Fields(line); len(f) > 1 {
+					p.User = userName(f[1])
+				}
+				break
+			}
+		}
+	}
+	return p, true
+}
+
+func procBootTime() time.Time {
+	bootTimeOnce.Do(func() {
+		data, err := os.ReadFile("/proc/stat")
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "btime ") {
+				sec, _ := strconv.ParseInt(strings.TrimSpace(line[6:]), 10, 64)
+				bootTime = time.Unix(sec, 0)
+			}
+		}
+	})
+	return bootTime
+}
@@ -0,0 +1,8 @@
+//go:build !windows && !linux
+
+package main
+
+// runProcessMonitor has no process source on this platform.
+func runProcessMonitor() {}
+
+func listProcesses() []processInfo { return nil }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestProcessTable(procs ...processInfo) *processTable {
+	t := &processTable{procs: make(map[int]*processInfo)}
+	for _, p := range procs {
+		t.add(p)
+	}
+	return t
+}
+
+func processEvents(entries []LogEntry) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Event+":"+e.RawData["process_name"].(string))
+	}
+	return out
+}
+
+func TestProcessTableSync(t *testing.T) {
+	boot := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	now := boot.Add(time.Hour)
+	initd := processInfo{PID: 1, Name: "init", Image: "/nonexistent/init", StartTime: boot}
+	sshd := processInfo{PID: 100, PPID: 1, Name: "sshd", Image: "/nonexistent/sshd", StartTime: boot.Add(time.Second)}
+	cron := processInfo{PID: 200, PPID: 1, Name: "cron", Image: "/nonexistent/cron", StartTime: boot.Add(2 * time.Second)}
+	table := newTestProcessTable(initd, sshd, cron)
+
+	// unchanged snapshot: nothing to report
+	if events := table.sync([]processInfo{initd, sshd, cron}, now); len(events) != 0 {
+		t.Fatalf("unchanged snapshot: %v", processEvents(events))
+	}
+
+	// PID 200 reused by a new process: the old one exits, the new one starts
+	worker := processInfo{PID: 200, PPID: 100, Name: "worker", Image: "/nonexistent/worker", StartTime: now.Add(-time.Minute)}
+	events := table.sync([]processInfo{initd, sshd, worker}, now)
+	if got, want := processEvents(events), []string{"process_exit:cron", "process_start:worker"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("reused PID: events %v, want %v", got, want)
+	}
+
+	// a snapshot without a start time keeps the entry as it is
+	if events := table.sync([]processInfo{initd, sshd, {PID: 200, PPID: 100, Name: "worker"}}, now); len(events) != 0 {
+		t.Fatalf("snapshot without start time: %v", processEvents(events))
+	}
+
+	// a process gone from the snapshot exits, once
+	events = table.sync([]processInfo{initd, sshd}, now.Add(time.Minute))
+	if got, want := processEvents(events), []string{"process_exit:worker"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("gone: events %v, want %v", got, want)
+	}
+	if d := events[0].RawData["duration_seconds"]; d != 120 {
+		t.Errorf("duration_seconds = %v, want 120", d)
+	}
+	if events := table.sync([]processInfo{initd, sshd}, now.Add(2*time.Minute)); len(events) != 0 {
+		t.Fatalf("exit reported twice: %v", processEvents(events))
+	}
+
+	// exited processes are forgotten after PROCESS_EXIT_TTL
+	table.sync([]processInfo{initd, sshd}, now.Add(time.Minute+PROCESS_EXIT_TTL+time.Second))
+	if _, ok := table.lookup(200); ok {
+		t.Error("exited process kept past PROCESS_EXIT_TTL")
+	}
+}
+
+func TestProcessAncestry(t *testing.T) {
+	boot := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		procs []processInfo
+		pid   int
+		want  []int
+	}{
+		{
+			"full chain",
+			[]processInfo{
+				{PID: 1, Name: "init", StartTime: boot},
+				{PID: 10, PPID: 1, Name: "sshd", StartTime: boot.Add(time.Second)},
+				{PID: 20, PPID: 10, Name: "bash", StartTime: boot.Add(time.Minute)},
+				{PID: 30, PPID: 20, Name: "curl", StartTime: boot.Add(time.Hour)},
+			},
+			30, []int{20, 10, 1},
+		},
+		{
+			"parent started after the child is a reused PID",
+			[]processInfo{
+				{PID: 1, Name: "init", StartTime: boot},
+				{PID: 10, PPID: 1, Name: "new-owner", StartTime: boot.Add(2 * time.Hour)},
+				{PID: 30, PPID: 10, Name: "orphan", StartTime: boot.Add(time.Hour)},
+			},
+			30, nil,
+		},
+		{
+			"pid cycle",
+			[]processInfo{
+				{PID: 10, PPID: 20, Name: "a"},
+				{PID: 20, PPID: 30, Name: "b"},
+				{PID: 30, PPID: 10, Name: "c"},
+			},
+			10, []int{20, 30},
+		},
+		{
+			"own parent",
+			[]processInfo{{PID: 10, PPID: 10, Name: "self"}},
+			10, nil,
+		},
+		{
+			"unknown parent",
+			[]processInfo{{PID: 10, PPID: 999, Name: "lost"}},
+			10, nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, p := range newTestProcessTable(tt.procs...).ancestry(tt.pid) {
+				got = append(got, p.PID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ancestry = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// the depth is capped
+	deep := newTestProcessTable()
+	for pid := 1; pid <= PROCESS_MAX_DEPTH+10; pid++ {
+		deep.add(processInfo{PID: pid, PPID: pid - 1})
+	}
+	if got := len(deep.ancestry(PROCESS_MAX_DEPTH + 10)); got != PROCESS_MAX_DEPTH {
+		t.Errorf("deep chain: %d ancestors, want %d", got, PROCESS_MAX_DEPTH)
+	}
+}
+
+func TestProcessEnrich(t *testing.T) {
+	boot := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	table := newTestProcessTable(
+		processInfo{PID: 1, Name: "init", Image: "/sbin/init", StartTime: boot},
+		processInfo{PID: 42, PPID: 1, Name: "curl", Image: "/usr/bin/curl", CommandLine: "curl http://example.com", User: "alice", SHA256: "abc", StartTime: boot.Add(time.Hour)},
+		processInfo{PID: 43, PPID: 1, Name: "gone", Image: "/usr/bin/gone", StartTime: boot.Add(time.Hour)},
+	)
+	table.exited(43, boot.Add(2*time.Hour), nil)
+
+	tests := []struct {
+		name     string
+		logType  string
+		pid      interface{}
+		enriched bool
+	}{
+		{"int", "network", 42, true},
+		{"float64 from JSON", "network_security", float64(42), true},
+		{"string", "usb", "42", true},
+		{"unparsable string", "network", "curl", false},
+		{"unknown pid", "network", 4242, false},
+		{"exited within PROCESS_EXIT_TTL", "file", 43, false},
+		{"log record pid", "system", 42, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := LogEntry{LogType: tt.logType, RawData: map[string]interface{}{"process_id": tt.pid}}
+			table.enrich(&entry)
+			_, got := entry.RawData["process_ancestry"]
+			if got != tt.enriched {
+				t.Fatalf("enriched = %v, want %v: %v", got, tt.enriched, entry.RawData)
+			}
+			if !tt.enriched {
+				if len(entry.RawData) != 1 {
+					t.Errorf("fields added: %v", entry.RawData)
+				}
+				return
+			}
+			if entry.RawData["image"] != "/usr/bin/curl" || entry.RawData["user"] != "alice" || entry.RawData["parent_image"] != "/sbin/init" {
+				t.Errorf("raw data = %v", entry.RawData)
+			}
+		})
+	}
+
+	// fields the event already has are kept
+	entry := LogEntry{LogType: "network", RawData: map[string]interface{}{"process_id": 42, "image": "C:\\reported.exe"}}
+	table.enrich(&entry)
+	if entry.RawData["image"] != "C:\\reported.exe" {
+		t.Errorf("image overwritten: %v", entry.RawData["image"])
+	}
+}
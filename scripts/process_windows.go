@@ -0,0 +1,86 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Windows process monitor: polls Win32_Process through CIM. Processes that
+// live shorter than the poll interval are only seen in the Security log
+// (4688/4689 with process creation auditing enabled).
+
+const processListScript = `
+	$owners = @{}
+	Get-Process -IncludeUserName -ErrorAction SilentlyContinue | ForEach-Object { $owners[$_.Id] = $_.UserName }
+	$items = Get-CimInstance Win32_Process -ErrorAction SilentlyContinue |
+		Select-Object ProcessId, ParentProcessId, Name, ExecutablePath, CommandLine,
+			@{Name='CreationDate';Expression={ if ($_.CreationDate) { $_.CreationDate.ToUniversalTime().ToString('o') } }},
+			@{Name='UserName';Expression={ $owners[[int]$_.ProcessId] }}
+	ConvertTo-Json -InputObject @($items) -Compress
+`
+
+// "/Date(1700000000000)/" as written by older ConvertTo-Json
+var jsonDateRegex = regexp.MustCompile(`^/Date\((\d+)\)/$`)
+
+// runProcessMonitor snapshots the running processes, then reports starts
+// and exits every PROCESS_POLL_INTERVAL.
+func runProcessMonitor() {
+	for _, p := range listProcesses() {
+		processes.add(p)
+	}
+	for {
+		time.Sleep(PROCESS_POLL_INTERVAL)
+		list := listProcesses()
+		if len(list) == 0 {
+			continue // PowerShell failed: do not report every process as exited
+		}
+		sendProcessEvents(processes.sync(list, time.Now()))
+	}
+}
+
+func listProcesses() []processInfo {
+	out, err := runCommandWithTimeout("powershell", "-Command", processListScript)
+	if err != nil || len(out) == 0 {
+		return nil
+	}
+
+	var rows []struct {
+		ProcessId       int
+		ParentProcessId int
+		Name            string
+		ExecutablePath  string
+		CommandLine     string
+		CreationDate    string
+		UserName        string
+	}
+	if json.Unmarshal(out, &rows) != nil {
+		return nil
+	}
+
+	list := make([]processInfo, 0, len(rows))
+	for _, r := range rows {
+		if r.ProcessId == 0 || r.ProcessId == 4 { // System Idle Process, System
+			continue
+		}
+		p := processInfo{
+			PID:         r.ProcessId,
+			PPID:        r.ParentProcessId,
+			Name:        r.Name,
+			Image:       r.ExecutablePath,
+			CommandLine: r.CommandLine,
+			User:        r.UserName,
+		}
+		if t, err := time.Parse(time.RFC3339Nano, r.CreationDate); err == nil {
+			p.StartTime = t
+		} else if m := jsonDateRegex.FindStringSubmatch(r.CreationDate); m != nil {
+			ms, _ := strconv.ParseInt(m[1], 10, 64)
+			p.StartTime = time.UnixMilli(ms)
+		}
+		list = append(list, p)
+	}
+	return list
+}
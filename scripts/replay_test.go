@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// replayEvents replays a fixture from testdata and returns the entries it
+// produced, starting from an empty neighbor table.
+func replayEvents(t *testing.T, path string) []LogEntry {
+	t.Helper()
+	neighbors = &neighborTable{entries: make(map[string]*neighborEntry)}
+
+	var out bytes.Buffer
+	if err := replayCapture(path, &out); err != nil {
+		t.Fatalf("replay %s: %v", path, err)
+	}
+	var entries []LogEntry
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var e LogEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("decode replay output: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestReplayFixtures(t *testing.T) {
+	tests := []struct {
+		file   string
+		events []string
+	}{
+		// two neighbors behind one port, then the second one shuts down
+		{"testdata/lldp.pcap", []string{"neighbor_added", "neighbor_added", "neighbor_expired"}},
+		// 192.168.1.20 flips between two MACs; a third MAC floods gratuitous ARP
+		{"testdata/arp.pcap", []string{"duplicate_ip", "gratuitous_arp_flood"}},
+		{"testdata/dns.pcap", []string{"dns_query", "dns_response"}},
+		// the CDP neighbor moves from Gi1/0/7 to Gi1/0/9
+		{"testdata/cdp.pcap", []string{"neighbor_added", "neighbor_changed"}},
+		{"testdata/edp.pcap", []string{"neighbor_added"}},
+		{"testdata/lldp_med.pcap", []string{"neighbor_added"}},
+		// a TLS 1.3 hello, application data, then a TLS 1.2-only hello
+		{"testdata/tls.pcap", []string{"tls_client_hello", "tls_client_hello"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			var events []string
+			for _, e := range replayEvents(t, tt.file) {
+				events = append(events, e.Event)
+			}
+			if !reflect.DeepEqual(events, tt.events) {
+				t.Errorf("events = %v, want %v", events, tt.events)
+			}
+		})
+	}
+}
+
+func TestReplayDetails(t *testing.T) {
+	entries := replayEvents(t, "testdata/dns.pcap")
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	resp := entries[1].RawData
+	if resp["query"] != "example.com" || resp["local_address"] != "192.168.1.20" {
+		t.Errorf("dns_response raw data = %v", resp)
+	}
+	if answers, _ := resp["answers"].([]interface{}); len(answers) != 1 || answers[0] != "93.184.216.34" {
+		t.Errorf("answers = %v, want [93.184.216.34]", resp["answers"])
+	}
+
+	entries = replayEvents(t, "testdata/arp.pcap")
+	if entries[0].RawData["ip_address"] != "192.168.1.20" || entries[0].RawData["previous_mac"] != "02:00:00:00:00:0b" {
+		t.Errorf("duplicate_ip raw data = %v", entries[0].RawData)
+	}
+}
+
+func TestReplayDiscoveryDetails(t *testing.T) {
+	tests := []struct {
+		file string
+		raw  map[string]interface{} // JSON-decoded values of the first entry
+	}{
+		{"testdata/cdp.pcap", map[string]interface{}{
+			"protocol":           "cdp",
+			"switch_name":        "core-sw1.example.com",
+			"port_id":            "GigabitEthernet1/0/7",
+			"platform":           "cisco WS-C3750X-48P",
+			"system_description": "Cisco IOS Software, C3750E Software, Version 15.2(4)E10",
+			"port_vlan_id":       20.0,
+			"ttl":                180.0,
+			"cdp_version":        2.0,
+			"full_duplex":        true,
+			"vtp_domain":         "CORP",
+			"capabilities":       []interface{}{"switch", "igmp_filter"},
+			"management_addresses": []interface{}{
+				map[string]interface{}{"family": "ipv4", "address": "192.0.2.2"},
+				map[string]interface{}{"family": "ipv4", "address": "10.0.0.2"},
+			},
+		}},
+		{"testdata/edp.pcap", map[string]interface{}{
+			"protocol":           "edp",
+			"switch_name":        "x450-core",
+			"chassis_id":         "00:04:96:12:34:56",
+			"port_id":            "1:24",
+			"system_description": "ExtremeXOS 16.1.2.14",
+			"edp_version":        1.0,
+			"vlans": []interface{}{
+				map[string]interface{}{"id": 10.0, "name": "Default"},
+				map[string]interface{}{"id": 20.0, "name": "voice"},
+			},
+			"management_addresses": []interface{}{
+				map[string]interface{}{"family": "ipv4", "address": "10.0.10.1"},
+			},
+		}},
+		{"testdata/lldp_med.pcap", map[string]interface{}{
+			"protocol":     "lldp",
+			"switch_name":  "SEP001122334455",
+			"platform":     "Cisco Systems, Inc. CP-8845",
+			"capabilities": []interface{}{"bridge", "telephone"},
+			"lldp_med": map[string]interface{}{
+				"device_class": "endpoint_class_3",
+				"capabilities": []interface{}{"capabilities", "network_policy", "location", "inventory"},
+				"network_policies": []interface{}{map[string]interface{}{
+					"application": "voice", "unknown": false, "tagged": true, "vlan_id": 100.0, "l2_priority": 5.0, "dscp": 46.0,
+				}},
+				"location": map[string]interface{}{
+					"format":       "civic",
+					"country_code": "US",
+					"civic":        map[string]interface{}{"city": "Springfield", "house_number": "742"},
+				},
+				"power":             map[string]interface{}{"type": "pd", "source": 1.0, "priority": "high", "watts": 6.5},
+				"hardware_revision": "1",
+				"firmware_revision": "1.2",
+				"software_revision": "sip88xx.14-1-1",
+				"serial_number":     "FCH1234ABCD",
+				"manufacturer":      "Cisco Systems, Inc.",
+				"model":             "CP-8845",
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			entries := replayEvents(t, tt.file)
+			if len(entries) == 0 {
+				t.Fatal("no entries")
+			}
+			raw := entries[0].RawData
+			for k, want := range tt.raw {
+				if !reflect.DeepEqual(raw[k], want) {
+					t.Errorf("%s = %#v, want %#v", k, raw[k], want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayTLSDetails(t *testing.T) {
+	entries := replayEvents(t, "testdata/tls.pcap")
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	tests := []map[string]interface{}{
+		{
+			"server_name":        "www.example.org",
+			"record_version":     "TLS1.0",
+			"hello_version":      "TLS1.2",
+			"supported_versions": []interface{}{"TLS1.3", "TLS1.2"},
+			"alpn":               []interface{}{"h2", "http/1.1"},
+			"remote_address":     "93.184.215.14",
+			"remote_port":        443.0,
+		},
+		{
+			"server_name":        "legacy.example.net",
+			"record_version":     "TLS1.0",
+			"hello_version":      "TLS1.2",
+			"supported_versions": nil,
+			"alpn":               nil,
+			"remote_address":     "198.51.100.7",
+			"remote_port":        8443.0,
+		},
+	}
+	for i, want := range tests {
+		raw := entries[i].RawData
+		for k, v := range want {
+			if !reflect.DeepEqual(raw[k], v) {
+				t.Errorf("hello %d: %s = %#v, want %#v", i, k, raw[k], v)
+			}
+		}
+		if n, _ := raw["cipher_suite_count"].(float64); n == 0 {
+			t.Errorf("hello %d: no cipher suites counted", i)
+		}
+	}
+	if want := "TLS ClientHello to www.example.org"; entries[0].Message != want {
+		t.Errorf("message = %q, want %q", entries[0].Message, want)
+	}
+}
+
+func TestReplayLeavesLiveMonitorAlone(t *testing.T) {
+	replayEvents(t, "testdata/arp.pcap")
+	arpWatch.mu.Lock()
+	defer arpWatch.mu.Unlock()
+	if arpWatch.anyScope {
+		t.Error("replay left the live ARP monitor in any-scope mode")
+	}
+	if len(arpWatch.bindings) != 0 {
+		t.Errorf("replay leaked %d bindings into the live ARP monitor", len(arpWatch.bindings))
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Process image inventory and reputation. Every executable seen running is
+// reported once (and again when its hashes change) as a process_image
+// inventory entry, and its SHA-256 is looked up on the server in batches.
+// The server aggregates the inventory of all hosts, so an unknown binary
+// that suddenly appears on many machines stands out there; the agent only
+// raises alerts for the verdicts the server returns.
+
+const (
+	REPUTATION_INTERVAL    = 5 * time.Minute
+	REPUTATION_BATCH_SIZE  = 100
+	REPUTATION_RECHECK     = 24 * time.Hour
+	REPUTATION_NO_ANSWER   = 6 * time.Hour // hashes the server returned no result for
+	IMAGE_INVENTORY_BATCH  = 200
+	IMAGE_INVENTORY_EXPIRY = 7 * 24 * time.Hour
+)
+
+// processImage is one executable version (path + SHA-256) seen on the host.
+type processImage struct {
+	Path       string
+	SHA256     string
+	Imphash    string
+	SSDeep     string
+	Size       int64
+	FirstSeen  time.Time
+	LastSeen   time.Time
+	Processes  int
+	Verdict    string
+	Prevalence int
+	reported   bool
+	recheckAt  time.Time
+}
+
+type imageInventory struct {
+	mu     sync.Mutex
+	images map[string]*processImage // "path|sha256"
+}
+
+var processImages = &imageInventory{images: make(map[string]*processImage)}
+
+type reputationResult struct {
+	SHA256     string `json:"sha256"`
+	Verdict    string `json:"verdict"`    // malicious, suspicious, clean, unknown
+	Prevalence int    `json:"prevalence"` // hosts that reported the hash
+	FirstSeen  string `json:"first_seen"` // first report across all hosts
+	Name       string `json:"name,omitempty"`
+}
+
+// record adds a process to the image inventory.
+func (inv *imageInventory) record(p processInfo, now time.Time) {
+	if p.SHA256 == "" || p.Image == "" {
+		return
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	key := p.Image + "|" + p.SHA256
+	img, ok := inv.images[key]
+	if !ok {
+		img = &processImage{Path: p.Image, SHA256: p.SHA256, Size: p.ImageSize, FirstSeen: now}
+		inv.images[key] = img
+	}
+	// extended hashes may arrive after the image was first reported
+	if p.Imphash != img.Imphash || p.SSDeep != img.SSDeep {
+		img.Imphash, img.SSDeep = p.Imphash, p.SSDeep
+		img.reported = false
+	}
+	img.LastSeen = now
+	img.Processes++
+}
+
+// runImageInventory hashes the processes found at startup, then reports
+// the inventory and looks up reputations every REPUTATION_INTERVAL.
+func runImageInventory() {
+	for {
+		now := time.Now()
+		for _, p := range processes.hashMissing() {
+			processImages.record(p, now)
+		}
+		imageHashCacheTable.save()
+
+		policyMutex.RLock()
+		quarantined := isQuarantined
+		policyMutex.RUnlock()
+		if deviceID != "" && !quarantined {
+			processImages.expire(now)
+			processImages.sendInventory()
+			processImages.checkReputation(now)
+		}
+		time.Sleep(REPUTATION_INTERVAL)
+	}
+}
+
+func (inv *imageInventory) expire(now time.Time) {
+	inv.mu.Lock()
+	for key, img := range inv.images {
+		if now.Sub(img.LastSeen) > IMAGE_INVENTORY_EXPIRY {
+			delete(inv.images, key)
+		}
+	}
+	inv.mu.Unlock()
+}
+
+// sendInventory ships the images not reported yet.
+func (inv *imageInventory) sendInventory() {
+	inv.mu.Lock()
+	var pending []*processImage
+	for _, img := range inv.images {
+		if !img.reported {
+			pending = append(pending, img)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].FirstSeen.Before(pending[j].FirstSeen) })
+	var batches [][]map[string]interface{}
+	for start := 0; start < len(pending); start += IMAGE_INVENTORY_BATCH {
+		var batch []map[string]interface{}
+		for _, img := range pending[start:min(start+IMAGE_INVENTORY_BATCH, len(pending))] {
+			batch = append(batch, img.inventoryRecord())
+			img.reported = true
+		}
+		batches = append(batches, batch)
+	}
+	inv.mu.Unlock()
+
+	for _, batch := range batches {
+		sendLog(LogEntry{
+			DeviceID:   deviceID,
+			DeviceName: deviceName,
+			Hostname:   getHostname(),
+			LogType:    "inventory",
+			Event:      "process_image",
+			Source:     "process-monitor",
+			Severity:   "info",
+			Message:    fmt.Sprintf("Process image inventory: %d new or changed executables", len(batch)),
+			Timestamp:  time.Now().UTC().Format(time.RFC3339),
+			RawData:    map[string]interface{}{"images": batch, "count": len(batch)},
+		})
+	}
+}
+
+func (img *processImage) inventoryRecord() map[string]interface{} {
+	rec := map[string]interface{}{
+		"image":         img.Path,
+		"file_name":     filepath.Base(img.Path),
+		"sha256":        img.SHA256,
+		"size":          img.Size,
+		"first_seen":    img.FirstSeen.UTC().Format(time.RFC3339),
+		"last_seen":     img.LastSeen.UTC().Format(time.RFC3339),
+		"process_count": img.Processes,
+	}
+	if img.Imphash != "" {
+		rec["imphash"] = img.Imphash
+	}
+	if img.SSDeep != "" {
+		rec["ssdeep"] = img.SSDeep
+	}
+	if img.Verdict != "" {
+		rec["verdict"] = img.Verdict
+	}
+	return rec
+}
+
+// checkReputation looks up the hashes that are due and alerts on bad verdicts.
+func (inv *imageInventory) checkReputation(now time.Time) {
+	hashes := inv.dueHashes(now)
+	for start := 0; start < len(hashes); start += REPUTATION_BATCH_SIZE {
+		batch := hashes[start:min(start+REPUTATION_BATCH_SIZE, len(hashes))]
+		results, err := queryReputation(batch)
+		if err != nil {
+			logMessage("Reputation lookup error: " + err.Error())
+			return // retried next interval
+		}
+		for _, alert := range inv.applyReputation(batch, results, now) {
+			sendLog(alert)
+		}
+	}
+}
+
+// dueHashes lists the hashes never looked up or whose recheck time passed.
+func (inv *imageInventory) dueHashes(now time.Time) []string {
+	inv.mu.Lock()
+	var hashes []string
+	queued := make(map[string]bool)
+	for _, img := range inv.images {
+		if !queued[img.SHA256] && !now.Before(img.recheckAt) {
+			queued[img.SHA256] = true
+			hashes = append(hashes, img.SHA256)
+		}
+	}
+	inv.mu.Unlock()
+	sort.Strings(hashes)
+	return hashes
+}
+
+func queryReputation(hashes []string) ([]reputationResult, error) {
+	data, _ := json.Marshal(map[string]interface{}{"device_id": deviceID, "hashes": hashes})
+	url := fmt.Sprintf("%s/api/reputation/lookup", apiURL)
+	client := http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, body)
+	}
+	var reply struct {
+		Results []reputationResult `json:"results"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Results, nil
+}
+
+// applyReputation stores the verdicts for the queried hashes and returns an
+// alert for every image whose verdict became malicious or suspicious.
+// Hashes without a result are asked again after REPUTATION_NO_ANSWER.
+func (inv *imageInventory) applyReputation(queried []string, results []reputationResult, now time.Time) []LogEntry {
+	asked := make(map[string]bool, len(queried))
+	for _, h := range queried {
+		asked[h] = true
+	}
+	bySHA := make(map[string]reputationResult, len(results))
+	for _, r := range results {
+		bySHA[r.SHA256] = r
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	var alerts []LogEntry
+	for _, img := range inv.images {
+		if !asked[img.SHA256] {
+			continue
+		}
+		r, ok := bySHA[img.SHA256]
+		if !ok {
+			img.recheckAt = now.Add(REPUTATION_NO_ANSWER)
+			continue
+		}
+		img.recheckAt = now.Add(REPUTATION_RECHECK)
+		changed := r.Verdict != img.Verdict
+		img.Verdict, img.Prevalence = r.Verdict, r.Prevalence
+		if !changed || (r.Verdict != "malicious" && r.Verdict != "suspicious") {
+			continue
+		}
+		severity := "high"
+		if r.Verdict == "malicious" {
+			severity = "critical"
+		}
+		raw := img.inventoryRecord()
+		raw["prevalence"] = r.Prevalence
+		raw["global_first_seen"] = r.FirstSeen
+		raw["reputation_name"] = r.Name
+		raw["process_ids"] = processes.pidsForImage(img.Path)
+		alerts = append(alerts, LogEntry{
+			DeviceID:   deviceID,
+			DeviceName: deviceName,
+			Hostname:   getHostname(),
+			LogType:    "security",
+			Event:      r.Verdict + "_executable",
+			Source:     "reputation",
+			Severity:   severity,
+			Message:    fmt.Sprintf("%s executable: %s (sha256 %s, seen on %d hosts)", r.Verdict, img.Path, img.SHA256, r.Prevalence),
+			Timestamp:  now.UTC().Format(time.RFC3339),
+			RawData:    raw,
+		})
+	}
+	return alerts
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReputationRecheck(t *testing.T) {
+	agentDir = t.TempDir()
+	inv := &imageInventory{images: make(map[string]*processImage)}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, p := range []processInfo{
+		{Image: "/usr/bin/known", SHA256: "aaa"},
+		{Image: "/tmp/dropper", SHA256: "bbb"},
+		{Image: "/opt/unanswered", SHA256: "ccc"},
+		{Image: "/opt/copy-of-known", SHA256: "aaa"},
+	} {
+		inv.record(p, now)
+	}
+
+	due := inv.dueHashes(now)
+	if want := []string{"aaa", "bbb", "ccc"}; !reflect.DeepEqual(due, want) {
+		t.Fatalf("first lookup = %v, want %v", due, want)
+	}
+
+	alerts := inv.applyReputation(due, []reputationResult{
+		{SHA256: "aaa", Verdict: "clean", Prevalence: 40},
+		{SHA256: "bbb", Verdict: "malicious", Prevalence: 1, Name: "Dropper"},
+	}, now)
+	if len(alerts) != 1 || alerts[0].Event != "malicious_executable" || alerts[0].Severity != "critical" {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+
+	// Every queried hash waits, including the one the server did not answer
+	if due := inv.dueHashes(now.Add(REPUTATION_INTERVAL)); len(due) != 0 {
+		t.Errorf("re-queried after one interval: %v", due)
+	}
+	if due := inv.dueHashes(now.Add(REPUTATION_NO_ANSWER)); !reflect.DeepEqual(due, []string{"ccc"}) {
+		t.Errorf("after REPUTATION_NO_ANSWER = %v, want [ccc]", due)
+	}
+	if due := inv.dueHashes(now.Add(REPUTATION_RECHECK)); len(due) != 3 {
+		t.Errorf("after REPUTATION_RECHECK = %v, want all hashes", due)
+	}
+
+	// An unchanged verdict does not alert again
+	if alerts := inv.applyReputation([]string{"bbb"}, []reputationResult{{SHA256: "bbb", Verdict: "malicious"}}, now.Add(REPUTATION_RECHECK)); len(alerts) != 0 {
+		t.Errorf("repeated verdict alerted: %+v", alerts)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rogue access point / evil-twin detection. The server sends the approved
+// corporate access points with the quarantine status poll; every Wi-Fi scan
+// result is checked against them, and the gateway MAC seen behind each SSID
+// and gateway address is remembered so an attacker answering for the gateway
+// is noticed. Keying on the gateway address too keeps roaming between sites
+// that share an SSID (eduroam, a guest network) from looking like a change.
+
+// ApprovedAccessPoint is one server-approved corporate BSSID.
+type ApprovedAccessPoint struct {
+	SSID     string `json:"ssid"`
+	BSSID    string `json:"bssid"`
+	Security string `json:"security"` // minimum expected, e.g. "wpa2-enterprise"
+}
+
+var (
+	approvedAccessPoints []ApprovedAccessPoint // guarded by policyMutex
+
+	rogueAPMutex sync.Mutex
+	// interface -> findings already reported for its current association, so
+	// each condition alerts once per SSID and BSSID
+	rogueAPReported = make(map[string]*rogueAPAssociation)
+	// SSID|gateway IP -> gateway MAC last seen while associated to it
+	ssidGatewayMAC = make(map[string]string)
+)
+
+type rogueAPAssociation struct {
+	key      string // SSID|BSSID
+	reported map[string]bool
+}
+
+// wifiSecurityRank orders security types for downgrade checks.
+func wifiSecurityRank(security string) int {
+	switch security {
+	case WIFI_SECURITY_OPEN:
+		return 0
+	case WIFI_SECURITY_WEP, WIFI_SECURITY_OWE:
+		return 1
+	case WIFI_SECURITY_WPA:
+		return 2
+	case WIFI_SECURITY_WPA2_PERSONAL, WIFI_SECURITY_WPA2_ENTERPRISE:
+		return 3
+	case WIFI_SECURITY_WPA3_PERSONAL, WIFI_SECURITY_WPA3_ENTERPRISE:
+		return 4
+	}
+	return -1 // unknown: never treated as a downgrade
+}
+
+// checkRogueAccessPoint compares an association against the approved list.
+func checkRogueAccessPoint(a wifiAssociation, now time.Time) {
+	policyMutex.RLock()
+	approved := approvedAccessPoints
+	policyMutex.RUnlock()
+
+	var findings []string
+	raw := map[string]interface{}{
+		"interface": a.Interface,
+		"ssid":      a.SSID,
+		"bssid":     a.BSSID,
+		"security":  a.Security,
+		"signal":    a.signalText(),
+	}
+
+	corporate := false
+	bssidApproved := false
+	expected := ""
+	var approvedBSSIDs []string
+	for _, ap := range approved {
+		if ap.SSID != a.SSID {
+			continue
+		}
+		corporate = true
+		approvedBSSIDs = append(approvedBSSIDs, strings.ToLower(ap.BSSID))
+		if strings.EqualFold(ap.BSSID, a.BSSID) {
+			bssidApproved = true
+		}
+		if wifiSecurityRank(ap.Security) > wifiSecurityRank(expected) {
+			expected = ap.Security
+		}
+	}
+
+	if corporate {
+		if !bssidApproved {
+			findings = append(findings, "rogue_access_point")
+			raw["approved_bssids"] = approvedBSSIDs
+		}
+		if expected == "" {
+			expected = WIFI_SECURITY_WPA2_PERSONAL
+		}
+		if rank := wifiSecurityRank(a.Security); rank >= 0 && rank < wifiSecurityRank(expected) {
+			findings = append(findings, "wifi_security_downgrade")
+			raw["expected_security"] = expected
+		}
+	}
+
+	if gatewayIP, gatewayMAC := defaultGateway(a.Interface); gatewayMAC != "" {
+		gatewayMAC = strings.ToLower(gatewayMAC)
+		raw["gateway_ip"] = gatewayIP
+		raw["gateway_mac"] = gatewayMAC
+
+		if previous := observeGatewayMAC(a.SSID, gatewayIP, gatewayMAC); previous != "" {
+			findings = append(findings, "gateway_mac_changed")
+			raw["previous_gateway_mac"] = previous
+		}
+	}
+
+	for _, finding := range findings {
+		// a second gateway flip on the same association is a new finding
+		dedup := finding
+		if finding == "gateway_mac_changed" {
+			dedup += "|" + fmt.Sprint(raw["gateway_mac"])
+		}
+		if rogueAPAlreadyReported(a.Interface, a.SSID+"|"+a.BSSID, dedup) {
+			continue
+		}
+
+		var severity, msg string
+		switch finding {
+		case "rogue_access_point":
+			severity = "critical"
+			msg = fmt.Sprintf("Associated to corporate SSID %q via unapproved BSSID %s", a.SSID, a.BSSID)
+		case "wifi_security_downgrade":
+			severity = "high"
+			msg = fmt.Sprintf("Corporate SSID %q is using %s security (expected %s)", a.SSID, a.Security, expected)
+		case "gateway_mac_changed":
+			severity = "high"
+			msg = fmt.Sprintf("Gateway MAC on SSID %q changed from %v to %v", a.SSID, raw["previous_gateway_mac"], raw["gateway_mac"])
+		}
+		logMessage("⚠️ WiFi: " + msg)
+		sendLog(LogEntry{
+			DeviceID:     deviceID,
+			DeviceName:   deviceName,
+			Hostname:     getHostname(),
+			LogType:      "network_security",
+			HardwareType: "wifi_ap",
+			Event:        finding,
+			Source:       "wifi-agent",
+			Severity:     severity,
+			Message:      msg,
+			Timestamp:    now.UTC().Format(time.RFC3339),
+			RawData:      raw,
+		})
+	}
+}
+
+// observeGatewayMAC remembers the gateway MAC seen behind an SSID and gateway
+// address and returns the previous one when it changed.
+func observeGatewayMAC(ssid, gatewayIP, gatewayMAC string) string {
+	key := ssid + "|" + gatewayIP
+	rogueAPMutex.Lock()
+	defer rogueAPMutex.Unlock()
+	previous := ssidGatewayMAC[key]
+	ssidGatewayMAC[key] = gatewayMAC
+	if previous == gatewayMAC {
+		return ""
+	}
+	return previous
+}
+
+// rogueAPAlreadyReported tells whether finding was already reported for the
+// current association of iface, and records it. A new SSID or BSSID starts
+// over.
+func rogueAPAlreadyReported(iface, association, finding string) bool {
+	rogueAPMutex.Lock()
+	defer rogueAPMutex.Unlock()
+	current := rogueAPReported[iface]
+	if current == nil || current.key != association {
+		current = &rogueAPAssociation{key: association, reported: make(map[string]bool)}
+		rogueAPReported[iface] = current
+	}
+	if current.reported[finding] {
+		return true
+	}
+	current.reported[finding] = true
+	return false
+}
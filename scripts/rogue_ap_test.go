@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestObserveGatewayMACPerNetwork(t *testing.T) {
+	ssidGatewayMAC = make(map[string]string)
+
+	steps := []struct {
+		gatewayIP, mac, previous string
+	}{
+		{"10.10.0.1", "00:00:5e:00:01:01", ""},
+		{"10.10.0.1", "00:00:5e:00:01:01", ""},
+		// roaming to another campus on the same SSID: different gateway
+		{"172.20.0.1", "00:00:5e:00:01:02", ""},
+		{"10.10.0.1", "00:00:5e:00:01:01", ""},
+		// someone else answers for the gateway of the first campus
+		{"10.10.0.1", "de:ad:be:ef:00:01", "00:00:5e:00:01:01"},
+	}
+	for i, s := range steps {
+		if got := observeGatewayMAC("eduroam", s.gatewayIP, s.mac); got != s.previous {
+			t.Errorf("step %d: previous = %q, want %q", i, got, s.previous)
+		}
+	}
+}
+
+func TestRogueAPReportedOncePerAssociation(t *testing.T) {
+	rogueAPReported = make(map[string]*rogueAPAssociation)
+
+	steps := []struct {
+		association, finding string
+		already              bool
+	}{
+		{"Corp|00:11:22:33:44:55", "rogue_access_point", false},
+		// a new finding on the same association does not resend the first one
+		{"Corp|00:11:22:33:44:55", "gateway_mac_changed|de:ad:be:ef:00:01", false},
+		{"Corp|00:11:22:33:44:55", "rogue_access_point", true},
+		{"Corp|00:11:22:33:44:55", "gateway_mac_changed|de:ad:be:ef:00:01", true},
+		// reassociating to another BSSID starts over
+		{"Corp|00:11:22:33:44:66", "rogue_access_point", false},
+	}
+	for i, s := range steps {
+		if got := rogueAPAlreadyReported("wlan0", s.association, s.finding); got != s.already {
+			t.Errorf("step %d: already reported = %v, want %v", i, got, s.already)
+		}
+	}
+}
+
+func TestCheckRogueAccessPoint(t *testing.T) {
+	agentDir = t.TempDir()
+	savedPolicy := approvedAccessPoints
+	approvedAccessPoints = []ApprovedAccessPoint{
+		{SSID: "Corp", BSSID: "00:11:22:33:44:55", Security: WIFI_SECURITY_WPA2_ENTERPRISE},
+		{SSID: "Corp", BSSID: "AA:BB:CC:00:00:01", Security: WIFI_SECURITY_WPA2_PERSONAL},
+		{SSID: "Lab", BSSID: "00:11:22:33:55:01"},
+	}
+	defer func() { approvedAccessPoints = savedPolicy }()
+
+	tests := []struct {
+		name     string
+		ssid     string
+		bssid    string
+		security string
+		events   []string
+		expected string // expected_security of a downgrade
+	}{
+		{"approved", "Corp", "00:11:22:33:44:55", WIFI_SECURITY_WPA2_ENTERPRISE, nil, ""},
+		{"approved bssid in another case", "Corp", "aa:bb:cc:00:00:01", WIFI_SECURITY_WPA3_PERSONAL, nil, ""},
+		{"unapproved bssid", "Corp", "de:ad:be:ef:00:01", WIFI_SECURITY_WPA2_ENTERPRISE, []string{"rogue_access_point"}, ""},
+		{"downgrade to open", "Corp", "00:11:22:33:44:55", WIFI_SECURITY_OPEN, []string{"wifi_security_downgrade"}, WIFI_SECURITY_WPA2_ENTERPRISE},
+		{"downgrade to wep", "Corp", "00:11:22:33:44:55", WIFI_SECURITY_WEP, []string{"wifi_security_downgrade"}, WIFI_SECURITY_WPA2_ENTERPRISE},
+		{"rogue and open", "Corp", "de:ad:be:ef:00:01", WIFI_SECURITY_OPEN, []string{"rogue_access_point", "wifi_security_downgrade"}, WIFI_SECURITY_WPA2_ENTERPRISE},
+		{"unknown security", "Corp", "00:11:22:33:44:55", "wpa4-future", nil, ""},
+		{"policy without security expects wpa2", "Lab", "00:11:22:33:55:01", WIFI_SECURITY_WPA, []string{"wifi_security_downgrade"}, WIFI_SECURITY_WPA2_PERSONAL},
+		{"not a corporate ssid", "CoffeeShop", "de:ad:be:ef:00:02", WIFI_SECURITY_OPEN, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := logServer(t, 10)
+			rogueAPReported = make(map[string]*rogueAPAssociation)
+			// an interface without a default route: no gateway check
+			checkRogueAccessPoint(wifiAssociation{Interface: "test-wlan", SSID: tt.ssid, BSSID: tt.bssid, Security: tt.security}, time.Now())
+
+			var events []string
+			expected := ""
+			for _, e := range received() {
+				events = append(events, e.Event)
+				if e.Event == "wifi_security_downgrade" {
+					expected, _ = e.RawData["expected_security"].(string)
+				}
+			}
+			if !reflect.DeepEqual(events, tt.events) || expected != tt.expected {
+				t.Errorf("events %v (expected %q), want %v (expected %q)", events, expected, tt.events, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Behavioral network detections built on top of trackNetworkConnections.
+// Every polled connection is fed into the detector; when one process (or one
+// remote source) crosses a threshold inside SCAN_WINDOW we emit a single
+// aggregated event carrying the full target list instead of one log per flow.
+const (
+	SCAN_WINDOW            = 2 * time.Minute
+	SCAN_ALERT_COOLDOWN    = 10 * time.Minute
+	SCAN_OUTBOUND_HOSTS    = 25 // distinct remote hosts contacted by one process
+	SCAN_OUTBOUND_PORTS    = 15 // distinct ports on one remote host from one process
+	SCAN_INBOUND_PORTS     = 10 // distinct local ports reached by one source
+	LATERAL_MOVEMENT_HOSTS = 5  // distinct internal hosts reached on admin ports
+	SCAN_MAX_TARGETS       = 100
+)
+
+// SMB, RDP and WinRM: the usual suspects for hands-on-keyboard lateral movement
+var lateralMovementPorts = map[int]string{
+	445:  "SMB",
+	3389: "RDP",
+	5985: "WINRM",
+	5986: "WINRM-TLS",
+}
+
+type scanDetector struct {
+	mu sync.Mutex
+	// process -> "ip:port" -> last seen
+	outbound map[string]map[string]time.Time
+	// remote ip -> local port -> last seen
+	inbound map[string]map[string]time.Time
+	// process -> "ip:port" -> last seen (internal admin ports only)
+	lateral map[string]map[string]time.Time
+	// detection key -> last alert
+	alerted map[string]time.Time
+}
+
+var scanTracker = newScanDetector()
+
+func newScanDetector() *scanDetector {
+	return &scanDetector{
+		outbound: make(map[string]map[string]time.Time),
+		inbound:  make(map[string]map[string]time.Time),
+		lateral:  make(map[string]map[string]time.Time),
+		alerted:  make(map[string]time.Time),
+	}
+}
+
+// observeOutbound records a flow initiated by this host. It returns true while
+// the process is under an active scan alert so the caller can skip the per-flow log.
+func (d *scanDetector) observeOutbound(process string, remoteAddr string, remotePort int, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	target := net.JoinHostPort(remoteAddr, strconv.Itoa(remotePort))
+	touchTarget(d.outbound, process, target, now)
+
+	suppressed := d.inCooldown("outbound:"+process, now)
+
+	if _, ok := lateralMovementPorts[remotePort]; ok && isInternalAddress(remoteAddr) {
+		touchTarget(d.lateral, process, target, now)
+		suppressed = suppressed || d.inCooldown("lateral:"+process, now)
+	}
+	return suppressed
+}
+
+// observeInbound records a connection accepted on one of our listening ports.
+func (d *scanDetector) observeInbound(remoteAddr string, localPort int, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// A busy client opens many connections to the same service; only the
+	// number of distinct ports it reaches says it is probing
+	touchTarget(d.inbound, remoteAddr, strconv.Itoa(localPort), now)
+	return d.inCooldown("inbound:"+remoteAddr, now)
+}
+
+// evaluate prunes expired observations and returns one LogEntry per new detection.
+func (d *scanDetector) evaluate(hostname string, now time.Time) []LogEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var alerts []LogEntry
+	ts := now.UTC().Format(time.RFC3339)
+
+	for process, targets := range d.outbound {
+		pruneTargets(targets, now)
+		if len(targets) == 0 {
+			delete(d.outbound, process)
+			continue
+		}
+
+		hosts, maxPorts, busiestHost := summarizeTargets(targets)
+		kind := ""
+		if hosts >= SCAN_OUTBOUND_HOSTS {
+			kind = "host_sweep"
+		} else if maxPorts >= SCAN_OUTBOUND_PORTS {
+			kind = "port_scan"
+		}
+		if kind == "" || !d.markAlerted("outbound:"+process, now) {
+			continue
+		}
+
+		list := targetList(targets)
+		msg := fmt.Sprintf("Outbound %s by %s: %d hosts / %d targets in %s", kind, process, hosts, len(targets), SCAN_WINDOW)
+		if kind == "port_scan" {
+			msg = fmt.Sprintf("Outbound port scan by %s: %d ports on %s in %s", process, maxPorts, busiestHost, SCAN_WINDOW)
+		}
+		logMessage("⚠️ " + msg)
+
+		alerts = append(alerts, scanAlert(hostname, ts, "outbound_scan", "high", msg, map[string]interface{}{
+			"process_name":   process,
+			"scan_type":      kind,
+			"distinct_hosts": hosts,
+			"max_ports_host": maxPorts,
+			"busiest_host":   busiestHost,
+			"target_count":   len(targets),
+			"targets":        list,
+			"window_seconds": int(SCAN_WINDOW.Seconds()),
+		}))
+	}
+
+	for source, ports := range d.inbound {
+		pruneTargets(ports, now)
+		if len(ports) == 0 {
+			delete(d.inbound, source)
+			continue
+		}
+		if len(ports) < SCAN_INBOUND_PORTS || !d.markAlerted("inbound:"+source, now) {
+			continue
+		}
+
+		portList := make([]int, 0, len(ports))
+		for p := range ports {
+			n, _ := strconv.Atoi(p)
+			portList = append(portList, n)
+		}
+		sort.Ints(portList)
+
+		msg := fmt.Sprintf("Inbound scan from %s: %d local ports in %s", source, len(portList), SCAN_WINDOW)
+		logMessage("⚠️ " + msg)
+
+		alerts = append(alerts, scanAlert(hostname, ts, "inbound_scan", "high", msg, map[string]interface{}{
+			"source_address": source,
+			"port_count":     len(portList),
+			"local_ports":    portList,
+			"window_seconds": int(SCAN_WINDOW.Seconds()),
+		}))
+	}
+
+	for process, targets := range d.lateral {
+		pruneTargets(targets, now)
+		if len(targets) == 0 {
+			delete(d.lateral, process)
+			continue
+		}
+
+		hosts, _, _ := summarizeTargets(targets)
+		if hosts < LATERAL_MOVEMENT_HOSTS || !d.markAlerted("lateral:"+process, now) {
+			continue
+		}
+
+		services := make(map[string]bool)
+		for target := range targets {
+			if _, port, err := net.SplitHostPort(target); err == nil {
+				p, _ := strconv.Atoi(port)
+				services[lateralMovementPorts[p]] = true
+			}
+		}
+		var serviceList []string
+		for s := range services {
+			serviceList = append(serviceList, s)
+		}
+		sort.Strings(serviceList)
+
+		msg := fmt.Sprintf("Possible lateral movement by %s: %s to %d internal hosts in %s",
+			process, strings.Join(serviceList, "/"), hosts, SCAN_WINDOW)
+		logMessage("⚠️ " + msg)
+
+		alerts = append(alerts, scanAlert(hostname, ts, "lateral_movement", "critical", msg, map[string]interface{}{
+			"process_name":   process,
+			"services":       serviceList,
+			"distinct_hosts": hosts,
+			"targets":        targetList(targets),
+			"window_seconds": int(SCAN_WINDOW.Seconds()),
+		}))
+	}
+
+	for key, at := range d.alerted {
+		if now.Sub(at) > SCAN_ALERT_COOLDOWN {
+			delete(d.alerted, key)
+		}
+	}
+
+	return alerts
+}
+
+func (d *scanDetector) inCooldown(key string, now time.Time) bool {
+	at, ok := d.alerted[key]
+	return ok && now.Sub(at) < SCAN_ALERT_COOLDOWN
+}
+
+// markAlerted returns false if the key already fired inside the cooldown.
+func (d *scanDetector) markAlerted(key string, now time.Time) bool {
+	if d.inCooldown(key, now) {
+		return false
+	}
+	d.alerted[key] = now
+	return true
+}
+
+func scanAlert(hostname, ts, event, severity, msg string, raw map[string]interface{}) LogEntry {
+	return LogEntry{
+		DeviceID:     deviceID,
+		DeviceName:   deviceName,
+		Hostname:     hostname,
+		LogType:      "network_security",
+		HardwareType: "network",
+		Event:        event,
+		Source:       "scan-detection",
+		Severity:     severity,
+		Message:      msg,
+		Timestamp:    ts,
+		RawData:      raw,
+	}
+}
+
+func touchTarget(buckets map[string]map[string]time.Time, key, target string, now time.Time) {
+	if buckets[key] == nil {
+		buckets[key] = make(map[string]time.Time)
+	}
+	buckets[key][target] = now
+}
+
+func pruneTargets(targets map[string]time.Time, now time.Time) {
+	for t, seen := range targets {
+		if now.Sub(seen) > SCAN_WINDOW {
+			delete(targets, t)
+		}
+	}
+}
+
+// summarizeTargets counts distinct hosts and finds the host with the most ports.
+func summarizeTargets(targets map[string]time.Time) (int, int, string) {
+	perHost := make(map[string]int)
+	for target := range targets {
+		host, _, err := net.SplitHostPort(target)
+		if err != nil {
+			host = target
+		}
+		perHost[host]++
+	}
+
+	maxPorts, busiest := 0, ""
+	for host, n := range perHost {
+		if n > maxPorts {
+			maxPorts, busiest = n, host
+		}
+	}
+	return len(perHost), maxPorts, busiest
+}
+
+func targetList(targets map[string]time.Time) []string {
+	list := make([]string, 0, len(targets))
+	for t := range targets {
+		list = append(list, t)
+	}
+	sort.Strings(list)
+	if len(list) > SCAN_MAX_TARGETS {
+		list = list[:SCAN_MAX_TARGETS]
+	}
+	return list
+}
+
+func isInternalAddress(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && (ip.IsPrivate() || ip.IsLinkLocalUnicast())
+}
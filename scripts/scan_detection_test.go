@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestInboundScanCountsDistinctLocalPorts(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// a busy client reconnecting to one service is not a scan
+	d := newScanDetector()
+	for i := 0; i < 200; i++ {
+		d.observeInbound("10.0.0.5", 443, now)
+	}
+	if alerts := d.evaluate("host", now); len(alerts) != 0 {
+		t.Fatalf("busy client flagged: %v", alerts[0].Message)
+	}
+
+	d = newScanDetector()
+	for port := 1; port <= SCAN_INBOUND_PORTS; port++ {
+		d.observeInbound("10.0.0.9", port, now)
+	}
+	alerts := d.evaluate("host", now)
+	if len(alerts) != 1 || alerts[0].Event != "inbound_scan" {
+		t.Fatalf("got %d alerts, want one inbound_scan", len(alerts))
+	}
+	if alerts[0].Source != "scan-detection" || alerts[0].RawData["port_count"] != SCAN_INBOUND_PORTS {
+		t.Errorf("unexpected alert %+v", alerts[0])
+	}
+
+	// the cooldown suppresses a second alert
+	if !d.observeInbound("10.0.0.9", 9999, now) {
+		t.Error("observeInbound not suppressed during cooldown")
+	}
+	if alerts := d.evaluate("host", now.Add(time.Second)); len(alerts) != 0 {
+		t.Errorf("alert repeated inside the cooldown")
+	}
+}
+
+func TestOutboundScanKinds(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		flows func(d *scanDetector)
+		want  string
+	}{
+		{"port scan", func(d *scanDetector) {
+			for port := 1; port <= SCAN_OUTBOUND_PORTS; port++ {
+				d.observeOutbound("nmap", "203.0.113.7", port, now)
+			}
+		}, "port_scan"},
+		{"host sweep", func(d *scanDetector) {
+			for i := 1; i <= SCAN_OUTBOUND_HOSTS; i++ {
+				d.observeOutbound("nmap", "198.51.100."+strconv.Itoa(i), 80, now)
+			}
+		}, "host_sweep"},
+		{"below threshold", func(d *scanDetector) {
+			for port := 1; port < SCAN_OUTBOUND_PORTS; port++ {
+				d.observeOutbound("curl", "203.0.113.7", port, now)
+			}
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newScanDetector()
+			tt.flows(d)
+			alerts := d.evaluate("host", now)
+			got := ""
+			if len(alerts) > 0 {
+				got, _ = alerts[0].RawData["scan_type"].(string)
+			}
+			if got != tt.want {
+				t.Errorf("scan_type = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLateralMovement(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := newScanDetector()
+	for i := 1; i <= LATERAL_MOVEMENT_HOSTS; i++ {
+		d.observeOutbound("psexec", "10.1.1."+strconv.Itoa(i), 445, now)
+	}
+	alerts := d.evaluate("host", now)
+	if len(alerts) != 1 || alerts[0].Event != "lateral_movement" {
+		t.Fatalf("got %d alerts, want one lateral_movement", len(alerts))
+	}
+
+	// public addresses on admin ports are not lateral movement
+	d = newScanDetector()
+	for i := 1; i <= LATERAL_MOVEMENT_HOSTS; i++ {
+		d.observeOutbound("mstsc", "203.0.113."+strconv.Itoa(i), 3389, now)
+	}
+	if alerts := d.evaluate("host", now); len(alerts) != 0 {
+		t.Errorf("public RDP targets flagged as lateral movement")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// logServer stands in for the API: it accepts the first accept entries
+// posted to /api/log and fails every later one. It returns the entries
+// accepted so far.
+func logServer(t *testing.T, accept int) func() []LogEntry {
+	t.Helper()
+	var mu sync.Mutex
+	var received []LogEntry
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/api/log" || len(received) >= accept {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var e LogEntry
+		json.NewDecoder(r.Body).Decode(&e)
+		received = append(received, e)
+	}))
+	savedURL := apiURL
+	apiURL = srv.URL
+	t.Cleanup(func() {
+		srv.Close()
+		apiURL = savedURL
+	})
+	return func() []LogEntry {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]LogEntry(nil), received...)
+	}
+}
+
+func TestSendLogReportsFailure(t *testing.T) {
+	agentDir = t.TempDir()
+	received := logServer(t, 1)
+
+	if err := sendLog(LogEntry{Event: "first", Message: "accepted"}); err != nil {
+		t.Fatalf("accepted entry: %v", err)
+	}
+	if err := sendLog(LogEntry{Event: "second", Message: "rejected"}); err == nil {
+		t.Fatal("rejected entry reported as sent")
+	}
+	if got := received(); len(got) != 1 || got[0].Event != "first" {
+		t.Errorf("server received %+v", got)
+	}
+
+	// An unreachable server is an error too
+	apiURL = "http://127.0.0.1:1"
+	if err := sendLog(LogEntry{Event: "third"}); err == nil {
+		t.Error("entry to an unreachable server reported as sent")
+	}
+}
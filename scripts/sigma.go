@@ -0,0 +1,969 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// Sigma rule evaluation. The server pushes rules (the Sigma YAML structure
+// as JSON) with the quarantine status poll; they are compiled once per
+// change and indexed by the log types their logsource can apply to. Events
+// leaving sendLog are queued to a worker that matches them against the rules
+// of their log type, so a large rule set never holds up logging. A match is
+// shipped as an extra detection LogEntry after the original event.
+
+const (
+	SIGMA_RULES_FILE = "sigma-rules.json"
+	SIGMA_QUEUE_SIZE = 4096 // events waiting for evaluation; further events are not evaluated
+)
+
+// SigmaRule is one rule as pushed by the server.
+type SigmaRule struct {
+	ID          string                 `json:"id"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	Status      string                 `json:"status,omitempty"`
+	Level       string                 `json:"level"`
+	Tags        []string               `json:"tags,omitempty"`
+	LogSource   SigmaLogSource         `json:"logsource"`
+	Detection   map[string]interface{} `json:"detection"`
+}
+
+type SigmaLogSource struct {
+	Product  string `json:"product,omitempty"`
+	Category string `json:"category,omitempty"`
+	Service  string `json:"service,omitempty"`
+}
+
+// sigmaRule is a compiled rule.
+type sigmaRule struct {
+	SigmaRule
+	selections map[string]*sigmaSelection
+	condition  sigmaCondition
+}
+
+// sigmaSelection is one named search identifier: its alternatives are ORed,
+// the matchers inside one alternative are ANDed.
+type sigmaSelection struct {
+	alternatives [][]sigmaFieldMatcher
+	keywords     []sigmaValueMatcher // field-less list: searched in the message
+}
+
+type sigmaFieldMatcher struct {
+	field  string
+	all    bool // |all: every value must match instead of any
+	exists *bool
+	values []sigmaValueMatcher
+}
+
+type sigmaValueMatcher func(value string, present bool) bool
+
+var (
+	sigmaMutex        sync.RWMutex
+	sigmaRules        map[string][]*sigmaRule // log type -> rules whose logsource can apply to it
+	sigmaFingerprint  string
+	sigmaQueue        = make(chan LogEntry, SIGMA_QUEUE_SIZE)
+	sigmaWorkerOnce   sync.Once
+	sigmaDropped      int
+	sigmaLogTypes     = map[string]bool{"application": true, "system": true, "security": true, "process": true, "network": true, "dns": true, "tls": true}
+	sigmaFieldAliases = map[string]string{
+		"event_id":            "EventID",
+		"source":              "Provider_Name",
+		"process_name":        "Image",
+		"remote_address":      "DestinationIp",
+		"remote_port":         "DestinationPort",
+		"local_address":       "SourceIp",
+		"local_port":          "SourcePort",
+		"query":               "QueryName",
+		"command_line":        "CommandLine",
+		"process_id":          "ProcessId",
+		"parent_process_id":   "ParentProcessId",
+		"current_directory":   "CurrentDirectory",
+		"user":                "User",
+		"parent_image":        "ParentImage",
+		"parent_command_line": "ParentCommandLine",
+	}
+)
+
+// updateSigmaRules recompiles the rule set when the server sent a different one.
+func updateSigmaRules(rules []SigmaRule) {
+	data, _ := json.Marshal(rules)
+	sigmaMutex.RLock()
+	unchanged := string(data) == sigmaFingerprint
+	sigmaMutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	setSigmaRules(rules, string(data))
+	if err := os.WriteFile(filepath.Join(agentDir, SIGMA_RULES_FILE), data, 0644); err != nil {
+		logMessage("Sigma rule cache write failed: " + err.Error())
+	}
+}
+
+// loadSigmaRules loads a rule file (the cached server rules, or -sigma-rules).
+func loadSigmaRules(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rules []SigmaRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	data, _ = json.Marshal(rules)
+	setSigmaRules(rules, string(data))
+	return nil
+}
+
+func setSigmaRules(rules []SigmaRule, fingerprint string) {
+	index := make(map[string][]*sigmaRule)
+	loaded := 0
+	for _, r := range rules {
+		c, err := compileSigmaRule(r)
+		if err != nil {
+			logMessage(fmt.Sprintf("Sigma rule %s (%s) skipped: %v", r.ID, r.Title, err))
+			continue
+		}
+		for _, logType := range sigmaRuleLogTypes(r.LogSource) {
+			index[logType] = append(index[logType], c)
+		}
+		loaded++
+	}
+
+	sigmaMutex.Lock()
+	sigmaRules = index
+	sigmaFingerprint = fingerprint
+	sigmaMutex.Unlock()
+
+	logMessage(fmt.Sprintf("Loaded %d of %d Sigma rules", loaded, len(rules)))
+}
+
+// sigmaRuleLogTypes returns the log types a logsource can match. It only
+// narrows the candidates; appliesTo still decides per event.
+func sigmaRuleLogTypes(ls SigmaLogSource) []string {
+	switch strings.ToLower(ls.Category) {
+	case "":
+	case "process_creation":
+		return []string{"process", "security", "application"} // Security 4688, Sysmon 1
+	case "network_connection":
+		return []string{"network", "application"}
+	case "dns", "dns_query":
+		return []string{"dns", "application"}
+	default:
+		return nil
+	}
+
+	switch service := strings.ToLower(ls.Service); service {
+	case "security", "system", "application":
+		return []string{service}
+	case "sysmon", "powershell":
+		return []string{"application"}
+	case "auth":
+		return []string{"security"}
+	}
+
+	all := make([]string, 0, len(sigmaLogTypes))
+	for logType := range sigmaLogTypes {
+		all = append(all, logType)
+	}
+	return all
+}
+
+// queueSigma hands an event that was just sent to the Sigma worker. Events
+// of a log type no rule applies to are not queued at all.
+func queueSigma(entry LogEntry) {
+	if entry.Event == "detection" {
+		return
+	}
+	sigmaMutex.RLock()
+	candidates := len(sigmaRules[entry.LogType])
+	sigmaMutex.RUnlock()
+	if candidates == 0 {
+		return
+	}
+
+	sigmaWorkerOnce.Do(func() { go sigmaWorker() })
+	select {
+	case sigmaQueue <- entry:
+	default:
+		sigmaMutex.Lock()
+		sigmaDropped++
+		dropped := sigmaDropped
+		sigmaMutex.Unlock()
+		if dropped%1000 == 1 {
+			logMessage(fmt.Sprintf("Sigma queue full: %d events not evaluated", dropped))
+		}
+	}
+}
+
+// sigmaWorker runs for the agent lifetime, evaluating queued events.
+func sigmaWorker() {
+	for entry := range sigmaQueue {
+		for _, d := range evaluateSigma(entry) {
+			sendLog(d)
+		}
+	}
+}
+
+// evaluateSigma returns one detection LogEntry per rule matching entry.
+func evaluateSigma(entry LogEntry) []LogEntry {
+	if entry.Event == "detection" || !sigmaLogTypes[entry.LogType] {
+		return nil
+	}
+	sigmaMutex.RLock()
+	rules := sigmaRules[entry.LogType]
+	sigmaMutex.RUnlock()
+	if len(rules) == 0 {
+		return nil
+	}
+
+	fields := sigmaEventFields(entry)
+	var detections []LogEntry
+	for _, r := range rules {
+		if !r.appliesTo(entry, fields) {
+			continue
+		}
+		results := make(map[string]bool, len(r.selections))
+		for name, sel := range r.selections {
+			results[name] = sel.match(fields)
+		}
+		if r.condition(results) {
+			detections = append(detections, r.detection(entry, fields, results))
+		}
+	}
+	return detections
+}
+
+// sigmaEventFields flattens an entry into the case-insensitive field map the
+// rules see: RawData (with event_data expanded) under its own names plus the
+// usual Sigma names for the fields we know.
+func sigmaEventFields(entry LogEntry) map[string]string {
+	fields := map[string]string{
+		"message":  entry.Message,
+		"logtype":  entry.LogType,
+		"event":    entry.Event,
+		"hostname": entry.Hostname,
+		"severity": entry.Severity,
+	}
+	for k, v := range entry.RawData {
+		switch val := v.(type) {
+		case map[string]string:
+			for fk, fv := range val {
+				fields[strings.ToLower(fk)] = fv
+			}
+			continue
+		case map[string]interface{}:
+			for fk, fv := range val {
+				fields[strings.ToLower(fk)] = fmt.Sprint(fv)
+			}
+			continue
+		case nil:
+			continue
+		}
+		s := fmt.Sprint(v)
+		fields[strings.ToLower(k)] = s
+		if alias, ok := sigmaFieldAliases[k]; ok {
+			if _, set := fields[strings.ToLower(alias)]; !set {
+				fields[strings.ToLower(alias)] = s
+			}
+		}
+	}
+	return fields
+}
+
+// sigmaEventProduct guesses which platform produced the entry.
+func sigmaEventProduct(entry LogEntry) string {
+	switch {
+	case strings.HasPrefix(entry.Source, "WinEventLog-"):
+		return "windows"
+	case entry.Source == "journald" || strings.HasPrefix(entry.Source, "syslog-") || entry.Source == "auditd":
+		return "linux"
+	case runtime.GOOS == "darwin":
+		return "macos"
+	}
+	return runtime.GOOS
+}
+
+// appliesTo checks the rule's logsource against the entry.
+func (r *sigmaRule) appliesTo(entry LogEntry, fields map[string]string) bool {
+	ls := r.LogSource
+	if ls.Product != "" && !strings.EqualFold(ls.Product, sigmaEventProduct(entry)) {
+		return false
+	}
+
+	channel := strings.ToLower(fields["channel"])
+	if ls.Service != "" {
+		ok := false
+		switch strings.ToLower(ls.Service) {
+		case "security", "system", "application":
+			ok = channel == strings.ToLower(ls.Service) || (channel == "" && entry.LogType == strings.ToLower(ls.Service))
+		case "sysmon":
+			ok = channel == "microsoft-windows-sysmon/operational"
+		case "powershell":
+			ok = channel == "microsoft-windows-powershell/operational" || channel == "windows powershell"
+		case "auth":
+			ok = entry.LogType == "security" && sigmaEventProduct(entry) == "linux"
+		case "syslog":
+			ok = entry.Source == "journald" || strings.HasPrefix(entry.Source, "syslog-")
+		case "auditd":
+			ok = entry.Source == "auditd"
+		default:
+			// sshd, sudo, cron, ...: the syslog identifier
+			ok = strings.EqualFold(fields["syslog_identifier"], ls.Service)
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if ls.Category != "" {
+		eventID := fields["eventid"]
+		switch strings.ToLower(ls.Category) {
+		case "process_creation":
+			return entry.LogType == "process" && (entry.Event == "" || entry.Event == "process_start") ||
+				channel == "microsoft-windows-sysmon/operational" && eventID == "1" ||
+				channel == "security" && eventID == "4688"
+		case "network_connection":
+			return entry.LogType == "network" ||
+				channel == "microsoft-windows-sysmon/operational" && eventID == "3"
+		case "dns", "dns_query":
+			return entry.LogType == "dns" ||
+				channel == "microsoft-windows-sysmon/operational" && eventID == "22"
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (s *sigmaSelection) match(fields map[string]string) bool {
+	if s.keywords != nil {
+		msg, present := fields["message"]
+		for _, m := range s.keywords {
+			if m(msg, present) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, alt := range s.alternatives {
+		matched := true
+		for _, fm := range alt {
+			if !fm.match(fields) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (fm *sigmaFieldMatcher) match(fields map[string]string) bool {
+	value, present := fields[strings.ToLower(fm.field)]
+	if fm.exists != nil {
+		return present == *fm.exists
+	}
+	for _, m := range fm.values {
+		ok := m(value, present)
+		if ok && !fm.all {
+			return true
+		}
+		if !ok && fm.all {
+			return false
+		}
+	}
+	return fm.all && len(fm.values) > 0
+}
+
+// detection builds the LogEntry for a match.
+func (r *sigmaRule) detection(entry LogEntry, fields map[string]string, results map[string]bool) LogEntry {
+	matched := make(map[string]string)
+	var selections []string
+	for name, ok := range results {
+		if !ok {
+			continue
+		}
+		selections = append(selections, name)
+		for _, alt := range r.selections[name].alternatives {
+			for _, fm := range alt {
+				if v, ok := fields[strings.ToLower(fm.field)]; ok {
+					matched[fm.field] = v
+				}
+			}
+		}
+	}
+	sort.Strings(selections)
+
+	severity := "warning"
+	switch strings.ToLower(r.Level) {
+	case "informational":
+		severity = "info"
+	case "high":
+		severity = "high"
+	case "critical":
+		severity = "critical"
+	}
+
+	msg := fmt.Sprintf("Sigma: %s", r.Title)
+	logMessage(fmt.Sprintf("⚠️ %s (rule %s, %s)", msg, r.ID, entry.Source))
+
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   entry.Hostname,
+		LogType:    "detection",
+		Event:      "detection",
+		Source:     "sigma",
+		Severity:   severity,
+		Message:    msg,
+		Timestamp:  entry.Timestamp,
+		RawData: map[string]interface{}{
+			"rule_id":            r.ID,
+			"rule_title":         r.Title,
+			"rule_level":         r.Level,
+			"rule_tags":          r.Tags,
+			"matched_selections": selections,
+			"matched_fields":     matched,
+			"event_source":       entry.Source,
+			"event_log_type":     entry.LogType,
+			"event_message":      entry.Message,
+			"event_time":         entry.Timestamp,
+			"event_raw":          entry.RawData,
+		},
+	}
+}
+
+// compileSigmaRule compiles the detection section of a rule.
+func compileSigmaRule(r SigmaRule) (*sigmaRule, error) {
+	if r.ID == "" || r.Title == "" {
+		return nil, fmt.Errorf("missing id or title")
+	}
+	c := &sigmaRule{SigmaRule: r, selections: make(map[string]*sigmaSelection)}
+
+	var conditions []string
+	for name, def := range r.Detection {
+		if name == "condition" {
+			switch cond := def.(type) {
+			case string:
+				conditions = []string{cond}
+			case []interface{}:
+				for _, v := range cond {
+					s, ok := v.(string)
+					if !ok {
+						return nil, fmt.Errorf("condition list must hold strings")
+					}
+					conditions = append(conditions, s)
+				}
+			default:
+				return nil, fmt.Errorf("condition must be a string")
+			}
+			continue
+		}
+		if name == "timeframe" {
+			return nil, fmt.Errorf("timeframe/aggregation rules are not supported")
+		}
+		sel, err := compileSigmaSelection(def)
+		if err != nil {
+			return nil, fmt.Errorf("selection %s: %v", name, err)
+		}
+		c.selections[name] = sel
+	}
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("missing condition")
+	}
+
+	// A list of conditions is ORed
+	var parts []sigmaCondition
+	for _, cond := range conditions {
+		p, err := parseSigmaCondition(cond, c.selections)
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %v", cond, err)
+		}
+		parts = append(parts, p)
+	}
+	c.condition = sigmaOr(parts)
+	return c, nil
+}
+
+func compileSigmaSelection(def interface{}) (*sigmaSelection, error) {
+	sel := &sigmaSelection{}
+	switch d := def.(type) {
+	case map[string]interface{}:
+		alt, err := compileSigmaMap(d)
+		if err != nil {
+			return nil, err
+		}
+		sel.alternatives = [][]sigmaFieldMatcher{alt}
+	case []interface{}:
+		if len(d) == 0 {
+			return nil, fmt.Errorf("empty list")
+		}
+		if _, isMap := d[0].(map[string]interface{}); isMap {
+			for _, item := range d {
+				m, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("mixed list")
+				}
+				alt, err := compileSigmaMap(m)
+				if err != nil {
+					return nil, err
+				}
+				sel.alternatives = append(sel.alternatives, alt)
+			}
+			break
+		}
+		sel.keywords = []sigmaValueMatcher{}
+		for _, item := range d {
+			m, err := compileSigmaValue(item, []string{"contains"})
+			if err != nil {
+				return nil, err
+			}
+			sel.keywords = append(sel.keywords, m...)
+		}
+	case string:
+		m, err := compileSigmaValue(d, []string{"contains"})
+		if err != nil {
+			return nil, err
+		}
+		sel.keywords = m
+	default:
+		return nil, fmt.Errorf("unsupported definition %T", def)
+	}
+	return sel, nil
+}
+
+func compileSigmaMap(m map[string]interface{}) ([]sigmaFieldMatcher, error) {
+	var matchers []sigmaFieldMatcher
+	for key, value := range m {
+		parts := strings.Split(key, "|")
+		fm := sigmaFieldMatcher{field: parts[0]}
+		var modifiers []string
+		for _, mod := range parts[1:] {
+			switch mod {
+			case "all":
+				fm.all = true
+			case "exists":
+				b, ok := value.(bool)
+				if !ok {
+					return nil, fmt.Errorf("%s: exists needs true or false", key)
+				}
+				fm.exists = &b
+			default:
+				modifiers = append(modifiers, mod)
+			}
+		}
+		if fm.exists == nil {
+			values := []interface{}{value}
+			if list, ok := value.([]interface{}); ok {
+				values = list
+			}
+			for _, v := range values {
+				vm, err := compileSigmaValue(v, modifiers)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", key, err)
+				}
+				fm.values = append(fm.values, vm...)
+			}
+		}
+		matchers = append(matchers, fm)
+	}
+	return matchers, nil
+}
+
+// compileSigmaValue turns one value and its modifiers into matchers. Value
+// transformations (base64, windash, ...) can expand to several alternatives;
+// for |all fields those count as one value, so they are folded into one matcher.
+func compileSigmaValue(v interface{}, modifiers []string) ([]sigmaValueMatcher, error) {
+	if v == nil {
+		return []sigmaValueMatcher{func(value string, present bool) bool {
+			return !present || value == ""
+		}}, nil
+	}
+
+	var s string
+	switch val := v.(type) {
+	case string:
+		s = val
+	case float64:
+		s = strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(val)
+	default:
+		return nil, fmt.Errorf("unsupported value %T", v)
+	}
+
+	variants := []string{s}
+	mode := "equals"
+	cased := false
+	for _, mod := range modifiers {
+		switch mod {
+		case "contains", "startswith", "endswith":
+			mode = mod
+		case "cased":
+			cased = true
+		case "re", "cidr", "gt", "gte", "lt", "lte":
+			mode = mod
+		case "i", "m", "s":
+			// regex flags, handled with re below
+		case "wide", "utf16le":
+			for i, vs := range variants {
+				variants[i] = utf16leString(vs)
+			}
+		case "base64":
+			for i, vs := range variants {
+				variants[i] = base64.StdEncoding.EncodeToString([]byte(vs))
+			}
+		case "base64offset":
+			var expanded []string
+			for _, vs := range variants {
+				expanded = append(expanded, base64Offsets(vs)...)
+			}
+			variants = expanded
+			if mode == "equals" {
+				mode = "contains"
+			}
+		case "windash":
+			var expanded []string
+			for _, vs := range variants {
+				expanded = append(expanded, windashVariants(vs)...)
+			}
+			variants = expanded
+		default:
+			return nil, fmt.Errorf("unsupported modifier %q", mod)
+		}
+	}
+
+	switch mode {
+	case "re":
+		flags := ""
+		for _, mod := range modifiers {
+			if mod == "i" || mod == "m" || mod == "s" {
+				flags += mod
+			}
+		}
+		if flags != "" {
+			s = "(?" + flags + ")" + s
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, err
+		}
+		return []sigmaValueMatcher{func(value string, present bool) bool {
+			return present && re.MatchString(value)
+		}}, nil
+	case "cidr":
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		return []sigmaValueMatcher{func(value string, present bool) bool {
+			ip := net.ParseIP(value)
+			return present && ip != nil && network.Contains(ip)
+		}}, nil
+	case "gt", "gte", "lt", "lte":
+		limit, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		return []sigmaValueMatcher{func(value string, present bool) bool {
+			n, err := strconv.ParseFloat(value, 64)
+			if !present || err != nil {
+				return false
+			}
+			switch mode {
+			case "gt":
+				return n > limit
+			case "gte":
+				return n >= limit
+			case "lt":
+				return n < limit
+			}
+			return n <= limit
+		}}, nil
+	}
+
+	var res []*regexp.Regexp
+	for _, vs := range variants {
+		pattern := sigmaWildcardRegex(vs)
+		switch mode {
+		case "contains":
+			pattern = ".*" + pattern + ".*"
+		case "startswith":
+			pattern = pattern + ".*"
+		case "endswith":
+			pattern = ".*" + pattern
+		}
+		prefix := "(?s"
+		if !cased {
+			prefix += "i"
+		}
+		re, err := regexp.Compile(prefix + ")^" + pattern + "$")
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return []sigmaValueMatcher{func(value string, present bool) bool {
+		if !present {
+			return false
+		}
+		for _, re := range res {
+			if re.MatchString(value) {
+				return true
+			}
+		}
+		return false
+	}}, nil
+}
+
+// sigmaWildcardRegex converts a Sigma string (* and ? wildcards, backslash
+// escapes for them) to a regular expression.
+func sigmaWildcardRegex(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == '*' || s[i+1] == '?' || s[i+1] == '\\'):
+			b.WriteString(regexp.QuoteMeta(string(s[i+1])))
+			i++
+		case c == '*':
+			b.WriteString(".*")
+		case c == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+func utf16leString(s string) string {
+	var b strings.Builder
+	for _, u := range utf16.Encode([]rune(s)) {
+		b.WriteByte(byte(u))
+		b.WriteByte(byte(u >> 8))
+	}
+	return b.String()
+}
+
+// base64Offsets returns the three encodings of s as it appears inside a
+// longer base64 string, depending on its offset modulo 3.
+func base64Offsets(s string) []string {
+	starts := []int{0, 2, 3}
+	ends := []int{0, 3, 2}
+	var out []string
+	for shift := 0; shift < 3; shift++ {
+		enc := base64.StdEncoding.EncodeToString(append(make([]byte, shift), s...))
+		end := len(enc) - ends[(len(s)+shift)%3]
+		out = append(out, enc[starts[shift]:end])
+	}
+	return out
+}
+
+// windashVariants returns s with every leading dash of an option replaced by
+// the characters Windows command lines accept instead.
+func windashVariants(s string) []string {
+	out := []string{s}
+	for _, dash := range []string{"/", "–", "—", "―"} {
+		var b strings.Builder
+		for i := 0; i < len(s); i++ {
+			if s[i] == '-' && (i == 0 || s[i-1] == ' ') {
+				b.WriteString(dash)
+				continue
+			}
+			b.WriteByte(s[i])
+		}
+		out = append(out, b.String())
+	}
+	return out
+}
+
+// sigmaCondition evaluates a condition against the selection results.
+type sigmaCondition func(results map[string]bool) bool
+
+func sigmaOr(parts []sigmaCondition) sigmaCondition {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return func(results map[string]bool) bool {
+		for _, p := range parts {
+			if p(results) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func sigmaAnd(parts []sigmaCondition) sigmaCondition {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return func(results map[string]bool) bool {
+		for _, p := range parts {
+			if !p(results) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+var sigmaTokenRegex = regexp.MustCompile(`\(|\)|[^\s()]+`)
+
+// sigmaConditionParser is a recursive descent parser for
+//
+//	expr   = term { "or" term }
+//	term   = factor { "and" factor }
+//	factor = "not" factor | "(" expr ")" | ("1"|"any"|"all") "of" (pattern|"them") | identifier
+type sigmaConditionParser struct {
+	tokens     []string
+	pos        int
+	selections map[string]*sigmaSelection
+}
+
+func parseSigmaCondition(cond string, selections map[string]*sigmaSelection) (sigmaCondition, error) {
+	if strings.Contains(cond, "|") {
+		return nil, fmt.Errorf("aggregations are not supported")
+	}
+	p := &sigmaConditionParser{tokens: sigmaTokenRegex.FindAllString(cond, -1), selections: selections}
+	c, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return c, nil
+}
+
+func (p *sigmaConditionParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *sigmaConditionParser) expr() (sigmaCondition, error) {
+	var parts []sigmaCondition
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, t)
+		if strings.ToLower(p.next()) != "or" {
+			return sigmaOr(parts), nil
+		}
+		p.pos++
+	}
+}
+
+func (p *sigmaConditionParser) term() (sigmaCondition, error) {
+	var parts []sigmaCondition
+	for {
+		f, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, f)
+		if strings.ToLower(p.next()) != "and" {
+			return sigmaAnd(parts), nil
+		}
+		p.pos++
+	}
+}
+
+func (p *sigmaConditionParser) factor() (sigmaCondition, error) {
+	tok := p.next()
+	if tok == "" {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	p.pos++
+
+	switch strings.ToLower(tok) {
+	case "not":
+		f, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return func(results map[string]bool) bool { return !f(results) }, nil
+	case "(":
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	case "1", "any", "all":
+		if strings.ToLower(p.next()) != "of" {
+			break
+		}
+		p.pos++
+		target := p.next()
+		if target == "" {
+			return nil, fmt.Errorf("missing target after of")
+		}
+		p.pos++
+		names := p.matchSelections(target)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no selection matches %q", target)
+		}
+		if strings.ToLower(tok) == "all" {
+			return func(results map[string]bool) bool {
+				for _, n := range names {
+					if !results[n] {
+						return false
+					}
+				}
+				return true
+			}, nil
+		}
+		return func(results map[string]bool) bool {
+			for _, n := range names {
+				if results[n] {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case ")", "and", "or", "of":
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+
+	if _, ok := p.selections[tok]; !ok {
+		return nil, fmt.Errorf("unknown selection %q", tok)
+	}
+	return func(results map[string]bool) bool { return results[tok] }, nil
+}
+
+// matchSelections resolves "them" or a wildcard pattern like "selection_*".
+func (p *sigmaConditionParser) matchSelections(target string) []string {
+	var names []string
+	re := regexp.MustCompile("^" + sigmaWildcardRegex(target) + "$")
+	for name := range p.selections {
+		if strings.ToLower(target) == "them" && !strings.HasPrefix(name, "_") || re.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func sigmaTestRule(t *testing.T, logsource, detection string) *sigmaRule {
+	t.Helper()
+	var r SigmaRule
+	src := `{"id": "test", "title": "Test rule", "level": "high", "logsource": ` + logsource + `, "detection": ` + detection + `}`
+	if err := json.Unmarshal([]byte(src), &r); err != nil {
+		t.Fatalf("rule JSON: %v", err)
+	}
+	c, err := compileSigmaRule(r)
+	if err != nil {
+		t.Fatalf("compile %s: %v", detection, err)
+	}
+	return c
+}
+
+func sigmaRuleMatches(r *sigmaRule, fields map[string]string) bool {
+	results := make(map[string]bool)
+	for name, sel := range r.selections {
+		results[name] = sel.match(fields)
+	}
+	return r.condition(results)
+}
+
+func TestSigmaMatcher(t *testing.T) {
+	cmd := map[string]string{
+		"image":             `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`,
+		"commandline":       `powershell.exe -NoProfile -EncodedCommand SQBFAFgAIAAoAE4AZQB3AC0ATwBiAGoAZQBjAHQA`,
+		"user":              `CORP\alice`,
+		"eventid":           "4688",
+		"destinationip":     "10.1.2.3",
+		"message":           "Process creation: powershell launched by winword",
+		"parentimage":       `C:\Program Files\Microsoft Office\root\Office16\WINWORD.EXE`,
+		"parentcommandline": `cmd.exe /c whoami /all`,
+		"logonid":           "",
+	}
+	tests := []struct {
+		name      string
+		detection string
+		want      bool
+	}{
+		{"equals is case-insensitive", `{"sel": {"User": "corp\\ALICE"}, "condition": "sel"}`, true},
+		{"equals does not match substrings", `{"sel": {"User": "alice"}, "condition": "sel"}`, false},
+		{"endswith", `{"sel": {"Image|endswith": "\\powershell.exe"}, "condition": "sel"}`, true},
+		{"startswith", `{"sel": {"Image|startswith": "C:\\Windows\\"}, "condition": "sel"}`, true},
+		{"contains any of a list", `{"sel": {"CommandLine|contains": ["-enc", "-nop"]}, "condition": "sel"}`, true},
+		{"contains all", `{"sel": {"CommandLine|contains|all": ["-NoProfile", "-EncodedCommand"]}, "condition": "sel"}`, true},
+		{"contains all, one missing", `{"sel": {"CommandLine|contains|all": ["-NoProfile", "-WindowStyle"]}, "condition": "sel"}`, false},
+		{"wildcards", `{"sel": {"Image": "*\\WindowsPowerShell\\v?.0\\\\*"}, "condition": "sel"}`, true},
+		{"escaped wildcard is literal", `{"sel": {"User": "CORP\\*"}, "condition": "sel"}`, false},
+		{"escaped backslash before wildcard", `{"sel": {"User": "CORP\\\\*"}, "condition": "sel"}`, true},
+		{"cased", `{"sel": {"CommandLine|contains|cased": "-noprofile"}, "condition": "sel"}`, false},
+		{"regex", `{"sel": {"CommandLine|re": "-Enc[a-zA-Z]*\\s+[A-Za-z0-9+/=]{20,}"}, "condition": "sel"}`, true},
+		{"regex with i flag", `{"sel": {"CommandLine|re|i": "-encodedcommand"}, "condition": "sel"}`, true},
+		{"cidr", `{"sel": {"DestinationIp|cidr": "10.0.0.0/8"}, "condition": "sel"}`, true},
+		{"cidr outside", `{"sel": {"DestinationIp|cidr": "192.168.0.0/16"}, "condition": "sel"}`, false},
+		{"gte", `{"sel": {"EventID|gte": 4688}, "condition": "sel"}`, true},
+		{"lt", `{"sel": {"EventID|lt": 4000}, "condition": "sel"}`, false},
+		{"numeric equals", `{"sel": {"EventID": 4688}, "condition": "sel"}`, true},
+		{"windash", `{"sel": {"ParentCommandLine|windash|contains": "-all"}, "condition": "sel"}`, true},
+		{"without windash", `{"sel": {"ParentCommandLine|contains": "-all"}, "condition": "sel"}`, false},
+		{"base64offset", `{"sel": {"CommandLine|base64offset|contains": "IEX"}, "condition": "sel"}`, false},
+		{"wide base64offset", `{"sel": {"CommandLine|wide|base64offset|contains": "IEX (New-Object"}, "condition": "sel"}`, true},
+		{"exists", `{"sel": {"ParentImage|exists": true}, "condition": "sel"}`, true},
+		{"not exists", `{"sel": {"TargetFilename|exists": true}, "condition": "sel"}`, false},
+		{"null matches missing field", `{"sel": {"TargetFilename": null}, "condition": "sel"}`, true},
+		{"null matches empty field", `{"sel": {"LogonId": null}, "condition": "sel"}`, true},
+		{"map fields are ANDed", `{"sel": {"Image|endswith": "\\powershell.exe", "User": "bob"}, "condition": "sel"}`, false},
+		{"list of maps is ORed", `{"sel": [{"User": "bob"}, {"Image|endswith": "\\powershell.exe"}], "condition": "sel"}`, true},
+		{"keywords search the message", `{"kw": ["winword", "excel"], "condition": "kw"}`, true},
+		{"and not", `{"sel": {"Image|endswith": "\\powershell.exe"}, "filter": {"ParentImage|endswith": "\\WINWORD.EXE"}, "condition": "sel and not filter"}`, false},
+		{"or with parentheses", `{"a": {"User": "bob"}, "b": {"EventID": 4688}, "c": {"User": "carol"}, "condition": "(a or b) and not c"}`, true},
+		{"1 of pattern", `{"sel_a": {"User": "bob"}, "sel_b": {"EventID": 4688}, "condition": "1 of sel_*"}`, true},
+		{"all of them", `{"sel_a": {"User": "bob"}, "sel_b": {"EventID": 4688}, "condition": "all of them"}`, false},
+		{"all of them skips underscore selections", `{"sel": {"EventID": 4688}, "_helper": {"User": "bob"}, "condition": "all of them"}`, true},
+		{"condition list is ORed", `{"a": {"User": "bob"}, "b": {"EventID": 4688}, "condition": ["a", "b"]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := sigmaTestRule(t, `{"product": "windows"}`, tt.detection)
+			if got := sigmaRuleMatches(r, cmd); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSigmaCompileErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		detection string
+	}{
+		{"missing condition", `{"sel": {"User": "bob"}}`},
+		{"unknown selection", `{"sel": {"User": "bob"}, "condition": "other"}`},
+		{"aggregation", `{"sel": {"User": "bob"}, "condition": "sel | count() > 5"}`},
+		{"timeframe", `{"sel": {"User": "bob"}, "timeframe": "5m", "condition": "sel"}`},
+		{"unsupported modifier", `{"sel": {"User|fuzzy": "bob"}, "condition": "sel"}`},
+		{"bad regex", `{"sel": {"User|re": "("}, "condition": "sel"}`},
+		{"unbalanced parentheses", `{"sel": {"User": "bob"}, "condition": "(sel"}`},
+		{"dangling operator", `{"sel": {"User": "bob"}, "condition": "sel and"}`},
+		{"no selection for pattern", `{"sel": {"User": "bob"}, "condition": "1 of filter_*"}`},
+	}
+	for _, tt := range tests {
+		var r SigmaRule
+		if err := json.Unmarshal([]byte(`{"id": "x", "title": "x", "detection": `+tt.detection+`}`), &r); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, err := compileSigmaRule(r); err == nil {
+			t.Errorf("%s: compiled without error", tt.name)
+		}
+	}
+}
+
+func TestSigmaLogSourceIndex(t *testing.T) {
+	agentDir = t.TempDir()
+	defer setSigmaRules(nil, "")
+
+	var rules []SigmaRule
+	if err := json.Unmarshal([]byte(`[
+		{"id": "proc", "title": "Encoded PowerShell", "level": "high",
+		 "logsource": {"category": "process_creation"},
+		 "detection": {"sel": {"CommandLine|contains": "-enc"}, "condition": "sel"}},
+		{"id": "sec", "title": "Audit log cleared", "level": "critical",
+		 "logsource": {"product": "windows", "service": "security"},
+		 "detection": {"sel": {"EventID": 1102}, "condition": "sel"}},
+		{"id": "any", "title": "Mimikatz keyword", "level": "critical",
+		 "logsource": {},
+		 "detection": {"kw": ["mimikatz"], "condition": "kw"}}
+	]`), &rules); err != nil {
+		t.Fatal(err)
+	}
+	setSigmaRules(rules, "test")
+
+	sigmaMutex.RLock()
+	counts := map[string]int{"process": len(sigmaRules["process"]), "security": len(sigmaRules["security"]), "dns": len(sigmaRules["dns"])}
+	sigmaMutex.RUnlock()
+	if counts["process"] != 2 || counts["security"] != 3 || counts["dns"] != 1 {
+		t.Errorf("rules per log type = %v, want process 2, security 3, dns 1", counts)
+	}
+
+	process := LogEntry{LogType: "process", Source: "process-monitor", Message: "process started", Timestamp: "2024-03-01T12:00:00Z",
+		RawData: map[string]interface{}{"command_line": "powershell -enc SQBFAFgA"}}
+	detections := evaluateSigma(process)
+	if len(detections) != 1 {
+		t.Fatalf("got %d detections, want 1", len(detections))
+	}
+	d := detections[0]
+	if d.LogType != "detection" || d.Event != "detection" || d.Source != "sigma" || d.Severity != "high" {
+		t.Errorf("detection = %+v", d)
+	}
+	if d.RawData["event_log_type"] != "process" || d.RawData["rule_id"] != "proc" || d.Timestamp != process.Timestamp {
+		t.Errorf("detection raw data = %v", d.RawData)
+	}
+
+	// a detection is never evaluated again
+	if again := evaluateSigma(d); len(again) != 0 {
+		t.Errorf("detection matched %d rules", len(again))
+	}
+
+	cleared := LogEntry{LogType: "security", Source: "WinEventLog-Security", Message: "log cleared",
+		RawData: map[string]interface{}{"event_id": 1102, "channel": "Security"}}
+	if got := evaluateSigma(cleared); len(got) != 1 || got[0].RawData["rule_id"] != "sec" {
+		t.Errorf("security event detections = %v", got)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Software inventory. Installed packages (dpkg, rpm, snap and flatpak on
+// Linux, the uninstall registry keys on Windows) are listed every
+// SOFTWARE_INTERVAL. The full inventory is sent on the first run and again
+// every SOFTWARE_FULL_RESYNC so the server can rebuild its copy; in between
+// only installs, removals and version changes are reported.
+
+const (
+	SOFTWARE_STATE_FILE     = "software.json"
+	SOFTWARE_INTERVAL       = time.Hour
+	SOFTWARE_FULL_RESYNC    = 7 * 24 * time.Hour
+	SOFTWARE_BATCH_SIZE     = 500
+	SOFTWARE_MAX_CHANGE_LOG = 500 // more changes than this (a distribution upgrade) resend the inventory
+)
+
+// softwarePackage is one installed package.
+type softwarePackage struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Vendor      string `json:"vendor,omitempty"`
+	InstallDate string `json:"install_date,omitempty"` // YYYY-MM-DD when the source records it
+	Arch        string `json:"arch,omitempty"`
+	Source      string `json:"source"` // dpkg, rpm, snap, flatpak, registry
+	// Source package a binary package was built from, when it differs
+	// (libssl3 from openssl); vulnerability feeds are keyed on it
+	SourcePackage string `json:"source_package,omitempty"`
+}
+
+func (p softwarePackage) key() string {
+	return p.Source + "|" + p.Name + "|" + p.Arch
+}
+
+// softwareKeys returns the key of a package within pkgs. Packages
+// installed in several versions side by side (RPM installonly packages
+// such as kernel) get the version added to the key, so each version is an
+// entry of its own.
+func softwareKeys(pkgs []softwarePackage) func(softwarePackage) string {
+	versions := make(map[string]int)
+	for _, p := range pkgs {
+		versions[p.key()]++
+	}
+	return func(p softwarePackage) string {
+		if versions[p.key()] > 1 {
+			return p.key() + "|" + p.Version
+		}
+		return p.key()
+	}
+}
+
+// indexSoftware maps pkgs by softwareKeys.
+func indexSoftware(pkgs []softwarePackage) map[string]softwarePackage {
+	key := softwareKeys(pkgs)
+	index := make(map[string]softwarePackage, len(pkgs))
+	for _, p := range pkgs {
+		index[key(p)] = p
+	}
+	return index
+}
+
+// softwareState is what the agent last reported.
+type softwareState struct {
+	Packages []softwarePackage `json:"packages"`
+	FullSync time.Time         `json:"full_sync"`
+}
+
+// softwareSource lists the packages of one package manager. ok is false
+// when the manager is not installed.
+type softwareSource struct {
+	name string
+	list func() (pkgs []softwarePackage, ok bool, err error)
+}
+
+func runSoftwareInventory() {
+	for {
+		policyMutex.RLock()
+		quarantined := isQuarantined
+		policyMutex.RUnlock()
+		// The state only advances when the changes can be reported
+		if deviceID != "" && !quarantined {
+			scanSoftware()
+		}
+		time.Sleep(SOFTWARE_INTERVAL)
+	}
+}
+
+func scanSoftware() {
+	sources := softwareSources()
+	if len(sources) == 0 {
+		return
+	}
+	var state softwareState
+	haveState := loadSoftwareState(&state)
+	previous := indexSoftware(state.Packages)
+
+	var list []softwarePackage
+	var used []string
+	for _, src := range sources {
+		pkgs, ok, err := src.list()
+		if err != nil {
+			logMessage("Software inventory: " + src.name + " failed: " + err.Error())
+			// keep what was known from this source instead of reporting it removed
+			for _, p := range state.Packages {
+				if p.Source == src.name {
+					list = append(list, p)
+				}
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		used = append(used, src.name)
+		list = append(list, pkgs...)
+	}
+	current := indexSoftware(list)
+
+	list = list[:0]
+	for _, p := range current {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].key() != list[j].key() {
+			return list[i].key() < list[j].key()
+		}
+		return list[i].Version < list[j].Version
+	})
+
+	var changes []LogEntry
+	if haveState {
+		changes = softwareChanges(previous, current)
+	}
+	now := time.Now()
+	switch {
+	case !haveState || now.Sub(state.FullSync) > SOFTWARE_FULL_RESYNC || len(changes) > SOFTWARE_MAX_CHANGE_LOG:
+		sendSoftwareInventory(list, used)
+		state.FullSync = now
+		logMessage(fmt.Sprintf("Software inventory sent: %d packages from %v", len(list), used))
+	case len(changes) > 0:
+		for _, e := range changes {
+			sendLog(e)
+		}
+	default:
+		return
+	}
+	state.Packages = list
+	saveSoftwareState(state)
+}
+
+// softwareChanges returns one event per package installed, removed or
+// whose version changed. Packages are compared by key() with the versions
+// of each side: one version on both sides is an update, otherwise (several
+// versions side by side) every version that appeared is an install and
+// every version that went away a removal.
+func softwareChanges(previous, current map[string]softwarePackage) []LogEntry {
+	before := make(map[string][]softwarePackage)
+	for _, p := range previous {
+		before[p.key()] = append(before[p.key()], p)
+	}
+	after := make(map[string][]softwarePackage)
+	for _, p := range current {
+		after[p.key()] = append(after[p.key()], p)
+	}
+
+	var events []LogEntry
+	for key, old := range before {
+		added, removed := softwareVersionDiff(old, after[key])
+		if len(old) == 1 && len(after[key]) == 1 && len(added) == 1 {
+			events = append(events, softwareEvent("software_updated", &removed[0], &added[0]))
+			continue
+		}
+		for i := range added {
+			events = append(events, softwareEvent("software_installed", nil, &added[i]))
+		}
+		for i := range removed {
+			events = append(events, softwareEvent("software_removed", &removed[i], nil))
+		}
+	}
+	for key, pkgs := range after {
+		if _, ok := before[key]; ok {
+			continue
+		}
+		for i := range pkgs {
+			events = append(events, softwareEvent("software_installed", nil, &pkgs[i]))
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Message < events[j].Message })
+	return events
+}
+
+// softwareVersionDiff returns the packages of after whose version is not in
+// before and the packages of before whose version is not in after.
+func softwareVersionDiff(before, after []softwarePackage) (added, removed []softwarePackage) {
+	versions := func(pkgs []softwarePackage) map[string]bool {
+		set := make(map[string]bool, len(pkgs))
+		for _, p := range pkgs {
+			set[p.Version] = true
+		}
+		return set
+	}
+	had, has := versions(before), versions(after)
+	for _, p := range after {
+		if !had[p.Version] {
+			added = append(added, p)
+		}
+	}
+	for _, p := range before {
+		if !has[p.Version] {
+			removed = append(removed, p)
+		}
+	}
+	return added, removed
+}
+
+func softwareEvent(event string, before, after *softwarePackage) LogEntry {
+	var msg string
+	raw := map[string]interface{}{}
+	p := after
+	switch event {
+	case "software_installed":
+		msg = fmt.Sprintf("Software installed: %s %s (%s)", after.Name, after.Version, after.Source)
+	case "software_removed":
+		p = before
+		msg = fmt.Sprintf("Software removed: %s %s (%s)", before.Name, before.Version, before.Source)
+	default:
+		msg = fmt.Sprintf("Software updated: %s %s -> %s (%s)", after.Name, before.Version, after.Version, after.Source)
+		raw["previous_version"] = before.Version
+	}
+	raw["name"] = p.Name
+	raw["version"] = p.Version
+	raw["vendor"] = p.Vendor
+	raw["install_date"] = p.InstallDate
+	raw["arch"] = p.Arch
+	raw["source"] = p.Source
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    "inventory",
+		Event:      event,
+		Source:     "software",
+		Severity:   "info",
+		Message:    msg,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RawData:    raw,
+	}
+}
+
+// sendSoftwareInventory sends the full package list; batch/batches let the
+// server tell when it has the complete set and can drop what is missing.
+func sendSoftwareInventory(list []softwarePackage, sources []string) {
+	batches := max((len(list)+SOFTWARE_BATCH_SIZE-1)/SOFTWARE_BATCH_SIZE, 1)
+	snapshot := time.Now().UTC().Format(time.RFC3339)
+	for i := 0; i < batches; i++ {
+		batch := list[min(i*SOFTWARE_BATCH_SIZE, len(list)):min((i+1)*SOFTWARE_BATCH_SIZE, len(list))]
+		sendLog(LogEntry{
+			DeviceID:   deviceID,
+			DeviceName: deviceName,
+			Hostname:   getHostname(),
+			LogType:    "inventory",
+			Event:      "software_inventory",
+			Source:     "software",
+			Severity:   "info",
+			Message:    fmt.Sprintf("Software inventory %d/%d: %d of %d packages", i+1, batches, len(batch), len(list)),
+			Timestamp:  snapshot,
+			RawData: map[string]interface{}{
+				"packages": batch,
+				"count":    len(batch),
+				"total":    len(list),
+				"batch":    i + 1,
+				"batches":  batches,
+				"sources":  sources,
+				"snapshot": snapshot,
+			},
+		})
+	}
+}
+
+func loadSoftwareState(state *softwareState) bool {
+	data, err := os.ReadFile(filepath.Join(agentDir, SOFTWARE_STATE_FILE))
+	if err != nil {
+		return false
+	}
+	if json.Unmarshal(data, state) != nil {
+		logMessage("Software inventory state unreadable, sending the full inventory")
+		*state = softwareState{}
+		return false
+	}
+	return true
+}
+
+func saveSoftwareState(state softwareState) {
+	data, _ := json.Marshal(state)
+	saveBookmark(SOFTWARE_STATE_FILE, string(data))
+}
+
+// installedPackages lists the packages of every source that answers and
+// names the sources that failed.
+func installedPackages() ([]softwarePackage, map[string]bool) {
+	var list []softwarePackage
+	failed := make(map[string]bool)
+	for _, src := range softwareSources() {
+		pkgs, _, err := src.list()
+		if err != nil {
+			logMessage("Software inventory: " + src.name + " failed: " + err.Error())
+			failed[src.name] = true
+			continue
+		}
+		list = append(list, pkgs...)
+	}
+	return list, failed
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func softwareSources() []softwareSource {
+	return []softwareSource{
+		{name: "dpkg", list: dpkgPackages},
+		{name: "rpm", list: rpmPackages},
+		{name: "snap", list: snapPackages},
+		{name: "flatpak", list: flatpakPackages},
+	}
+}
+
+// packageCommand runs a package manager query; ok is false when the
+// manager is not installed on this host.
+func packageCommand(name string, args ...string) (out string, ok bool, err error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return "", false, nil
+	}
+	data, err := runCommandWithTimeout(name, args...)
+	if err != nil {
+		return "", true, err
+	}
+	return string(data), true, nil
+}
+
+func dpkgPackages() ([]softwarePackage, bool, error) {
+	out, ok, err := packageCommand("dpkg-query", "-W", "-f=${Package}\t${Version}\t${Architecture}\t${Maintainer}\t${db:Status-Abbrev}\t${source:Package}\n")
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	var pkgs []softwarePackage
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Split(line, "\t")
+		// only installed packages ("ii"); removed ones leave config behind as "rc"
+		if len(f) < 6 || !strings.HasPrefix(f[4], "ii") {
+			continue
+		}
+		p := softwarePackage{Name: f[0], Version: f[1], Arch: f[2], Vendor: f[3], Source: "dpkg"}
+		if f[5] != f[0] {
+			p.SourcePackage = f[5]
+		}
+		// dpkg does not record install times; the file list is written on install and upgrade
+		for _, list := range []string{f[0] + ":" + f[2] + ".list", f[0] + ".list"} {
+			if info, err := os.Stat("/var/lib/dpkg/info/" + list); err == nil {
+				p.InstallDate = info.ModTime().UTC().Format("2006-01-02")
+				break
+			}
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, true, nil
+}
+
+func rpmPackages() ([]softwarePackage, bool, error) {
+	out, ok, err := packageCommand("rpm", "-qa", "--qf", "%{NAME}\t%{EPOCH}\t%{VERSION}-%{RELEASE}\t%{ARCH}\t%{VENDOR}\t%{INSTALLTIME}\t%{SOURCERPM}\n")
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	var pkgs []softwarePackage
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Split(line, "\t")
+		if len(f) < 7 || f[0] == "gpg-pubkey" {
+			continue
+		}
+		p := softwarePackage{Name: f[0], Version: f[2], Arch: f[3], Source: "rpm"}
+		if f[1] != "(none)" && f[1] != "" {
+			p.Version = f[1] + ":" + f[2]
+		}
+		if f[4] != "(none)" {
+			p.Vendor = f[4]
+		}
+		if sec, err := strconv.ParseInt(f[5], 10, 64); err == nil {
+			p.InstallDate = time.Unix(sec, 0).UTC().Format("2006-01-02")
+		}
+		// name-version-release.src.rpm
+		if parts := strings.Split(strings.TrimSuffix(f[6], ".src.rpm"), "-"); len(parts) > 2 {
+			if src := strings.Join(parts[:len(parts)-2], "-"); src != f[0] {
+				p.SourcePackage = src
+			}
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, true, nil
+}
+
+// snapPackages parses "snap list": Name Version Rev Tracking Publisher Notes.
+func snapPackages() ([]softwarePackage, bool, error) {
+	out, ok, err := packageCommand("snap", "list", "--unicode=never")
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	var pkgs []softwarePackage
+	for i, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		if i == 0 || len(f) < 5 {
+			continue
+		}
+		// verified publishers are marked with a trailing "*" (or ✓)
+		vendor := strings.TrimRight(f[4], "*✓")
+		if vendor == "-" {
+			vendor = ""
+		}
+		pkgs = append(pkgs, softwarePackage{Name: f[0], Version: f[1], Vendor: vendor, Source: "snap"})
+	}
+	return pkgs, true, nil
+}
+
+func flatpakPackages() ([]softwarePackage, bool, error) {
+	out, ok, err := packageCommand("flatpak", "list", "--columns=application,version,arch,origin")
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	var pkgs []softwarePackage
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Split(line, "\t")
+		if len(f) < 4 || f[0] == "" {
+			continue
+		}
+		// flatpak has no vendor field; the remote (flathub, fedora) is the closest
+		pkgs = append(pkgs, softwarePackage{Name: f[0], Version: f[1], Arch: f[2], Vendor: f[3], Source: "flatpak"})
+	}
+	return pkgs, true, nil
+}
@@ -0,0 +1,8 @@
+//go:build !windows && !linux
+
+package main
+
+// No package sources are read on this platform yet.
+func softwareSources() []softwareSource {
+	return nil
+}
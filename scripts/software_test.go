@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestSoftwareChanges(t *testing.T) {
+	kernel := func(version string) softwarePackage {
+		return softwarePackage{Name: "kernel", Version: version, Arch: "x86_64", Source: "rpm"}
+	}
+	bash := func(version string) softwarePackage {
+		return softwarePackage{Name: "bash", Version: version, Arch: "x86_64", Source: "rpm"}
+	}
+	tests := []struct {
+		name   string
+		before []softwarePackage
+		after  []softwarePackage
+		want   []string
+	}{
+		{"unchanged", []softwarePackage{kernel("5.14.0-1"), kernel("5.14.0-2"), bash("5.1")}, []softwarePackage{bash("5.1"), kernel("5.14.0-2"), kernel("5.14.0-1")}, nil},
+		{"update", []softwarePackage{bash("5.1")}, []softwarePackage{bash("5.2")}, []string{"Software updated: bash 5.1 -> 5.2 (rpm)"}},
+		{"second kernel installed", []softwarePackage{kernel("5.14.0-1")}, []softwarePackage{kernel("5.14.0-1"), kernel("5.14.0-2")}, []string{"Software installed: kernel 5.14.0-2 (rpm)"}},
+		{"oldest kernel rotated out", []softwarePackage{kernel("5.14.0-1"), kernel("5.14.0-2")}, []softwarePackage{kernel("5.14.0-2"), kernel("5.14.0-3")}, []string{"Software installed: kernel 5.14.0-3 (rpm)", "Software removed: kernel 5.14.0-1 (rpm)"}},
+		{"back to one kernel", []softwarePackage{kernel("5.14.0-1"), kernel("5.14.0-2")}, []softwarePackage{kernel("5.14.0-2")}, []string{"Software removed: kernel 5.14.0-1 (rpm)"}},
+		{"install and removal", []softwarePackage{bash("5.1")}, []softwarePackage{kernel("5.14.0-1")}, []string{"Software installed: kernel 5.14.0-1 (rpm)", "Software removed: bash 5.1 (rpm)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range softwareChanges(indexSoftware(tt.before), indexSoftware(tt.after)) {
+				got = append(got, e.Message)
+			}
+			sort.Strings(got)
+			sort.Strings(tt.want)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %q, want %q", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+
+	index := indexSoftware([]softwarePackage{kernel("5.14.0-1"), kernel("5.14.0-2"), bash("5.1")})
+	for _, key := range []string{"rpm|kernel|x86_64|5.14.0-1", "rpm|kernel|x86_64|5.14.0-2", "rpm|bash|x86_64"} {
+		if _, ok := index[key]; !ok {
+			t.Errorf("key %s missing from %v", key, index)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Installed programs as listed by Programs and Features: the machine-wide
+// uninstall keys (64 and 32 bit) and those of every loaded user profile.
+// Components and updates hidden from that list are skipped.
+const softwareListScript = `
+	$ErrorActionPreference = 'SilentlyContinue'
+	$paths = @(
+		'HKLM:\Software\Microsoft\Windows\CurrentVersion\Uninstall\*',
+		'HKLM:\Software\WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall\*'
+	)
+	Get-ChildItem 'Registry::HKEY_USERS' | Where-Object { $_.PSChildName -match '^S-1-5-21-[\d-]+$' } | ForEach-Object {
+		$paths += "Registry::HKEY_USERS\$($_.PSChildName)\Software\Microsoft\Windows\CurrentVersion\Uninstall\*"
+	}
+	$items = foreach ($p in $paths) {
+		Get-ItemProperty -Path $p | Where-Object { $_.DisplayName -and $_.SystemComponent -ne 1 -and -not $_.ParentKeyName } | ForEach-Object {
+			$arch = 'x64'
+			if ($p -like '*WOW6432Node*') { $arch = 'x86' }
+			[pscustomobject]@{
+				name = [string]$_.DisplayName; version = [string]$_.DisplayVersion; vendor = [string]$_.Publisher
+				install_date = [string]$_.InstallDate; arch = $arch
+			}
+		}
+	}
+	ConvertTo-Json -InputObject @($items) -Compress
+`
+
+func softwareSources() []softwareSource {
+	return []softwareSource{{name: "registry", list: registryPackages}}
+}
+
+func registryPackages() ([]softwarePackage, bool, error) {
+	out, err := runCommandWithTimeout("powershell", "-Command", softwareListScript)
+	if err != nil {
+		return nil, true, err
+	}
+	if len(out) == 0 {
+		return nil, true, errors.New("no output from PowerShell")
+	}
+	var pkgs []softwarePackage
+	if err := json.Unmarshal(out, &pkgs); err != nil {
+		return nil, true, err
+	}
+	for i := range pkgs {
+		pkgs[i].Source = "registry"
+		// InstallDate is written as yyyyMMdd
+		if d := pkgs[i].InstallDate; len(d) == 8 {
+			pkgs[i].InstallDate = d[:4] + "-" + d[4:6] + "-" + d[6:]
+		} else {
+			pkgs[i].InstallDate = ""
+		}
+	}
+	return pkgs, true, nil
+}
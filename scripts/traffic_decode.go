@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Application-layer parsers for DNS and TLS ClientHello. They work on any
+// gopacket.Packet, so the same code handles live capture and -replay.
+
+const (
+	tlsRecordHandshake   = 0x16
+	tlsHandshakeClientHi = 0x01
+	tlsExtServerName     = 0x0000
+	tlsExtALPN           = 0x0010
+	tlsExtSupportedVers  = 0x002b
+)
+
+// decodeTrafficPacket returns the DNS / TLS log entries found in packet.
+func decodeTrafficPacket(packet gopacket.Packet, at time.Time) []LogEntry {
+	var entries []LogEntry
+	if entry, ok := decodeDNSPacket(packet, at); ok {
+		entries = append(entries, entry)
+	}
+	if entry, ok := decodeTLSClientHello(packet, at); ok {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func decodeDNSPacket(packet gopacket.Packet, at time.Time) (LogEntry, bool) {
+	l := packet.Layer(layers.LayerTypeDNS)
+	if l == nil {
+		return LogEntry{}, false
+	}
+	dns := l.(*layers.DNS)
+	if len(dns.Questions) == 0 {
+		return LogEntry{}, false
+	}
+
+	q := dns.Questions[0]
+	name := string(q.Name)
+	raw := packetEndpoints(packet)
+	raw["query"] = name
+	raw["query_type"] = q.Type.String()
+	raw["transaction_id"] = dns.ID
+
+	event := "dns_query"
+	message := fmt.Sprintf("DNS %s %s", q.Type, name)
+
+	if dns.QR {
+		// Responses travel server -> client; keep local_* as the client side
+		raw["local_address"], raw["remote_address"] = raw["remote_address"], raw["local_address"]
+		raw["local_port"], raw["remote_port"] = raw["remote_port"], raw["local_port"]
+
+		event = "dns_response"
+		raw["response_code"] = dns.ResponseCode.String()
+
+		var answers []string
+		for _, a := range dns.Answers {
+			switch a.Type {
+			case layers.DNSTypeA, layers.DNSTypeAAAA:
+				answers = append(answers, a.IP.String())
+			case layers.DNSTypeCNAME:
+				answers = append(answers, string(a.CNAME))
+			case layers.DNSTypePTR:
+				answers = append(answers, string(a.PTR))
+			}
+		}
+		raw["answers"] = answers
+		message = fmt.Sprintf("DNS %s %s -> %s", q.Type, name, strings.Join(answers, ", "))
+
+		if dns.ResponseCode != layers.DNSResponseCodeNoErr {
+			message = fmt.Sprintf("DNS %s %s -> %s", q.Type, name, dns.ResponseCode)
+		}
+	}
+
+	return trafficEntry("dns", event, "dns-parser", "info", message, at, raw), true
+}
+
+// decodeTLSClientHello extracts SNI, ALPN and offered versions from the first
+// TLS record of a TCP segment. Hellos split across segments are not reassembled.
+func decodeTLSClientHello(packet gopacket.Packet, at time.Time) (LogEntry, bool) {
+	l := packet.Layer(layers.LayerTypeTCP)
+	if l == nil {
+		return LogEntry{}, false
+	}
+	data := l.(*layers.TCP).Payload
+
+	// record header(5) + handshake header(4) + version(2) + random(32)
+	if len(data) < 43 || data[0] != tlsRecordHandshake || data[5] != tlsHandshakeClientHi {
+		return LogEntry{}, false
+	}
+	recordVersion := binary.BigEndian.Uint16(data[1:3])
+	body := data[9:]
+	helloVersion := binary.BigEndian.Uint16(body[0:2])
+	body = body[34:]
+
+	// session id, cipher suites, compression methods
+	if len(body) < 1 || len(body) < 1+int(body[0]) {
+		return LogEntry{}, false
+	}
+	body = body[1+int(body[0]):]
+	if len(body) < 2 {
+		return LogEntry{}, false
+	}
+	cipherLen := int(binary.BigEndian.Uint16(body[0:2]))
+	if len(body) < 2+cipherLen+1 {
+		return LogEntry{}, false
+	}
+	cipherCount := cipherLen / 2
+	body = body[2+cipherLen:]
+	if len(body) < 1+int(body[0]) {
+		return LogEntry{}, false
+	}
+	body = body[1+int(body[0]):]
+
+	var serverName string
+	var alpn, versions []string
+	if len(body) >= 2 {
+		extLen := int(binary.BigEndian.Uint16(body[0:2]))
+		ext := body[2:]
+		if extLen < len(ext) {
+			ext = ext[:extLen]
+		}
+		for len(ext) >= 4 {
+			extType := binary.BigEndian.Uint16(ext[0:2])
+			n := int(binary.BigEndian.Uint16(ext[2:4]))
+			if len(ext) < 4+n {
+				break
+			}
+			value := ext[4 : 4+n]
+			ext = ext[4+n:]
+
+			switch extType {
+			case tlsExtServerName:
+				// list length(2) type(1) name length(2) name
+				if len(value) >= 5 && value[2] == 0 {
+					nameLen := int(binary.BigEndian.Uint16(value[3:5]))
+					if len(value) >= 5+nameLen {
+						serverName = string(value[5 : 5+nameLen])
+					}
+				}
+			case tlsExtALPN:
+				if len(value) >= 2 {
+					list := value[2:]
+					for len(list) >= 1 && len(list) >= 1+int(list[0]) {
+						alpn = append(alpn, string(list[1:1+int(list[0])]))
+						list = list[1+int(list[0]):]
+					}
+				}
+			case tlsExtSupportedVers:
+				if len(value) >= 1 {
+					list := value[1:]
+					for len(list) >= 2 {
+						versions = append(versions, tlsVersionName(binary.BigEndian.Uint16(list[0:2])))
+						list = list[2:]
+					}
+				}
+			}
+		}
+	}
+
+	raw := packetEndpoints(packet)
+	raw["server_name"] = serverName
+	raw["record_version"] = tlsVersionName(recordVersion)
+	raw["hello_version"] = tlsVersionName(helloVersion)
+	raw["cipher_suite_count"] = cipherCount
+	if len(alpn) > 0 {
+		raw["alpn"] = alpn
+	}
+	if len(versions) > 0 {
+		raw["supported_versions"] = versions
+	}
+
+	target := serverName
+	if target == "" {
+		target = fmt.Sprint(raw["remote_address"])
+	}
+	return trafficEntry("tls", "tls_client_hello", "tls-parser", "info", "TLS ClientHello to "+target, at, raw), true
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case 0x0300:
+		return "SSL3.0"
+	case 0x0301:
+		return "TLS1.0"
+	case 0x0302:
+		return "TLS1.1"
+	case 0x0303:
+		return "TLS1.2"
+	case 0x0304:
+		return "TLS1.3"
+	}
+	if v&0x0f0f == 0x0a0a {
+		return "GREASE"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// packetEndpoints returns the addressing fields shared by traffic log entries,
+// using the same key names as trackNetworkConnections.
+func packetEndpoints(packet gopacket.Packet) map[string]interface{} {
+	raw := make(map[string]interface{})
+	if net := packet.NetworkLayer(); net != nil {
+		src, dst := net.NetworkFlow().Endpoints()
+		raw["local_address"] = src.String()
+		raw["remote_address"] = dst.String()
+	}
+	switch t := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		raw["local_port"] = int(t.SrcPort)
+		raw["remote_port"] = int(t.DstPort)
+		raw["transport"] = "TCP"
+	case *layers.UDP:
+		raw["local_port"] = int(t.SrcPort)
+		raw["remote_port"] = int(t.DstPort)
+		raw["transport"] = "UDP"
+	}
+	return raw
+}
+
+func trafficEntry(logType, event, source, severity, message string, at time.Time, raw map[string]interface{}) LogEntry {
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    logType,
+		Event:      event,
+		Source:     source,
+		Severity:   severity,
+		Message:    message,
+		Timestamp:  at.UTC().Format(time.RFC3339),
+		RawData:    raw,
+	}
+}
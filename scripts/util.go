@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Small file and string helpers shared by the collectors.
+
+// configLines returns the non-empty, non-comment lines of a file.
+func configLines(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// globAll returns the matches of every pattern.
+func globAll(patterns []string) []string {
+	var out []string
+	for _, p := range patterns {
+		matches, _ := filepath.Glob(p)
+		out = append(out, matches...)
+	}
+	return out
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
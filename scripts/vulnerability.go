@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Vulnerability matching. Installed packages are matched against an OSV
+// feed every VULN_SCAN_INTERVAL. The feed is Config.VulnFeed (a file,
+// directory or zip kept up to date by hand on air-gapped networks) or,
+// when that is not set, the copy the server publishes, downloaded into
+// agentDir. Findings are reported once as vulnerability events and again
+// as vulnerability_resolved when the package is upgraded or removed.
+
+const (
+	VULN_STATE_FILE     = "vulnerabilities.json"
+	VULN_FEED_FILE      = "osv-feed"
+	VULN_SCAN_INTERVAL  = 6 * time.Hour
+	VULN_MAX_EVENTS     = 1000 // per scan; the rest are only counted in the summary
+	VULN_MAX_REFERENCES = 5
+)
+
+// vulnState is a finding already reported.
+type vulnState struct {
+	ID      string   `json:"id"`
+	CVEs    []string `json:"cves,omitempty"`
+	Package string   `json:"package"`
+	Version string   `json:"version"`
+	Source  string   `json:"source"`
+}
+
+func runVulnerabilityScanner() {
+	for {
+		policyMutex.RLock()
+		quarantined := isQuarantined
+		policyMutex.RUnlock()
+		if deviceID != "" && !quarantined {
+			if path := vulnFeedPath(); path != "" {
+				if err := scanVulnerabilities(path, true); err != nil {
+					logMessage("Vulnerability scan failed: " + err.Error())
+				}
+			}
+		}
+		time.Sleep(VULN_SCAN_INTERVAL)
+	}
+}
+
+// vulnFeedPath returns the configured feed, or refreshes and returns the
+// server copy ("" when there is none).
+func vulnFeedPath() string {
+	if agentConfig.VulnFeed != "" {
+		return os.ExpandEnv(agentConfig.VulnFeed)
+	}
+	path := filepath.Join(agentDir, VULN_FEED_FILE)
+	if err := downloadVulnFeed(path); err != nil {
+		logMessage("Vulnerability feed download failed: " + err.Error())
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// downloadVulnFeed fetches the server feed when it changed since the copy
+// on disk was written.
+func downloadVulnFeed(path string) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/vulnerabilities/feed?device_id=%s", apiURL, deviceID), nil)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		req.Header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+	}
+	client := http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified, resp.StatusCode == http.StatusNotFound:
+		return nil // unchanged, or the server does not publish a feed
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	logMessage("Vulnerability feed updated from server")
+	return nil
+}
+
+// matchVulnerabilities loads the feed entries naming an installed package
+// and matches them.
+func matchVulnerabilities(feedPath string, pkgs []softwarePackage) (*osvFeed, []osvFinding, error) {
+	names := make(map[string]bool)
+	for _, p := range pkgs {
+		names[strings.ToLower(p.Name)] = true
+		if p.SourcePackage != "" {
+			names[strings.ToLower(p.SourcePackage)] = true
+		}
+	}
+	feed, err := loadOSVFeed(feedPath, func(name string) bool { return names[name] })
+	if err != nil {
+		return nil, nil, err
+	}
+	findings := feed.match(pkgs, hostEcosystems())
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].pkg.Name != findings[j].pkg.Name {
+			return findings[i].pkg.Name < findings[j].pkg.Name
+		}
+		return findings[i].record.ID < findings[j].record.ID
+	})
+	return feed, findings, nil
+}
+
+// scanVulnerabilities matches the installed packages against the feed.
+func scanVulnerabilities(feedPath string, track bool) error {
+	pkgs, failed := installedPackages()
+	return reportVulnerabilities(feedPath, pkgs, failed, track)
+}
+
+// reportVulnerabilities reports the findings not reported yet and, when
+// tracking state, the ones that went away. Findings of the sources in
+// failed, which could not be listed, are kept as they are.
+func reportVulnerabilities(feedPath string, pkgs []softwarePackage, failed map[string]bool, track bool) error {
+	if len(pkgs) == 0 {
+		return nil
+	}
+	feed, findings, err := matchVulnerabilities(feedPath, pkgs)
+	if err != nil {
+		return err
+	}
+
+	previous := make(map[string]vulnState)
+	if track {
+		if data := loadBookmark(VULN_STATE_FILE); data != "" {
+			json.Unmarshal([]byte(data), &previous)
+		}
+	}
+
+	// reported holds what the server has been told; findings over the
+	// per-scan limit stay out of it and are sent on the next scan
+	reported := make(map[string]vulnState, len(findings))
+	counts := make(map[string]int)
+	fresh, sent := 0, 0
+	pkgKey := softwareKeys(pkgs)
+	for _, f := range findings {
+		key := pkgKey(f.pkg) + "|" + f.record.ID
+		level, _, _ := f.severity()
+		counts[level]++
+		state := vulnState{ID: f.record.ID, CVEs: f.record.cveIDs(), Package: f.pkg.Name, Version: f.pkg.Version, Source: f.pkg.Source}
+		if _, known := previous[key]; known {
+			reported[key] = state
+			continue
+		}
+		fresh++
+		if sent < VULN_MAX_EVENTS {
+			sendLog(f.logEntry())
+			reported[key] = state
+			sent++
+		}
+	}
+
+	installed := indexSoftware(pkgs)
+	resolved := 0
+	for key, v := range previous {
+		if _, ok := reported[key]; ok {
+			continue
+		}
+		if failed[v.Source] {
+			// Not listed this time: keep the finding instead of reporting it resolved
+			reported[key] = v
+			continue
+		}
+		sendLog(vulnResolvedEntry(v, installed[strings.TrimSuffix(key, "|"+v.ID)]))
+		resolved++
+	}
+
+	sendLog(LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    "security",
+		Event:      "vulnerability_scan_completed",
+		Source:     "osv",
+		Severity:   "info",
+		Message:    fmt.Sprintf("Vulnerability scan: %d findings in %d packages (%d new, %d resolved)", len(findings), len(pkgs), fresh, resolved),
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RawData: map[string]interface{}{
+			"findings":       len(findings),
+			"by_severity":    counts,
+			"packages":       len(pkgs),
+			"feed":           feedPath,
+			"feed_records":   feed.records,
+			"new":            fresh,
+			"resolved":       resolved,
+			"events_omitted": fresh - sent,
+		},
+	})
+
+	if track {
+		data, _ := json.Marshal(reported)
+		saveBookmark(VULN_STATE_FILE, string(data))
+	}
+	return nil
+}
+
+func (f osvFinding) logEntry() LogEntry {
+	level, score, vector := f.severity()
+	cves := f.record.cveIDs()
+	title := f.record.ID
+	if len(cves) > 0 && !strings.HasPrefix(f.record.ID, "CVE-") {
+		title = strings.Join(cves, ", ") + " (" + f.record.ID + ")"
+	}
+	msg := fmt.Sprintf("%s in %s %s (%s)", title, f.pkg.Name, f.pkg.Version, f.pkg.Source)
+	if f.fixed != "" {
+		msg += ": fixed in " + f.fixed
+	} else {
+		msg += ": no fixed version known"
+	}
+
+	var refs []string
+	for _, r := range f.record.References {
+		if len(refs) == VULN_MAX_REFERENCES {
+			break
+		}
+		refs = append(refs, r.URL)
+	}
+	summary := f.record.Summary
+	if summary == "" {
+		summary, _, _ = strings.Cut(f.record.Details, "\n")
+	}
+	raw := map[string]interface{}{
+		"vulnerability_id": f.record.ID,
+		"cve_ids":          cves,
+		"aliases":          f.record.Aliases,
+		"summary":          summary,
+		"severity":         level,
+		"package":          f.pkg.Name,
+		"version":          f.pkg.Version,
+		"package_source":   f.pkg.Source,
+		"arch":             f.pkg.Arch,
+		"ecosystem":        f.affected.Package.Ecosystem,
+		"fixed_version":    f.fixed,
+		"published":        f.record.Published,
+		"modified":         f.record.Modified,
+		"references":       refs,
+	}
+	if f.pkg.SourcePackage != "" {
+		raw["source_package"] = f.pkg.SourcePackage
+	}
+	if vector != "" {
+		raw["cvss_score"] = score
+		raw["cvss_vector"] = vector
+	}
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    "security",
+		Event:      "vulnerability",
+		Source:     "osv",
+		Severity:   level,
+		Message:    msg,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RawData:    raw,
+	}
+}
+
+func vulnResolvedEntry(v vulnState, now softwarePackage) LogEntry {
+	reason := "package removed"
+	if now.Name != "" {
+		reason = "package now at " + now.Version
+		if now.Version == v.Version {
+			reason = "no longer in the feed"
+		}
+	}
+	return LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    "security",
+		Event:      "vulnerability_resolved",
+		Source:     "osv",
+		Severity:   "info",
+		Message:    fmt.Sprintf("%s resolved in %s %s: %s", v.ID, v.Package, v.Version, reason),
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RawData: map[string]interface{}{
+			"vulnerability_id": v.ID,
+			"cve_ids":          v.CVEs,
+			"package":          v.Package,
+			"version":          v.Version,
+			"package_source":   v.Source,
+			"current_version":  now.Version,
+			"reason":           reason,
+		},
+	}
+}
+
+// vulnScanFeed matches the installed packages against a feed file and
+// prints every finding (the -vuln-scan flag).
+func vulnScanFeed(feedPath string, out io.Writer) error {
+	replayOutput = out
+	defer func() { replayOutput = nil }()
+	return scanVulnerabilities(feedPath, false)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const vulnTestFeed = `[
+  {"id": "TEST-2024-1", "aliases": ["CVE-2024-0001"], "summary": "snap tool flaw",
+   "affected": [{"package": {"ecosystem": "snap", "name": "tool"}, "ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "2.0"}]}]}]},
+  {"id": "TEST-2024-2", "aliases": ["CVE-2024-0002"], "summary": "flatpak app flaw",
+   "affected": [{"package": {"ecosystem": "flatpak", "name": "app"}, "ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "5.0"}]}]}]}
+]`
+
+func TestVulnerabilitiesKeptForFailedSources(t *testing.T) {
+	agentDir = t.TempDir()
+	feed := filepath.Join(t.TempDir(), "feed.json")
+	os.WriteFile(feed, []byte(vulnTestFeed), 0644)
+
+	var out bytes.Buffer
+	replayOutput = &out
+	defer func() { replayOutput = nil }()
+
+	tool := softwarePackage{Name: "tool", Version: "1.0", Source: "snap"}
+	app := softwarePackage{Name: "app", Version: "4.0", Source: "flatpak"}
+	scan := func(pkgs []softwarePackage, failed map[string]bool) string {
+		t.Helper()
+		out.Reset()
+		if err := reportVulnerabilities(feed, pkgs, failed, true); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	if got := scan([]softwarePackage{tool, app}, nil); strings.Count(got, `"event":"vulnerability"`) != 2 {
+		t.Fatalf("first scan: %s", got)
+	}
+
+	// flatpak could not be listed: its finding is neither resolved nor new
+	got := scan([]softwarePackage{tool}, map[string]bool{"flatpak": true})
+	if strings.Contains(got, `"event":"vulnerability_resolved"`) || strings.Contains(got, `"event":"vulnerability"`) {
+		t.Fatalf("failed source changed the findings: %s", got)
+	}
+	if got := scan([]softwarePackage{tool, app}, nil); strings.Contains(got, `"event":"vulnerability"`) {
+		t.Fatalf("kept finding reported again: %s", got)
+	}
+
+	// Upgraded while listed: resolved
+	app.Version = "5.1"
+	got = scan([]softwarePackage{tool, app}, nil)
+	if strings.Count(got, `"event":"vulnerability_resolved"`) != 1 || !strings.Contains(got, "TEST-2024-2") {
+		t.Fatalf("upgrade not resolved: %s", got)
+	}
+}
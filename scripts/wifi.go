@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Wi-Fi association discovery. Each platform fills a wifiAssociation (netsh on
+// Windows, nl80211 on Linux) and the result is tracked like a switch neighbor,
+// with the BSSID as chassis ID, so a BSSID change is reported as a roam.
+
+const (
+	WIFI_SCAN_INTERVAL = 30 * time.Second
+	WIFI_NEIGHBOR_TTL  = 90 // seconds; three missed scans
+)
+
+// Normalized security types, weakest first
+const (
+	WIFI_SECURITY_OPEN            = "open"
+	WIFI_SECURITY_OWE             = "owe"
+	WIFI_SECURITY_WEP             = "wep"
+	WIFI_SECURITY_WPA             = "wpa"
+	WIFI_SECURITY_WPA2_PERSONAL   = "wpa2-personal"
+	WIFI_SECURITY_WPA2_ENTERPRISE = "wpa2-enterprise"
+	WIFI_SECURITY_WPA3_PERSONAL   = "wpa3-personal"
+	WIFI_SECURITY_WPA3_ENTERPRISE = "wpa3-enterprise"
+)
+
+type wifiAssociation struct {
+	Interface      string
+	SSID           string
+	BSSID          string
+	FrequencyMHz   int
+	Channel        int
+	SignalDBM      int // 0 when the platform only reports a percentage
+	SignalPercent  int
+	Security       string
+	Authentication string // platform's own authentication/AKM description
+}
+
+func (a *wifiAssociation) neighbor() topologyNeighbor {
+	details := map[string]interface{}{
+		"ssid":     a.SSID,
+		"bssid":    a.BSSID,
+		"signal":   a.signalText(),
+		"security": a.Security,
+	}
+	if a.FrequencyMHz != 0 {
+		details["frequency_mhz"] = a.FrequencyMHz
+	}
+	if a.Channel != 0 {
+		details["channel"] = a.Channel
+	}
+	if a.SignalDBM != 0 {
+		details["signal_dbm"] = a.SignalDBM
+	}
+	if a.SignalPercent != 0 {
+		details["signal_percent"] = a.SignalPercent
+	}
+	if a.Authentication != "" {
+		details["authentication"] = a.Authentication
+	}
+
+	return topologyNeighbor{
+		Protocol:   "wifi",
+		Interface:  a.Interface,
+		SystemName: a.SSID,
+		ChassisID:  a.BSSID,
+		PortID:     a.BSSID,
+		TTL:        WIFI_NEIGHBOR_TTL,
+		Details:    details,
+	}
+}
+
+func (a *wifiAssociation) signalText() string {
+	if a.SignalDBM != 0 {
+		return fmt.Sprintf("%d dBm", a.SignalDBM)
+	}
+	if a.SignalPercent != 0 {
+		return fmt.Sprintf("%d%%", a.SignalPercent)
+	}
+	return ""
+}
+
+// trackWifi feeds one scan result into the neighbor table and the rogue AP checks.
+func trackWifi(a wifiAssociation, now time.Time) {
+	if a.BSSID == "" {
+		return
+	}
+	trackNeighbor(a.neighbor(), now)
+	checkRogueAccessPoint(a, now)
+}
+
+// wifiChannel converts a center frequency to its IEEE 802.11 channel number.
+func wifiChannel(freq int) int {
+	switch {
+	case freq == 2484:
+		return 14
+	case freq >= 2412 && freq < 2484:
+		return (freq - 2407) / 5
+	case freq >= 5955 && freq <= 7115: // 6 GHz
+		return (freq - 5950) / 5
+	case freq >= 5000 && freq < 5955:
+		return (freq - 5000) / 5
+	case freq >= 58320 && freq <= 70200: // 60 GHz
+		return (freq - 56160) / 2160
+	}
+	return 0
+}
+
+// wifiSecurityFromIEs classifies the BSS security from its information
+// elements (RSN / WPA vendor IE) and the capability privacy bit.
+func wifiSecurityFromIEs(ies []byte, privacy bool) string {
+	var rsn, wpa []byte
+	for len(ies) >= 2 {
+		id, n := ies[0], int(ies[1])
+		if len(ies) < 2+n {
+			break
+		}
+		body := ies[2 : 2+n]
+		ies = ies[2+n:]
+
+		switch {
+		case id == 48:
+			rsn = body
+		case id == 221 && n >= 4 && body[0] == 0x00 && body[1] == 0x50 && body[2] == 0xf2 && body[3] == 1:
+			wpa = body
+		}
+	}
+
+	if rsn != nil {
+		return wifiSecurityFromRSN(rsn)
+	}
+	if wpa != nil {
+		return WIFI_SECURITY_WPA
+	}
+	if privacy {
+		return WIFI_SECURITY_WEP
+	}
+	return WIFI_SECURITY_OPEN
+}
+
+// wifiSecurityFromRSN picks the strongest AKM suite advertised in an RSN IE.
+func wifiSecurityFromRSN(rsn []byte) string {
+	// version(2) group cipher(4) pairwise count(2) pairwise suites akm count(2) akm suites
+	if len(rsn) < 8 {
+		return WIFI_SECURITY_WPA2_PERSONAL
+	}
+	pairwise := int(rsn[6]) | int(rsn[7])<<8
+	rest := rsn[8:]
+	if len(rest) < pairwise*4+2 {
+		return WIFI_SECURITY_WPA2_PERSONAL
+	}
+	rest = rest[pairwise*4:]
+	akms := int(rest[0]) | int(rest[1])<<8
+	rest = rest[2:]
+
+	security := ""
+	rank := -1
+	for i := 0; i < akms && len(rest) >= 4; i++ {
+		suite := rest[:4]
+		rest = rest[4:]
+		if suite[0] != 0x00 || suite[1] != 0x0f || suite[2] != 0xac {
+			continue
+		}
+		var s string
+		var r int
+		switch suite[3] {
+		case 1, 3, 5: // 802.1X, FT-802.1X, 802.1X-SHA256
+			s, r = WIFI_SECURITY_WPA2_ENTERPRISE, 2
+		case 2, 4, 6: // PSK, FT-PSK, PSK-SHA256
+			s, r = WIFI_SECURITY_WPA2_PERSONAL, 1
+		case 8, 9, 24, 25: // SAE, FT-SAE, SAE-EXT-KEY
+			s, r = WIFI_SECURITY_WPA3_PERSONAL, 3
+		case 11, 12, 13: // Suite B
+			s, r = WIFI_SECURITY_WPA3_ENTERPRISE, 4
+		case 18: // OWE
+			s, r = WIFI_SECURITY_OWE, 0
+		default:
+			continue
+		}
+		if r > rank {
+			security, rank = s, r
+		}
+	}
+	if security == "" {
+		return WIFI_SECURITY_WPA2_PERSONAL
+	}
+	return security
+}
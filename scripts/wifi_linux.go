@@ -0,0 +1,146 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// nl80211Conn is a generic netlink socket bound to the nl80211 family.
+type nl80211Conn struct {
+	fd     int
+	family uint16
+	seq    uint32
+}
+
+func dialNL80211() (*nl80211Conn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_GENERIC)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	tv := unix.NsecToTimeval((5 * time.Second).Nanoseconds())
+	unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+
+	c := &nl80211Conn{fd: fd}
+	msgs, err := c.request(genlIDCtrl, unix.NLM_F_REQUEST, ctrlCmdGetFamily,
+		encodeNetlinkAttr(nil, ctrlAttrName, append([]byte("nl80211"), 0)))
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("resolve nl80211 family: %v", err)
+	}
+	for _, msg := range msgs {
+		if id, ok := nlAttrU16(genlAttrs(msg), ctrlAttrFamilyID); ok {
+			c.family = id
+		}
+	}
+	if c.family == 0 {
+		c.Close()
+		return nil, fmt.Errorf("nl80211 family not available")
+	}
+	return c, nil
+}
+
+func (c *nl80211Conn) Close() error {
+	return unix.Close(c.fd)
+}
+
+// request sends one message and collects replies until DONE (dumps) or the
+// first reply (single requests).
+func (c *nl80211Conn) request(family uint16, flags uint16, cmd uint8, attrs []byte) ([]netlinkMessage, error) {
+	c.seq++
+	req := encodeGenlRequest(family, flags, c.seq, cmd, attrs)
+	if err := unix.Sendto(c.fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	dump := flags&unix.NLM_F_DUMP == unix.NLM_F_DUMP
+	buf := make([]byte, 64*1024)
+	var out []netlinkMessage
+	for {
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return out, err
+		}
+		msgs, done, err := parseNetlinkMessages(append([]byte(nil), buf[:n]...))
+		for _, msg := range msgs {
+			if msg.Seq == c.seq {
+				out = append(out, msg)
+			}
+		}
+		if err != nil {
+			return out, err
+		}
+		if done || (!dump && len(out) > 0) {
+			return out, nil
+		}
+	}
+}
+
+func (c *nl80211Conn) dump(cmd uint8, attrs []byte) ([]netlinkMessage, error) {
+	return c.request(c.family, unix.NLM_F_REQUEST|unix.NLM_F_DUMP, cmd, attrs)
+}
+
+// wifiAssociations returns the current association of every station interface.
+func wifiAssociations() ([]wifiAssociation, error) {
+	c, err := dialNL80211()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	msgs, err := c.dump(nl80211CmdGetInterface, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []wifiAssociation
+	for _, iface := range parseNL80211Interfaces(msgs) {
+		if iface.Type != nl80211IftypeStation {
+			continue
+		}
+		index := make([]byte, 4)
+		binary.LittleEndian.PutUint32(index, iface.Index)
+		ifAttr := encodeNetlinkAttr(nil, nl80211AttrIfindex, index)
+
+		scan, err := c.dump(nl80211CmdGetScan, ifAttr)
+		if err != nil {
+			logMessage("WiFi: scan dump failed on " + iface.Name + ": " + err.Error())
+			continue
+		}
+		a, ok := parseNL80211Association(iface, scan)
+		if !ok {
+			continue
+		}
+		// Station info carries the live signal; the BSS entry is from the last scan
+		if stations, err := c.dump(nl80211CmdGetStation, ifAttr); err == nil {
+			if dbm, ok := parseNL80211StationSignal(stations, a.BSSID); ok {
+				a.SignalDBM = dbm
+			}
+		}
+		result = append(result, a)
+	}
+	return result, nil
+}
+
+// scanWifiAccessPoint polls nl80211 for the associated access point.
+func scanWifiAccessPoint() {
+	ticker := time.NewTicker(WIFI_SCAN_INTERVAL)
+	for range ticker.C {
+		assocs, err := wifiAssociations()
+		if err != nil {
+			// No wireless hardware / nl80211 module: nothing to track
+			continue
+		}
+		for _, a := range assocs {
+			trackWifi(a, time.Now())
+		}
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !windows && !linux
+
+package main
+
+// scanWifiAccessPoint has no implementation on this platform.
+func scanWifiAccessPoint() {}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import (
+	"time"
+)
+
+// scanWifiAccessPoint polls `netsh wlan show interfaces` for the associated
+// access point.
+func scanWifiAccessPoint() {
+	ticker := time.NewTicker(WIFI_SCAN_INTERVAL)
+	for range ticker.C {
+		out, err := runCommandWithTimeout("netsh", "wlan", "show", "interfaces")
+		if err != nil {
+			continue
+		}
+		for _, a := range parseNetshInterfaces(string(out)) {
+			trackWifi(a, time.Now())
+		}
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -16,168 +16,11 @@ import (
 	"runtime"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
-
-	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
-	"golang.org/x/sys/windows/svc"
 )
 
 
 
-func captureLLDP() {
-	if runtime.GOOS != "windows" {
-		return
-	}
-
-	// Wait for network to be ready
-	time.Sleep(10 * time.Second)
-	logMessage("Initializing LLDP Capture...")
-
-	devices, err := pcap.FindAllDevs()
-	if err != nil {
-		logMessage("LLDP Error: Could not list interfaces: " + err.Error())
-		return
-	}
-
-	for _, device := range devices {
-		// Ignore loopback
-		if strings.Contains(strings.ToLower(device.Description), "loopback") {
-			continue
-		}
-
-		go func(dev pcap.Interface) {
-			logMessage("LLDP: Attempting to listen on " + dev.Description)
-			
-			// Promiscuous mode often fails on Wi-Fi on Windows. Try false first if true fails?
-			// Actually, standard is promiscuous=true. But for LLDP (multicast), non-promiscuous might work if multicast is allowed.
-			handle, err := pcap.OpenLive(dev.Name, 1600, true, 30*time.Second)
-			if err != nil {
-				logMessage("LLDP Warning: Failed to open " + dev.Description + ": " + err.Error())
-				return
-			}
-			defer handle.Close()
-
-			if err := handle.SetBPFFilter("ether proto 0x88cc"); err != nil {
-				logMessage("LLDP: Failed to set BPF filter on " + dev.Description)
-				return
-			}
-			
-			logMessage("LLDP: Listening on " + dev.Description)
-
-			packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-			for packet := range packetSource.Packets() {
-				// ... existing packet processing ...
-				lldpLayer := packet.Layer(layers.LayerTypeLinkLayerDiscovery)
-				if lldpLayer != nil {
-					lldp := lldpLayer.(*layers.LinkLayerDiscovery)
-					
-					var chassisID, portID, sysName string
-					
-					for _, tlv := range lldp.Values {
-						switch tlv.Type {
-						case layers.LLDPTLVChassisID:
-							chassisID = string(tlv.Value)
-						case layers.LLDPTLVPortID:
-							portID = string(tlv.Value)
-						case layers.LLDPTLVSysName:
-							sysName = string(tlv.Value)
-						}
-					}
-					
-					info := fmt.Sprintf("Switch: %s | Port: %s | Chassis: %s", sysName, portID, chassisID)
-					// Always log distinct new info
-					if !strings.Contains(lldpNeighborInfo, info) {
-						lldpNeighborInfo = info
-						logMessage("LLDP Discovery: " + info)
-						
-						sendLog(LogEntry{
-							DeviceID:     deviceID,
-							DeviceName:   deviceName,
-							Hostname:     getHostname(),
-							LogType:      "network_topology",
-							HardwareType: "switch",
-							Event:        "lldp_discovery",
-							Source:       "lldp-agent",
-							Severity:     "info",
-							Message:      "LLDP Neighbor Found: " + info,
-							Timestamp:    time.Now().UTC().Format(time.RFC3339),
-							RawData: map[string]interface{}{
-								"switch_name": sysName,
-								"port_id":     portID,
-								"chassis_id":  chassisID,
-								"interface":   dev.Description,
-							},
-						})
-					}
-				}
-			}
-		}(device)
-	}
-
-	// Wi-Fi "LLDP" Fallback (BSSID Discovery)
-	go scanWifiAccessPoint()
-}
-
-func scanWifiAccessPoint() {
-	ticker := time.NewTicker(30 * time.Second)
-	for range ticker.C {
-		out, err := runCommandWithTimeout("netsh", "wlan", "show", "interfaces")
-		if err == nil {
-			output := string(out)
-			var ssid, bssid, signal string
-			lines := strings.Split(output, "\n")
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				if strings.HasPrefix(line, "SSID") && !strings.HasPrefix(line, "BSSID") {
-					parts := strings.Split(line, ":")
-					if len(parts) > 1 { ssid = strings.TrimSpace(parts[1]) }
-				}
-				if strings.HasPrefix(line, "BSSID") {
-					parts := strings.Split(line, ":")
-					if len(parts) > 1 { 
-						// Reconstruct MAC (it splits on colons)
-						bssid = strings.TrimSpace(strings.Join(parts[1:], ":")) 
-					}
-				}
-				if strings.HasPrefix(line, "Signal") {
-					parts := strings.Split(line, ":")
-					if len(parts) > 1 { signal = strings.TrimSpace(parts[1]) }
-				}
-			}
-			
-			if bssid != "" {
-				info := fmt.Sprintf("WiFi AP: %s | BSSID: %s | Signal: %s", ssid, bssid, signal)
-				// Basic dedup
-				if !strings.Contains(lldpNeighborInfo, bssid) {
-					lldpNeighborInfo += " | " + info
-					logMessage("WiFi Discovery: " + info)
-					
-					sendLog(LogEntry{
-						DeviceID:     deviceID,
-						DeviceName:   deviceName,
-						Hostname:     getHostname(),
-						LogType:      "network_topology",
-						HardwareType: "wifi_ap",
-						Event:        "wifi_discovery",
-						Source:       "windows-agent",
-						Severity:     "info",
-						Message:      "Connected to AP: " + ssid,
-						Timestamp:    time.Now().UTC().Format(time.RFC3339),
-						RawData: map[string]interface{}{
-							"ssid": ssid,
-							"bssid": bssid, // Acts as the "Port ID" or "Chassis ID" for WiFi
-							"signal": signal,
-						},
-					})
-				}
-			}
-		}
-	}
-}
-
 const (
 	DEFAULT_API_URL = "https://lily-recrudescent-scantly.ngrok-free.dev" // replaced by build script
 	POLL_INTERVAL             = 3 * time.Second // Faster polling for USB
@@ -202,7 +45,11 @@ var (
 	apiURL        string
 	
 	agentDir      string
+	agentConfig   Config
 	isQuarantined = false
+	// Set when the agent quarantined itself (malware scan match) until the server confirms or releases it
+	localQuarantineReason string
+	localQuarantineSince  time.Time
 	// Rate limiting for network logs: key = "process:remote_ip:port", value = last log time
 	networkLogCache = make(map[string]time.Time)
 
@@ -220,7 +67,6 @@ var (
 	
 	// Track connected USBs to detect disconnects
 	lastConnectedUSB = make(map[string]bool)
-	lldpNeighborInfo string
 
 	// MUTEX for safe concurrent access to policies
 	policyMutex sync.RWMutex
@@ -236,6 +82,7 @@ type DeviceRegistration struct {
 	MACAddress   string `json:"mac_address"`
 	OSVersion    string `json:"os_version"`
 	AgentVersion string `json:"agent_version"`
+	Hardware     *hardwareInventory `json:"hardware,omitempty"`
 }
 
 type LogEntry struct {
@@ -254,6 +101,29 @@ type LogEntry struct {
 
 type Config struct {
 	ServerURL string `json:"server_url"`
+
+	// Optional NetFlow v9 / IPFIX export of tracked connections
+	FlowCollector    string `json:"flow_collector,omitempty"` // host:port (UDP)
+	FlowFormat       string `json:"flow_format,omitempty"`    // "ipfix" (default) or "netflow9"
+	FlowEnterpriseID uint32 `json:"flow_enterprise_id,omitempty"`
+
+	// Max event log / journal entries sent per source per collection cycle
+	LogCatchUpLimit int `json:"log_catchup_limit,omitempty"`
+
+	// Install the auditd baseline rule set on Linux
+	AuditBaselineRules bool `json:"audit_baseline_rules,omitempty"`
+
+	// Add imphash and ssdeep fuzzy hashes to executable SHA-256 hashes
+	ExtendedHashes bool `json:"extended_hashes,omitempty"`
+
+	// File integrity monitoring: paths (globs allowed) replacing the
+	// platform defaults, and extra exclusion patterns
+	FIMPaths   []string `json:"fim_paths,omitempty"`
+	FIMExclude []string `json:"fim_exclude,omitempty"`
+
+	// OSV vulnerability feed (JSON file, directory or zip); when empty the
+	// feed published by the server is downloaded
+	VulnFeed string `json:"vuln_feed,omitempty"`
 }
 
 type UsbPolicy struct {
@@ -275,6 +145,12 @@ type QuarantineStatus struct {
 	UsbReadOnly      bool        `json:"usb_read_only"`
 	UsbExpiration    string      `json:"usb_expiration_date"`
 	UsbPolicies      []UsbPolicy `json:"usb_policies"`
+	WifiApprovedAPs  []ApprovedAccessPoint `json:"wifi_approved_aps"`
+	SigmaRules       []SigmaRule `json:"sigma_rules"`
+	Yara             YaraPolicy  `json:"yara"`
+	Posture          PosturePolicy `json:"posture"`
+	// Releases a quarantine the agent entered itself (YARA match) that started before this time
+	LocalQuarantineReleasedAt string `json:"local_quarantine_released_at"`
 }
 
 func init() {
@@ -297,6 +173,14 @@ func init() {
 	} else {
 		apiURL = string(decoded)
 	}
+
+	loadDeviceID()
+}
+
+// loadServerConfig applies agent.config (or auto-detects the server) and starts
+// passive discovery. Kept out of init() so offline tools like -replay start
+// without probing the network.
+func loadServerConfig() {
 	// Note: loadOrDetectServerURL might overwrite this if config exists
 	// But we set the default here.
 	
@@ -311,7 +195,6 @@ func init() {
 		// Let's rely on loadOrDetectServerURL but use apiURL as fallback if needed.
 	}
 
-	loadDeviceID()
 	go captureLLDP()
 }
 
@@ -375,14 +258,13 @@ func testConnection(url string) bool {
 	return false
 }
 
+// getLocalIP returns the IPv4 address of the primary interface, "" when
+// there is none.
 func getLocalIP() string {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
-		return ""
+	if ifc := primaryInterface(localInterfaces()); ifc != nil {
+		return ifc.address(true)
 	}
-	defer conn.Close()
-	ip := conn.LocalAddr().(*net.UDPAddr)
-	return ip.IP.String()
+	return ""
 }
 
 func loadOrDetectServerURL() string {
@@ -390,6 +272,7 @@ func loadOrDetectServerURL() string {
 	if data, err := os.ReadFile(path); err == nil {
 		var cfg Config
 		if json.Unmarshal(data, &cfg) == nil {
+			agentConfig = cfg
 			if cfg.ServerURL != "" {
 				logMessage("Loaded server URL from config")
 				return cfg.ServerURL
@@ -402,8 +285,9 @@ func loadOrDetectServerURL() string {
 }
 
 func saveConfig(url string) {
-	cfg := Config{ServerURL: url}
-	data, _ := json.Marshal(cfg)
+	// Keep any other settings (flow export etc.) already present in the file
+	agentConfig.ServerURL = url
+	data, _ := json.Marshal(agentConfig)
 	os.WriteFile(filepath.Join(agentDir, CONFIG_FILE), data, 0644)
 }
 
@@ -435,6 +319,11 @@ func saveDeviceID(id string) {
 func logMessage(msg string) {
 	t := time.Now().Format("2006-01-02 15:04:05")
 	line := "[" + t + "] " + msg + "\n"
+	if replayOutput != nil {
+		// Keep stdout a clean LogEntry stream and leave the agent log untouched
+		fmt.Fprint(os.Stderr, line)
+		return
+	}
 	fmt.Print(line)
 
 	path := filepath.Join(agentDir, LOG_FILE)
@@ -462,7 +351,7 @@ func runCommandWithTimeout(name string, args ...string) ([]byte, error) {
 
 	cmd := exec.CommandContext(ctx, name, args...)
 	// On Windows, forcing hide window if possible (though for internal commands it's less visible)
-	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	hideWindow(cmd)
 	
 	return cmd.Output()
 }
@@ -472,6 +361,7 @@ func initializeDevice() error {
 	ip := getIPAddress()
 	mac := getMACAddress()
 	osv := getOSVersion()
+	hw := collectHardware()
 
 
 	// Ensure device_name is always the hostname, not a USB device name
@@ -489,6 +379,7 @@ func initializeDevice() error {
 		MACAddress:   mac,
 		OSVersion:    osv,
 		AgentVersion: VERSION,
+		Hardware:     &hw,
 	}
 
 	data, _ := json.Marshal(reg)
@@ -508,6 +399,7 @@ func initializeDevice() error {
 		// Always save the device ID, even if we had one before
 		// This handles the case where device was deleted and re-registered
 		saveDeviceID(id)
+		saveHardwareState(hw)
 		logMessage("Device registered ID: " + id)
 		return nil
 	}
@@ -515,65 +407,24 @@ func initializeDevice() error {
 	return fmt.Errorf("register failed: %s", string(body))
 }
 
+// getIPAddress returns the address of the interface holding the default
+// route (IPv4 preferred).
 func getIPAddress() string {
-	// Use PowerShell to get the primary network adapter IP address
-	out, err := runCommandWithTimeout("powershell", "-Command",
-		"Get-NetIPAddress -AddressFamily IPv4 | "+
-			"Where-Object { $_.IPAddress -notlike '127.*' -and $_.IPAddress -notlike '169.254.*' } | "+
-			"Sort-Object InterfaceIndex | "+
-			"Select-Object -First 1 -ExpandProperty IPAddress")
-
-	if err != nil {
-		// Fallback to old method
-		out2, err2 := runCommandWithTimeout("ipconfig")
-		if err2 != nil {
-			return "127.0.0.1"
+	if ifc := primaryInterface(localInterfaces()); ifc != nil {
+		if ip := ifc.address(false); ip != "" {
+			return ip
 		}
-
-		for _, line := range strings.Split(string(out2), "\n") {
-
-			if strings.Contains(line, "IPv4") {
-				parts := strings.Fields(line)
-				if len(parts) > 0 {
-					ip := parts[len(parts)-1]
-					if !strings.HasPrefix(ip, "127.") && !strings.HasPrefix(ip, "169.254.") {
-						return ip
-					}
-				}
-			}
-		}
-		return "127.0.0.1"
-	}
-
-	ip := strings.TrimSpace(string(out))
-	if ip != "" && !strings.HasPrefix(ip, "127.") && !strings.HasPrefix(ip, "169.254.") {
-		return ip
 	}
 	return "127.0.0.1"
 }
 
+// getMACAddress returns the MAC address of the same interface as
+// getIPAddress, as XX:XX:XX:XX:XX:XX.
 func getMACAddress() string {
-	// Use PowerShell to get the primary network adapter MAC address
-	out, err := runCommandWithTimeout("powershell", "-Command",
-		"Get-NetAdapter | "+
-			"Where-Object { $_.Status -eq 'Up' -and $_.InterfaceDescription -notlike '*Loopback*' } | "+
-			"Sort-Object InterfaceIndex | "+
-			"Select-Object -First 1 -ExpandProperty MacAddress")
-
-	if err != nil {
-		return ""
+	if ifc := primaryInterface(localInterfaces()); ifc != nil {
+		return ifc.MAC
 	}
-
-	mac := strings.TrimSpace(string(out))
-	// Remove dashes and colons, return in standard format
-	mac = strings.ReplaceAll(mac, "-", "")
-	mac = strings.ReplaceAll(mac, ":", "")
-	if len(mac) == 12 {
-		// Format as XX:XX:XX:XX:XX:XX
-		return fmt.Sprintf("%s:%s:%s:%s:%s:%s",
-			mac[0:2], mac[2:4], mac[4:6], mac[6:8], mac[8:10], mac[10:12])
-	}
-	return mac
+	return ""
 }
 
 func getOSVersion() string {
@@ -614,9 +465,10 @@ func checkQuarantineStatus() {
 	}
 
 	// Check State Change
-	policyMutex.RLock()
+	policyMutex.Lock()
 	currentlyQuarantined := isQuarantined
-	policyMutex.RUnlock()
+	localHold := localQuarantineHeld(q)
+	policyMutex.Unlock()
 
 	if q.IsQuarantined && !currentlyQuarantined {
 		// CHANGE: Safe -> Quarantined
@@ -626,7 +478,7 @@ func checkQuarantineStatus() {
 		
 		logMessage("⚠️ QUARANTINE: " + q.QuarantineReason)
 		enforceQuarantine(q.QuarantineReason)
-	} else if !q.IsQuarantined && currentlyQuarantined {
+	} else if !q.IsQuarantined && currentlyQuarantined && !localHold {
 		// CHANGE: Quarantined -> Safe
 		policyMutex.Lock()
 		isQuarantined = false
@@ -642,8 +494,13 @@ func checkQuarantineStatus() {
 	usbReadOnly = q.UsbReadOnly
 	usbExpiration = q.UsbExpiration
 	currentPolicies = q.UsbPolicies
+	approvedAccessPoints = q.WifiApprovedAPs
 	policyMutex.Unlock()
 
+	updateSigmaRules(q.SigmaRules)
+	updateYaraPolicy(q.Yara)
+	updatePosturePolicy(q.Posture)
+
 	logMessage(fmt.Sprintf("Received %d USB policies from server", len(currentPolicies)))
 }
 
@@ -1118,9 +975,9 @@ func trackNetworkConnections() {
 	// For UDP, we use Get-NetUDPEndpoint. It doesn't have RemoteAddress/RemotePort usually (connectionless),
 	// so we will fill those with "*" or "0".
 	psScript := `
-		$tcp = Get-NetTCPConnection -State Established -ErrorAction SilentlyContinue | 
+		$tcp = Get-NetTCPConnection -State Established,SynSent,SynReceived,Listen -ErrorAction SilentlyContinue | 
 			Where-Object { $_.RemoteAddress -notlike '127.*' -and $_.RemoteAddress -ne '::1' } |
-			Select-Object LocalAddress, LocalPort, RemoteAddress, RemotePort, State, OwningProcess, @{Name='Protocol';Expression={'TCP'}}
+			Select-Object LocalAddress, LocalPort, RemoteAddress, RemotePort, @{Name='State';Expression={$_.State.ToString()}}, OwningProcess, @{Name='Protocol';Expression={'TCP'}}
 		
 		$udp = Get-NetUDPEndpoint -ErrorAction SilentlyContinue | 
 			Where-Object { $_.LocalAddress -notlike '127.*' -and $_.LocalAddress -ne '::1' } |
@@ -1145,7 +1002,17 @@ func trackNetworkConnections() {
 	}
 
 	hostname := getHostname()
-	ts := time.Now().UTC().Format(time.RFC3339)
+	now := time.Now()
+	ts := now.UTC().Format(time.RFC3339)
+
+	// Ports we listen on: a flow whose local port is one of these was accepted, not initiated
+	listenPorts := make(map[int]bool)
+	for _, conn := range connections {
+		if state, _ := conn["State"].(string); state == "Listen" {
+			localPort, _ := conn["LocalPort"].(float64)
+			listenPorts[int(localPort)] = true
+		}
+	}
 
 	// Browser processes to exclude (optional: keep or remove based on "all protocols")
 	excludedProcesses := []string{
@@ -1172,9 +1039,25 @@ func trackNetworkConnections() {
 		pid, _ := conn["OwningProcess"].(float64)
 		transport, _ := conn["Protocol"].(string) // "TCP" or "UDP" from PowerShell
 
-		// Get process name from PID
+		if state == "Listen" {
+			continue
+		}
+
+		// Inbound scan detection runs before the process filter: the accepting
+		// process is often svchost (SMB/RDP), which is excluded below.
+		inbound := transport == "TCP" && listenPorts[int(localPort)]
+		if inbound && scanTracker.observeInbound(remoteAddr, int(localPort), now) {
+			continue // Covered by the aggregated inbound_scan event
+		}
+		if state == "SynReceived" {
+			continue // Half-open attempts only feed the detector
+		}
+
+		// Get process name from PID: the process table first, PowerShell for processes it has not seen yet
 		processName := "unknown"
-		if pid > 0 {
+		if name := processNameByPID(int(pid)); name != "" {
+			processName = name
+		} else if pid > 0 {
 			pidOut, err := runCommandWithTimeout("powershell", "-Command",
 				fmt.Sprintf("(Get-Process -Id %d -ErrorAction SilentlyContinue).ProcessName", int(pid)))
 			if err == nil {
@@ -1182,6 +1065,17 @@ func trackNetworkConnections() {
 			}
 		}
 
+		// Flow export covers every established flow, including processes excluded from logging
+		if remoteAddr != "*" && remotePort != 0 && state != "SynSent" {
+			flowExport.observe(flowKey{
+				localAddr:  localAddr,
+				localPort:  int(localPort),
+				remoteAddr: remoteAddr,
+				remotePort: int(remotePort),
+				transport:  transport,
+			}, processName, int(pid), now)
+		}
+
 // ... (omitting middle part, assuming replace handles block properly if contiguous but let's be careful)
 // Actually I should split this if lines are not contiguous or if "..." logic fails.
 // Let's do sendSystemLogs separately.
@@ -1205,6 +1099,13 @@ func trackNetworkConnections() {
 			continue
 		}
 
+		if !inbound && scanTracker.observeOutbound(processName, remoteAddr, int(remotePort), now) {
+			continue // Covered by the aggregated outbound_scan / lateral_movement event
+		}
+		if state == "SynSent" {
+			continue // Unanswered attempts (closed or filtered ports) only feed the detector
+		}
+
 		// Rate limiting: only log same connection once per 5 minutes
 		connKey := fmt.Sprintf("%s:%s:%d", processName, remoteAddr, int(remotePort))
 		if lastLog, exists := networkLogCache[connKey]; exists {
@@ -1257,6 +1158,11 @@ func trackNetworkConnections() {
 			RawData:    rawData,
 		})
 	}
+
+	for _, alert := range scanTracker.evaluate(hostname, now) {
+		sendLog(alert)
+	}
+	flowExport.flush(now)
 }
 
 func resolveProtocol(port int) string {
@@ -1338,103 +1244,65 @@ func sendSystemLogs() {
 	}
 	policyMutex.RUnlock()
 
-	host := getHostname()
-	ts := time.Now().UTC().Format(time.RFC3339)
+	collectSystemLogs()
+}
 
-	// Collect logs from multiple sources: Application, System, and Security
-	logSources := []struct {
-		logName string
-		logType string
-	}{
-		{"Application", "application"},
-		{"System", "system"},
-		{"Security", "security"},
-	}
+// safeGo runs fn in a goroutine whose panic is logged instead of killing
+// the agent.
+func safeGo(name string, fn func()) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logMessage(fmt.Sprintf("CRITICAL ERROR: Routine '%s' panicked: %v", name, r))
+				// Optional: Restart routine? For now, we just log to prevent full crash.
+			}
+		}()
+		fn()
+	}()
+}
 
-	for _, source := range logSources {
-		out, err := runCommandWithTimeout("powershell", "-Command",
-			fmt.Sprintf("Get-EventLog -LogName %s -Newest 10 -ErrorAction SilentlyContinue | "+
-				"Select-Object Message, EventID, EntryType, @{Name='TimeGenerated'; Expression={$_.TimeGenerated.ToUniversalTime().ToString('yyyy-MM-ddTHH:mm:ssZ')}}, Source | "+
-				"ConvertTo-Json", source.logName))
+// sendLog posts one entry. The error tells callers that track what was sent
+// (bookmarks, baselines) that the entry did not reach the server.
+func sendLog(entry LogEntry) error {
+	processes.enrich(&entry)
 
-		if err != nil || len(out) == 0 {
-			continue
+	// Detections are shipped right after the event that triggered them
+	detections := authAnalytics.observe(&entry)
+	if replayOutput != nil {
+		// Offline replay keeps each detection right after its event
+		detections = append(detections, evaluateSigma(entry)...)
+	}
+	defer func() {
+		for _, d := range detections {
+			sendLog(d)
 		}
-
-		var logs []map[string]interface{}
-		if json.Unmarshal(out, &logs) != nil {
-			var single map[string]interface{}
-			if json.Unmarshal(out, &single) == nil {
-				logs = []map[string]interface{}{single}
-			} else {
-				continue
-			}
+		if replayOutput == nil {
+			queueSigma(entry)
 		}
+	}()
 
-		for _, logItem := range logs {
-			msg, _ := logItem["Message"].(string)
-			if msg == "" {
-				continue
-			}
-
-			etype, _ := logItem["EntryType"].(string)
-			eventID, _ := logItem["EventID"].(float64)
-			logSource, _ := logItem["Source"].(string)
-
-			// Parse timestamp if available
-			timeGen, _ := logItem["TimeGenerated"].(string)
-			if timeGen == "" {
-				timeGen = ts
-			}
-
-			severity := "info"
-			switch etype {
-			case "Error":
-				severity = "error"
-			case "Warning":
-				severity = "warning"
-			case "FailureAudit":
-				severity = "high"
-			case "SuccessAudit":
-				severity = "info"
-			}
-
-			// Create raw data with event details
-			rawData := map[string]interface{}{
-				"event_id":   int(eventID),
-				"entry_type": etype,
-				"source":     logSource,
-			}
+	data, _ := json.Marshal(entry)
 
-			sendLog(LogEntry{
-				DeviceID:   deviceID,
-				DeviceName: deviceName,
-				Hostname:   host,
-				LogType:    source.logType,
-				Source:     fmt.Sprintf("WinEventLog-%s", source.logName),
-				Severity:   severity,
-				Message:    msg,
-				Timestamp:  timeGen,
-				RawData:    rawData,
-			})
-		}
+	// Offline replay prints the stream instead of posting it
+	if replayOutput != nil {
+		_, err := replayOutput.Write(append(data, '\n'))
+		return err
 	}
-}
 
-func sendLog(entry LogEntry) {
-	data, _ := json.Marshal(entry)
 	url := fmt.Sprintf("%s/api/log", apiURL)
 	resp, err := http.Post(url, "application/json", strings.NewReader(string(data)))
 	if err != nil {
 		logMessage("Log send error: " + err.Error())
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
 		logMessage("API error: " + string(body))
+		return fmt.Errorf("log rejected: %s", resp.Status)
 	}
+	return nil
 }
 
 func updateDeviceStatus() {
@@ -1442,10 +1310,15 @@ func updateDeviceStatus() {
 		return
 	}
 
+	// security_status comes from the last posture assessment
+	securityStatus, score, failed := postureStatus()
 	s := map[string]interface{}{
 		"device_id":       deviceID,
 		"status":          "online",
-		"security_status": "secure",
+		"security_status": securityStatus,
+		"posture_score":   score,
+		"posture_failed":  failed,
+		"neighbors":       neighbors.snapshot(),
 	}
 	
 	policyMutex.RLock()
@@ -1460,49 +1333,10 @@ func updateDeviceStatus() {
 	http.Post(url, "application/json", strings.NewReader(string(data)))
 }
 
-// ----------------- main service wrapper -----------------
-
-type cyartService struct{}
-
-// Execute implements svc.Handler
-func (m *cyartService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
-	const accepts = svc.AcceptStop | svc.AcceptShutdown
-
-	// Notify Start Pending
-	changes <- svc.Status{State: svc.StartPending}
-
-	// Start initialization in background quickly so SCM doesn't time out
-	go func() {
-		initializeAgent()
-	}()
-
-	// Notify Running
-	changes <- svc.Status{State: svc.Running, Accepts: accepts}
-
-loop:
-	for {
-		select {
-		case c := <-r:
-			switch c.Cmd {
-			case svc.Interrogate:
-				changes <- c.CurrentStatus
-			case svc.Stop, svc.Shutdown:
-				// break the loop to stop service
-				break loop
-			default:
-				// ignore other requests
-			}
-		}
-	}
-
-	// Notify Stop Pending
-	changes <- svc.Status{State: svc.StopPending}
-	// Cleanup if needed (none)
-	return false, 0
-}
-
 // initializeAgent runs the agent main loop (background)
 func initializeAgent() {
+	loadServerConfig()
+
 	logMessage(fmt.Sprintf("Starting CyArt Security Agent v%s...", VERSION))
 	logMessage(fmt.Sprintf("Server URL: %s", apiURL))
 
@@ -1523,25 +1357,28 @@ func initializeAgent() {
 		}
 	}
 
+	startFlowExporter()
+
+	// Rules from the last policy fetch until the server is reachable
+	if err := loadSigmaRules(filepath.Join(agentDir, SIGMA_RULES_FILE)); err != nil && !os.IsNotExist(err) {
+		logMessage("Sigma rule cache: " + err.Error())
+	}
+	if err := loadYaraPolicy(filepath.Join(agentDir, YARA_POLICY_FILE)); err != nil && !os.IsNotExist(err) {
+		logMessage("YARA policy cache: " + err.Error())
+	}
+	if err := loadPosturePolicy(filepath.Join(agentDir, POSTURE_POLICY_FILE)); err != nil && !os.IsNotExist(err) {
+		logMessage("Posture policy cache: " + err.Error())
+	}
+
+	// A quarantine the agent entered itself holds across restarts
+	restoreLocalQuarantine()
+
 	logMessage("Agent entering background monitoring loop")
 
 	// START CONCURRENT ROUTINES
 	// We use a simple channel to keep the main function alive
 	done := make(chan bool)
 
-	// Helper for panic recovery
-	safeGo := func(name string, fn func()) {
-		go func() {
-			defer func() {
-				if r := recover(); r != nil {
-					logMessage(fmt.Sprintf("CRITICAL ERROR: Routine '%s' panicked: %v", name, r))
-					// Optional: Restart routine? For now, we just log to prevent full crash.
-				}
-			}()
-			fn()
-		}()
-	}
-
 	// 1. USB Policy Enforcement & Device Tracking (CRITICAL: 2s)
 	safeGo("USB_Loop", func() {
 		for {
@@ -1568,17 +1405,44 @@ func initializeAgent() {
 	})
 
 	// 4. Network Monitoring (HEAVY TASK: 15s)
+	//    and interface address changes (30s)
 	safeGo("Network_Monitor", func() {
 		for {
 			trackNetworkConnections()
 			time.Sleep(15 * time.Second)
 		}
 	})
+	safeGo("Interface_Monitor", runInterfaceMonitor)
 
-	// 5. Log Collection (HEAVY TASK: 30s)
+	// 5. Process Telemetry (starts/exits, process tree for enrichment)
+	safeGo("Process_Monitor", runProcessMonitor)
+	safeGo("Image_Inventory", runImageInventory)
+
+	// 6. Malware Scanning (scheduled, on-demand, removable media)
+	safeGo("YARA_Scanner", runYaraScanner)
+
+	// 7. File Integrity Monitoring (inotify on Linux, rescans elsewhere)
+	safeGo("FIM", runFIM)
+
+	// 8. Persistence Inventory (autostart entries added/removed: 10m)
+	safeGo("Persistence_Monitor", runPersistenceMonitor)
+
+	// 9. Software Inventory (full list first, then installs/removals/updates: 1h)
+	//    and vulnerability matching against the OSV feed (6h)
+	safeGo("Software_Inventory", runSoftwareInventory)
+	safeGo("Vulnerability_Scanner", runVulnerabilityScanner)
+
+	// 10. Hardware Inventory (sent at registration, then on change: 1h)
+	safeGo("Hardware_Inventory", runHardwareInventory)
+
+	// 11. Security Posture (benchmark checklist from the server: 1h)
+	safeGo("Posture_Assessment", runPostureAssessment)
+
+	// 12. Log Collection (HEAVY TASK: 30s)
 	safeGo("Log_Collector", func() {
 		for {
 			sendSystemLogs()
+			authAnalytics.flushBaseline()
 			time.Sleep(30 * time.Second)
 		}
 	})
@@ -1588,23 +1452,71 @@ func initializeAgent() {
 }
 
 func main() {
-	isInt, err := svc.IsAnInteractiveSession()
-	if err != nil {
-		log.Fatalf("Failed to detect session type: %v", err)
+	replayFile := flag.String("replay", "", "decode a .pcap/.pcapng capture offline and print the resulting log entries")
+	replayJournalFile := flag.String("replay-journal", "", "convert a journal export file (journalctl -o export) and print the resulting log entries")
+	replayEvtxFile := flag.String("replay-evtx", "", "parse a Windows .evtx file and print the resulting log entries")
+	replayAuditFile := flag.String("replay-audit", "", "convert a Linux audit log (/var/log/audit/audit.log) and print the resulting log entries")
+	sigmaRulesFile := flag.String("sigma-rules", "", "JSON Sigma rule file evaluated against replayed entries")
+	yaraRulesFile := flag.String("yara-rules", "", "YARA rule file used by -yara-scan")
+	yaraScanTarget := flag.String("yara-scan", "", "scan a file, directory or process ID with -yara-rules and print the resulting log entries")
+	vulnScanFile := flag.String("vuln-scan", "", "match installed packages against an OSV feed (JSON file, directory or .zip) and print the resulting log entries")
+	postureCheck := flag.Bool("posture", false, "evaluate the security posture checklist and print the resulting log entries")
+	flag.Parse()
+
+	if *sigmaRulesFile != "" {
+		if err := loadSigmaRules(*sigmaRulesFile); err != nil {
+			log.Fatalf("Loading Sigma rules failed: %v", err)
+		}
 	}
 
-	if isInt {
-		// Interactive / console mode
-		log.Printf("CyArtAgent: Running in interactive mode")
-		initializeAgent() // blocks
+	if *yaraScanTarget != "" {
+		if err := yaraScanPath(*yaraRulesFile, *yaraScanTarget, os.Stdout); err != nil {
+			log.Fatalf("YARA scan failed: %v", err)
+		}
 		return
 	}
 
-	// Run as a windows service
-	err = svc.Run(SERVICE_NAME, &cyartService{})
-	if err != nil {
-		log.Printf("CyArtAgent service failed: %v", err)
+	if *vulnScanFile != "" {
+		if err := vulnScanFeed(*vulnScanFile, os.Stdout); err != nil {
+			log.Fatalf("Vulnerability scan failed: %v", err)
+		}
+		return
+	}
+
+	if *postureCheck {
+		postureReport(os.Stdout)
+		return
+	}
+
+	if *replayEvtxFile != "" {
+		if err := replayEvtx(*replayEvtxFile, os.Stdout); err != nil {
+			log.Fatalf("EVTX replay failed: %v", err)
+		}
+		return
+	}
+
+	if *replayAuditFile != "" {
+		if err := replayAudit(*replayAuditFile, os.Stdout); err != nil {
+			log.Fatalf("Audit replay failed: %v", err)
+		}
+		return
+	}
+
+	if *replayJournalFile != "" {
+		if err := replayJournal(*replayJournalFile, os.Stdout); err != nil {
+			log.Fatalf("Journal replay failed: %v", err)
+		}
+		return
 	}
+
+	if *replayFile != "" {
+		if err := replayCapture(*replayFile, os.Stdout); err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+		return
+	}
+
+	runAgent()
 }
 
 // isAdmin checks for administrative privileges without exiting the process.
@@ -0,0 +1,1364 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// YARA-compatible rule engine. Rules are written in YARA syntax so the same
+// rule files work with the yara command line tool; the supported subset is:
+//
+//   - private/global rules, tags, meta
+//   - text strings with nocase, wide, ascii, fullword and private
+//   - hex strings with ?? / nibble wildcards, [n-m] jumps and (a|b) alternatives
+//   - regular expressions with the i and s flags (RE2 syntax, matched as UTF-8)
+//   - conditions: and/or/not, comparisons, + - * \ %, filesize, $a, #a, @a[i],
+//     !a[i], $a at x, $a in (x..y), all/any/none/N/N% of (them | $a*, ...),
+//     uint8/16/32(be)/int8/16/32(be)(offset), true/false and rule references
+//
+// Modules (pe, elf, ...), for loops, xor/base64 strings and entrypoint are
+// not supported; rules using them are skipped with a compile error.
+
+const (
+	YARA_MAX_STRING_MATCHES = 1000
+	YARA_MATCH_PREVIEW      = 64
+	// Unbounded jumps in hex strings without fixed leading bytes are tried at
+	// every offset, each scanning to the end of the data; they are capped
+	// at this span to keep the scan linear in the data size.
+	YARA_MAX_UNANCHORED_JUMP = 1024
+)
+
+type yaraRule struct {
+	Name      string
+	Tags      []string
+	Meta      map[string]string
+	Private   bool
+	Global    bool
+	strings   []*yaraString
+	condition yaraExpr
+}
+
+type yaraString struct {
+	id       string
+	private  bool
+	literals [][]byte // ascii and/or wide forms, lowercase with nocase
+	nocase   bool
+	wide     bool
+	fullword bool
+	hex      []yaraHexToken
+	prefix   []byte // fixed leading bytes of a hex string, used to find candidates
+	re       *regexp.Regexp
+}
+
+// yaraHexToken is a masked byte, a jump or a group of alternatives.
+type yaraHexToken struct {
+	value, mask      byte
+	jump             bool
+	jumpMin, jumpMax int // jumpMax -1: unbounded
+	alts             [][]yaraHexToken
+}
+
+// yaraBlock is a contiguous piece of scanned data: a whole file at base 0,
+// or one readable process memory region at its virtual address.
+type yaraBlock struct {
+	base uint64
+	data []byte
+}
+
+type yaraHit struct {
+	offset uint64
+	block  int
+	start  int
+	length int
+}
+
+type yaraScanState struct {
+	blocks   []yaraBlock
+	filesize int64 // -1 when the target is not a file
+	lower    map[int][]byte
+	hits     map[*yaraString][]yaraHit
+	rules    map[string]bool
+}
+
+// yaraRuleMatch is a rule that matched, with its (non private) string hits.
+type yaraRuleMatch struct {
+	Rule    *yaraRule
+	Strings []yaraStringMatch
+}
+
+type yaraStringMatch struct {
+	ID     string
+	Offset uint64
+	Data   []byte
+}
+
+// yaraExpr evaluates a condition; ok=false is YARA's "undefined".
+type yaraExpr func(s *yaraScanState) (value int64, ok bool)
+
+// scanYara evaluates every rule against blocks and returns the public
+// rules that matched.
+func scanYara(rules []*yaraRule, blocks []yaraBlock, filesize int64) []yaraRuleMatch {
+	s := &yaraScanState{
+		blocks:   blocks,
+		filesize: filesize,
+		lower:    make(map[int][]byte),
+		hits:     make(map[*yaraString][]yaraHit),
+		rules:    make(map[string]bool),
+	}
+
+	// A false global rule disables every rule of the set
+	for _, r := range rules {
+		if r.Global {
+			v, ok := r.condition(s)
+			s.rules[r.Name] = ok && v != 0
+			if !s.rules[r.Name] {
+				return nil
+			}
+		}
+	}
+
+	var matches []yaraRuleMatch
+	for _, r := range rules {
+		if r.Global {
+			continue
+		}
+		v, ok := r.condition(s)
+		matched := ok && v != 0
+		s.rules[r.Name] = matched
+		if !matched || r.Private {
+			continue
+		}
+		m := yaraRuleMatch{Rule: r}
+		for _, str := range r.strings {
+			if str.private {
+				continue
+			}
+			for _, h := range s.stringHits(str) {
+				data := s.blocks[h.block].data[h.start : h.start+min(h.length, YARA_MATCH_PREVIEW)]
+				m.Strings = append(m.Strings, yaraStringMatch{ID: str.id, Offset: h.offset, Data: append([]byte(nil), data...)})
+			}
+		}
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+// stringHits finds (once per scan) the occurrences of a string.
+func (s *yaraScanState) stringHits(str *yaraString) []yaraHit {
+	if hits, done := s.hits[str]; done {
+		return hits
+	}
+	var hits []yaraHit
+	add := func(block, start, length int) bool {
+		hits = append(hits, yaraHit{offset: s.blocks[block].base + uint64(start), block: block, start: start, length: length})
+		return len(hits) < YARA_MAX_STRING_MATCHES
+	}
+
+search:
+	for bi, b := range s.blocks {
+		switch {
+		case str.re != nil:
+			for _, loc := range str.re.FindAllIndex(b.data, YARA_MAX_STRING_MATCHES-len(hits)) {
+				if loc[1] > loc[0] && !add(bi, loc[0], loc[1]-loc[0]) {
+					break search
+				}
+			}
+		case str.hex != nil:
+			data := b.data
+			for pos := 0; pos < len(data); pos++ {
+				if len(str.prefix) > 0 {
+					i := bytes.Index(data[pos:], str.prefix)
+					if i < 0 {
+						break
+					}
+					pos += i
+				}
+				if end, ok := matchYaraHex(str.hex, data, pos); ok && !add(bi, pos, end-pos) {
+					break search
+				}
+			}
+		default:
+			data := b.data
+			if str.nocase {
+				data = s.lowerBlock(bi)
+			}
+			for _, lit := range str.literals {
+				for pos := 0; pos+len(lit) <= len(data); {
+					i := bytes.Index(data[pos:], lit)
+					if i < 0 {
+						break
+					}
+					start := pos + i
+					pos = start + 1
+					if str.fullword && !yaraFullword(data, start, start+len(lit), str.wide && lit[len(lit)-1] == 0) {
+						continue
+					}
+					if !add(bi, start, len(lit)) {
+						break search
+					}
+				}
+			}
+		}
+	}
+	s.hits[str] = hits
+	return hits
+}
+
+func (s *yaraScanState) lowerBlock(i int) []byte {
+	if l, ok := s.lower[i]; ok {
+		return l
+	}
+	data := s.blocks[i].data
+	l := make([]byte, len(data))
+	for j, c := range data {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		l[j] = c
+	}
+	s.lower[i] = l
+	return l
+}
+
+// yaraFullword reports whether data[start:end] is delimited by non
+// alphanumeric characters.
+func yaraFullword(data []byte, start, end int, wide bool) bool {
+	alnum := func(c byte) bool {
+		return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	before := start - 1
+	if wide {
+		before = start - 2
+	}
+	if before >= 0 && alnum(data[before]) {
+		return false
+	}
+	return end >= len(data) || !alnum(data[end])
+}
+
+// matchYaraHex matches tokens at pos and returns the end of the match.
+func matchYaraHex(tokens []yaraHexToken, data []byte, pos int) (int, bool) {
+	for i, t := range tokens {
+		switch {
+		case t.alts != nil:
+			for _, alt := range t.alts {
+				if end, ok := matchYaraHex(alt, data, pos); ok {
+					if end, ok := matchYaraHex(tokens[i+1:], data, end); ok {
+						return end, true
+					}
+				}
+			}
+			return 0, false
+		case t.jump:
+			most := len(data) - pos
+			if t.jumpMax >= 0 && t.jumpMax < most {
+				most = t.jumpMax
+			}
+			for n := t.jumpMin; n <= most; n++ {
+				if end, ok := matchYaraHex(tokens[i+1:], data, pos+n); ok {
+					return end, true
+				}
+			}
+			return 0, false
+		default:
+			if pos >= len(data) || data[pos]&t.mask != t.value {
+				return 0, false
+			}
+			pos++
+		}
+	}
+	return pos, true
+}
+
+// readInt implements uint8(x) ... int32be(x).
+func (s *yaraScanState) readInt(addr int64, size int, bigEndian, signed bool) (int64, bool) {
+	if addr < 0 {
+		return 0, false
+	}
+	for _, b := range s.blocks {
+		if uint64(addr) < b.base || uint64(addr)-b.base+uint64(size) > uint64(len(b.data)) {
+			continue
+		}
+		p := b.data[uint64(addr)-b.base:]
+		var order binary.ByteOrder = binary.LittleEndian
+		if bigEndian {
+			order = binary.BigEndian
+		}
+		switch size {
+		case 1:
+			if signed {
+				return int64(int8(p[0])), true
+			}
+			return int64(p[0]), true
+		case 2:
+			if signed {
+				return int64(int16(order.Uint16(p))), true
+			}
+			return int64(order.Uint16(p)), true
+		default:
+			if signed {
+				return int64(int32(order.Uint32(p))), true
+			}
+			return int64(order.Uint32(p)), true
+		}
+	}
+	return 0, false
+}
+
+// ----------------- rule compiler -----------------
+
+// compileYaraRules parses a rule source. Rules that fail to compile are
+// skipped and reported in errs; a syntax error outside a rule body stops
+// the parse there.
+func compileYaraRules(src string) (rules []*yaraRule, errs []error) {
+	p := &yaraParser{src: src}
+	known := make(map[string]bool)
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return rules, errs
+		}
+		word := p.ident()
+		switch word {
+		case "import", "include":
+			p.skipSpace()
+			if _, err := p.quoted(); err != nil {
+				return rules, append(errs, p.errorf("%s: %v", word, err))
+			}
+			continue
+		}
+
+		r := &yaraRule{Meta: make(map[string]string)}
+		for word == "private" || word == "global" {
+			r.Private = r.Private || word == "private"
+			r.Global = r.Global || word == "global"
+			p.skipSpace()
+			word = p.ident()
+		}
+		if word != "rule" {
+			return rules, append(errs, p.errorf("expected rule, found %q", word+p.peekChar()))
+		}
+		p.skipSpace()
+		r.Name = p.ident()
+		if r.Name == "" {
+			return rules, append(errs, p.errorf("missing rule name"))
+		}
+		p.skipSpace()
+		if p.peekChar() == ":" {
+			p.pos++
+			for {
+				p.skipSpace()
+				tag := p.ident()
+				if tag == "" {
+					break
+				}
+				r.Tags = append(r.Tags, tag)
+			}
+		}
+		p.skipSpace()
+		if p.peekChar() != "{" {
+			return rules, append(errs, p.errorf("rule %s: expected {", r.Name))
+		}
+		end, err := p.bodyEnd(p.pos)
+		if err != nil {
+			return rules, append(errs, fmt.Errorf("rule %s: %v", r.Name, err))
+		}
+		body := &yaraParser{src: p.src[:end], pos: p.pos + 1, line: p.line}
+		p.line += strings.Count(p.src[p.pos:end+1], "\n")
+		p.pos = end + 1
+
+		if known[r.Name] {
+			errs = append(errs, fmt.Errorf("rule %s: duplicate rule name", r.Name))
+			continue
+		}
+		if err := body.ruleBody(r, known); err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %v", r.Name, err))
+			continue
+		}
+		known[r.Name] = true
+		rules = append(rules, r)
+	}
+}
+
+type yaraParser struct {
+	src  string
+	pos  int
+	line int
+
+	rule  *yaraRule
+	known map[string]bool
+}
+
+func (p *yaraParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line+1, fmt.Sprintf(format, args...))
+}
+
+func (p *yaraParser) peekChar() string {
+	if p.pos >= len(p.src) {
+		return ""
+	}
+	return p.src[p.pos : p.pos+1]
+}
+
+// skipSpace skips white space and comments.
+func (p *yaraParser) skipSpace() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				end = len(p.src) - p.pos - 4
+			}
+			p.line += strings.Count(p.src[p.pos:p.pos+end+4], "\n")
+			p.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+func isYaraIdentChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (p *yaraParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		// module members (pe.is_dll), but not ranges (filesize..)
+		dot := p.pos > start && p.src[p.pos] == '.' && p.pos+1 < len(p.src) && isYaraIdentChar(p.src[p.pos+1])
+		if !isYaraIdentChar(p.src[p.pos]) && !dot {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// bodyEnd returns the index of the } closing the { at open, skipping
+// strings, regular expressions and comments.
+func (p *yaraParser) bodyEnd(open int) (int, error) {
+	depth := 0
+	for i := open; i < len(p.src); i++ {
+		switch c := p.src[i]; {
+		case c == '"':
+			for i++; i < len(p.src) && p.src[i] != '"'; i++ {
+				if p.src[i] == '\\' {
+					i++
+				}
+			}
+		case strings.HasPrefix(p.src[i:], "//"):
+			for i < len(p.src) && p.src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(p.src[i:], "/*"):
+			end := strings.Index(p.src[i+2:], "*/")
+			if end < 0 {
+				return 0, fmt.Errorf("unterminated comment")
+			}
+			i += end + 3
+		case c == '/':
+			for i++; i < len(p.src) && p.src[i] != '/' && p.src[i] != '\n'; i++ {
+				if p.src[i] == '\\' {
+					i++
+				}
+			}
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("missing closing }")
+}
+
+// quoted reads a "..." string with YARA escapes.
+func (p *yaraParser) quoted() (string, error) {
+	if p.peekChar() != `"` {
+		return "", p.errorf("expected string")
+	}
+	var b strings.Builder
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		case c == '\n':
+			return "", p.errorf("unterminated string")
+		case c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'x':
+				if p.pos+2 >= len(p.src) {
+					return "", p.errorf("bad \\x escape")
+				}
+				v, err := strconv.ParseUint(p.src[p.pos+1:p.pos+3], 16, 8)
+				if err != nil {
+					return "", p.errorf("bad \\x escape")
+				}
+				b.WriteByte(byte(v))
+				p.pos += 2
+			default:
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// ruleBody parses the meta, strings and condition sections.
+func (p *yaraParser) ruleBody(r *yaraRule, known map[string]bool) error {
+	p.rule, p.known = r, known
+	section := ""
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			break
+		}
+		if word := p.ident(); word == "meta" || word == "strings" || word == "condition" {
+			p.skipSpace()
+			if p.peekChar() == ":" {
+				p.pos++
+				section = word
+				if word == "condition" {
+					cond, err := p.condition()
+					if err != nil {
+						return err
+					}
+					r.condition = cond
+					break
+				}
+				continue
+			}
+			p.pos -= len(word)
+		} else {
+			p.pos -= len(word)
+		}
+
+		var err error
+		switch section {
+		case "meta":
+			err = p.metaEntry(r)
+		case "strings":
+			err = p.stringEntry(r)
+		default:
+			err = p.errorf("unexpected %q", p.src[p.pos:min(p.pos+20, len(p.src))])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if r.condition == nil {
+		return fmt.Errorf("missing condition")
+	}
+	return nil
+}
+
+func (p *yaraParser) metaEntry(r *yaraRule) error {
+	key := p.ident()
+	p.skipSpace()
+	if key == "" || p.peekChar() != "=" {
+		return p.errorf("bad meta entry")
+	}
+	p.pos++
+	p.skipSpace()
+	if p.peekChar() == `"` {
+		v, err := p.quoted()
+		if err != nil {
+			return err
+		}
+		r.Meta[key] = v
+		return nil
+	}
+	start := p.pos
+	if p.peekChar() == "-" {
+		p.pos++
+	}
+	v := p.src[start:p.pos] + p.ident()
+	if v == "" {
+		return p.errorf("bad meta value for %s", key)
+	}
+	r.Meta[key] = v
+	return nil
+}
+
+func (p *yaraParser) stringEntry(r *yaraRule) error {
+	if p.peekChar() != "$" {
+		return p.errorf("expected string identifier")
+	}
+	p.pos++
+	id := "$" + p.ident()
+	if id == "$" {
+		id = fmt.Sprintf("$%d", len(r.strings)) // anonymous string
+	}
+	for _, s := range r.strings {
+		if s.id == id {
+			return p.errorf("duplicate string %s", id)
+		}
+	}
+	p.skipSpace()
+	if p.peekChar() != "=" {
+		return p.errorf("expected = after %s", id)
+	}
+	p.pos++
+	p.skipSpace()
+
+	str := &yaraString{id: id}
+	var text string
+	var regex string
+	var err error
+	switch p.peekChar() {
+	case `"`:
+		text, err = p.quoted()
+		if err == nil && text == "" {
+			err = p.errorf("empty string %s", id)
+		}
+	case "{":
+		err = p.hexString(str)
+	case "/":
+		regex, err = p.regexString()
+	default:
+		err = p.errorf("bad value for %s", id)
+	}
+	if err != nil {
+		return err
+	}
+
+	ascii := false
+	for {
+		p.skipSpace()
+		start := p.pos
+		mod := p.ident()
+		switch mod {
+		case "nocase":
+			str.nocase = true
+		case "wide":
+			str.wide = true
+		case "ascii":
+			ascii = true
+		case "fullword":
+			str.fullword = true
+		case "private":
+			str.private = true
+		case "xor", "base64", "base64wide":
+			return p.errorf("%s: the %s modifier is not supported", id, mod)
+		default:
+			p.pos = start
+			goto done
+		}
+	}
+done:
+	switch {
+	case str.hex != nil:
+		if str.nocase || str.wide || str.fullword || ascii {
+			return p.errorf("%s: hex strings take no modifiers", id)
+		}
+	case regex != "":
+		if str.wide {
+			return p.errorf("%s: wide regular expressions are not supported", id)
+		}
+		if str.nocase {
+			regex = "(?i)" + regex
+		}
+		if str.re, err = regexp.Compile(regex); err != nil {
+			return p.errorf("%s: %v", id, err)
+		}
+	default:
+		if str.nocase {
+			text = strings.ToLower(text)
+		}
+		if ascii || !str.wide {
+			str.literals = append(str.literals, []byte(text))
+		}
+		if str.wide {
+			wide := make([]byte, 0, 2*len(text))
+			for i := 0; i < len(text); i++ {
+				wide = append(wide, text[i], 0)
+			}
+			str.literals = append(str.literals, wide)
+		}
+	}
+	r.strings = append(r.strings, str)
+	return nil
+}
+
+// regexString reads /.../flags and returns it in Go syntax.
+func (p *yaraParser) regexString() (string, error) {
+	var b strings.Builder
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		c := p.src[p.pos]
+		switch {
+		case c == '/':
+			p.pos++
+			flags := ""
+			for p.pos < len(p.src) && (p.src[p.pos] == 'i' || p.src[p.pos] == 's') {
+				flags += p.src[p.pos : p.pos+1]
+				p.pos++
+			}
+			if b.Len() == 0 {
+				return "", p.errorf("empty regular expression")
+			}
+			if flags != "" {
+				return "(?" + flags + ")" + b.String(), nil
+			}
+			return b.String(), nil
+		case c == '\n':
+			return "", p.errorf("unterminated regular expression")
+		case c == '\\' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '/':
+			b.WriteByte('/')
+			p.pos++
+		case c == '\\' && p.pos+1 < len(p.src):
+			b.WriteString(p.src[p.pos : p.pos+2])
+			p.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated regular expression")
+}
+
+// hexString parses { 4D 5A ?? [2-4] (90 | EB ?0) }.
+func (p *yaraParser) hexString(str *yaraString) error {
+	end := strings.IndexByte(p.src[p.pos:], '}')
+	if end < 0 {
+		return p.errorf("unterminated hex string")
+	}
+	src := p.src[p.pos+1 : p.pos+end]
+	p.line += strings.Count(src, "\n")
+	p.pos += end + 1
+
+	// drop comments, then tokenize
+	var clean strings.Builder
+	for i := 0; i < len(src); i++ {
+		if strings.HasPrefix(src[i:], "//") {
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		}
+		if strings.HasPrefix(src[i:], "/*") {
+			if e := strings.Index(src[i+2:], "*/"); e >= 0 {
+				i += e + 3
+				continue
+			}
+		}
+		clean.WriteByte(src[i])
+	}
+	hex := strings.Join(strings.Fields(clean.String()), "")
+
+	pos := 0
+	tokens, err := parseYaraHex(hex, &pos, false)
+	if err != nil {
+		return p.errorf("%s: %v", str.id, err)
+	}
+	if pos != len(hex) {
+		return p.errorf("%s: unexpected %q in hex string", str.id, hex[pos:])
+	}
+	if len(tokens) == 0 || tokens[0].jump || tokens[len(tokens)-1].jump {
+		return p.errorf("%s: hex strings cannot be empty or start or end with a jump", str.id)
+	}
+	str.hex = tokens
+	for _, t := range tokens {
+		if t.jump || t.alts != nil || t.mask != 0xff {
+			break
+		}
+		str.prefix = append(str.prefix, t.value)
+	}
+	if len(str.prefix) == 0 {
+		for i := range tokens {
+			if tokens[i].jump && (tokens[i].jumpMax < 0 || tokens[i].jumpMax > YARA_MAX_UNANCHORED_JUMP) {
+				tokens[i].jumpMax = max(tokens[i].jumpMin, YARA_MAX_UNANCHORED_JUMP)
+			}
+		}
+	}
+	return nil
+}
+
+func parseYaraHex(hex string, pos *int, inGroup bool) ([]yaraHexToken, error) {
+	var tokens []yaraHexToken
+	for *pos < len(hex) {
+		c := hex[*pos]
+		switch {
+		case c == '|' || c == ')':
+			if !inGroup {
+				return nil, fmt.Errorf("unexpected %q", c)
+			}
+			return tokens, nil
+		case c == '(':
+			*pos++
+			var alts [][]yaraHexToken
+			for {
+				alt, err := parseYaraHex(hex, pos, true)
+				if err != nil {
+					return nil, err
+				}
+				for _, t := range alt {
+					if t.jump && t.jumpMax < 0 {
+						return nil, fmt.Errorf("unbounded jumps are not allowed in alternatives")
+					}
+				}
+				alts = append(alts, alt)
+				if *pos >= len(hex) {
+					return nil, fmt.Errorf("unterminated alternative")
+				}
+				*pos++
+				if hex[*pos-1] == ')' {
+					break
+				}
+			}
+			tokens = append(tokens, yaraHexToken{alts: alts})
+		case c == '[':
+			end := strings.IndexByte(hex[*pos:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated jump")
+			}
+			spec := hex[*pos+1 : *pos+end]
+			*pos += end + 1
+			t := yaraHexToken{jump: true, jumpMax: -1}
+			lo, hi, isRange := strings.Cut(spec, "-")
+			var err error
+			if lo != "" {
+				if t.jumpMin, err = strconv.Atoi(lo); err != nil {
+					return nil, fmt.Errorf("bad jump [%s]", spec)
+				}
+			}
+			switch {
+			case !isRange:
+				t.jumpMax = t.jumpMin
+			case hi != "":
+				if t.jumpMax, err = strconv.Atoi(hi); err != nil || t.jumpMax < t.jumpMin {
+					return nil, fmt.Errorf("bad jump [%s]", spec)
+				}
+			}
+			tokens = append(tokens, t)
+		default:
+			if *pos+2 > len(hex) {
+				return nil, fmt.Errorf("odd number of hex digits")
+			}
+			t := yaraHexToken{}
+			for i, n := range hex[*pos : *pos+2] {
+				shift := 4 * (1 - i)
+				if n == '?' {
+					continue
+				}
+				v, err := strconv.ParseUint(string(n), 16, 8)
+				if err != nil {
+					return nil, fmt.Errorf("bad hex byte %q", hex[*pos:*pos+2])
+				}
+				t.value |= byte(v) << shift
+				t.mask |= 0xf << shift
+			}
+			*pos += 2
+			tokens = append(tokens, t)
+		}
+	}
+	if inGroup {
+		return nil, fmt.Errorf("unterminated alternative")
+	}
+	return tokens, nil
+}
+
+// ----------------- conditions -----------------
+
+// condition parses the rest of the body as the rule condition.
+func (p *yaraParser) condition() (yaraExpr, error) {
+	e, err := p.orExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q in condition", p.src[p.pos:min(p.pos+20, len(p.src))])
+	}
+	return e, nil
+}
+
+// accept consumes tok (a keyword or operator) if it comes next.
+func (p *yaraParser) accept(tok string) bool {
+	p.skipSpace()
+	if !strings.HasPrefix(p.src[p.pos:], tok) {
+		return false
+	}
+	end := p.pos + len(tok)
+	if isYaraIdentChar(tok[len(tok)-1]) && end < len(p.src) && isYaraIdentChar(p.src[end]) {
+		return false // "and" must not match "android"
+	}
+	p.pos = end
+	return true
+}
+
+func yaraBool(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *yaraParser) orExpr() (yaraExpr, error) {
+	left, err := p.andExpr()
+	for err == nil && p.accept("or") {
+		var right yaraExpr
+		if right, err = p.andExpr(); err == nil {
+			l, r := left, right
+			left = func(s *yaraScanState) (int64, bool) {
+				if v, ok := l(s); ok && v != 0 {
+					return 1, true
+				}
+				v, ok := r(s)
+				return yaraBool(ok && v != 0), true
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *yaraParser) andExpr() (yaraExpr, error) {
+	left, err := p.notExpr()
+	for err == nil && p.accept("and") {
+		var right yaraExpr
+		if right, err = p.notExpr(); err == nil {
+			l, r := left, right
+			left = func(s *yaraScanState) (int64, bool) {
+				if v, ok := l(s); !ok || v == 0 {
+					return 0, true
+				}
+				v, ok := r(s)
+				return yaraBool(ok && v != 0), true
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *yaraParser) notExpr() (yaraExpr, error) {
+	if p.accept("not") {
+		x, err := p.notExpr()
+		if err != nil {
+			return nil, err
+		}
+		return func(s *yaraScanState) (int64, bool) {
+			v, ok := x(s)
+			return yaraBool(v == 0), ok
+		}, nil
+	}
+	return p.comparison()
+}
+
+func (p *yaraParser) comparison() (yaraExpr, error) {
+	left, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if !p.accept(op) {
+			continue
+		}
+		right, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		return func(s *yaraScanState) (int64, bool) {
+			a, ok1 := left(s)
+			b, ok2 := right(s)
+			if !ok1 || !ok2 {
+				return 0, false
+			}
+			switch op {
+			case "==":
+				return yaraBool(a == b), true
+			case "!=":
+				return yaraBool(a != b), true
+			case "<=":
+				return yaraBool(a <= b), true
+			case ">=":
+				return yaraBool(a >= b), true
+			case "<":
+				return yaraBool(a < b), true
+			}
+			return yaraBool(a > b), true
+		}, nil
+	}
+	return left, nil
+}
+
+func (p *yaraParser) additive() (yaraExpr, error) {
+	return p.binary(p.multiplicative, "+", "-")
+}
+
+func (p *yaraParser) multiplicative() (yaraExpr, error) {
+	return p.binary(p.primary, "*", "\\", "%")
+}
+
+func (p *yaraParser) binary(operand func() (yaraExpr, error), ops ...string) (yaraExpr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, o := range ops {
+			if p.accept(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(s *yaraScanState) (int64, bool) {
+			a, ok1 := l(s)
+			b, ok2 := right(s)
+			if !ok1 || !ok2 {
+				return 0, false
+			}
+			switch op {
+			case "+":
+				return a + b, true
+			case "-":
+				return a - b, true
+			case "*":
+				return a * b, true
+			}
+			if b == 0 {
+				return 0, false
+			}
+			if op == "%" {
+				return a % b, true
+			}
+			return a / b, true
+		}
+	}
+}
+
+func (p *yaraParser) primary() (yaraExpr, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected end of condition")
+	}
+
+	switch c := p.src[p.pos]; {
+	case c == '(':
+		p.pos++
+		e, err := p.orExpr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("missing )")
+		}
+		return e, nil
+	case c == '-':
+		p.pos++
+		x, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		return func(s *yaraScanState) (int64, bool) {
+			v, ok := x(s)
+			return -v, ok
+		}, nil
+	case c >= '0' && c <= '9':
+		n, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		// "N of" and "N% of" quantifiers; a % not followed by of is modulo
+		save, line := p.pos, p.line
+		percent := p.accept("%")
+		if p.accept("of") {
+			p.pos -= 2
+			return p.ofExpr(yaraQuantity(n, percent))
+		}
+		p.pos, p.line = save, line
+		return func(*yaraScanState) (int64, bool) { return n, true }, nil
+	case c == '$':
+		return p.stringCondition()
+	case c == '#' || c == '@' || c == '!':
+		return p.stringValue(c)
+	}
+
+	word := p.ident()
+	switch word {
+	case "true", "false":
+		v := yaraBool(word == "true")
+		return func(*yaraScanState) (int64, bool) { return v, true }, nil
+	case "filesize":
+		return func(s *yaraScanState) (int64, bool) { return s.filesize, s.filesize >= 0 }, nil
+	case "all", "any", "none":
+		return p.ofExpr(func(total int) int {
+			switch word {
+			case "all":
+				return total
+			case "any":
+				return 1
+			}
+			return 0
+		})
+	case "uint8", "uint16", "uint32", "int8", "int16", "int32", "uint8be", "uint16be", "uint32be", "int8be", "int16be", "int32be":
+		if !p.accept("(") {
+			return nil, p.errorf("expected ( after %s", word)
+		}
+		addr, err := p.orExpr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("missing ) after %s", word)
+		}
+		signed := !strings.HasPrefix(word, "u")
+		bigEndian := strings.HasSuffix(word, "be")
+		bits, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimLeft(word, "uint"), "be"))
+		return func(s *yaraScanState) (int64, bool) {
+			a, ok := addr(s)
+			if !ok {
+				return 0, false
+			}
+			return s.readInt(a, bits/8, bigEndian, signed)
+		}, nil
+	case "for", "entrypoint", "matches", "contains", "of", "them":
+		return nil, p.errorf("%q is not supported here", word)
+	case "":
+		return nil, p.errorf("unexpected %q in condition", p.src[p.pos:min(p.pos+20, len(p.src))])
+	}
+	if strings.Contains(word, ".") {
+		return nil, p.errorf("module %s is not supported", word)
+	}
+	if !p.known[word] {
+		return nil, p.errorf("undefined identifier %s", word)
+	}
+	return func(s *yaraScanState) (int64, bool) { return yaraBool(s.rules[word]), true }, nil
+}
+
+// number reads a decimal, hex or octal literal with an optional KB/MB suffix.
+func (p *yaraParser) number() (int64, error) {
+	start := p.pos
+	for p.pos < len(p.src) && isYaraIdentChar(p.src[p.pos]) {
+		p.pos++
+	}
+	lit := p.src[start:p.pos]
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(lit, "KB"):
+		mult, lit = 1024, strings.TrimSuffix(lit, "KB")
+	case strings.HasSuffix(lit, "MB"):
+		mult, lit = 1024*1024, strings.TrimSuffix(lit, "MB")
+	}
+	var n int64
+	var err error
+	switch {
+	case strings.HasPrefix(lit, "0x"):
+		n, err = strconv.ParseInt(lit[2:], 16, 64)
+	case strings.HasPrefix(lit, "0o"):
+		n, err = strconv.ParseInt(lit[2:], 8, 64)
+	default:
+		n, err = strconv.ParseInt(lit, 10, 64)
+	}
+	if err != nil {
+		return 0, p.errorf("bad number %q", p.src[start:p.pos])
+	}
+	return n * mult, nil
+}
+
+// yaraQuantity turns "N" or "N%" into the number of strings required.
+func yaraQuantity(n int64, percent bool) func(total int) int {
+	return func(total int) int {
+		if percent {
+			return int((n*int64(total) + 99) / 100)
+		}
+		return int(n)
+	}
+}
+
+// ofExpr parses "of them" / "of ($a, $b*)" after the quantifier.
+func (p *yaraParser) ofExpr(required func(total int) int) (yaraExpr, error) {
+	if !p.accept("of") {
+		return nil, p.errorf("expected of")
+	}
+	var set []*yaraString
+	if p.accept("them") {
+		set = p.rule.strings
+	} else {
+		if !p.accept("(") {
+			return nil, p.errorf("expected them or a string set")
+		}
+		for {
+			p.skipSpace()
+			if p.peekChar() != "$" {
+				return nil, p.errorf("expected string identifier in set")
+			}
+			p.pos++
+			pattern := "$" + p.ident()
+			wildcard := p.peekChar() == "*"
+			if wildcard {
+				p.pos++
+			}
+			found := false
+			for _, s := range p.rule.strings {
+				if s.id == pattern || wildcard && strings.HasPrefix(s.id, pattern) {
+					set = append(set, s)
+					found = true
+				}
+			}
+			if !found {
+				return nil, p.errorf("undefined string %s", pattern)
+			}
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, p.errorf("expected , or ) in string set")
+			}
+		}
+	}
+	if len(set) == 0 {
+		return nil, p.errorf("empty string set")
+	}
+	none := required(1) == 0
+	return func(s *yaraScanState) (int64, bool) {
+		matched := 0
+		for _, str := range set {
+			if len(s.stringHits(str)) > 0 {
+				matched++
+			}
+		}
+		if none {
+			return yaraBool(matched == 0), true
+		}
+		return yaraBool(matched >= required(len(set))), true
+	}, nil
+}
+
+func (p *yaraParser) lookupString(prefix string) (*yaraString, error) {
+	name := p.ident()
+	for _, s := range p.rule.strings {
+		if s.id == "$"+name {
+			return s, nil
+		}
+	}
+	return nil, p.errorf("undefined string %s%s", prefix, name)
+}
+
+// stringCondition parses $a, $a at x and $a in (x..y).
+func (p *yaraParser) stringCondition() (yaraExpr, error) {
+	p.pos++
+	str, err := p.lookupString("$")
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("at") {
+		at, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		return func(s *yaraScanState) (int64, bool) {
+			off, ok := at(s)
+			if !ok {
+				return 0, false
+			}
+			for _, h := range s.stringHits(str) {
+				if int64(h.offset) == off {
+					return 1, true
+				}
+			}
+			return 0, true
+		}, nil
+	}
+	if p.accept("in") {
+		if !p.accept("(") {
+			return nil, p.errorf("expected ( after in")
+		}
+		lo, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept("..") {
+			return nil, p.errorf("expected .. in range")
+		}
+		hi, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("missing ) after range")
+		}
+		return func(s *yaraScanState) (int64, bool) {
+			a, ok1 := lo(s)
+			b, ok2 := hi(s)
+			if !ok1 || !ok2 {
+				return 0, false
+			}
+			for _, h := range s.stringHits(str) {
+				if int64(h.offset) >= a && int64(h.offset) <= b {
+					return 1, true
+				}
+			}
+			return 0, true
+		}, nil
+	}
+	return func(s *yaraScanState) (int64, bool) {
+		return yaraBool(len(s.stringHits(str)) > 0), true
+	}, nil
+}
+
+// stringValue parses #a (count), @a[i] (offset) and !a[i] (length).
+func (p *yaraParser) stringValue(kind byte) (yaraExpr, error) {
+	p.pos++
+	str, err := p.lookupString(string(kind))
+	if err != nil {
+		return nil, err
+	}
+	if kind == '#' {
+		return func(s *yaraScanState) (int64, bool) { return int64(len(s.stringHits(str))), true }, nil
+	}
+
+	index := yaraExpr(func(*yaraScanState) (int64, bool) { return 1, true })
+	if p.accept("[") {
+		if index, err = p.orExpr(); err != nil {
+			return nil, err
+		}
+		if !p.accept("]") {
+			return nil, p.errorf("missing ]")
+		}
+	}
+	return func(s *yaraScanState) (int64, bool) {
+		i, ok := index(s)
+		hits := s.stringHits(str)
+		if !ok || i < 1 || i > int64(len(hits)) {
+			return 0, false
+		}
+		if kind == '@' {
+			return int64(hits[i-1].offset), true
+		}
+		return int64(hits[i-1].length), true
+	}, nil
+}
@@ -0,0 +1,111 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Memory read per process; processes are scanned one after the other, so
+// this bounds what the agent holds (twice with nocase strings) at a time
+const (
+	YARA_MAX_REGION_SIZE    = 16 * 1024 * 1024
+	YARA_MAX_PROCESS_MEMORY = 64 * 1024 * 1024
+)
+
+// /proc/mounts escapes blanks in mount points as octal
+var mountUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// removableMounts lists the mount points of removable block devices (USB
+// sticks, SD cards).
+func removableMounts() []string {
+	data, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return nil
+	}
+	var mounts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 || !strings.HasPrefix(f[0], "/dev/") {
+			continue
+		}
+		if blockDeviceRemovable(filepath.Base(f[0])) {
+			mounts = append(mounts, mountUnescaper.Replace(f[1]))
+		}
+	}
+	return mounts
+}
+
+func blockDeviceRemovable(name string) bool {
+	dev, err := filepath.EvalSymlinks("/sys/class/block/" + name)
+	if err != nil {
+		return false
+	}
+	// USB disks often report removable=0, but they sit under a usb bus
+	if strings.Contains(dev, "/usb") {
+		return true
+	}
+	if _, err := os.Stat(filepath.Join(dev, "partition")); err == nil {
+		dev = filepath.Dir(dev) // the flag lives on the disk
+	}
+	flag, err := os.ReadFile(filepath.Join(dev, "removable"))
+	return err == nil && strings.TrimSpace(string(flag)) == "1"
+}
+
+// processMemoryBlocks reads the memory regions of pid worth scanning:
+// anonymous and writable mappings (heap, stacks, injected code) and the
+// main executable. Read-only mappings of other files are skipped, they are
+// the same bytes as on disk in every process that loads them.
+func processMemoryBlocks(pid int, image string) ([]yaraBlock, error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	maps, err := os.ReadFile(dir + "/maps")
+	if err != nil {
+		return nil, err
+	}
+	mem, err := os.Open(dir + "/mem")
+	if err != nil {
+		return nil, err
+	}
+	defer mem.Close()
+
+	var blocks []yaraBlock
+	total := 0
+	scanner := bufio.NewScanner(bytes.NewReader(maps))
+	for scanner.Scan() && total < YARA_MAX_PROCESS_MEMORY {
+		// start-end perms offset dev inode [path]
+		f := strings.Fields(scanner.Text())
+		if len(f) < 5 || f[1][0] != 'r' {
+			continue
+		}
+		path := ""
+		if len(f) >= 6 {
+			path = strings.Join(f[5:], " ")
+		}
+		fileBacked := strings.HasPrefix(path, "/")
+		if path == "[vvar]" || path == "[vsyscall]" || fileBacked && f[1][1] != 'w' && path != image {
+			continue
+		}
+		lo, hi, ok := strings.Cut(f[0], "-")
+		if !ok {
+			continue
+		}
+		start, err1 := strconv.ParseUint(lo, 16, 64)
+		end, err2 := strconv.ParseUint(hi, 16, 64)
+		if err1 != nil || err2 != nil || end <= start {
+			continue
+		}
+		size := int(min(end-start, YARA_MAX_REGION_SIZE, uint64(YARA_MAX_PROCESS_MEMORY-total)))
+		buf := make([]byte, size)
+		n, _ := mem.ReadAt(buf, int64(start))
+		if n > 0 {
+			blocks = append(blocks, yaraBlock{base: start, data: buf[:n]})
+			total += n
+		}
+	}
+	return blocks, nil
+}
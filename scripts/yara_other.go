@@ -0,0 +1,29 @@
+//go:build !windows && !linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// removableMounts lists the volumes mounted under /Volumes except the boot
+// volume, which is a symlink to /.
+func removableMounts() []string {
+	entries, err := os.ReadDir("/Volumes")
+	if err != nil {
+		return nil
+	}
+	var mounts []string
+	for _, e := range entries {
+		if e.IsDir() && e.Type()&os.ModeSymlink == 0 {
+			mounts = append(mounts, filepath.Join("/Volumes", e.Name()))
+		}
+	}
+	return mounts
+}
+
+// processMemoryBlocks is only implemented on Linux (/proc/<pid>/mem).
+func processMemoryBlocks(pid int, image string) ([]yaraBlock, error) {
+	return nil, errYaraMemoryUnsupported
+}
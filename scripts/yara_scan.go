@@ -0,0 +1,549 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Malware scanning with YARA rules pushed by the server. Three triggers:
+// the scheduled scan of the configured paths (and process memory where the
+// platform allows it), an on-demand scan whenever the server sends a new
+// scan_request_id, and files that appear on removable media. Matches are
+// reported as malware_scan events and can put the device into quarantine.
+
+const (
+	YARA_POLICY_FILE         = "yara-policy.json"
+	YARA_STATE_FILE          = "yara-scan.state"
+	YARA_QUARANTINE_FILE     = "quarantine-local.json"
+	YARA_POLL_INTERVAL       = 30 * time.Second
+	YARA_DEFAULT_INTERVAL    = 24 * time.Hour
+	YARA_MAX_FILE_SIZE       = 64 * 1024 * 1024
+	YARA_MAX_REPORTED_HITS   = 50
+	YARA_REMOVABLE_MAX_FILES = 20000 // per volume and poll
+)
+
+var errYaraMemoryUnsupported = errors.New("process memory scanning is not supported on this platform")
+
+// YaraPolicy is the scan configuration sent with the quarantine status.
+type YaraPolicy struct {
+	Rules               string   `json:"rules"` // YARA source
+	ScanPaths           []string `json:"scan_paths"`
+	ScanIntervalMinutes int      `json:"scan_interval_minutes"` // 0: daily
+	ScanMemory          bool     `json:"scan_memory"`
+	ScanRemovable       bool     `json:"scan_removable"`
+	QuarantineOnMatch   bool     `json:"quarantine_on_match"`
+	ScanRequestID       string   `json:"scan_request_id"` // a new ID starts an on-demand scan
+}
+
+type yaraScanStats struct {
+	Files     int
+	Skipped   int
+	Processes int
+	Bytes     int64
+	Matches   int
+	Errors    int
+}
+
+// yaraSchedule is persisted in YARA_STATE_FILE so a restart does not rescan.
+type yaraSchedule struct {
+	LastScan    time.Time `json:"last_scan"`
+	LastRequest string    `json:"last_request"`
+}
+
+var yaraScanner = struct {
+	mu          sync.Mutex
+	policy      YaraPolicy
+	rules       []*yaraRule
+	fingerprint string
+	schedule    yaraSchedule
+	removable   map[string]map[string]bool // mount point -> "path|size|mtime" already scanned
+}{removable: make(map[string]map[string]bool)}
+
+// updateYaraPolicy applies the policy when the server sent a different one.
+func updateYaraPolicy(policy YaraPolicy) {
+	data, _ := json.Marshal(policy)
+	yaraScanner.mu.Lock()
+	unchanged := string(data) == yaraScanner.fingerprint
+	yaraScanner.mu.Unlock()
+	if unchanged {
+		return
+	}
+
+	setYaraPolicy(policy, string(data))
+	if err := os.WriteFile(filepath.Join(agentDir, YARA_POLICY_FILE), data, 0644); err != nil {
+		logMessage("YARA policy cache write failed: " + err.Error())
+	}
+}
+
+// loadYaraPolicy loads the cached server policy.
+func loadYaraPolicy(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var policy YaraPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	data, _ = json.Marshal(policy)
+	setYaraPolicy(policy, string(data))
+
+	if state := loadBookmark(YARA_STATE_FILE); state != "" {
+		var saved yaraSchedule
+		if json.Unmarshal([]byte(state), &saved) == nil {
+			yaraScanner.mu.Lock()
+			yaraScanner.schedule = saved
+			yaraScanner.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func setYaraPolicy(policy YaraPolicy, fingerprint string) {
+	rules, errs := compileYaraRules(policy.Rules)
+	for _, err := range errs {
+		logMessage("YARA rule skipped: " + err.Error())
+	}
+	if policy.Rules != "" {
+		logMessage(fmt.Sprintf("Loaded %d YARA rules", len(rules)))
+	}
+
+	yaraScanner.mu.Lock()
+	yaraScanner.policy = policy
+	yaraScanner.rules = rules
+	yaraScanner.fingerprint = fingerprint
+	yaraScanner.mu.Unlock()
+}
+
+func saveYaraState() {
+	yaraScanner.mu.Lock()
+	data, _ := json.Marshal(yaraScanner.schedule)
+	yaraScanner.mu.Unlock()
+	saveBookmark(YARA_STATE_FILE, string(data))
+}
+
+// runYaraScanner starts the scans that are due every YARA_POLL_INTERVAL.
+func runYaraScanner() {
+	for {
+		time.Sleep(YARA_POLL_INTERVAL)
+
+		policyMutex.RLock()
+		quarantined := isQuarantined
+		policyMutex.RUnlock()
+		yaraScanner.mu.Lock()
+		policy, rules := yaraScanner.policy, yaraScanner.rules
+		schedule := yaraScanner.schedule
+		yaraScanner.mu.Unlock()
+		if deviceID == "" || quarantined || len(rules) == 0 {
+			continue
+		}
+
+		interval := YARA_DEFAULT_INTERVAL
+		if policy.ScanIntervalMinutes > 0 {
+			interval = time.Duration(policy.ScanIntervalMinutes) * time.Minute
+		}
+		switch {
+		case policy.ScanRequestID != "" && policy.ScanRequestID != schedule.LastRequest:
+			yaraScanner.mu.Lock()
+			yaraScanner.schedule.LastRequest = policy.ScanRequestID
+			yaraScanner.mu.Unlock()
+			saveYaraState()
+			runYaraScan("on_demand", policy.ScanRequestID, policy, rules)
+		case (len(policy.ScanPaths) > 0 || policy.ScanMemory) && time.Since(schedule.LastScan) >= interval:
+			yaraScanner.mu.Lock()
+			yaraScanner.schedule.LastScan = time.Now()
+			yaraScanner.mu.Unlock()
+			saveYaraState()
+			runYaraScan("scheduled", fmt.Sprintf("scheduled-%d", time.Now().Unix()), policy, rules)
+		}
+
+		if policy.ScanRemovable {
+			scanRemovableMedia(policy, rules)
+		}
+	}
+}
+
+// runYaraScan scans the configured paths and, when enabled, the memory of
+// every process, then reports a malware_scan_completed summary.
+func runYaraScan(trigger, scanID string, policy YaraPolicy, rules []*yaraRule) {
+	start := time.Now()
+	logMessage(fmt.Sprintf("YARA %s scan %s started (%d rules)", trigger, scanID, len(rules)))
+	var stats yaraScanStats
+
+	for _, root := range policy.ScanPaths {
+		root = os.ExpandEnv(root)
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				stats.Errors++
+				return nil // unreadable directory: skip it, keep walking
+			}
+			if d.Type().IsRegular() && !strings.HasPrefix(path, agentDir) {
+				scanYaraFile(path, trigger, scanID, policy, rules, &stats)
+			}
+			return nil
+		})
+	}
+
+	if policy.ScanMemory {
+		self := os.Getpid()
+		for _, p := range listProcesses() {
+			if p.PID == self {
+				continue
+			}
+			scanYaraProcess(p, trigger, scanID, policy, rules, &stats)
+		}
+	}
+
+	severity := "info"
+	if stats.Matches > 0 {
+		severity = "high"
+	}
+	msg := fmt.Sprintf("YARA %s scan finished: %d files, %d processes, %d matches", trigger, stats.Files, stats.Processes, stats.Matches)
+	logMessage(msg)
+	sendLog(LogEntry{
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Hostname:   getHostname(),
+		LogType:    "security",
+		Event:      "malware_scan_completed",
+		Source:     "yara",
+		Severity:   severity,
+		Message:    msg,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RawData: map[string]interface{}{
+			"trigger":           trigger,
+			"scan_id":           scanID,
+			"rules":             len(rules),
+			"scan_paths":        policy.ScanPaths,
+			"files_scanned":     stats.Files,
+			"files_skipped":     stats.Skipped,
+			"processes_scanned": stats.Processes,
+			"bytes_scanned":     stats.Bytes,
+			"matches":           stats.Matches,
+			"errors":            stats.Errors,
+			"duration_seconds":  int(time.Since(start).Seconds()),
+		},
+	})
+}
+
+// scanYaraFile scans one file and reports its matches.
+func scanYaraFile(path, trigger, scanID string, policy YaraPolicy, rules []*yaraRule, stats *yaraScanStats) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		stats.Errors++
+		return
+	}
+	if info.Size() > YARA_MAX_FILE_SIZE {
+		stats.Skipped++
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		stats.Errors++
+		return
+	}
+	stats.Files++
+	stats.Bytes += int64(len(data))
+
+	matches := scanYara(rules, []yaraBlock{{data: data}}, int64(len(data)))
+	if len(matches) == 0 {
+		return
+	}
+	sum := sha256.Sum256(data)
+	target := map[string]interface{}{
+		"target_type": "file",
+		"path":        path,
+		"file_size":   len(data),
+		"sha256":      hex.EncodeToString(sum[:]),
+		"modified":    info.ModTime().UTC().Format(time.RFC3339),
+	}
+	reportYaraMatches(matches, "file "+path, target, trigger, scanID, policy, stats)
+}
+
+// scanYaraProcess scans the readable memory of one process.
+func scanYaraProcess(p processInfo, trigger, scanID string, policy YaraPolicy, rules []*yaraRule, stats *yaraScanStats) {
+	blocks, err := processMemoryBlocks(p.PID, p.Image)
+	if err != nil || len(blocks) == 0 {
+		if err != nil && err != errYaraMemoryUnsupported {
+			stats.Errors++ // exited, or not ours to read
+		}
+		return
+	}
+	stats.Processes++
+	for _, b := range blocks {
+		stats.Bytes += int64(len(b.data))
+	}
+
+	matches := scanYara(rules, blocks, -1)
+	if len(matches) == 0 {
+		return
+	}
+	target := map[string]interface{}{
+		"target_type":  "process",
+		"process_id":   p.PID,
+		"process_name": p.Name,
+		"image":        p.Image,
+		"command_line": p.CommandLine,
+		"user":         p.User,
+	}
+	reportYaraMatches(matches, fmt.Sprintf("process %s (pid %d)", p.Name, p.PID), target, trigger, scanID, policy, stats)
+}
+
+// scanRemovableMedia scans the files not seen yet on mounted removable
+// volumes. A newly inserted volume is scanned in full.
+func scanRemovableMedia(policy YaraPolicy, rules []*yaraRule) {
+	mounts := removableMounts()
+	current := make(map[string]bool, len(mounts))
+	for _, m := range mounts {
+		current[m] = true
+	}
+	yaraScanner.mu.Lock()
+	for m := range yaraScanner.removable {
+		if !current[m] {
+			delete(yaraScanner.removable, m) // ejected: rescan when it comes back
+		}
+	}
+	yaraScanner.mu.Unlock()
+
+	for _, mount := range mounts {
+		yaraScanner.mu.Lock()
+		seen, known := yaraScanner.removable[mount]
+		if !known {
+			seen = make(map[string]bool)
+			yaraScanner.removable[mount] = seen
+		}
+		yaraScanner.mu.Unlock()
+		if !known {
+			logMessage("YARA: scanning removable volume " + mount)
+		}
+
+		var stats yaraScanStats
+		visited := 0
+		filepath.WalkDir(mount, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if visited >= YARA_REMOVABLE_MAX_FILES {
+				return filepath.SkipAll // the rest is picked up on the next poll
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			key := fmt.Sprintf("%s|%d|%d", path, info.Size(), info.ModTime().UnixNano())
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+			visited++
+			scanYaraFile(path, "removable_media", "removable-"+filepath.Base(mount), policy, rules, &stats)
+			return nil
+		})
+	}
+}
+
+// reportYaraMatches sends one malware_scan event per matching rule and
+// triggers quarantine when the policy or the rule asks for it.
+func reportYaraMatches(matches []yaraRuleMatch, what string, target map[string]interface{}, trigger, scanID string, policy YaraPolicy, stats *yaraScanStats) {
+	for _, m := range matches {
+		stats.Matches++
+		r := m.Rule
+
+		severity := "high"
+		switch strings.ToLower(r.Meta["severity"]) {
+		case "critical":
+			severity = "critical"
+		case "medium", "warning":
+			severity = "warning"
+		case "low", "info", "informational":
+			severity = "info"
+		}
+		quarantine := policy.QuarantineOnMatch || r.Meta["quarantine"] == "true"
+
+		hits := []map[string]interface{}{}
+		for _, h := range m.Strings {
+			if len(hits) >= YARA_MAX_REPORTED_HITS {
+				break
+			}
+			hits = append(hits, map[string]interface{}{
+				"identifier": h.ID,
+				"offset":     h.Offset,
+				"data_hex":   hex.EncodeToString(h.Data),
+				"data_text":  printableYaraData(h.Data),
+			})
+		}
+		identifiers := make(map[string]bool)
+		for _, h := range m.Strings {
+			identifiers[h.ID] = true
+		}
+		matched := make([]string, 0, len(identifiers))
+		for id := range identifiers {
+			matched = append(matched, id)
+		}
+		sort.Strings(matched)
+
+		raw := map[string]interface{}{
+			"rule":                 r.Name,
+			"rule_tags":            r.Tags,
+			"rule_meta":            r.Meta,
+			"trigger":              trigger,
+			"scan_id":              scanID,
+			"matched_identifiers":  matched,
+			"matched_strings":      hits,
+			"string_match_count":   len(m.Strings),
+			"quarantine_triggered": quarantine,
+		}
+		for k, v := range target {
+			raw[k] = v
+		}
+
+		msg := fmt.Sprintf("YARA rule %s matched %s", r.Name, what)
+		logMessage("⚠️ " + msg)
+		sendLog(LogEntry{
+			DeviceID:   deviceID,
+			DeviceName: deviceName,
+			Hostname:   getHostname(),
+			LogType:    "security",
+			Event:      "malware_scan",
+			Source:     "yara",
+			Severity:   severity,
+			Message:    msg,
+			Timestamp:  time.Now().UTC().Format(time.RFC3339),
+			RawData:    raw,
+		})
+
+		if quarantine && replayOutput == nil {
+			quarantineDevice(msg)
+		}
+	}
+}
+
+// localQuarantine is the agent-side hold persisted in YARA_QUARANTINE_FILE.
+type localQuarantine struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// quarantineDevice enters quarantine from the agent side through the same
+// enforcement as a server quarantine. The server only learns about it from
+// the malware_scan event, so the local hold survives status polls that say
+// "not quarantined" and agent restarts until the server either confirms
+// the quarantine (from then on it owns it) or releases the hold.
+func quarantineDevice(reason string) {
+	hold := localQuarantine{Reason: reason, Since: time.Now().UTC()}
+	data, _ := json.Marshal(hold)
+	saveBookmark(YARA_QUARANTINE_FILE, string(data))
+
+	policyMutex.Lock()
+	already := isQuarantined
+	isQuarantined = true
+	localQuarantineReason, localQuarantineSince = hold.Reason, hold.Since
+	policyMutex.Unlock()
+	if !already {
+		logMessage("⚠️ QUARANTINE (local): " + reason)
+		enforceQuarantine(reason)
+	}
+}
+
+// restoreLocalQuarantine re-enters a local quarantine held before a restart.
+func restoreLocalQuarantine() {
+	var hold localQuarantine
+	if json.Unmarshal([]byte(loadBookmark(YARA_QUARANTINE_FILE)), &hold) != nil || hold.Reason == "" {
+		return
+	}
+	policyMutex.Lock()
+	isQuarantined = true
+	localQuarantineReason, localQuarantineSince = hold.Reason, hold.Since
+	policyMutex.Unlock()
+	logMessage("⚠️ QUARANTINE (local, restored): " + hold.Reason)
+	enforceQuarantine(hold.Reason)
+}
+
+// localQuarantineHeld reports whether a local quarantine still holds after
+// the status q. It ends when the server confirms the quarantine or releases
+// it with local_quarantine_released_at after the hold started. Callers hold
+// policyMutex.
+func localQuarantineHeld(q QuarantineStatus) bool {
+	if localQuarantineReason == "" {
+		return false
+	}
+	released, err := time.Parse(time.RFC3339, q.LocalQuarantineReleasedAt)
+	releasedNow := err == nil && released.After(localQuarantineSince)
+	if !q.IsQuarantined && !releasedNow {
+		return true
+	}
+	if releasedNow {
+		logMessage("Local quarantine released by the server: " + localQuarantineReason)
+	}
+	localQuarantineReason, localQuarantineSince = "", time.Time{}
+	if err := os.Remove(filepath.Join(agentDir, YARA_QUARANTINE_FILE)); err != nil && !os.IsNotExist(err) {
+		logMessage("Local quarantine state removal failed: " + err.Error())
+	}
+	return false
+}
+
+// printableYaraData renders matched bytes for the dashboard, dots for
+// anything not printable.
+func printableYaraData(data []byte) string {
+	var b strings.Builder
+	for _, c := range data {
+		if c < 0x80 && unicode.IsPrint(rune(c)) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('.')
+		}
+	}
+	return b.String()
+}
+
+// yaraScanPath scans a file or directory offline with a rule file and
+// prints the malware_scan events (-yara-rules with -yara-scan).
+func yaraScanPath(rulesPath, target string, out io.Writer) error {
+	src, err := os.ReadFile(rulesPath)
+	if err != nil {
+		return err
+	}
+	rules, errs := compileYaraRules(string(src))
+	for _, err := range errs {
+		logMessage("YARA rule skipped: " + err.Error())
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("%s: no usable rules", rulesPath)
+	}
+
+	replayOutput = out
+	defer func() { replayOutput = nil }()
+
+	policy := YaraPolicy{ScanPaths: []string{target}}
+	if pid, err := strconv.Atoi(target); err == nil {
+		// a PID scans that process's memory instead
+		policy = YaraPolicy{}
+		for _, p := range listProcesses() {
+			if p.PID == pid {
+				var stats yaraScanStats
+				scanYaraProcess(p, "on_demand", "cli", policy, rules, &stats)
+				logMessage(fmt.Sprintf("Scanned pid %d: %d matches", pid, stats.Matches))
+				return nil
+			}
+		}
+		return fmt.Errorf("no process with pid %d", pid)
+	}
+	runYaraScan("on_demand", "cli", policy, rules)
+	return nil
+}
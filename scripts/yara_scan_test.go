@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalQuarantineHold(t *testing.T) {
+	agentDir = t.TempDir()
+	defer func() {
+		isQuarantined, localQuarantineReason, localQuarantineSince = false, "", time.Time{}
+	}()
+
+	quarantineDevice("YARA rule Test matched file /tmp/x")
+	path := filepath.Join(agentDir, YARA_QUARANTINE_FILE)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("hold not saved: %v", err)
+	}
+
+	// Restart: the hold comes back from disk
+	isQuarantined, localQuarantineReason, localQuarantineSince = false, "", time.Time{}
+	restoreLocalQuarantine()
+	if !isQuarantined || localQuarantineReason == "" {
+		t.Fatal("hold not restored")
+	}
+	since := localQuarantineSince
+
+	if !localQuarantineHeld(QuarantineStatus{}) {
+		t.Fatal("hold dropped by a status without quarantine")
+	}
+	old := since.Add(-time.Hour).Format(time.RFC3339)
+	if !localQuarantineHeld(QuarantineStatus{LocalQuarantineReleasedAt: old}) {
+		t.Fatal("hold dropped by a release older than the hold")
+	}
+	release := since.Add(time.Minute).Format(time.RFC3339)
+	if localQuarantineHeld(QuarantineStatus{LocalQuarantineReleasedAt: release}) {
+		t.Fatal("hold kept after the server released it")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("hold file still present: %v", err)
+	}
+
+	// A server quarantine takes over the hold
+	quarantineDevice("YARA rule Test matched file /tmp/y")
+	if localQuarantineHeld(QuarantineStatus{IsQuarantined: true}) || localQuarantineReason != "" {
+		t.Fatal("hold kept after the server confirmed the quarantine")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("hold file still present: %v", err)
+	}
+}
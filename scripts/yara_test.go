@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestYaraUnanchoredJumpCap(t *testing.T) {
+	rules, errs := compileYaraRules(`
+rule anchored { strings: $a = { 4D 5A [-] 50 45 } condition: $a }
+rule unanchored { strings: $a = { ?? 5A [-] 50 45 } condition: $a }
+rule wide_range { strings: $a = { (4D | 4E) [10-5000] 50 45 } condition: $a }
+`)
+	if len(errs) != 0 || len(rules) != 3 {
+		t.Fatalf("compile: %v", errs)
+	}
+	jump := func(r *yaraRule) yaraHexToken {
+		for _, tok := range r.strings[0].hex {
+			if tok.jump {
+				return tok
+			}
+		}
+		t.Fatalf("%s: no jump", r.Name)
+		return yaraHexToken{}
+	}
+	if j := jump(rules[0]); j.jumpMax != -1 {
+		t.Errorf("anchored jump capped to %d", j.jumpMax)
+	}
+	if j := jump(rules[1]); j.jumpMax != YARA_MAX_UNANCHORED_JUMP {
+		t.Errorf("unanchored jump max = %d, want %d", j.jumpMax, YARA_MAX_UNANCHORED_JUMP)
+	}
+	if j := jump(rules[2]); j.jumpMin != 10 || j.jumpMax != YARA_MAX_UNANCHORED_JUMP {
+		t.Errorf("wide range jump = [%d-%d]", j.jumpMin, j.jumpMax)
+	}
+
+	near := append([]byte("MZ"), append(bytes.Repeat([]byte{0}, 100), "PE"...)...)
+	far := append([]byte("MZ"), append(bytes.Repeat([]byte{0}, 2*YARA_MAX_UNANCHORED_JUMP), "PE"...)...)
+	for _, tt := range []struct {
+		data []byte
+		want int
+	}{{near, 3}, {far, 1}} {
+		if got := len(scanYara(rules, []yaraBlock{{data: tt.data}}, int64(len(tt.data)))); got != tt.want {
+			t.Errorf("%d byte sample: %d rules matched, want %d", len(tt.data), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package main
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// removableMounts lists the drive roots of removable drives (USB sticks,
+// SD cards).
+func removableMounts() []string {
+	drives, err := windows.GetLogicalDrives()
+	if err != nil {
+		return nil
+	}
+	var mounts []string
+	for i := 0; i < 26; i++ {
+		if drives&(1<<i) == 0 {
+			continue
+		}
+		root := string(rune('A'+i)) + `:\`
+		p, err := windows.UTF16PtrFromString(root)
+		if err == nil && windows.GetDriveType(p) == windows.DRIVE_REMOVABLE {
+			mounts = append(mounts, root)
+		}
+	}
+	return mounts
+}
+
+// processMemoryBlocks is only implemented on Linux (/proc/<pid>/mem).
+func processMemoryBlocks(pid int, image string) ([]yaraBlock, error) {
+	return nil, errYaraMemoryUnsupported
+}